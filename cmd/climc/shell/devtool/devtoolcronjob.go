@@ -70,6 +70,7 @@ func init() {
 		Interval int64  `help:"Cronjob runs at given interval" default:"0"`
 		Start    bool   `help:"start job when created" default:"false"`
 		Enabled  bool   `help:"Set job status enabled" default:"false"`
+		TimeZone string `help:"Time zone of the hour, min and sec, e.g. Asia/Shanghai"`
 	}
 	R(
 		&CronjobCreateOptions{},
@@ -94,6 +95,9 @@ func init() {
 			params.Add(jsonutils.NewString(ansiblePlaybookName), "name")
 
 			params.Add(jsonutils.NewString(ansiblePlaybookID), "ansible_playbook_id")
+			if args.TimeZone != "" {
+				params.Add(jsonutils.NewString(args.TimeZone), "time_zone")
+			}
 
 			if args.Start {
 				params.Add(jsonutils.JSONTrue, "start")
@@ -148,6 +152,7 @@ func init() {
 		Stop     bool   `help:"start job when created"`
 		Enable   bool   `help:"Set job status enabled"`
 		Disable  bool   `help:"Set job status enabled"`
+		TimeZone string `help:"Time zone of the hour, min and sec, e.g. Asia/Shanghai"`
 	}
 	R(&DevToolCronjobUpdateOptions{}, "devtoolcronjob-update", "Update DevToolCronjob", func(s *mcclient.ClientSession, args *DevToolCronjobUpdateOptions) error {
 		result, err := modules.DevToolCronjobs.Get(s, args.ID, nil)
@@ -183,6 +188,9 @@ func init() {
 		if args.Sec >= 0 {
 			params.Add(jsonutils.NewInt(int64(args.Sec)), "sec")
 		}
+		if args.TimeZone != "" {
+			params.Add(jsonutils.NewString(args.TimeZone), "time_zone")
+		}
 
 		ok, err := paramValidator(params)
 		if err != nil || !ok {
@@ -220,4 +228,13 @@ func init() {
 		printObject(result)
 		return nil
 	})
+
+	R(&DevToolCronjobShowOptions{}, "devtoolcronjob-run", "Run DevToolCronjob once now", func(s *mcclient.ClientSession, args *DevToolCronjobShowOptions) error {
+		result, err := modules.DevToolCronjobs.PerformAction(s, args.ID, "run", nil)
+		if err != nil {
+			return err
+		}
+		printObject(result)
+		return nil
+	})
 }
@@ -111,6 +111,7 @@ func init() {
 		Action       string
 		Contacts     string
 		IsFailed     string
+		Async        bool `help:"Dispatch the notification in the background"`
 	}
 	R(&NotificationEventInput{}, "notify-event-send", "Send notify event message", func(s *mcclient.ClientSession, args *NotificationEventInput) error {
 		body, err := jsonutils.ParseString(args.MsgBody)
@@ -130,6 +131,7 @@ func init() {
 			ResourceType:    args.ResourceType,
 			Action:          api.SAction(args.Action),
 			IsFailed:        api.SResult(args.IsFailed),
+			Async:           args.Async,
 		}
 		_, err = modules.Notification.PerformClassAction(s, "event-notify", jsonutils.Marshal(params))
 		if err != nil {
@@ -27,4 +27,5 @@ func init() {
 	cmd.Update(new(options.TopicUpdateOptions))
 	cmd.Show(new(options.TopicOptions))
 	cmd.Delete(new(options.TopicOptions))
+	cmd.Perform("backfill", new(options.TopicBackfillOptions))
 }
@@ -60,6 +60,39 @@ var NVIDIA_GPU_TYPES = []string{
 	CONTAINER_DEV_NVIDIA_GPU_SHARE,
 }
 
+// ContainerGPUVendor is the vendor of a container gpu device type
+type ContainerGPUVendor string
+
+const (
+	CONTAINER_GPU_VENDOR_UNKNOWN    ContainerGPUVendor = ""
+	CONTAINER_GPU_VENDOR_NVIDIA     ContainerGPUVendor = "nvidia"
+	CONTAINER_GPU_VENDOR_CPH_AMD    ContainerGPUVendor = "cph_amd"
+	CONTAINER_GPU_VENDOR_VASTAITECH ContainerGPUVendor = "vastaitech"
+)
+
+// containerGPUVendors maps each container gpu device type to its vendor,
+// a new gpu device type only needs to be registered here and in
+// CONTAINER_GPU_TYPES
+var containerGPUVendors = map[string]ContainerGPUVendor{
+	CONTAINER_DEV_NVIDIA_GPU:       CONTAINER_GPU_VENDOR_NVIDIA,
+	CONTAINER_DEV_NVIDIA_MPS:       CONTAINER_GPU_VENDOR_NVIDIA,
+	CONTAINER_DEV_NVIDIA_GPU_SHARE: CONTAINER_GPU_VENDOR_NVIDIA,
+	CONTAINER_DEV_CPH_AMD_GPU:      CONTAINER_GPU_VENDOR_CPH_AMD,
+	CONTAINER_DEV_VASTAITECH_GPU:   CONTAINER_GPU_VENDOR_VASTAITECH,
+}
+
+// IsGPUDeviceType tells whether the container device type is a gpu
+func IsGPUDeviceType(t string) bool {
+	_, ok := containerGPUVendors[t]
+	return ok
+}
+
+// GPUVendor returns the vendor of the container gpu device type,
+// CONTAINER_GPU_VENDOR_UNKNOWN if it is not a gpu
+func GPUVendor(t string) ContainerGPUVendor {
+	return containerGPUVendors[t]
+}
+
 const (
 	CONTAINER_STORAGE_LOCAL_RAW = "local_raw"
 )
@@ -0,0 +1,63 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compute
+
+import (
+	"testing"
+
+	"yunion.io/x/pkg/utils"
+)
+
+func TestGPUVendor(t *testing.T) {
+	cases := []struct {
+		devType string
+		vendor  ContainerGPUVendor
+	}{
+		{CONTAINER_DEV_NVIDIA_GPU, CONTAINER_GPU_VENDOR_NVIDIA},
+		{CONTAINER_DEV_NVIDIA_MPS, CONTAINER_GPU_VENDOR_NVIDIA},
+		{CONTAINER_DEV_NVIDIA_GPU_SHARE, CONTAINER_GPU_VENDOR_NVIDIA},
+		{CONTAINER_DEV_CPH_AMD_GPU, CONTAINER_GPU_VENDOR_CPH_AMD},
+		{CONTAINER_DEV_VASTAITECH_GPU, CONTAINER_GPU_VENDOR_VASTAITECH},
+		{CONTAINER_DEV_CPH_AOSP_BINDER, CONTAINER_GPU_VENDOR_UNKNOWN},
+		{CONTAINER_DEV_NETINT_CA_ASIC, CONTAINER_GPU_VENDOR_UNKNOWN},
+		{CONTAINER_DEV_NETINT_CA_QUADRA, CONTAINER_GPU_VENDOR_UNKNOWN},
+		{CONTAINER_DEV_ASCEND_NPU, CONTAINER_GPU_VENDOR_UNKNOWN},
+		{"", CONTAINER_GPU_VENDOR_UNKNOWN},
+	}
+	for _, c := range cases {
+		if got := GPUVendor(c.devType); got != c.vendor {
+			t.Errorf("GPUVendor(%q) = %q, want %q", c.devType, got, c.vendor)
+		}
+		if got := IsGPUDeviceType(c.devType); got != (c.vendor != CONTAINER_GPU_VENDOR_UNKNOWN) {
+			t.Errorf("IsGPUDeviceType(%q) = %v", c.devType, got)
+		}
+	}
+	// the registry must agree with the gpu type lists
+	for _, devType := range CONTAINER_GPU_TYPES {
+		if !IsGPUDeviceType(devType) {
+			t.Errorf("%s in CONTAINER_GPU_TYPES is not registered", devType)
+		}
+	}
+	for _, devType := range NVIDIA_GPU_TYPES {
+		if GPUVendor(devType) != CONTAINER_GPU_VENDOR_NVIDIA {
+			t.Errorf("%s in NVIDIA_GPU_TYPES is not a nvidia gpu", devType)
+		}
+	}
+	for devType := range containerGPUVendors {
+		if !utils.IsInStringArray(devType, CONTAINER_GPU_TYPES) {
+			t.Errorf("%s is missing in CONTAINER_GPU_TYPES", devType)
+		}
+	}
+}
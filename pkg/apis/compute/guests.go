@@ -680,6 +680,17 @@ type ServerSaveGuestImageInput struct {
 
 	// 保存镜像后是否自动启动
 	AutoStart *bool `json:"auto_start"`
+
+	// 不保存的数据盘ID列表, 系统盘不能排除
+	ExcludeDiskIds []string `json:"exclude_disk_ids"`
+
+	// 保存运行中的主机, 保存前通过 qemu guest agent 冻结文件系统, 宿主机拷贝磁盘后即解冻, 再上传镜像
+	// guest agent 不可用, 或磁盘需分批保存时, 保存失败
+	Quiesce bool `json:"quiesce"`
+}
+
+// 取消只停止控制节点的保存任务, 宿主机上正在进行的磁盘拷贝或上传不会中断, 完成后结果被丢弃
+type ServerCancelSaveGuestImageInput struct {
 }
 
 type ServerDeleteInput struct {
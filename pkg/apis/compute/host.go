@@ -599,6 +599,9 @@ type SHostPingInput struct {
 	StorageStats []SHostStorageStat `json:"storage_stats"`
 
 	QgaRunningGuestIds []string `json:"qga_running_guests"`
+
+	// DiskSaveInFlight is the number of the running disk save operations
+	DiskSaveInFlight int `json:"disk_save_in_flight"`
 }
 
 type HostReserveCpusInput struct {
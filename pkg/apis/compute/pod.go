@@ -40,6 +40,9 @@ const (
 	POD_METADATA_CRI_CONFIG               = "cri_config"
 	POD_METADATA_PORT_MAPPINGS            = "port_mappings"
 	POD_METADATA_POST_STOP_CLEANUP_CONFIG = "post_stop_cleanup_config"
+	// POD_METADATA_BANDWIDTH_LIMIT is the bandwidth limit applied to the
+	// sandbox of the pod by the host
+	POD_METADATA_BANDWIDTH_LIMIT = "bandwidth_limit"
 )
 
 type PodContainerCreateInput struct {
@@ -84,6 +87,15 @@ type PodCreateInput struct {
 	HostIPC    bool                       `json:"host_ipc"`
 	//PortMappings    []*PodPortMapping          `json:"port_mappings"`
 	SecurityContext *PodSecurityContext `json:"security_context,omitempty"`
+	// BandwidthLimit is shaped by tc in the network namespace of the pod,
+	// which is shared by all the containers of the pod
+	BandwidthLimit *PodBandwidthLimit `json:"bandwidth_limit,omitempty"`
+}
+
+// PodBandwidthLimit is the bandwidth limit of the pod in Mbps, 0 means unlimited
+type PodBandwidthLimit struct {
+	EgressMbps  int `json:"egress_mbps"`
+	IngressMbps int `json:"ingress_mbps"`
 }
 
 type PodStartResponse struct {
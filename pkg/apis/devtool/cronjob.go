@@ -0,0 +1,113 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package devtool
+
+import (
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/apis"
+)
+
+const (
+	// CRONJOB_ACT_RUN is the action of the ops log recorded on each run
+	CRONJOB_ACT_RUN = "cronjob_run"
+	// CRONJOB_ACT_RUN_FAIL is the action of the ops log recorded when the
+	// ansible playbook of a run can't be started, e.g. of a server of the
+	// template
+	CRONJOB_ACT_RUN_FAIL = "cronjob_run_fail"
+	// CRONJOB_ACT_SCHEDULE_FAIL is the action of the ops log recorded when
+	// the cronjob can't be registered to the cron manager
+	CRONJOB_ACT_SCHEDULE_FAIL = "cronjob_schedule_fail"
+
+	// CRONJOB_STATUS_READY means the cronjob is scheduled
+	CRONJOB_STATUS_READY = "ready"
+	// CRONJOB_STATUS_DISABLED means the cronjob isn't scheduled since it's disabled
+	CRONJOB_STATUS_DISABLED = "disabled"
+	// CRONJOB_STATUS_PAUSED means the cronjob is enabled but isn't scheduled
+	// since the cronjobs of its project are paused
+	CRONJOB_STATUS_PAUSED = "paused"
+	// CRONJOB_STATUS_SCHEDULE_FAILED means the cronjob is enabled but isn't
+	// scheduled, the reason is in the ops log
+	CRONJOB_STATUS_SCHEDULE_FAILED = "schedule_failed"
+)
+
+type CronjobCreateInput struct {
+	apis.VirtualResourceCreateInput
+
+	// description: run every Day days at Hour:Min:Sec, ignored when Interval is set
+	Day  int `json:"day"`
+	Hour int `json:"hour"`
+	Min  int `json:"min"`
+	Sec  int `json:"sec"`
+	// description: run every Interval seconds
+	Interval int64 `json:"interval"`
+	Start    bool  `json:"start"`
+	Enabled  bool  `json:"enabled"`
+	// description: time zone of Hour:Min:Sec, e.g. Asia/Shanghai, empty means the time zone of the service
+	TimeZone string `json:"time_zone"`
+
+	// description: the ansible playbook run, optional for a cronjob of a template without server_id
+	AnsiblePlaybookID string `json:"ansible_playbook_id"`
+	// description: a cronjob of the template without server_id runs the ansible playbooks of all the servers bound to the template
+	TemplateID string `json:"template_id"`
+	ServerID   string `json:"server_id"`
+	// description: extra vars passed to the ansible playbook run, must be a json object.
+	// The {{ var }} templates in the string values are expanded on each run,
+	// var is one of date, datetime, timestamp, hostname, seq, cronjob_id and cronjob_name
+	ExtraVars jsonutils.JSONObject `json:"extra_vars"`
+}
+
+type CronjobUpdateInput struct {
+	apis.VirtualResourceBaseUpdateInput
+
+	Day      *int   `json:"day"`
+	Hour     *int   `json:"hour"`
+	Min      *int   `json:"min"`
+	Sec      *int   `json:"sec"`
+	Interval *int64 `json:"interval"`
+	Start    *bool  `json:"start"`
+	Enabled  *bool  `json:"enabled"`
+	// description: time zone of Hour:Min:Sec, empty means the time zone of the service
+	TimeZone *string `json:"time_zone"`
+
+	AnsiblePlaybookID string `json:"ansible_playbook_id"`
+	TemplateID        string `json:"template_id"`
+	ServerID          string `json:"server_id"`
+	// description: extra vars passed to the ansible playbook run, must be a json object.
+	// The {{ var }} templates in the string values are expanded on each run,
+	// var is one of date, datetime, timestamp, hostname, seq, cronjob_id and cronjob_name
+	ExtraVars jsonutils.JSONObject `json:"extra_vars"`
+}
+
+type CronjobValidateInput struct {
+}
+
+type CronjobValidateOutput struct {
+	// description: whether all the references of the cronjob exist
+	Valid bool `json:"valid"`
+	// description: the dangling references, e.g. ansible_playbook_id
+	DanglingReferences []string `json:"dangling_references"`
+}
+
+type CronjobRunInput struct {
+}
+
+type CronjobRunOutput struct {
+	// description: the ansible playbook run, whose status and output tell the result of the run,
+	// empty for a cronjob of a template, whose runs of the servers are recorded in the ops log
+	AnsiblePlaybookID string `json:"ansible_playbook_id"`
+	// description: the seq of the run, which is recorded in the cronjob_run ops log
+	Seq int64 `json:"seq"`
+}
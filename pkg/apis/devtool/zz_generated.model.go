@@ -17,6 +17,8 @@ package devtool
 import (
 	time "time"
 
+	jsonutils "yunion.io/x/jsonutils"
+
 	"yunion.io/x/onecloud/pkg/apis"
 	ansible "yunion.io/x/onecloud/pkg/util/ansible"
 )
@@ -27,6 +29,8 @@ type SCronjob struct {
 	AnsiblePlaybookID string `json:"ansible_playbook_id"`
 	TemplateID        string `json:"template_id"`
 	ServerID          string `json:"server_id"`
+	// ExtraVars is passed as the body of the ansible playbook run action
+	ExtraVars jsonutils.JSONObject `json:"extra_vars"`
 	apis.SVirtualResourceBase
 }
 
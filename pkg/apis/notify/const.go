@@ -64,6 +64,12 @@ const (
 	NOTIFICATION_STATUS_OK       = "ok"
 	NOTIFICATION_STATUS_PART_OK  = "part_ok"
 
+	EVENT_DISPATCH_STATUS_QUEUED    = "queued"
+	EVENT_DISPATCH_STATUS_RETRYING  = "retrying"
+	EVENT_DISPATCH_STATUS_DELIVERED = "delivered"
+	EVENT_DISPATCH_STATUS_FAILED    = "failed"
+	EVENT_DISPATCH_STATUS_DROPPED   = "dropped"
+
 	NOTIFICATION_TAG_ALERT = "alert"
 
 	TEMPLATE_TYPE_TITLE   = "title"
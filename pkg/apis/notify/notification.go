@@ -139,6 +139,10 @@ type NotificationManagerEventNotifyInput struct {
 	// example: f627e09f038645f08ce6880c8d9cb8fd
 	ProjectId string `json:"project_id"`
 	IsFailed  SResult
+	// description: dispatch the notification in the background and retry the failures, the failed contact types aren't returned
+	// required: false
+	// example: false
+	Async bool `json:"async"`
 }
 
 type NotificationManagerEventNotifyOutput struct {
@@ -77,6 +77,12 @@ type PerformEnableInput struct {
 type PerformDisableInput struct {
 }
 
+type TopicBackfillInput struct {
+	// description: resource type the topic is rolled out to
+	// example: server
+	ResourceType string `json:"resource_type"`
+}
+
 type STopicGroupKeys []string
 type TopicAdvanceDays []int
 
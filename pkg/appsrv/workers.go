@@ -353,6 +353,14 @@ func (s SWorkerManagerStates) IsBusy() bool {
 	return true
 }
 
+// GetState returns a snapshot of the queue and worker usage of the manager
+func (wm *SWorkerManager) GetState() SWorkerManagerStates {
+	wm.workerLock.Lock()
+	defer wm.workerLock.Unlock()
+
+	return wm.getState()
+}
+
 func (wm *SWorkerManager) getState() SWorkerManagerStates {
 	state := SWorkerManagerStates{}
 
@@ -371,7 +379,7 @@ func (wm *SWorkerManager) getState() SWorkerManagerStates {
 func WorkerStatsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	stats := make([]SWorkerManagerStates, 0)
 	for i := 0; i < len(workerManagers); i += 1 {
-		stats = append(stats, workerManagers[i].getState())
+		stats = append(stats, workerManagers[i].GetState())
 	}
 	result := jsonutils.NewDict()
 	result.Add(jsonutils.Marshal(&stats), "workers")
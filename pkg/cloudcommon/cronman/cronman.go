@@ -18,10 +18,13 @@ import (
 	"container/heap"
 	"context"
 	"fmt"
+	"net/http"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"yunion.io/x/jsonutils"
 	"yunion.io/x/log"
 	"yunion.io/x/pkg/appctx"
 	"yunion.io/x/pkg/errors"
@@ -44,6 +47,10 @@ var (
 type TCronJobFunction func(ctx context.Context, userCred mcclient.TokenCredential, isStart bool)
 type TCronJobFunctionWithStartTime func(ctx context.Context, userCred mcclient.TokenCredential, start time.Time, isStart bool)
 
+// TCronJobFunctionWithError is a TCronJobFunction reporting whether the run
+// failed, which is counted by the Failed stats of the manager
+type TCronJobFunctionWithError func(ctx context.Context, userCred mcclient.TokenCredential, isStart bool) error
+
 var manager *SCronJobManager
 
 type ICronTimer interface {
@@ -60,9 +67,15 @@ func (t *Timer1) Next(now time.Time) time.Time {
 
 type Timer2 struct {
 	day, hour, min, sec int
+	// loc is the time zone of hour:min:sec, nil means the time zone of
+	// the manager
+	loc *time.Location
 }
 
 func (t *Timer2) Next(now time.Time) time.Time {
+	if t.loc != nil {
+		now = now.In(t.loc)
+	}
 	next := now.Add(time.Hour * time.Duration(t.day) * 24)
 	nextTime := time.Date(next.Year(), next.Month(), next.Day(), t.hour, t.min, t.sec, 0, next.Location())
 	if nextTime.Sub(now) > time.Duration(t.day)*time.Hour*24 {
@@ -71,6 +84,18 @@ func (t *Timer2) Next(now time.Time) time.Time {
 	return nextTime
 }
 
+// NewTimerEveryFewDays returns the timer of the jobs added by
+// AddJobEveryFewDaysInLocation, e.g. to tell when such a job runs next.
+func NewTimerEveryFewDays(day, hour, min, sec int, loc *time.Location) ICronTimer {
+	return &Timer2{
+		day:  day,
+		hour: hour,
+		min:  min,
+		sec:  sec,
+		loc:  loc,
+	}
+}
+
 type TimerHour struct {
 	hour, min, sec int
 }
@@ -88,10 +113,14 @@ type SCronJob struct {
 	Name             string
 	job              TCronJobFunction
 	jobWithStartTime TCronJobFunctionWithStartTime
+	jobWithError     TCronJobFunctionWithError
 	Timer            ICronTimer
 	Next             time.Time
 	StartRun         bool
-	times            []time.Time
+	// NonReentrant skips a run if the previous run of the job is still in progress
+	NonReentrant bool
+	times        []time.Time
+	running      int32
 }
 
 type CronJobTimerHeap []*SCronJob
@@ -134,6 +163,40 @@ func (cjth *CronJobTimerHeap) Pop() interface{} {
 	return x
 }
 
+// SCronJobManagerStats is a snapshot of the counters of a cron job manager
+type SCronJobManagerStats struct {
+	// Fired is the number of runs dispatched to the worker pool
+	Fired int64
+	// Succeeded is the number of runs finished without error or panic, the
+	// runs of the jobs not reporting errors succeed unless they panic
+	Succeeded int64
+	// Failed is the number of runs returning an error or aborted by a panic
+	Failed int64
+	// Skipped is the number of runs of non reentrant jobs skipped because
+	// the previous run was still in progress
+	Skipped int64
+	// Running is the number of runs currently in progress
+	Running int64
+
+	// WorkerCount is the size of the worker pool
+	WorkerCount int
+	// ActiveWorkers is the number of busy workers
+	ActiveWorkers int
+	// QueuedRuns is the number of runs waiting for a free worker
+	QueuedRuns int
+	// Saturation is ActiveWorkers/WorkerCount, a value close to 1 together
+	// with QueuedRuns > 0 means the worker pool is the bottleneck
+	Saturation float64
+}
+
+type cronJobCounters struct {
+	fired     int64
+	succeeded int64
+	failed    int64
+	skipped   int64
+	running   int64
+}
+
 type SCronJobManager struct {
 	jobs     CronJobTimerHeap
 	stopFunc context.CancelFunc
@@ -142,6 +205,8 @@ type SCronJobManager struct {
 	workers  *appsrv.SWorkerManager
 	dataLock *sync.Mutex
 	timezone *time.Location
+
+	counters cronJobCounters
 }
 
 func InitCronJobManager(isDbWorker bool, workerCount int, timezone string) *SCronJobManager {
@@ -166,6 +231,11 @@ func GetCronJobManager() *SCronJobManager {
 	return manager
 }
 
+// TimeZone returns the time zone the jobs are scheduled in.
+func (self *SCronJobManager) TimeZone() *time.Location {
+	return self.timezone
+}
+
 func (self *SCronJobManager) IsNameUnique(name string) bool {
 	for i := 0; i < len(self.jobs); i++ {
 		if self.jobs[i].Name == name {
@@ -216,34 +286,70 @@ func (self *SCronJobManager) AddJobAtIntervalsWithStarTimeStartRun(name string,
 }
 
 func (self *SCronJobManager) AddJobAtIntervalsWithStartRun(name string, interval time.Duration, jobFunc TCronJobFunction, startRun bool) error {
+	return self.addJobAtIntervals(&SCronJob{
+		Name:     name,
+		job:      jobFunc,
+		StartRun: startRun,
+	}, interval)
+}
+
+// AddJobAtIntervalsWithError is AddJobAtIntervalsWithStartRun of a job
+// reporting its failed runs.
+func (self *SCronJobManager) AddJobAtIntervalsWithError(name string, interval time.Duration, jobFunc TCronJobFunctionWithError, startRun bool) error {
+	return self.addJobAtIntervals(&SCronJob{
+		Name:         name,
+		jobWithError: jobFunc,
+		StartRun:     startRun,
+	}, interval)
+}
+
+func (self *SCronJobManager) addJobAtIntervals(job *SCronJob, interval time.Duration) error {
 	if interval <= 0 {
 		return errors.Error("AddJobAtIntervals: interval must > 0")
 	}
 	self.dataLock.Lock()
 	defer self.dataLock.Unlock()
 
-	if !self.IsNameUnique(name) {
+	if !self.IsNameUnique(job.Name) {
 		return ErrCronJobNameConflict
 	}
 
-	t := Timer1{
+	job.Timer = &Timer1{
 		dur: interval,
 	}
-	job := SCronJob{
-		Name:     name,
-		job:      jobFunc,
-		Timer:    &t,
-		StartRun: startRun,
-	}
 	if !self.running {
-		self.jobs = append(self.jobs, &job)
+		self.jobs = append(self.jobs, job)
 	} else {
-		self.addJob(&job)
+		self.addJob(job)
 	}
 	return nil
 }
 
 func (self *SCronJobManager) AddJobEveryFewDays(name string, day, hour, min, sec int, jobFunc TCronJobFunction, startRun bool) error {
+	return self.AddJobEveryFewDaysInLocation(name, day, hour, min, sec, nil, jobFunc, startRun)
+}
+
+// AddJobEveryFewDaysInLocation is AddJobEveryFewDays with hour:min:sec in the
+// time zone loc instead of the one of the manager, nil means the latter.
+func (self *SCronJobManager) AddJobEveryFewDaysInLocation(name string, day, hour, min, sec int, loc *time.Location, jobFunc TCronJobFunction, startRun bool) error {
+	return self.addJobEveryFewDays(&SCronJob{
+		Name:     name,
+		job:      jobFunc,
+		StartRun: startRun,
+	}, day, hour, min, sec, loc)
+}
+
+// AddJobEveryFewDaysInLocationWithError is AddJobEveryFewDaysInLocation of a
+// job reporting its failed runs.
+func (self *SCronJobManager) AddJobEveryFewDaysInLocationWithError(name string, day, hour, min, sec int, loc *time.Location, jobFunc TCronJobFunctionWithError, startRun bool) error {
+	return self.addJobEveryFewDays(&SCronJob{
+		Name:         name,
+		jobWithError: jobFunc,
+		StartRun:     startRun,
+	}, day, hour, min, sec, loc)
+}
+
+func (self *SCronJobManager) addJobEveryFewDays(job *SCronJob, day, hour, min, sec int, loc *time.Location) error {
 	switch {
 	case day <= 0:
 		return errors.Error("AddJobEveryFewDays: day must > 0")
@@ -258,26 +364,15 @@ func (self *SCronJobManager) AddJobEveryFewDays(name string, day, hour, min, sec
 	self.dataLock.Lock()
 	defer self.dataLock.Unlock()
 
-	if !self.IsNameUnique(name) {
+	if !self.IsNameUnique(job.Name) {
 		return ErrCronJobNameConflict
 	}
 
-	t := Timer2{
-		day:  day,
-		hour: hour,
-		min:  min,
-		sec:  sec,
-	}
-	job := SCronJob{
-		Name:     name,
-		job:      jobFunc,
-		Timer:    &t,
-		StartRun: startRun,
-	}
+	job.Timer = NewTimerEveryFewDays(day, hour, min, sec, loc)
 	if !self.running {
-		self.jobs = append(self.jobs, &job)
+		self.jobs = append(self.jobs, job)
 	} else {
-		self.addJob(&job)
+		self.addJob(job)
 	}
 	return nil
 }
@@ -346,6 +441,80 @@ func (self *SCronJobManager) Remove(name string) error {
 	return nil
 }
 
+func (self *SCronJobManager) getJob(name string) *SCronJob {
+	for i := 0; i < len(self.jobs); i++ {
+		if self.jobs[i].Name == name {
+			return self.jobs[i]
+		}
+	}
+	return nil
+}
+
+// SetJobNonReentrant makes the named job skip a run when its previous run
+// has not finished yet, so that an out of band run triggered by RunJobNow
+// never overlaps with a scheduled run.
+func (self *SCronJobManager) SetJobNonReentrant(name string, nonReentrant bool) error {
+	self.dataLock.Lock()
+	defer self.dataLock.Unlock()
+
+	job := self.getJob(name)
+	if job == nil {
+		return errors.Errorf("job %s not found", name)
+	}
+	job.NonReentrant = nonReentrant
+	return nil
+}
+
+// RunJobNow fires the named job once immediately, out of band. The next
+// scheduled time of the job is left untouched, so the periodic cadence is
+// not disturbed.
+func (self *SCronJobManager) RunJobNow(name string) error {
+	self.dataLock.Lock()
+	defer self.dataLock.Unlock()
+
+	job := self.getJob(name)
+	if job == nil {
+		return errors.Errorf("job %s not found", name)
+	}
+	job.runJob(true, time.Now().In(self.timezone))
+	return nil
+}
+
+// GetStats returns the run counters of the manager and the usage of its
+// worker pool
+func (self *SCronJobManager) GetStats() SCronJobManagerStats {
+	stats := SCronJobManagerStats{
+		Fired:     atomic.LoadInt64(&self.counters.fired),
+		Succeeded: atomic.LoadInt64(&self.counters.succeeded),
+		Failed:    atomic.LoadInt64(&self.counters.failed),
+		Skipped:   atomic.LoadInt64(&self.counters.skipped),
+		Running:   atomic.LoadInt64(&self.counters.running),
+	}
+	state := self.workers.GetState()
+	stats.WorkerCount = state.MaxWorkerCnt
+	stats.ActiveWorkers = state.ActiveWorkerCnt
+	stats.QueuedRuns = state.QueueCnt
+	if stats.WorkerCount > 0 {
+		stats.Saturation = float64(stats.ActiveWorkers) / float64(stats.WorkerCount)
+	}
+	return stats
+}
+
+// AddCronJobStatsHandler serves the stats of the cron job manager at
+// prefix/cronjob_stats, the stats are empty if the manager isn't initialized,
+// e.g. on a slave node.
+func AddCronJobStatsHandler(prefix string, app *appsrv.Application) {
+	app.AddDefaultHandler("GET", fmt.Sprintf("%s/cronjob_stats", prefix), appsrv.WhitelistFilter(cronJobStatsHandler), "cronjob_stats")
+}
+
+func cronJobStatsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	stats := SCronJobManagerStats{}
+	if manager != nil {
+		stats = manager.GetStats()
+	}
+	appsrv.SendJSON(w, jsonutils.Marshal(&stats))
+}
+
 func (self *SCronJobManager) next(now time.Time) {
 	for _, job := range self.jobs {
 		job.Next = job.Timer.Next(now)
@@ -446,18 +615,33 @@ func (job *SCronJob) Dump() string {
 func (job *SCronJob) runJob(isStart bool, now time.Time) {
 	job.StartRun = isStart
 	job.times = append(job.times, now)
+	atomic.AddInt64(&manager.counters.fired, 1)
 	manager.workers.Run(job, nil, nil)
 }
 
 func (job *SCronJob) runJobInWorker(isStart bool, startTime time.Time) {
+	counters := &manager.counters
 	defer func() {
 		if r := recover(); r != nil {
+			atomic.AddInt64(&counters.failed, 1)
 			log.Errorf("CronJob task %s run error: %s", job.Name, r)
 			debug.PrintStack()
 			yunionconf.BugReport.SendBugReport(context.Background(), version.GetShortString(), string(debug.Stack()), errors.Errorf("%s", r))
 		}
 	}()
 
+	if job.NonReentrant {
+		if !atomic.CompareAndSwapInt32(&job.running, 0, 1) {
+			log.Warningf("Cron job: %s is still running, skip this run", job.Name)
+			atomic.AddInt64(&counters.skipped, 1)
+			return
+		}
+		defer atomic.StoreInt32(&job.running, 0)
+	}
+
+	atomic.AddInt64(&counters.running, 1)
+	defer atomic.AddInt64(&counters.running, -1)
+
 	log.Debugf("Cron job: %s started, startTime: %s", job.Name, startTime.Format(time.RFC3339))
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, appctx.APP_CONTEXT_KEY_APPNAME, fmt.Sprintf("%s/cron-service", consts.GetServiceName()))
@@ -467,5 +651,12 @@ func (job *SCronJob) runJobInWorker(isStart bool, startTime time.Time) {
 		job.job(ctx, userCred, isStart)
 	} else if job.jobWithStartTime != nil {
 		job.jobWithStartTime(ctx, userCred, startTime, isStart)
+	} else if job.jobWithError != nil {
+		if err := job.jobWithError(ctx, userCred, isStart); err != nil {
+			atomic.AddInt64(&counters.failed, 1)
+			log.Errorf("Cron job: %s failed: %s", job.Name, err)
+			return
+		}
 	}
+	atomic.AddInt64(&counters.succeeded, 1)
 }
@@ -16,9 +16,12 @@ package cronman
 
 import (
 	"context"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"yunion.io/x/pkg/errors"
+
 	"yunion.io/x/onecloud/pkg/mcclient"
 )
 
@@ -40,3 +43,110 @@ func TestSCronJobManager_AddRemoveJobs(t *testing.T) {
 	manager.AddJobEveryFewDays("Test7", 1, 1, 1, 1, testFunc, false)
 	t.Logf("Jobs \n%s", manager.String())
 }
+
+func TestSCronJobManager_RunJobNowNonReentrant(t *testing.T) {
+	DefaultAdminSessionGenerator = func() mcclient.TokenCredential { return nil }
+	manager := InitCronJobManager(false, 4, "")
+	var count int32
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	testFunc := func(ctx context.Context, userCred mcclient.TokenCredential, isStart bool) {
+		atomic.AddInt32(&count, 1)
+		started <- struct{}{}
+		<-release
+	}
+	if err := manager.AddJobAtIntervals("TestRunNow", time.Second*100, testFunc); err != nil {
+		t.Fatalf("AddJobAtIntervals: %v", err)
+	}
+	defer manager.Remove("TestRunNow")
+	if err := manager.SetJobNonReentrant("TestRunNow", true); err != nil {
+		t.Fatalf("SetJobNonReentrant: %v", err)
+	}
+	next := manager.getJob("TestRunNow").Next
+	before := manager.GetStats()
+
+	if err := manager.RunJobNow("TestRunNow"); err != nil {
+		t.Fatalf("RunJobNow: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("job not started")
+	}
+	// the first run is still in progress, the second one must be skipped
+	if err := manager.RunJobNow("TestRunNow"); err != nil {
+		t.Fatalf("RunJobNow: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	time.Sleep(100 * time.Millisecond)
+
+	if c := atomic.LoadInt32(&count); c != 1 {
+		t.Errorf("expect job run once, got %d", c)
+	}
+	if !manager.getJob("TestRunNow").Next.Equal(next) {
+		t.Errorf("RunJobNow should not change the next schedule time")
+	}
+	after := manager.GetStats()
+	if d := after.Skipped - before.Skipped; d != 1 {
+		t.Errorf("expect 1 skipped run, got %d", d)
+	}
+	if d := after.Succeeded - before.Succeeded; d != 1 {
+		t.Errorf("expect 1 succeeded run, got %d", d)
+	}
+}
+
+func TestSCronJobManager_FailedRuns(t *testing.T) {
+	DefaultAdminSessionGenerator = func() mcclient.TokenCredential { return nil }
+	manager := InitCronJobManager(false, 4, "")
+	done := make(chan struct{}, 2)
+	var fail int32 = 1
+	testFunc := func(ctx context.Context, userCred mcclient.TokenCredential, isStart bool) error {
+		defer func() { done <- struct{}{} }()
+		if atomic.LoadInt32(&fail) == 1 {
+			return errors.Error("run failed")
+		}
+		return nil
+	}
+	if err := manager.AddJobAtIntervalsWithError("TestFailedRuns", time.Second*100, testFunc, false); err != nil {
+		t.Fatalf("AddJobAtIntervalsWithError: %v", err)
+	}
+	defer manager.Remove("TestFailedRuns")
+	before := manager.GetStats()
+
+	for _, f := range []int32{1, 0} {
+		atomic.StoreInt32(&fail, f)
+		if err := manager.RunJobNow("TestFailedRuns"); err != nil {
+			t.Fatalf("RunJobNow: %v", err)
+		}
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("job not run")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	after := manager.GetStats()
+	if d := after.Failed - before.Failed; d != 1 {
+		t.Errorf("expect 1 failed run, got %d", d)
+	}
+	if d := after.Succeeded - before.Succeeded; d != 1 {
+		t.Errorf("expect 1 succeeded run, got %d", d)
+	}
+}
+
+func TestTimer2NextInLocation(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	// 2024-01-01 20:00 UTC is 2024-01-02 04:00 in CST
+	now := time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+
+	timer := &Timer2{day: 1, hour: 3, min: 0, sec: 0}
+	if got, want := timer.Next(now), time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("next in manager zone: want %s, got %s", want, got)
+	}
+	timer = &Timer2{day: 1, hour: 3, min: 0, sec: 0, loc: shanghai}
+	if got, want := timer.Next(now), time.Date(2024, 1, 3, 3, 0, 0, 0, shanghai); !got.Equal(want) {
+		t.Errorf("next in CST: want %s, got %s", want, got)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"yunion.io/x/jsonutils"
@@ -97,8 +98,14 @@ func init() {
 	DefaultProjectQuery = TenantCacheManager.GetTenantQuery
 }
 
+var userCredCacheUpdaterOnce sync.Once
+
+// RegistUserCredCacheUpdater registers the auth hook refreshing the user and
+// tenant caches, calling it more than once registers the hook only once
 func RegistUserCredCacheUpdater() {
-	auth.RegisterAuthHook(onAuthCompleteUpdateCache)
+	userCredCacheUpdaterOnce.Do(func() {
+		auth.RegisterAuthHook(onAuthCompleteUpdateCache)
+	})
 }
 
 func onAuthCompleteUpdateCache(ctx context.Context, userCred mcclient.TokenCredential) {
@@ -50,6 +50,11 @@ func (w *SWorkManager) done() {
 	atomic.AddInt32(&w.curCount, -1)
 }
 
+// ActiveWorkerCount returns the count of the tasks being run by the workers.
+func (w *SWorkManager) ActiveWorkerCount() int {
+	return w.worker.ActiveWorkerCount()
+}
+
 func (w *SWorkManager) DelayTask(ctx context.Context, task DelayTaskFunc, params interface{}) {
 	w.delayTask(ctx, task, params, w.worker)
 }
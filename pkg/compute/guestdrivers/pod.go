@@ -82,6 +82,11 @@ func (p *SPodDriver) ValidateCreateData(ctx context.Context, userCred mcclient.T
 	if len(input.Pod.Containers) == 0 {
 		return nil, httperrors.NewNotEmptyError("containers data is empty")
 	}
+	if bw := input.Pod.BandwidthLimit; bw != nil {
+		if bw.EgressMbps < 0 || bw.IngressMbps < 0 {
+			return nil, httperrors.NewInputParameterError("invalid bandwidth_limit egress %d Mbps, ingress %d Mbps", bw.EgressMbps, bw.IngressMbps)
+		}
+	}
 	// validate port mappings
 	/*if err := p.validatePortMappings(input.Pod); err != nil {
 		return nil, errors.Wrap(err, "validate port mappings")
@@ -233,7 +233,7 @@ func (self *SGuest) StartGuestSaveImage(ctx context.Context, userCred mcclient.T
 func (self *SGuest) PerformSaveGuestImage(ctx context.Context, userCred mcclient.TokenCredential,
 	query jsonutils.JSONObject, input api.ServerSaveGuestImageInput) (jsonutils.JSONObject, error) {
 
-	if !utils.IsInStringArray(self.Status, []string{api.VM_READY}) {
+	if !utils.IsInStringArray(self.Status, []string{api.VM_READY}) && !(input.Quiesce && self.Status == api.VM_RUNNING) {
 		return nil, httperrors.NewBadRequestError("Cannot save image in status %s", self.Status)
 	}
 	if len(input.Name) == 0 && len(input.GenerateName) == 0 {
@@ -247,6 +247,29 @@ func (self *SGuest) PerformSaveGuestImage(ctx context.Context, userCred mcclient
 	if disks.Root == nil {
 		return nil, httperrors.NewInternalServerError("No root image")
 	}
+	for _, diskId := range input.ExcludeDiskIds {
+		if diskId == disks.Root.Id {
+			return nil, httperrors.NewInputParameterError("root disk %s can't be excluded", diskId)
+		}
+		found := false
+		for _, disk := range disks.Data {
+			if disk.Id == diskId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, httperrors.NewInputParameterError("disk %s is not a data disk of server %s", diskId, self.Name)
+		}
+	}
+	disks = disks.ExcludeDataDisks(input.ExcludeDiskIds)
+	if self.Status == api.VM_RUNNING {
+		// the filesystems can't stay frozen across the batches of disk saves
+		limit := options.Options.GuestSaveImageMaxConcurrency
+		if limit > 0 && limit < len(disks.Data)+1 {
+			return nil, httperrors.NewBadRequestError("Cannot quiesce server with %d disks saved in batches of %d", len(disks.Data)+1, limit)
+		}
+	}
 
 	if len(self.EncryptKeyId) > 0 && (input.EncryptKeyId == nil || len(*input.EncryptKeyId) == 0) {
 		// server encrypted, so image must be encrypted
@@ -323,14 +346,67 @@ func (self *SGuest) PerformSaveGuestImage(ctx context.Context, userCred mcclient
 	}
 	imageIds = append(imageIds, guestImageInfo.RootImage.ID)
 	taskParams := jsonutils.NewDict()
-	if input.AutoStart != nil && *input.AutoStart {
+	if self.Status == api.VM_RUNNING {
+		// the running guest is quiesced instead of being started after the save
+		taskParams.Add(jsonutils.JSONTrue, "quiesce")
+	} else if input.AutoStart != nil && *input.AutoStart {
 		taskParams.Add(jsonutils.JSONTrue, "auto_start")
 	}
 	taskParams.Add(jsonutils.Marshal(imageIds), "image_ids")
+	if len(input.ExcludeDiskIds) > 0 {
+		taskParams.Add(jsonutils.NewStringArray(input.ExcludeDiskIds), "exclude_disk_ids")
+	}
+	taskParams.Add(jsonutils.NewString(guestImageId), "guest_image_id")
 	log.Infof("before StartGuestSaveGuestImage image_ids: %s", imageIds)
 	return nil, self.StartGuestSaveGuestImage(ctx, userCred, taskParams, "")
 }
 
+// 取消保存主机模板
+func (self *SGuest) PerformCancelSaveGuestImage(ctx context.Context, userCred mcclient.TokenCredential,
+	query jsonutils.JSONObject, input api.ServerCancelSaveGuestImageInput) (jsonutils.JSONObject, error) {
+	isOpen := true
+	// saving the images of a guest may take hours
+	tasks, err := taskman.TaskManager.FetchTasksOfObject(self, time.Time{}, &isOpen)
+	if err != nil {
+		return nil, errors.Wrap(err, "FetchTasksOfObject")
+	}
+	saveTasks := make([]iGuestSaveImageTask, 0)
+	for i := range tasks {
+		saveTasks = append(saveTasks, &tasks[i])
+	}
+	// cancelling a guest without a running save task is a no-op
+	return nil, cancelGuestSaveImageTasks(ctx, userCred, query, saveTasks)
+}
+
+// iGuestSaveImageTask is the open task of the guest cancelled by
+// PerformCancelSaveGuestImage.
+type iGuestSaveImageTask interface {
+	GetId() string
+	GetName() string
+	SaveParams(data *jsonutils.JSONDict) error
+	PerformCancel(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input apis.TaskCancelInput) (jsonutils.JSONObject, error)
+}
+
+// cancelGuestSaveImageTasks cancels the GuestSaveGuestImageTask of tasks.
+// The cancelled flag is saved before the cancel, so the task tells the
+// cancel from a failure of the disk saves.
+func cancelGuestSaveImageTasks(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, tasks []iGuestSaveImageTask) error {
+	for _, task := range tasks {
+		if task.GetName() != "GuestSaveGuestImageTask" {
+			continue
+		}
+		params := jsonutils.NewDict()
+		params.Set("cancelled", jsonutils.JSONTrue)
+		if err := task.SaveParams(params); err != nil {
+			return errors.Wrapf(err, "save params of task %s", task.GetId())
+		}
+		if _, err := task.PerformCancel(ctx, userCred, query, apis.TaskCancelInput{}); err != nil {
+			return errors.Wrapf(err, "cancel task %s", task.GetId())
+		}
+	}
+	return nil
+}
+
 func (self *SGuest) StartGuestSaveGuestImage(ctx context.Context, userCred mcclient.TokenCredential, data *jsonutils.JSONDict, parentTaskId string) error {
 	driver, err := self.GetDriver()
 	if err != nil {
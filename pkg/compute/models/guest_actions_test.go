@@ -0,0 +1,83 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/apis"
+	"yunion.io/x/onecloud/pkg/mcclient"
+)
+
+type fakeGuestSaveImageTask struct {
+	id        string
+	name      string
+	cancelErr error
+	// calls records the saved params and the cancel in order
+	calls *[]string
+}
+
+func (t *fakeGuestSaveImageTask) GetId() string {
+	return t.id
+}
+
+func (t *fakeGuestSaveImageTask) GetName() string {
+	return t.name
+}
+
+func (t *fakeGuestSaveImageTask) SaveParams(data *jsonutils.JSONDict) error {
+	*t.calls = append(*t.calls, t.id+" save "+data.String())
+	return nil
+}
+
+func (t *fakeGuestSaveImageTask) PerformCancel(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input apis.TaskCancelInput) (jsonutils.JSONObject, error) {
+	*t.calls = append(*t.calls, t.id+" cancel")
+	return nil, t.cancelErr
+}
+
+func TestCancelGuestSaveImageTasks(t *testing.T) {
+	calls := []string{}
+	tasks := []iGuestSaveImageTask{
+		&fakeGuestSaveImageTask{id: "sync", name: "GuestSyncstatusTask", calls: &calls},
+		&fakeGuestSaveImageTask{id: "save", name: "GuestSaveGuestImageTask", calls: &calls},
+	}
+	if err := cancelGuestSaveImageTasks(context.Background(), nil, nil, tasks); err != nil {
+		t.Fatalf("cancelGuestSaveImageTasks: %v", err)
+	}
+	// only the save task is cancelled, after its cancelled flag is saved
+	want := []string{`save save {"cancelled":true}`, "save cancel"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %v, want %v", calls, want)
+	}
+
+	// no save task is a no-op
+	calls = []string{}
+	if err := cancelGuestSaveImageTasks(context.Background(), nil, nil, tasks[:1]); err != nil || len(calls) > 0 {
+		t.Errorf("got calls %v error %v, want none", calls, err)
+	}
+
+	calls = []string{}
+	tasks = []iGuestSaveImageTask{
+		&fakeGuestSaveImageTask{id: "save", name: "GuestSaveGuestImageTask", cancelErr: errors.ErrInvalidStatus, calls: &calls},
+	}
+	if err := cancelGuestSaveImageTasks(context.Background(), nil, nil, tasks); errors.Cause(err) != errors.ErrInvalidStatus {
+		t.Errorf("got error %v, want %v", err, errors.ErrInvalidStatus)
+	}
+}
@@ -4767,6 +4767,21 @@ func (self *SGuest) CategorizeDisks() SGuestDiskCategory {
 	return diskCat
 }
 
+// ExcludeDataDisks returns the category without the data disks of the ids
+func (cat SGuestDiskCategory) ExcludeDataDisks(diskIds []string) SGuestDiskCategory {
+	if len(diskIds) == 0 {
+		return cat
+	}
+	data := make([]*SDisk, 0, len(cat.Data))
+	for _, disk := range cat.Data {
+		if !utils.IsInStringArray(disk.Id, diskIds) {
+			data = append(data, disk)
+		}
+	}
+	cat.Data = data
+	return cat
+}
+
 type SGuestNicCategory struct {
 	InternalNics []SGuestnetwork
 	ExternalNics []SGuestnetwork
@@ -4968,6 +4968,7 @@ func (hh *SHost) PerformPing(ctx context.Context, userCred mcclient.TokenCredent
 		hh.SetMetadata(ctx, "root_partition_used_capacity_mb", input.RootPartitionUsedCapacityMb, userCred)
 		hh.SetMetadata(ctx, "memory_used_mb", input.MemoryUsedMb, userCred)
 		hh.SetMetadata(ctx, "cpu_usage_percent", input.CpuUsagePercent, userCred)
+		hh.SetMetadata(ctx, "disk_save_in_flight", input.DiskSaveInFlight, userCred)
 
 		guests, _ := hh.GetGuests()
 		for _, guest := range guests {
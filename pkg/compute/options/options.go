@@ -226,6 +226,12 @@ type ComputeOptions struct {
 
 	SaveCloudImageToGlance bool `help:"Auto save cloud vm image to glance" default:"true"`
 
+	// 保存主机镜像时同时保存的磁盘数, 自适应模式下按宿主机CPU使用率减少
+	GuestSaveImageMaxConcurrency      int     `help:"Max disks of a guest saved to image at the same time, 0 means no limit" default:"0"`
+	GuestSaveImageAdaptiveConcurrency bool    `help:"Save less disks of a guest at the same time when the cpu usage of the host is high" default:"false"`
+	GuestSaveImageIdleCpuPercent      float64 `help:"Cpu usage percent of the host under which the disks are saved with the max concurrency in adaptive mode" default:"50"`
+	GuestSaveImageBusyCpuPercent      float64 `help:"Cpu usage percent of the host from which the disks are saved one by one in adaptive mode" default:"90"`
+
 	ResourceExpiredNotifyDays []int `help:"The notify of resource expired" default:"1,3,30"`
 
 	SkipSyncHostConfigInfoProviders    string `help:"Skip sync host cpu and mem config by provider"`
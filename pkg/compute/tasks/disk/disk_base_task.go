@@ -18,6 +18,7 @@ import (
 	"context"
 
 	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
 
 	"yunion.io/x/onecloud/pkg/cloudcommon/db/quotas"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db/taskman"
@@ -46,16 +47,54 @@ func (self *SDiskBaseTask) finalReleasePendingUsage(ctx context.Context) {
 	}
 }
 
+// CleanHostSchedCache clears the sched cache of the storages of the disk
+// synchronously. Use it when the following scheduling depends on the
+// changed capacity, e.g. after a disk is created or resized, where the
+// next disk may be scheduled right after the task stage completes.
 func (self *SDiskBaseTask) CleanHostSchedCache(disk *models.SDisk) {
+	clearStoragesSchedCache(diskSchedCacheStorages(disk))
+}
+
+// CleanHostSchedCacheAsync clears the sched cache of the storages of the
+// disk in background without blocking the task, the errors are logged only.
+// Use it when the clear is not on the critical path, e.g. after a disk is
+// deleted, where a stale cache only underestimates the free capacity.
+func (self *SDiskBaseTask) CleanHostSchedCacheAsync(disk *models.SDisk) {
+	clearStoragesSchedCacheAsync(diskSchedCacheStorages(disk))
+}
+
+type iSchedCacheStorage interface {
+	GetId() string
+	ClearSchedDescCache() error
+}
+
+// diskSchedCacheStorages returns the storage and the backup storage of the disk
+func diskSchedCacheStorages(disk *models.SDisk) []iSchedCacheStorage {
 	storage, _ := disk.GetStorage()
 	if storage == nil {
-		return
+		return nil
 	}
-	storage.ClearSchedDescCache()
+	storages := []iSchedCacheStorage{storage}
 	if len(disk.BackupStorageId) > 0 {
 		bkStorage := models.StorageManager.FetchStorageById(disk.BackupStorageId)
 		if bkStorage != nil {
-			bkStorage.ClearSchedDescCache()
+			storages = append(storages, bkStorage)
 		}
 	}
+	return storages
+}
+
+func clearStoragesSchedCache(storages []iSchedCacheStorage) {
+	for _, storage := range storages {
+		if err := storage.ClearSchedDescCache(); err != nil {
+			log.Errorf("storage %s ClearSchedDescCache: %v", storage.GetId(), err)
+		}
+	}
+}
+
+func clearStoragesSchedCacheAsync(storages []iSchedCacheStorage) {
+	if len(storages) == 0 {
+		return
+	}
+	go clearStoragesSchedCache(storages)
 }
@@ -0,0 +1,86 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package disk
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSchedCacheStorage struct {
+	id      string
+	err     error
+	cleared *[]string
+	lock    *sync.Mutex
+	wg      *sync.WaitGroup
+}
+
+func (s *fakeSchedCacheStorage) GetId() string {
+	return s.id
+}
+
+func (s *fakeSchedCacheStorage) ClearSchedDescCache() error {
+	s.lock.Lock()
+	*s.cleared = append(*s.cleared, s.id)
+	s.lock.Unlock()
+	s.wg.Done()
+	return s.err
+}
+
+func TestClearStoragesSchedCache(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		t.Run(fmt.Sprintf("async=%v", async), func(t *testing.T) {
+			cleared := []string{}
+			lock := &sync.Mutex{}
+			wg := &sync.WaitGroup{}
+			storages := []iSchedCacheStorage{}
+			for _, id := range []string{"storage", "backup-storage"} {
+				wg.Add(1)
+				storages = append(storages, &fakeSchedCacheStorage{
+					id:      id,
+					err:     fmt.Errorf("clear %s failed", id),
+					cleared: &cleared,
+					lock:    lock,
+					wg:      wg,
+				})
+			}
+			if async {
+				clearStoragesSchedCacheAsync(storages)
+				done := make(chan struct{})
+				go func() {
+					wg.Wait()
+					close(done)
+				}()
+				select {
+				case <-done:
+				case <-time.After(5 * time.Second):
+					t.Fatal("storages are not cleared in background")
+				}
+			} else {
+				clearStoragesSchedCache(storages)
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			sort.Strings(cleared)
+			// an error of a storage doesn't stop clearing the others
+			if fmt.Sprint(cleared) != "[backup-storage storage]" {
+				t.Errorf("cleared storages %v", cleared)
+			}
+		})
+	}
+}
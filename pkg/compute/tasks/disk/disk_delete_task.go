@@ -214,7 +214,7 @@ func (self *DiskDeleteTask) OnGuestDiskDeleteComplete(ctx context.Context, obj d
 	}
 
 	disk := obj.(*models.SDisk)
-	self.CleanHostSchedCache(disk)
+	self.CleanHostSchedCacheAsync(disk)
 	db.OpsLog.LogEvent(disk, db.ACT_DELOCATE, disk.GetShortDesc(ctx), self.UserCred)
 	notifyclient.EventNotify(ctx, self.UserCred, notifyclient.SEventNotifyParam{
 		Obj:    disk,
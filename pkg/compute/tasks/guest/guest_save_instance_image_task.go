@@ -16,14 +16,30 @@ package guest
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
 
 	"yunion.io/x/jsonutils"
 	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/util/httputils"
 
 	api "yunion.io/x/onecloud/pkg/apis/compute"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db/lockman"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db/taskman"
 	"yunion.io/x/onecloud/pkg/compute/models"
+	"yunion.io/x/onecloud/pkg/compute/options"
+	"yunion.io/x/onecloud/pkg/mcclient/auth"
+	"yunion.io/x/onecloud/pkg/mcclient/modules/image"
 	"yunion.io/x/onecloud/pkg/util/logclient"
 )
 
@@ -40,25 +56,284 @@ func (self *GuestSaveGuestImageTask) OnInit(ctx context.Context, obj db.IStandal
 	guest := obj.(*models.SGuest)
 
 	self.SetStage("OnSaveRootImageComplete", nil)
-	disks := guest.CategorizeDisks()
+	excludeDiskIds := []string{}
+	self.Params.Unmarshal(&excludeDiskIds, "exclude_disk_ids")
+	// the image ids are allocated for the disks not excluded, in the same order
+	disks := guest.CategorizeDisks().ExcludeDataDisks(excludeDiskIds)
 	imageIds := []string{}
 	self.Params.Unmarshal(&imageIds, "image_ids")
 	self.Params.Remove("image_ids")
 
-	// data disk
+	// the data disks are saved before the root disk
+	jobs := make([]diskSaveJob, 0, len(disks.Data)+1)
 	for index, dataDisk := range disks.Data {
-		params := jsonutils.DeepCopy(self.Params).(*jsonutils.JSONDict)
-		params.Add(jsonutils.NewString(imageIds[index]), "image_id")
-		opts := api.DiskSaveInput{ImageId: imageIds[index]}
-		if err := dataDisk.StartDiskSaveTask(ctx, self.UserCred, opts, self.GetTaskId()); err != nil {
-			self.taskFailed(ctx, guest, jsonutils.NewString(err.Error()))
+		jobs = append(jobs, diskSaveJob{DiskId: dataDisk.Id, ImageId: imageIds[index]})
+	}
+	rootImageId := imageIds[len(imageIds)-1]
+	self.Params.Add(jsonutils.NewString(rootImageId), "image_id")
+	jobs = append(jobs, diskSaveJob{DiskId: disks.Root.Id, ImageId: rootImageId})
+	limit := self.diskSaveConcurrency(ctx, guest, len(jobs))
+	if jsonutils.QueryBoolean(self.Params, "quiesce", false) {
+		// the running guest is never saved live
+		if limit > 0 && limit < len(jobs) {
+			// the filesystems can't stay frozen across the batches
+			self.quiesceFailed(ctx, guest, fmt.Errorf("%d disks are saved in batches of %d", len(jobs), limit))
+			return
+		}
+		if err := self.quiesceGuest(ctx, guest); err != nil {
+			self.quiesceFailed(ctx, guest, err)
+			return
 		}
 	}
+	self.startDiskSaves(ctx, guest, jobs, limit)
+}
 
-	self.Params.Add(jsonutils.NewString(imageIds[len(imageIds)-1]), "image_id")
-	opts := api.DiskSaveInput{ImageId: imageIds[len(imageIds)-1]}
-	if err := disks.Root.StartDiskSaveTask(ctx, self.UserCred, opts, self.GetTaskId()); err != nil {
-		self.taskFailed(ctx, guest, jsonutils.NewString(err.Error()))
+// guestFsFreezeTimeoutSeconds is the timeout of the freeze and thaw of the
+// filesystems by the guest agent
+const guestFsFreezeTimeoutSeconds = 30
+
+// quiesceGuest freezes the filesystems of the running guest by the guest
+// agent so the disks are saved consistently. The filesystems are thawed once
+// the hosts have copied the disks, before the copies are uploaded, since the
+// disk save tasks resume this task as soon as their backup step completes.
+func (self *GuestSaveGuestImageTask) quiesceGuest(ctx context.Context, guest *models.SGuest) error {
+	err := self.requestGuestFsFreeze(ctx, guest, "guest-fsfreeze-freeze")
+	if err != nil {
+		return errors.Wrap(err, "quiesce")
+	}
+	params := jsonutils.NewDict()
+	params.Set("quiesced", jsonutils.JSONTrue)
+	self.SaveParams(params)
+	logclient.AddActionLogWithStartable(self, guest, logclient.ACT_IMAGE_SAVE, "filesystems quiesced", self.UserCred, true)
+	return nil
+}
+
+// quiesceFailed fails the save of the running guest which can't be quiesced
+// before any disk is saved, the allocated guest image is deleted.
+func (self *GuestSaveGuestImageTask) quiesceFailed(ctx context.Context, guest *models.SGuest, err error) {
+	log.Errorf("quiesce guest %s failed: %v", guest.Name, err)
+	self.deleteGuestImage(ctx, guest)
+	self.taskFailed(ctx, guest, jsonutils.NewString(errors.Wrap(err, "unable to quiesce running server").Error()))
+}
+
+// thawGuest thaws the filesystems frozen by quiesceGuest.
+func (self *GuestSaveGuestImageTask) thawGuest(ctx context.Context, guest *models.SGuest) {
+	if !jsonutils.QueryBoolean(self.Params, "quiesced", false) {
+		return
+	}
+	err := self.requestGuestFsFreeze(ctx, guest, "guest-fsfreeze-thaw")
+	if err != nil {
+		log.Errorf("thaw guest %s failed: %v", guest.Name, err)
+		logclient.AddActionLogWithStartable(self, guest, logclient.ACT_IMAGE_SAVE, errors.Wrap(err, "thaw failed"), self.UserCred, false)
+		return
+	}
+	params := jsonutils.NewDict()
+	params.Set("quiesced", jsonutils.JSONFalse)
+	self.SaveParams(params)
+}
+
+func (self *GuestSaveGuestImageTask) requestGuestFsFreeze(ctx context.Context, guest *models.SGuest, command string) error {
+	host, err := guest.GetHost()
+	if err != nil {
+		return errors.Wrap(err, "GetHost")
+	}
+	drv, err := guest.GetDriver()
+	if err != nil {
+		return errors.Wrap(err, "GetDriver")
+	}
+	input := api.ServerQgaCommandInput{
+		Command: jsonutils.Marshal(map[string]string{"execute": command}).String(),
+	}
+	input.Timeout = guestFsFreezeTimeoutSeconds
+	_, err = drv.RequestQgaCommand(ctx, self.UserCred, jsonutils.Marshal(input), host, guest)
+	if err != nil {
+		return errors.Wrap(err, command)
+	}
+	return nil
+}
+
+// diskSaveJob is the save of a disk, which is queued in the params of the
+// task until the saves started before it complete.
+type diskSaveJob struct {
+	DiskId  string `json:"disk_id"`
+	ImageId string `json:"image_id"`
+}
+
+// startDiskSaves starts the saves of the disks up to the concurrency cap
+// limit, 0 means no limit, the rest are queued and started once the started
+// ones complete.
+func (self *GuestSaveGuestImageTask) startDiskSaves(ctx context.Context, guest *models.SGuest, jobs []diskSaveJob, limit int) {
+	batch := len(jobs)
+	if limit > 0 && limit < batch {
+		batch = limit
+	}
+	// the queue is saved before the saves are started, which may complete
+	// and resume the task at once
+	params := jsonutils.NewDict()
+	params.Set("disk_save_queue", jsonutils.Marshal(jobs[batch:]))
+	self.SaveParams(params)
+
+	for _, job := range jobs[:batch] {
+		if self.isCancelled() {
+			// stop launching new disk saves, the ones started are cancelled
+			// along with the task
+			self.taskCancelled(ctx, guest)
+			return
+		}
+		diskObj, err := models.DiskManager.FetchById(job.DiskId)
+		if err != nil {
+			self.taskFailed(ctx, guest, jsonutils.NewString(errors.Wrapf(err, "fetch disk %s", job.DiskId).Error()))
+			return
+		}
+		opts := api.DiskSaveInput{ImageId: job.ImageId}
+		if err := self.startDiskSaveTask(ctx, diskObj.(*models.SDisk), opts); err != nil {
+			if errors.Cause(err) == errDiskSaveCancelled {
+				self.taskCancelled(ctx, guest)
+			} else {
+				self.taskFailed(ctx, guest, jsonutils.NewString(err.Error()))
+			}
+			return
+		}
+	}
+}
+
+// diskSaveConcurrency returns how many disks of the guest are saved at the
+// same time, 0 means no limit.
+//
+// It's GuestSaveImageMaxConcurrency by default. In the adaptive mode the
+// cap is reduced by the cpu usage of the host of the guest, which is
+// reported by the host on ping as the cpu_usage_percent metadata. The io
+// load isn't reported to the region, the host queues the disk saves by
+// its DiskSaveWorkerCount though, and reports the running ones on ping as
+// the disk_save_in_flight metadata. The cap isn't reduced if the usage isn't
+// reported.
+func (self *GuestSaveGuestImageTask) diskSaveConcurrency(ctx context.Context, guest *models.SGuest, disks int) int {
+	limit := options.Options.GuestSaveImageMaxConcurrency
+	if !options.Options.GuestSaveImageAdaptiveConcurrency {
+		return limit
+	}
+	if limit <= 0 || limit > disks {
+		limit = disks
+	}
+	host, err := guest.GetHost()
+	if err != nil {
+		log.Warningf("get host of guest %s: %v, save disks with concurrency %d", guest.Name, err, limit)
+		return limit
+	}
+	usage, err := strconv.ParseFloat(host.GetMetadata(ctx, "cpu_usage_percent", self.UserCred), 64)
+	if err != nil {
+		return limit
+	}
+	ret := adaptiveDiskSaveConcurrency(limit, usage, options.Options.GuestSaveImageIdleCpuPercent, options.Options.GuestSaveImageBusyCpuPercent)
+	log.Infof("host %s cpu usage %.1f%%, save %d disks of guest %s with concurrency %d", host.Name, usage, disks, guest.Name, ret)
+	return ret
+}
+
+// adaptiveDiskSaveConcurrency keeps the max concurrency while the cpu usage
+// is below idle, saves the disks one by one from busy on and decreases the
+// concurrency linearly in between.
+func adaptiveDiskSaveConcurrency(max int, usage, idle, busy float64) int {
+	switch {
+	case max <= 1 || usage < idle:
+		return max
+	case usage >= busy:
+		return 1
+	}
+	ret := max - int(float64(max-1)*(usage-idle)/(busy-idle)+0.5)
+	if ret < 1 {
+		ret = 1
+	}
+	return ret
+}
+
+var (
+	// diskSaveStartRetries is the number of retries of starting a disk save
+	// task failed with a transient error
+	diskSaveStartRetries = 3
+	// diskSaveStartRetryBackoff is the backoff before the first retry, it's
+	// doubled for each further retry
+	diskSaveStartRetryBackoff = time.Second
+)
+
+// errDiskSaveCancelled stops the retries once the task is cancelled
+const errDiskSaveCancelled = errors.Error("disk save cancelled")
+
+// the mysql error numbers of lock wait timeout and deadlock
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrLockDeadlock    = 1213
+)
+
+// isTransientDiskSaveError tells whether starting a disk save task may
+// succeed on retry, e.g. the database or the storage is momentarily busy.
+// The other errors, e.g. bad params or missing storage, fail fast.
+func isTransientDiskSaveError(err error) bool {
+	cause := errors.Cause(err)
+	switch cause {
+	case driver.ErrBadConn, sql.ErrConnDone, io.ErrUnexpectedEOF, context.DeadlineExceeded:
+		return true
+	}
+	if netErr, ok := cause.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	switch httpErrorCode(err) {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	if myErr, ok := cause.(*mysql.MySQLError); ok {
+		switch myErr.Number {
+		case mysqlErrLockWaitTimeout, mysqlErrLockDeadlock:
+			return true
+		}
+	}
+	return false
+}
+
+// httpErrorCode returns the code of the http error in the chain of err, -1
+// if there's none. The http error has its own cause, which is the class of
+// the error, so it's lost by errors.Cause.
+func httpErrorCode(err error) int {
+	for err != nil {
+		if code := httputils.ErrorCode(err); code > 0 {
+			return code
+		}
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = causer.Cause()
+	}
+	return -1
+}
+
+// startDiskSaveTask starts the save task of the disk, retrying with backoff
+// on transient errors so a brief storage contention doesn't abort the save
+// of all the disks. The backoff is cut short once ctx is done.
+func (self *GuestSaveGuestImageTask) startDiskSaveTask(ctx context.Context, disk *models.SDisk, opts api.DiskSaveInput) error {
+	backoff := diskSaveStartRetryBackoff
+	for retry := 0; ; retry++ {
+		err := disk.StartDiskSaveTask(ctx, self.UserCred, opts, self.GetTaskId())
+		if err == nil {
+			return nil
+		}
+		if !isTransientDiskSaveError(err) {
+			return errors.Wrapf(err, "StartDiskSaveTask %s", disk.Name)
+		}
+		if retry >= diskSaveStartRetries {
+			return errors.Wrapf(err, "StartDiskSaveTask %s after %d retries", disk.Name, retry)
+		}
+		log.Warningf("StartDiskSaveTask %s failed with transient error, retry %d/%d in %s: %v", disk.Name, retry+1, diskSaveStartRetries, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrapf(ctx.Err(), "StartDiskSaveTask %s retry %d", disk.Name, retry+1)
+		case <-timer.C:
+		}
+		backoff *= 2
+		if self.isCancelled() {
+			return errDiskSaveCancelled
+		}
 	}
 }
 
@@ -73,7 +348,20 @@ func (self *GuestSaveGuestImageTask) OnSaveRootImageComplete(ctx context.Context
 		return
 	}
 
-	if restart, _ := self.GetParams().Bool("auto_start"); restart {
+	queue := []diskSaveJob{}
+	self.Params.Unmarshal(&queue, "disk_save_queue")
+	if len(queue) > 0 {
+		self.startDiskSaves(ctx, guest, queue, self.diskSaveConcurrency(ctx, guest, len(queue)))
+		return
+	}
+
+	if jsonutils.QueryBoolean(self.Params, "quiesce", false) {
+		// the guest has been running during the save, the disks have been
+		// copied and are uploaded by the hosts in the background
+		self.thawGuest(ctx, guest)
+		guest.StartSyncstatus(ctx, self.UserCred, "")
+		self.taskSuc(ctx, guest)
+	} else if restart, _ := self.GetParams().Bool("auto_start"); restart {
 		self.SetStage("OnStartServerComplete", nil)
 		guest.StartGueststartTask(ctx, self.GetUserCred(), nil, self.GetTaskId())
 	} else {
@@ -83,10 +371,77 @@ func (self *GuestSaveGuestImageTask) OnSaveRootImageComplete(ctx context.Context
 }
 
 func (self *GuestSaveGuestImageTask) OnSaveRootImageCompleteFailed(ctx context.Context, guest *models.SGuest, data jsonutils.JSONObject) {
+	if self.isCancelled() {
+		self.taskCancelled(ctx, guest)
+		return
+	}
 	log.Errorf("Guest save image failed: %s", data.PrettyString())
 	self.taskFailed(ctx, guest, data)
 }
 
+// isCancelled reloads the params of the task since the cancel flag is set
+// by PerformCancelSaveGuestImage out of the task.
+func (self *GuestSaveGuestImageTask) isCancelled() bool {
+	task := taskman.TaskManager.FetchTaskById(self.GetTaskId())
+	if task == nil {
+		return false
+	}
+	return jsonutils.QueryBoolean(task.GetParams(), "cancelled", false)
+}
+
+// markCancelHandled records the cancel_handled param of the task, it returns
+// false if the cancel has been handled already.
+func (self *GuestSaveGuestImageTask) markCancelHandled(ctx context.Context) bool {
+	lockman.LockRawObject(ctx, taskman.TaskManager.Keyword(), self.GetTaskId())
+	defer lockman.ReleaseRawObject(ctx, taskman.TaskManager.Keyword(), self.GetTaskId())
+
+	task := taskman.TaskManager.FetchTaskById(self.GetTaskId())
+	if task != nil && jsonutils.QueryBoolean(task.GetParams(), "cancel_handled", false) {
+		return false
+	}
+	params := jsonutils.NewDict()
+	params.Set("cancel_handled", jsonutils.JSONTrue)
+	self.SaveParams(params)
+	return true
+}
+
+// deleteGuestImage deletes the partially saved guest image.
+func (self *GuestSaveGuestImageTask) deleteGuestImage(ctx context.Context, guest *models.SGuest) {
+	guestImageId, _ := self.GetParams().GetString("guest_image_id")
+	if len(guestImageId) == 0 {
+		return
+	}
+	s := auth.GetAdminSession(ctx, options.Options.Region)
+	params := jsonutils.NewDict()
+	params.Set("override_pending_delete", jsonutils.JSONTrue)
+	if _, err := image.GuestImages.Delete(s, guestImageId, params); err != nil && httputils.ErrorCode(err) != 404 {
+		log.Errorf("delete partial guest image %s of guest %s: %v", guestImageId, guest.Name, err)
+	}
+}
+
+// taskCancelled deletes the partially saved guest image and brings the guest
+// back to ready, or thaws the guest saved while running. The in-flight disk
+// saves are cancelled along with the task, the failure of each of them
+// resumes the task, so only the first call is handled. The cancel doesn't
+// reach the hosts, a disk copy or upload already running on a host goes on
+// until it completes and its result is discarded along with the image.
+func (self *GuestSaveGuestImageTask) taskCancelled(ctx context.Context, guest *models.SGuest) {
+	if !self.markCancelHandled(ctx) {
+		return
+	}
+	self.deleteGuestImage(ctx, guest)
+	reason := jsonutils.NewString("cancelled by user")
+	if jsonutils.QueryBoolean(self.Params, "quiesce", false) {
+		self.thawGuest(ctx, guest)
+		guest.StartSyncstatus(ctx, self.UserCred, "")
+	} else {
+		guest.SetStatus(ctx, self.UserCred, api.VM_READY, reason.String())
+	}
+	db.OpsLog.LogEvent(guest, db.ACT_GUEST_SAVE_GUEST_IMAGE_FAIL, reason, self.UserCred)
+	logclient.AddActionLogWithStartable(self, guest, logclient.ACT_IMAGE_SAVE, reason, self.UserCred, false)
+	self.SetStageFailed(ctx, reason)
+}
+
 func (self *GuestSaveGuestImageTask) OnStartServerComplete(ctx context.Context, guest *models.SGuest, data jsonutils.JSONObject) {
 	self.taskSuc(ctx, guest)
 }
@@ -101,8 +456,13 @@ func (self *GuestSaveGuestImageTask) taskSuc(ctx context.Context, guest *models.
 }
 
 func (self *GuestSaveGuestImageTask) taskFailed(ctx context.Context, guest *models.SGuest, reason jsonutils.JSONObject) {
-
-	guest.SetStatus(ctx, self.UserCred, api.VM_SAVE_DISK_FAILED, reason.String())
+	if jsonutils.QueryBoolean(self.Params, "quiesce", false) {
+		// the running guest is left running
+		self.thawGuest(ctx, guest)
+		guest.StartSyncstatus(ctx, self.UserCred, "")
+	} else {
+		guest.SetStatus(ctx, self.UserCred, api.VM_SAVE_DISK_FAILED, reason.String())
+	}
 	db.OpsLog.LogEvent(guest, db.ACT_GUEST_SAVE_GUEST_IMAGE_FAIL, reason, self.UserCred)
 	logclient.AddActionLogWithStartable(self, guest, logclient.ACT_IMAGE_SAVE, reason, self.UserCred, false)
 
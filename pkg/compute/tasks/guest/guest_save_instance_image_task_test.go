@@ -0,0 +1,56 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package guest
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/util/httputils"
+)
+
+func TestIsTransientDiskSaveError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "bad conn", err: driver.ErrBadConn, want: true},
+		{name: "wrapped bad conn", err: errors.Wrap(driver.ErrBadConn, "StartDiskSaveTask"), want: true},
+		{name: "deadline", err: context.DeadlineExceeded, want: true},
+		{name: "net timeout", err: &net.DNSError{Err: "timeout", IsTimeout: true}, want: true},
+		{name: "net error", err: &net.DNSError{Err: "no such host"}, want: false},
+		{name: "service unavailable", err: httputils.NewJsonClientError(http.StatusServiceUnavailable, "ServiceUnavailable", "busy"), want: true},
+		{name: "wrapped gateway timeout", err: errors.Wrap(httputils.NewJsonClientError(http.StatusGatewayTimeout, "GatewayTimeout", "timeout"), "request"), want: true},
+		{name: "bad request", err: httputils.NewJsonClientError(http.StatusBadRequest, "InputParameterError", "bad"), want: false},
+		{name: "lock wait timeout", err: &mysql.MySQLError{Number: mysqlErrLockWaitTimeout, Message: "Lock wait timeout exceeded"}, want: true},
+		{name: "wrapped deadlock", err: errors.Wrap(&mysql.MySQLError{Number: mysqlErrLockDeadlock, Message: "Deadlock found"}, "update"), want: true},
+		{name: "duplicate entry", err: &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, want: false},
+		// only the error number of mysql counts, not the message
+		{name: "message only", err: errors.Error("Error 1205: Lock wait timeout exceeded"), want: false},
+		{name: "other", err: errors.ErrNotFound, want: false},
+	}
+	for _, c := range cases {
+		if got := isTransientDiskSaveError(c.err); got != c.want {
+			t.Errorf("%s: isTransientDiskSaveError(%v) = %v, want %v", c.name, c.err, got, c.want)
+		}
+	}
+}
@@ -163,11 +163,19 @@ func (obj *SDevtoolTemplate) Binding(ctx context.Context, userCred mcclient.Toke
 
 	newCronjobParams := jsonutils.NewDict()
 	newCronjobParams.Add(jsonutils.NewString(newCronjobName), "name")
-	newCronjobParams.Add(jsonutils.NewInt(int64(template.Day)), "day")
-	newCronjobParams.Add(jsonutils.NewInt(int64(template.Hour)), "hour")
-	newCronjobParams.Add(jsonutils.NewInt(int64(template.Min)), "min")
-	newCronjobParams.Add(jsonutils.NewInt(int64(template.Sec)), "sec")
-	newCronjobParams.Add(jsonutils.NewInt(int64(template.Interval)), "interval")
+	// interval and day/hour/min/sec are mutually exclusive, only send the
+	// ones the template schedules by
+	if template.Interval > 0 {
+		newCronjobParams.Add(jsonutils.NewInt(template.Interval), "interval")
+	} else {
+		newCronjobParams.Add(jsonutils.NewInt(int64(template.Day)), "day")
+		newCronjobParams.Add(jsonutils.NewInt(int64(template.Hour)), "hour")
+		newCronjobParams.Add(jsonutils.NewInt(int64(template.Min)), "min")
+		newCronjobParams.Add(jsonutils.NewInt(int64(template.Sec)), "sec")
+	}
+	if template.TimeZone != "" {
+		newCronjobParams.Add(jsonutils.NewString(template.TimeZone), "time_zone")
+	}
 	newCronjobParams.Add(jsonutils.NewBool(template.Start), "start")
 	newCronjobParams.Add(jsonutils.NewBool(template.Enabled), "enabled")
 	newCronjobParams.Add(jsonutils.NewString(ansibleId), "ansible_playbook_id")
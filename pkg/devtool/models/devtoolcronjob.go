@@ -16,18 +16,27 @@ package models
 
 import (
 	"context"
+	"database/sql"
+	"os"
+	"strings"
 	"time"
 
 	"yunion.io/x/jsonutils"
 	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/util/httputils"
+	"yunion.io/x/sqlchemy"
 
+	api "yunion.io/x/onecloud/pkg/apis/devtool"
 	"yunion.io/x/onecloud/pkg/cloudcommon/cronman"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db/taskman"
 	"yunion.io/x/onecloud/pkg/devtool/options"
+	"yunion.io/x/onecloud/pkg/httperrors"
 	"yunion.io/x/onecloud/pkg/mcclient"
 	"yunion.io/x/onecloud/pkg/mcclient/auth"
 	"yunion.io/x/onecloud/pkg/mcclient/modules/ansible"
+	"yunion.io/x/onecloud/pkg/mcclient/modules/compute"
 )
 
 type SVSCronjob struct {
@@ -38,20 +47,47 @@ type SVSCronjob struct {
 	Interval int64 `nullable:"true" create:"optional" list:"user" update:"user" default:"0"`
 	Start    bool  `nullable:"true" create:"optional" list:"user" update:"user" default:"false"`
 	Enabled  bool  `nullable:"true" create:"optional" list:"user" update:"user" default:"false"`
+	// TimeZone is the time zone of Hour:Min:Sec, e.g. Asia/Shanghai, empty
+	// means the time zone of the service
+	TimeZone string `width:"64" charset:"ascii" nullable:"true" create:"optional" list:"user" update:"user"`
 }
 
 type SCronjob struct {
 	SVSCronjob
-	AnsiblePlaybookID string `width:"36" nullable:"false" create:"required" index:"true" list:"user" update:"user"`
+	// AnsiblePlaybookID is empty for the cronjob of a template without
+	// server, which runs the playbooks of the servers of the template
+	AnsiblePlaybookID string `width:"36" nullable:"true" create:"optional" index:"true" list:"user" update:"user"`
 	TemplateID        string `width:"36" nullable:"true" create:"optional" index:"true" list:"user" update:"user"`
 	ServerID          string `width:"36" nullable:"true" create:"optional" index:"true" list:"user" update:"user"`
+	// ExtraVars is passed as the body of the ansible playbook run action,
+	// the {{ var }} templates in its string values are expanded on each run
+	ExtraVars jsonutils.JSONObject `nullable:"true" create:"optional" list:"user" update:"user"`
+	// RunCount is the number of the runs, which is the seq of the last run
+	RunCount int64 `nullable:"false" default:"0" list:"user"`
+	// LastRunAt is when the cronjob is last run, by the schedule or the run
+	// action
+	LastRunAt time.Time `nullable:"true" list:"user"`
+	// NextRunAt is when the cronjob runs next by the schedule, empty if
+	// it's not scheduled
+	NextRunAt time.Time `nullable:"true" list:"user"`
+	// Paused is set by PauseByTenant, the enabled cronjob isn't scheduled
+	// until ResumeByTenant
+	Paused bool `nullable:"false" default:"false" list:"user"`
 	db.SVirtualResourceBase
+
+	// startTurnedOn is set by PreUpdate when the update turns Start on, so
+	// PostUpdate fires the job once
+	startTurnedOn bool
 }
 
 type SCronjobManager struct {
 	db.SVirtualResourceBaseManager
 }
 
+const (
+	minTaskArchiveIntervalMinutes = 5
+)
+
 var (
 	CronjobManager     *SCronjobManager
 	DevToolCronManager *cronman.SCronJobManager
@@ -69,49 +105,683 @@ func init() {
 	CronjobManager.SetVirtualObject(CronjobManager)
 }
 
-func RunAnsibleCronjob(id string, s *mcclient.ClientSession) cronman.TCronJobFunction {
-	return func(ctx context.Context, userCred mcclient.TokenCredential, isStart bool) {
+// validateCronjobSchedule checks the schedule fields of a cronjob, a cronjob
+// runs either every Interval seconds or every Day days at Hour:Min:Sec, so
+// they are mutually exclusive, and one of Interval and Day must be set or
+// the cronjob never runs. A positive interval must be at least minInterval,
+// zero skips the check.
+func validateCronjobSchedule(day, hour, min, sec int, interval int64, minInterval int64) error {
+	switch {
+	case day < 0:
+		return httperrors.NewOutOfRangeError("day must be >= 0, got %d", day)
+	case hour < 0 || hour > 23:
+		return httperrors.NewOutOfRangeError("hour must be in [0, 23], got %d", hour)
+	case min < 0 || min > 59:
+		return httperrors.NewOutOfRangeError("min must be in [0, 59], got %d", min)
+	case sec < 0 || sec > 59:
+		return httperrors.NewOutOfRangeError("sec must be in [0, 59], got %d", sec)
+	case interval < 0:
+		return httperrors.NewOutOfRangeError("interval must be >= 0, got %d", interval)
+	}
+	if interval == 0 && day == 0 {
+		return httperrors.NewInputParameterError("either interval or day must be set, the cronjob never runs otherwise")
+	}
+	if interval > 0 && (day > 0 || hour > 0 || min > 0 || sec > 0) {
+		return httperrors.NewInputParameterError("interval and day/hour/min/sec are mutually exclusive, the cronjob runs either every interval seconds or every day days at hour:min:sec, set the others to 0")
+	}
+	if interval > 0 && interval < minInterval {
+		return httperrors.NewOutOfRangeError("interval must be >= %d, got %d", minInterval, interval)
+	}
+	return nil
+}
+
+// loadCronjobTimeZone loads the time zone of a cronjob, nil for the empty
+// one, which is the time zone of DevToolCronManager.
+func loadCronjobTimeZone(timeZone string) (*time.Location, error) {
+	if timeZone == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, httperrors.NewInputParameterError("unknown time_zone %q: %v", timeZone, err)
+	}
+	return loc, nil
+}
+
+// getDanglingCronjobReferences returns the names of the references of a
+// cronjob which don't exist, empty references are skipped.
+func getDanglingCronjobReferences(ctx context.Context, playbookId, templateId, serverId string) ([]string, error) {
+	// the template is local, only the playbook and the server need a session
+	var s *mcclient.ClientSession
+	if playbookId != "" || serverId != "" {
+		s = auth.GetAdminSession(ctx, "")
+	}
+	dangling := []string{}
+	isDangling := func(err error) (bool, error) {
+		if err == nil {
+			return false, nil
+		}
+		if httputils.ErrorCode(err) == 404 || errors.Cause(err) == sql.ErrNoRows {
+			return true, nil
+		}
+		return false, err
+	}
+	if playbookId != "" {
+		_, err := ansible.AnsiblePlaybooks.Get(s, playbookId, nil)
+		if ok, err := isDangling(err); err != nil {
+			return nil, errors.Wrapf(err, "get ansible playbook %s", playbookId)
+		} else if ok {
+			dangling = append(dangling, "ansible_playbook_id")
+		}
+	}
+	if templateId != "" {
+		_, err := DevtoolTemplateManager.FetchById(templateId)
+		if ok, err := isDangling(err); err != nil {
+			return nil, errors.Wrapf(err, "fetch template %s", templateId)
+		} else if ok {
+			dangling = append(dangling, "template_id")
+		}
+	}
+	if serverId != "" {
+		_, err := compute.Servers.Get(s, serverId, nil)
+		if ok, err := isDangling(err); err != nil {
+			return nil, errors.Wrapf(err, "get server %s", serverId)
+		} else if ok {
+			dangling = append(dangling, "server_id")
+		}
+	}
+	return dangling, nil
+}
+
+// validateCronjobPlaybook rejects a cronjob whose ansible playbook doesn't
+// exist, such a cronjob can never succeed.
+func validateCronjobPlaybook(ctx context.Context, playbookId string) error {
+	if playbookId == "" {
+		return httperrors.NewMissingParameterError("ansible_playbook_id")
+	}
+	dangling, err := getDanglingCronjobReferences(ctx, playbookId, "", "")
+	if err != nil {
+		return errors.Wrap(err, "getDanglingCronjobReferences")
+	}
+	if len(dangling) > 0 {
+		return httperrors.NewResourceNotFoundError2("ansible playbook", playbookId)
+	}
+	return nil
+}
+
+// validateCronjobDevtoolTemplate rejects a cronjob of a template which doesn't exist.
+func validateCronjobDevtoolTemplate(ctx context.Context, templateId string) error {
+	dangling, err := getDanglingCronjobReferences(ctx, "", templateId, "")
+	if err != nil {
+		return errors.Wrap(err, "getDanglingCronjobReferences")
+	}
+	if len(dangling) > 0 {
+		return httperrors.NewResourceNotFoundError2("devtool template", templateId)
+	}
+	return nil
+}
+
+// validateCronjobExtraVars requires the extra vars to be a json object, a
+// string of a json object is parsed. The templates must refer to the known
+// variables only.
+func validateCronjobExtraVars(extraVars jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	if extraVars == nil || extraVars == jsonutils.JSONNull {
+		return nil, nil
+	}
+	if str, ok := extraVars.(*jsonutils.JSONString); ok {
+		content, _ := str.GetString()
+		parsed, err := jsonutils.ParseString(content)
+		if err != nil {
+			return nil, httperrors.NewInputParameterError("invalid extra_vars %q: %v", content, err)
+		}
+		extraVars = parsed
+	}
+	if _, ok := extraVars.(*jsonutils.JSONDict); !ok {
+		return nil, httperrors.NewInputParameterError("extra_vars must be a json object, got %s", extraVars.String())
+	}
+	if err := validateCronjobExtraVarsTemplates(extraVars); err != nil {
+		return nil, httperrors.NewInputParameterError("invalid extra_vars template: %v", err)
+	}
+	return extraVars, nil
+}
+
+func (manager *SCronjobManager) ValidateCreateData(ctx context.Context, userCred mcclient.TokenCredential, ownerId mcclient.IIdentityProvider, query jsonutils.JSONObject, input api.CronjobCreateInput) (api.CronjobCreateInput, error) {
+	var err error
+	input.VirtualResourceCreateInput, err = manager.SVirtualResourceBaseManager.ValidateCreateData(ctx, userCred, ownerId, query, input.VirtualResourceCreateInput)
+	if err != nil {
+		return input, err
+	}
+	if err := validateCronjobSchedule(input.Day, input.Hour, input.Min, input.Sec, input.Interval, int64(options.Options.CronjobMinIntervalSeconds)); err != nil {
+		return input, err
+	}
+	input.TimeZone = strings.TrimSpace(input.TimeZone)
+	if _, err := loadCronjobTimeZone(input.TimeZone); err != nil {
+		return input, err
+	}
+	// the cronjob of a template runs the playbooks of the servers of it
+	isTemplateCronjob := input.TemplateID != "" && input.ServerID == ""
+	if isTemplateCronjob {
+		if err := validateCronjobDevtoolTemplate(ctx, input.TemplateID); err != nil {
+			return input, err
+		}
+	}
+	if !isTemplateCronjob || input.AnsiblePlaybookID != "" {
+		if err := validateCronjobPlaybook(ctx, input.AnsiblePlaybookID); err != nil {
+			return input, err
+		}
+	}
+	input.ExtraVars, err = validateCronjobExtraVars(input.ExtraVars)
+	if err != nil {
+		return input, err
+	}
+	return input, nil
+}
+
+func (job *SCronjob) ValidateUpdateData(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input api.CronjobUpdateInput) (api.CronjobUpdateInput, error) {
+	var err error
+	input.VirtualResourceBaseUpdateInput, err = job.SVirtualResourceBase.ValidateUpdateData(ctx, userCred, query, input.VirtualResourceBaseUpdateInput)
+	if err != nil {
+		return input, err
+	}
+	day, hour, min, sec, interval := job.Day, job.Hour, job.Min, job.Sec, job.Interval
+	if input.Day != nil {
+		day = *input.Day
+	}
+	if input.Hour != nil {
+		hour = *input.Hour
+	}
+	if input.Min != nil {
+		min = *input.Min
+	}
+	if input.Sec != nil {
+		sec = *input.Sec
+	}
+	// the minimal interval only applies to the interval updated, so the
+	// cronjob created before the option can still be updated otherwise
+	var minInterval int64
+	if input.Interval != nil {
+		interval = *input.Interval
+		minInterval = int64(options.Options.CronjobMinIntervalSeconds)
+	}
+	if err := validateCronjobSchedule(day, hour, min, sec, interval, minInterval); err != nil {
+		return input, err
+	}
+	if input.TimeZone != nil {
+		timeZone := strings.TrimSpace(*input.TimeZone)
+		if _, err := loadCronjobTimeZone(timeZone); err != nil {
+			return input, err
+		}
+		input.TimeZone = &timeZone
+	}
+	if input.AnsiblePlaybookID != "" && input.AnsiblePlaybookID != job.AnsiblePlaybookID {
+		if err := validateCronjobPlaybook(ctx, input.AnsiblePlaybookID); err != nil {
+			return input, err
+		}
+	}
+	input.ExtraVars, err = validateCronjobExtraVars(input.ExtraVars)
+	if err != nil {
+		return input, err
+	}
+	return input, nil
+}
+
+// PerformValidate reports the references of the cronjob which don't exist.
+func (job *SCronjob) PerformValidate(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input api.CronjobValidateInput) (api.CronjobValidateOutput, error) {
+	output := api.CronjobValidateOutput{}
+	dangling, err := getDanglingCronjobReferences(ctx, job.AnsiblePlaybookID, job.TemplateID, job.ServerID)
+	if err != nil {
+		return output, errors.Wrap(err, "getDanglingCronjobReferences")
+	}
+	output.DanglingReferences = dangling
+	output.Valid = len(dangling) == 0
+	return output, nil
+}
+
+// RunAnsibleCronjob returns the job of the cronjob, whose failed runs are
+// counted by DevToolCronManager.
+func RunAnsibleCronjob(id string, s *mcclient.ClientSession) cronman.TCronJobFunctionWithError {
+	return func(ctx context.Context, userCred mcclient.TokenCredential, isStart bool) error {
 		obj, err := CronjobManager.FetchById(id)
 		if err != nil {
 			log.Errorf("No cronjob with id: %s", id)
-			return
+			return errors.Wrapf(err, "fetch cronjob %s", id)
 		}
 		log.Debugf("[RunAnsibleCronjob] %+v: ", obj)
 		item := obj.(*SCronjob)
-
-		log.Debugf("[RunAnsibleCronjob] perform ansible cronjob run: %s", item.AnsiblePlaybookID)
-		ret, err := ansible.AnsiblePlaybooks.PerformAction(s, item.AnsiblePlaybookID, "run", nil)
+		seq, err := item.countRun()
 		if err != nil {
-			log.Errorf("AnsiblePlaybooks.PerformAction error: %s", err)
+			log.Errorf("count run of cronjob %s: %s", item.Id, err)
+			return errors.Wrapf(err, "count run of cronjob %s", item.Id)
 		}
-		log.Debugf("AnsiblePlaybooks.PerformAction ret: %+v", ret)
+		return item.runPlaybook(userCred, s, seq, false)
 	}
 }
 
-func AddOneCronjob(item *SCronjob, s *mcclient.ClientSession) error {
+// runPlaybook runs the ansible playbook of the cronjob as the run seq, manual
+// tells the run is triggered by the run action instead of the schedule.
+func (job *SCronjob) runPlaybook(userCred mcclient.TokenCredential, s *mcclient.ClientSession, seq int64, manual bool) error {
+	startAt := time.Now()
+	extraVars := job.renderExtraVars(seq)
+	var err error
+	if job.isTemplateCronjob() {
+		err = job.runTemplatePlaybooks(userCred, s, seq, manual, extraVars)
+	} else {
+		err = job.runOnePlaybook(userCred, s, job.AnsiblePlaybookID, "", seq, manual, extraVars)
+	}
+	job.recordRun(startAt, manual)
+	return err
+}
+
+// isTemplateCronjob tells whether the cronjob runs for all the servers bound
+// to its template instead of a single playbook. The cronjobs created by
+// binding a template to a server have the server set.
+func (job *SCronjob) isTemplateCronjob() bool {
+	return job.TemplateID != "" && job.ServerID == ""
+}
+
+// runOnePlaybook runs the ansible playbook, of the server if serverId is set.
+func (job *SCronjob) runOnePlaybook(userCred mcclient.TokenCredential, s *mcclient.ClientSession, playbookId string, serverId string, seq int64, manual bool, extraVars jsonutils.JSONObject) error {
+	log.Debugf("[RunAnsibleCronjob] perform ansible cronjob run: %s", playbookId)
+	notes := jsonutils.NewDict()
+	notes.Set("ansible_playbook_id", jsonutils.NewString(playbookId))
+	if serverId != "" {
+		notes.Set("server_id", jsonutils.NewString(serverId))
+	}
+	notes.Set("seq", jsonutils.NewInt(seq))
+	if manual {
+		notes.Set("manual", jsonutils.JSONTrue)
+	}
+	if extraVars != nil {
+		notes.Set("extra_vars", extraVars)
+	}
+	db.OpsLog.LogEvent(job, api.CRONJOB_ACT_RUN, notes, userCred)
+	ret, err := ansible.AnsiblePlaybooks.PerformAction(s, playbookId, "run", extraVars)
+	if err != nil {
+		log.Errorf("AnsiblePlaybooks.PerformAction error: %s", err)
+		notes.Set("error", jsonutils.NewString(err.Error()))
+		db.OpsLog.LogEvent(job, api.CRONJOB_ACT_RUN_FAIL, notes, userCred)
+		return err
+	}
+	log.Debugf("AnsiblePlaybooks.PerformAction ret: %+v", ret)
+	return nil
+}
+
+// runTemplatePlaybooks runs the playbooks of the servers the template of the
+// cronjob is bound to at the time of the run, each run of a server is
+// recorded in the ops log. A template bound to no server, or only to the
+// servers of enabled cronjobs, runs nothing. The run fails if any of the
+// servers fails.
+func (job *SCronjob) runTemplatePlaybooks(userCred mcclient.TokenCredential, s *mcclient.ClientSession, seq int64, manual bool, extraVars jsonutils.JSONObject) error {
+	servers, err := job.getTemplateServerPlaybooks()
+	if err != nil {
+		log.Errorf("get servers of template %s of cronjob %s: %s", job.TemplateID, job.Id, err)
+		notes := jsonutils.NewDict()
+		notes.Set("seq", jsonutils.NewInt(seq))
+		notes.Set("error", jsonutils.NewString(err.Error()))
+		db.OpsLog.LogEvent(job, api.CRONJOB_ACT_RUN_FAIL, notes, userCred)
+		return errors.Wrap(err, "getTemplateServerPlaybooks")
+	}
+	if len(servers) == 0 {
+		log.Infof("template %s of cronjob %s has no server to run, skip run %d", job.TemplateID, job.Id, seq)
+		notes := jsonutils.NewDict()
+		notes.Set("seq", jsonutils.NewInt(seq))
+		notes.Set("servers", jsonutils.NewInt(0))
+		db.OpsLog.LogEvent(job, api.CRONJOB_ACT_RUN, notes, userCred)
+		return nil
+	}
+	failed := 0
+	for _, server := range servers {
+		if err := job.runOnePlaybook(userCred, s, server.AnsiblePlaybookID, server.ServerID, seq, manual, extraVars); err != nil {
+			log.Errorf("run cronjob %s on server %s: %s", job.Id, server.ServerID, err)
+			failed++
+		}
+	}
+	log.Infof("cronjob %s run %d on %d servers of template %s, %d failed", job.Id, seq, len(servers), job.TemplateID, failed)
+	if failed > 0 {
+		return errors.Errorf("%d of %d servers of template %s failed", failed, len(servers), job.TemplateID)
+	}
+	return nil
+}
+
+// getTemplateServerPlaybooks returns the cronjobs created by binding the
+// template of the cronjob to the servers, which carry the playbooks of the
+// servers, one per server. The enabled ones are left out since they run the
+// playbooks of their servers by their own schedule already.
+func (job *SCronjob) getTemplateServerPlaybooks() ([]SCronjob, error) {
+	q := CronjobManager.Query().Equals("template_id", job.TemplateID).IsNotEmpty("server_id").NotEquals("id", job.Id).Asc("server_id")
+	q = q.Filter(sqlchemy.OR(sqlchemy.IsFalse(q.Field("enabled")), sqlchemy.IsNull(q.Field("enabled"))))
+	items := make([]SCronjob, 0)
+	if err := db.FetchModelObjects(CronjobManager, q, &items); err != nil {
+		return nil, errors.Wrap(err, "FetchModelObjects")
+	}
+	ret := make([]SCronjob, 0, len(items))
+	for i := range items {
+		if items[i].AnsiblePlaybookID == "" {
+			continue
+		}
+		if len(ret) > 0 && ret[len(ret)-1].ServerID == items[i].ServerID {
+			continue
+		}
+		ret = append(ret, items[i])
+	}
+	return ret, nil
+}
+
+// recordRun records the run started at startAt, a manual run doesn't change
+// the schedule.
+func (job *SCronjob) recordRun(startAt time.Time, manual bool) {
+	_, err := db.Update(job, func() error {
+		job.LastRunAt = startAt
+		if !manual {
+			job.NextRunAt = job.nextRunAt(startAt)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("record run of cronjob %s: %s", job.Id, err)
+	}
+}
+
+// nextRunAt returns when the cronjob runs next by the schedule after now,
+// i.e. now + Interval, or the next Hour:Min:Sec in its time zone every Day
+// days.
+func (job *SCronjob) nextRunAt(now time.Time) time.Time {
+	if job.Interval > 0 {
+		return now.Add(time.Duration(job.Interval) * time.Second)
+	}
+	return cronman.NewTimerEveryFewDays(job.Day, job.Hour, job.Min, job.Sec, job.location()).Next(now)
+}
+
+// setNextRunAt records when the cronjob runs next, the zero time means it's
+// not scheduled.
+func (job *SCronjob) setNextRunAt(next time.Time) {
+	if job.NextRunAt.Equal(next) {
+		return
+	}
+	_, err := db.Update(job, func() error {
+		job.NextRunAt = next
+		return nil
+	})
+	if err != nil {
+		log.Errorf("set next run of cronjob %s: %s", job.Id, err)
+	}
+}
+
+// countRun counts a run of the cronjob and returns its seq.
+func (job *SCronjob) countRun() (int64, error) {
+	var seq int64
+	_, err := db.Update(job, func() error {
+		job.RunCount += 1
+		seq = job.RunCount
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "update run count")
+	}
+	return seq, nil
+}
+
+// renderExtraVars expands the extra vars templates with the values of the
+// run seq.
+func (job *SCronjob) renderExtraVars(seq int64) jsonutils.JSONObject {
+	if job.ExtraVars == nil {
+		return nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Warningf("get hostname: %s", err)
+	}
+	return renderCronjobExtraVars(job.ExtraVars, SCronjobRunVars{
+		Now:         time.Now().In(job.location()),
+		Hostname:    hostname,
+		Seq:         seq,
+		CronjobId:   job.Id,
+		CronjobName: job.Name,
+	})
+}
+
+// PerformRun runs the ansible playbook of the cronjob once now, out of its
+// schedule and whether it's enabled or not. The playbook is run in the
+// background, the caller polls the status of the playbook for the result
+// of the run. The runs of the servers of a cronjob of a template are recorded
+// in the ops log instead.
+func (job *SCronjob) PerformRun(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input api.CronjobRunInput) (api.CronjobRunOutput, error) {
+	output := api.CronjobRunOutput{}
+	if job.isTemplateCronjob() {
+		if err := validateCronjobDevtoolTemplate(ctx, job.TemplateID); err != nil {
+			return output, err
+		}
+	} else if err := validateCronjobPlaybook(ctx, job.AnsiblePlaybookID); err != nil {
+		return output, err
+	}
+	seq, err := job.countRun()
+	if err != nil {
+		return output, errors.Wrap(err, "countRun")
+	}
+	session := auth.GetAdminSession(ctx, "")
+	go job.runPlaybook(userCred, session, seq, true)
+	if !job.isTemplateCronjob() {
+		output.AnsiblePlaybookID = job.AnsiblePlaybookID
+	}
+	output.Seq = seq
+	return output, nil
+}
+
+// location returns the time zone the cronjob runs in.
+func (job *SCronjob) location() *time.Location {
+	if loc, err := loadCronjobTimeZone(job.TimeZone); err == nil && loc != nil {
+		return loc
+	}
+	return DevToolCronManager.TimeZone()
+}
+
+// AddOneCronjob registers the cronjob to DevToolCronManager and records when
+// it runs next. When fireNow
+// is set, the job is fired once immediately out of band and then follows its
+// normal schedule; the job is non-reentrant so that the immediate run and the
+// next scheduled run never overlap, the latter is skipped if it collides. The
+// daily schedule is in item.TimeZone if it's set.
+func AddOneCronjob(item *SCronjob, s *mcclient.ClientSession, fireNow bool) error {
 
 	if !item.Enabled {
 		log.Debugf("ansible cronjob %s (devtool item.Id: %s) is not enabled", item.Name, item.Id)
 		return nil
 	}
+	if item.Paused {
+		log.Debugf("ansible cronjob %s (devtool item.Id: %s) is paused", item.Name, item.Id)
+		return nil
+	}
 	if item.Interval > 0 {
-		err := DevToolCronManager.AddJobAtIntervalsWithStartRun(item.Id, time.Duration(item.Interval)*time.Second, RunAnsibleCronjob(item.Id, s), item.Start)
+		err := DevToolCronManager.AddJobAtIntervalsWithError(item.Id, time.Duration(item.Interval)*time.Second, RunAnsibleCronjob(item.Id, s), false)
 		if err != nil {
 			log.Errorf("ansible cronjob %s (devtool item.Id: %s) error! %s", item.Name, item.Id, err)
 			return err
 		}
 		log.Infof("ansible cronjob %s (devtool item.Id: %s) registered at item.Interval: %ds", item.Name, item.Id, item.Interval)
 	} else {
-		err := DevToolCronManager.AddJobEveryFewDays(item.Id, int(item.Day), int(item.Hour), int(item.Min), int(item.Sec), RunAnsibleCronjob(item.Id, s), item.Start)
+		loc, err := loadCronjobTimeZone(item.TimeZone)
+		if err != nil {
+			log.Errorf("ansible cronjob %s (devtool item.Id: %s) error! %s", item.Name, item.Id, err)
+			return err
+		}
+		err = DevToolCronManager.AddJobEveryFewDaysInLocationWithError(item.Id, int(item.Day), int(item.Hour), int(item.Min), int(item.Sec), loc, RunAnsibleCronjob(item.Id, s), false)
 		if err != nil {
 			log.Errorf("ansible cronjob %s (devtool item.Id: %s) registered at item.Interval: item.Day(%d) item.Hour(%d) item.Min(%d) item.Sec(%d) error: %s", item.Name, item.Id, int(item.Day), int(item.Hour), int(item.Min), int(item.Sec), err)
 			return err
 		}
 		log.Infof("ansible cronjob %s (devtool item.Id: %s) registered at item.Interval: item.Day(%d) item.Hour(%d) item.Min(%d) item.Sec(%d)", item.Name, item.Id, int(item.Day), int(item.Hour), int(item.Min), int(item.Sec))
 	}
+	if err := DevToolCronManager.SetJobNonReentrant(item.Id, true); err != nil {
+		// don't leave the job registered half way
+		DevToolCronManager.Remove(item.Id)
+		return errors.Wrap(err, "SetJobNonReentrant")
+	}
+	item.setNextRunAt(item.nextRunAt(time.Now()))
+	if fireNow {
+		// the job is scheduled even if the immediate run fails
+		if err := DevToolCronManager.RunJobNow(item.Id); err != nil {
+			log.Errorf("ansible cronjob %s (devtool item.Id: %s) fire on start: %s", item.Name, item.Id, err)
+		} else {
+			log.Infof("ansible cronjob %s (devtool item.Id: %s) fired once on start", item.Name, item.Id)
+		}
+	}
 	return nil
 }
 
+// rescheduleCronjob registers the cronjob to DevToolCronManager again, so it
+// can be called any times: the job is always removed first, and only added
+// back if it's enabled and not paused. fireNow is passed to AddOneCronjob, it's
+// only set when the cronjob is created or its Start is turned on. The result is recorded in the status of the cronjob,
+// and a scheduling failure is also recorded in the ops log.
+func (job *SCronjob) rescheduleCronjob(ctx context.Context, userCred mcclient.TokenCredential, s *mcclient.ClientSession, fireNow bool) error {
+	DevToolCronManager.Remove(job.Id)
+	if !job.Enabled {
+		job.setNextRunAt(time.Time{})
+		job.SetStatus(ctx, userCred, api.CRONJOB_STATUS_DISABLED, "")
+		return nil
+	}
+	if job.Paused {
+		job.setNextRunAt(time.Time{})
+		job.SetStatus(ctx, userCred, api.CRONJOB_STATUS_PAUSED, "")
+		return nil
+	}
+	if err := AddOneCronjob(job, s, fireNow); err != nil {
+		job.setNextRunAt(time.Time{})
+		db.OpsLog.LogEvent(job, api.CRONJOB_ACT_SCHEDULE_FAIL, err.Error(), userCred)
+		job.SetStatus(ctx, userCred, api.CRONJOB_STATUS_SCHEDULE_FAILED, err.Error())
+		return errors.Wrapf(err, "schedule cronjob %s", job.Id)
+	}
+	job.SetStatus(ctx, userCred, api.CRONJOB_STATUS_READY, "")
+	return nil
+}
+
+// FetchByIds fetches the cronjobs of ids in one query, missing ids are ignored.
+func (manager *SCronjobManager) FetchByIds(ids []string) ([]SCronjob, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	q := manager.Query().In("id", ids)
+	ret := make([]SCronjob, 0, len(ids))
+	err := db.FetchModelObjects(manager, q, &ret)
+	if err != nil {
+		return nil, errors.Wrap(err, "FetchModelObjects")
+	}
+	return ret, nil
+}
+
+// fetchEnabledIds returns the ids of the enabled cronjobs, which are loaded
+// by FetchByIds on start.
+func (manager *SCronjobManager) fetchEnabledIds() ([]string, error) {
+	q := manager.Query("id").IsTrue("enabled")
+	rows, err := q.Rows()
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "Query")
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "rows.Scan")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// PauseByTenant pauses all the cronjobs of the project tenantId for a
+// maintenance window, they are removed from DevToolCronManager until
+// ResumeByTenant. It returns the number of the cronjobs paused, the ones
+// already paused aren't counted.
+func (manager *SCronjobManager) PauseByTenant(ctx context.Context, userCred mcclient.TokenCredential, tenantId string) (int, error) {
+	return manager.setPausedByTenant(ctx, userCred, tenantId, true)
+}
+
+// ResumeByTenant resumes the cronjobs of the project tenantId paused by
+// PauseByTenant, the enabled ones are scheduled again. It returns the number
+// of the cronjobs resumed.
+func (manager *SCronjobManager) ResumeByTenant(ctx context.Context, userCred mcclient.TokenCredential, tenantId string) (int, error) {
+	return manager.setPausedByTenant(ctx, userCred, tenantId, false)
+}
+
+func (manager *SCronjobManager) setPausedByTenant(ctx context.Context, userCred mcclient.TokenCredential, tenantId string, paused bool) (int, error) {
+	if len(tenantId) == 0 {
+		return 0, errors.Wrap(errors.ErrEmpty, "tenant id")
+	}
+	q := manager.Query().Equals("tenant_id", tenantId)
+	items := make([]SCronjob, 0)
+	err := db.FetchModelObjects(manager, q, &items)
+	if err != nil {
+		return 0, errors.Wrap(err, "FetchModelObjects")
+	}
+	jobs := selectCronjobsToPause(items, tenantId, paused)
+	session := auth.GetAdminSession(ctx, "")
+	errs := []error{}
+	count := 0
+	for _, job := range jobs {
+		_, err := db.Update(job, func() error {
+			job.Paused = paused
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "update cronjob %s", job.Id))
+			continue
+		}
+		count++
+		// a failure to schedule the resumed job is recorded in its status
+		if err := job.rescheduleCronjob(ctx, userCred, session, false); err != nil {
+			log.Errorf("reschedule cronjob %s: %s", job.Id, err)
+		}
+	}
+	return count, errors.NewAggregate(errs)
+}
+
+// selectCronjobsToPause returns the cronjobs of the tenant whose paused flag
+// is to be flipped to paused.
+func selectCronjobsToPause(items []SCronjob, tenantId string, paused bool) []*SCronjob {
+	ret := []*SCronjob{}
+	for i := range items {
+		if items[i].ProjectId == tenantId && items[i].Paused != paused {
+			ret = append(ret, &items[i])
+		}
+	}
+	return ret
+}
+
+// ReconcileJobs re-registers the cronjobs of changedIds to DevToolCronManager,
+// the deleted or disabled ones are removed from it.
+func ReconcileJobs(ctx context.Context, changedIds []string) error {
+	items, err := CronjobManager.FetchByIds(changedIds)
+	if err != nil {
+		return errors.Wrap(err, "FetchByIds")
+	}
+	for _, id := range changedIds {
+		DevToolCronManager.Remove(id)
+	}
+	session := auth.GetAdminSession(ctx, "")
+	errs := []error{}
+	for i := range items {
+		if err := items[i].rescheduleCronjob(ctx, auth.AdminCredential(), session, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.NewAggregate(errs)
+}
+
+// taskArchiveInterval converts the configured task archive interval into a
+// duration, clamping it to minTaskArchiveIntervalMinutes so that a zero or
+// negative option neither disables task cleanup nor makes it busy-loop
+func taskArchiveInterval(minutes int) time.Duration {
+	if minutes < minTaskArchiveIntervalMinutes {
+		log.Warningf("task_archive_interval_minutes %d out of range, use %d instead", minutes, minTaskArchiveIntervalMinutes)
+		minutes = minTaskArchiveIntervalMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
 func InitializeCronjobs(ctx context.Context) error {
 	err := taskman.TaskManager.InitializeData()
 	if err != nil {
@@ -120,20 +790,18 @@ func InitializeCronjobs(ctx context.Context) error {
 
 	DevToolCronManager = cronman.InitCronJobManager(true, 8, options.Options.TimeZone)
 
-	DevToolCronManager.AddJobAtIntervalsWithStartRun("TaskCleanupJob", time.Duration(options.Options.TaskArchiveIntervalMinutes)*time.Minute, taskman.TaskManager.TaskCleanupJob, true)
+	DevToolCronManager.AddJobAtIntervalsWithStartRun("TaskCleanupJob", taskArchiveInterval(options.Options.TaskArchiveIntervalMinutes), taskman.TaskManager.TaskCleanupJob, true)
 
 	DevToolCronManager.Start()
-	Session := auth.GetAdminSession(ctx, "")
 
 	go func() {
-		items := make([]SCronjob, 0)
-		q := CronjobManager.Query().Equals("enabled", true)
-		err := q.All(&items)
+		ids, err := CronjobManager.fetchEnabledIds()
 		if err != nil {
-			log.Errorf("query error: %s", err)
+			log.Errorf("InitializeCronjobs: %s", err)
+			return
 		}
-		for _, item := range items {
-			AddOneCronjob(&item, Session)
+		if err := ReconcileJobs(ctx, ids); err != nil {
+			log.Errorf("InitializeCronjobs: %s", err)
 		}
 	}()
 
@@ -143,16 +811,35 @@ func InitializeCronjobs(ctx context.Context) error {
 func (job *SCronjob) PostCreate(ctx context.Context, userCred mcclient.TokenCredential, ownerID mcclient.IIdentityProvider, query jsonutils.JSONObject, data jsonutils.JSONObject) {
 	Session := auth.GetAdminSession(ctx, "")
 	job.SStandaloneResourceBase.PostCreate(ctx, userCred, nil, query, data)
-	AddOneCronjob(job, Session)
+	if err := job.rescheduleCronjob(ctx, userCred, Session, job.Start); err != nil {
+		log.Errorf("PostCreate: %s", err)
+	}
 }
 
 func (job *SCronjob) PostDelete(ctx context.Context, userCred mcclient.TokenCredential) {
 	DevToolCronManager.Remove(job.Id)
 }
 
+// isCronjobStartTurnedOn tells whether the update data turns Start on from
+// oldStart.
+func isCronjobStartTurnedOn(oldStart bool, data jsonutils.JSONObject) bool {
+	if oldStart || data == nil || !data.Contains("start") {
+		return false
+	}
+	return jsonutils.QueryBoolean(data, "start", false)
+}
+
+func (job *SCronjob) PreUpdate(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) {
+	job.SVirtualResourceBase.PreUpdate(ctx, userCred, query, data)
+	job.startTurnedOn = isCronjobStartTurnedOn(job.Start, data)
+}
+
 func (job *SCronjob) PostUpdate(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) {
 	Session := auth.GetAdminSession(ctx, "")
 	job.SStandaloneResourceBase.PostUpdate(ctx, userCred, query, data)
-	DevToolCronManager.Remove(job.Id)
-	AddOneCronjob(job, Session)
+	fireNow := job.startTurnedOn
+	job.startTurnedOn = false
+	if err := job.rescheduleCronjob(ctx, userCred, Session, fireNow); err != nil {
+		log.Errorf("PostUpdate: %s", err)
+	}
 }
@@ -0,0 +1,110 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"testing"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db/lockman"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/util/ansible"
+)
+
+func TestCreateTemplateCronjob(t *testing.T) {
+	openTestDB(t, CronjobManager, DevtoolTemplateManager)
+	lockman.Init(lockman.NewInMemoryLockManager())
+
+	ctx := context.Background()
+	template := &SDevtoolTemplate{}
+	template.Id = "template1"
+	template.Name = "template1"
+	template.Playbook = &ansible.Playbook{}
+	if err := DevtoolTemplateManager.TableSpec().Insert(ctx, template); err != nil {
+		t.Fatalf("insert template: %v", err)
+	}
+
+	userCred := &mcclient.SSimpleToken{UserId: "user1", ProjectId: "project1", ProjectDomainId: "default"}
+	ownerId := &db.SOwnerId{ProjectId: "project1", DomainId: "default"}
+	data := jsonutils.NewDict()
+	data.Set("name", jsonutils.NewString("daily-template1"))
+	data.Set("day", jsonutils.NewInt(1))
+	data.Set("template_id", jsonutils.NewString(template.Id))
+	model, err := db.DoCreate(CronjobManager, ctx, userCred, nil, data, ownerId)
+	if err != nil {
+		t.Fatalf("create cronjob of template without ansible_playbook_id: %v", err)
+	}
+	job := model.(*SCronjob)
+	if job.TemplateID != template.Id || job.AnsiblePlaybookID != "" || !job.isTemplateCronjob() {
+		t.Errorf("want a template cronjob of %s, got template %q playbook %q", template.Id, job.TemplateID, job.AnsiblePlaybookID)
+	}
+
+	data = jsonutils.NewDict()
+	data.Set("name", jsonutils.NewString("daily-missing"))
+	data.Set("day", jsonutils.NewInt(1))
+	data.Set("template_id", jsonutils.NewString("missing"))
+	if _, err := db.DoCreate(CronjobManager, ctx, userCred, nil, data, ownerId); err == nil {
+		t.Errorf("create cronjob of a missing template: want error")
+	}
+}
+
+func TestGetTemplateServerPlaybooks(t *testing.T) {
+	openTestDB(t, CronjobManager)
+
+	for _, c := range []struct {
+		id         string
+		serverId   string
+		playbookId string
+		enabled    bool
+	}{
+		{id: "template-job"},
+		{id: "job1", serverId: "server1", playbookId: "playbook1"},
+		{id: "job2", serverId: "server2", playbookId: "playbook2", enabled: true},
+		{id: "job3", serverId: "server3"},
+		{id: "job4", serverId: "server4", playbookId: "playbook4"},
+		{id: "job5", serverId: "server4", playbookId: "playbook5"},
+	} {
+		job := &SCronjob{}
+		job.Id = c.id
+		job.Name = c.id
+		job.TemplateID = "template1"
+		job.ServerID = c.serverId
+		job.AnsiblePlaybookID = c.playbookId
+		job.Enabled = c.enabled
+		if err := CronjobManager.TableSpec().Insert(context.Background(), job); err != nil {
+			t.Fatalf("insert %s: %v", c.id, err)
+		}
+	}
+
+	job := &SCronjob{}
+	job.Id = "template-job"
+	job.TemplateID = "template1"
+	servers, err := job.getTemplateServerPlaybooks()
+	if err != nil {
+		t.Fatalf("getTemplateServerPlaybooks: %v", err)
+	}
+	got := []string{}
+	for _, server := range servers {
+		got = append(got, server.ServerID+"/"+server.AnsiblePlaybookID)
+	}
+	// server2 runs by its enabled cronjob and server3 has no playbook
+	want := []string{"server1/playbook1", "server4/playbook4"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
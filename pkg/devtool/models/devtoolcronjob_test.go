@@ -0,0 +1,186 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"yunion.io/x/jsonutils"
+)
+
+func TestTaskArchiveInterval(t *testing.T) {
+	cases := []struct {
+		minutes int
+		want    time.Duration
+	}{
+		{minutes: -1, want: minTaskArchiveIntervalMinutes * time.Minute},
+		{minutes: 0, want: minTaskArchiveIntervalMinutes * time.Minute},
+		{minutes: minTaskArchiveIntervalMinutes, want: minTaskArchiveIntervalMinutes * time.Minute},
+		{minutes: 60, want: time.Hour},
+	}
+	for _, c := range cases {
+		if got := taskArchiveInterval(c.minutes); got != c.want {
+			t.Errorf("taskArchiveInterval(%d) = %s, want %s", c.minutes, got, c.want)
+		}
+	}
+}
+
+func TestLoadCronjobTimeZone(t *testing.T) {
+	if loc, err := loadCronjobTimeZone(""); err != nil || loc != nil {
+		t.Errorf("empty time zone: want nil, got %v, %v", loc, err)
+	}
+	if loc, err := loadCronjobTimeZone("UTC"); err != nil || loc != time.UTC {
+		t.Errorf("UTC: want UTC, got %v, %v", loc, err)
+	}
+	if _, err := loadCronjobTimeZone("Mars/Olympus_Mons"); err == nil {
+		t.Errorf("unknown time zone: want error")
+	}
+}
+
+func TestValidateCronjobSchedule(t *testing.T) {
+	cases := []struct {
+		name                string
+		day, hour, min, sec int
+		interval            int64
+		wantErr             bool
+	}{
+		{name: "daily", day: 1, hour: 2, min: 3, sec: 4},
+		{name: "interval", interval: 60},
+		{name: "interval and day", day: 1, interval: 60, wantErr: true},
+		{name: "interval and sec", sec: 30, interval: 60, wantErr: true},
+		{name: "negative interval", interval: -1, wantErr: true},
+		{name: "negative min", min: -1, wantErr: true},
+		{name: "interval below minimum", interval: 5, wantErr: true},
+		{name: "neither interval nor day", hour: 2, wantErr: true},
+		{name: "all zero", wantErr: true},
+	}
+	for _, c := range cases {
+		err := validateCronjobSchedule(c.day, c.hour, c.min, c.sec, c.interval, 10)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: want error %v, got %v", c.name, c.wantErr, err)
+		}
+	}
+}
+
+func TestValidateTemplateSchedule(t *testing.T) {
+	cases := []struct {
+		name     string
+		schedule SVSCronjob
+		wantErr  bool
+	}{
+		{name: "daily", schedule: SVSCronjob{Day: 1, Hour: 2, TimeZone: "Asia/Shanghai"}},
+		{name: "interval", schedule: SVSCronjob{Interval: 60}},
+		{name: "interval and hour", schedule: SVSCronjob{Hour: 2, Interval: 60}, wantErr: true},
+		{name: "unknown time zone", schedule: SVSCronjob{Day: 1, TimeZone: "Mars/Olympus"}, wantErr: true},
+	}
+	for _, c := range cases {
+		err := validateTemplateSchedule(c.schedule, 10)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: want error %v, got %v", c.name, c.wantErr, err)
+		}
+	}
+}
+
+func TestCronjobNextRunAt(t *testing.T) {
+	// 2024-01-01 20:00 UTC is 2024-01-02 04:00 in Asia/Shanghai
+	now := time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+
+	job := &SCronjob{}
+	job.Interval = 600
+	if got, want := job.nextRunAt(now), now.Add(10*time.Minute); !got.Equal(want) {
+		t.Errorf("interval: want %s, got %s", want, got)
+	}
+
+	job = &SCronjob{}
+	job.Day, job.Hour, job.TimeZone = 1, 3, "Asia/Shanghai"
+	// 03:00 of 2024-01-03 in Asia/Shanghai
+	if got, want := job.nextRunAt(now), time.Date(2024, 1, 2, 19, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("daily: want %s, got %s", want, got)
+	}
+}
+
+func TestSelectCronjobsToPause(t *testing.T) {
+	newJob := func(id, tenantId string, paused bool) SCronjob {
+		job := SCronjob{Paused: paused}
+		job.Id = id
+		job.ProjectId = tenantId
+		return job
+	}
+	items := []SCronjob{
+		newJob("a1", "tenant-a", false),
+		newJob("a2", "tenant-a", true),
+		newJob("a3", "tenant-a", false),
+		newJob("b1", "tenant-b", false),
+		newJob("b2", "tenant-b", true),
+	}
+	ids := func(jobs []*SCronjob) string {
+		ret := []string{}
+		for _, job := range jobs {
+			ret = append(ret, job.Id)
+		}
+		return strings.Join(ret, ",")
+	}
+	cases := []struct {
+		tenantId string
+		paused   bool
+		want     string
+	}{
+		{tenantId: "tenant-a", paused: true, want: "a1,a3"},
+		{tenantId: "tenant-a", paused: false, want: "a2"},
+		{tenantId: "tenant-b", paused: true, want: "b1"},
+		{tenantId: "tenant-b", paused: false, want: "b2"},
+		{tenantId: "tenant-c", paused: true, want: ""},
+	}
+	for _, c := range cases {
+		if got := ids(selectCronjobsToPause(items, c.tenantId, c.paused)); got != c.want {
+			t.Errorf("%s paused %v: want %q, got %q", c.tenantId, c.paused, c.want, got)
+		}
+	}
+	// the selected jobs are the items, so the flag is flipped in place
+	selectCronjobsToPause(items, "tenant-b", true)[0].Paused = true
+	if !items[3].Paused {
+		t.Errorf("want the item of b1 paused")
+	}
+}
+
+func TestCronjobPreUpdateStartTurnedOn(t *testing.T) {
+	cases := []struct {
+		name     string
+		oldStart bool
+		data     string
+		want     bool
+	}{
+		{name: "start turned on", oldStart: false, data: `{"start":true}`, want: true},
+		{name: "start left on", oldStart: true, data: `{"start":true}`, want: false},
+		{name: "start not updated", oldStart: true, data: `{"interval":3600}`, want: false},
+		{name: "start left off", oldStart: false, data: `{"start":false}`, want: false},
+		{name: "start turned off", oldStart: true, data: `{"start":false}`, want: false},
+	}
+	for _, c := range cases {
+		data, err := jsonutils.ParseString(c.data)
+		if err != nil {
+			t.Fatalf("%s: parse %s: %s", c.name, c.data, err)
+		}
+		job := &SCronjob{}
+		job.Start = c.oldStart
+		job.PreUpdate(context.Background(), nil, nil, data)
+		if job.startTurnedOn != c.want {
+			t.Errorf("%s: want fire %v, got %v", c.name, c.want, job.startTurnedOn)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+)
+
+// cronjobVarPattern matches a {{ name }} template in the extra vars.
+var cronjobVarPattern = regexp.MustCompile(`{{\s*([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+
+// SCronjobRunVars are the runtime values the extra vars of a cronjob run
+// are rendered with.
+type SCronjobRunVars struct {
+	Now      time.Time
+	Hostname string
+	// Seq is the sequence number of the run, starting from 1
+	Seq         int64
+	CronjobId   string
+	CronjobName string
+}
+
+// cronjobTemplateVars are the variables allowed in the extra vars templates.
+var cronjobTemplateVars = map[string]func(v SCronjobRunVars) string{
+	// date of the run, e.g. 2006-01-02
+	"date": func(v SCronjobRunVars) string { return v.Now.Format("2006-01-02") },
+	// time of the run in RFC3339, e.g. 2006-01-02T15:04:05+08:00
+	"datetime":     func(v SCronjobRunVars) string { return v.Now.Format(time.RFC3339) },
+	"timestamp":    func(v SCronjobRunVars) string { return fmt.Sprintf("%d", v.Now.Unix()) },
+	"hostname":     func(v SCronjobRunVars) string { return v.Hostname },
+	"seq":          func(v SCronjobRunVars) string { return fmt.Sprintf("%d", v.Seq) },
+	"cronjob_id":   func(v SCronjobRunVars) string { return v.CronjobId },
+	"cronjob_name": func(v SCronjobRunVars) string { return v.CronjobName },
+}
+
+func cronjobTemplateVarNames() []string {
+	names := make([]string, 0, len(cronjobTemplateVars))
+	for name := range cronjobTemplateVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateCronjobTemplate requires every template of the string to be a
+// known variable.
+func validateCronjobTemplate(str string) error {
+	for _, match := range cronjobVarPattern.FindAllStringSubmatch(str, -1) {
+		if _, ok := cronjobTemplateVars[match[1]]; !ok {
+			return errors.Wrapf(errors.ErrNotSupported, "unknown variable %q in %q, supported variables are %s", match[1], str, strings.Join(cronjobTemplateVarNames(), ", "))
+		}
+	}
+	if strings.Contains(cronjobVarPattern.ReplaceAllString(str, ""), "{{") {
+		return errors.Wrapf(errors.ErrInvalidFormat, "malformed template in %q", str)
+	}
+	return nil
+}
+
+// validateCronjobExtraVarsTemplates checks the templates in the string values
+// of the extra vars.
+func validateCronjobExtraVarsTemplates(extraVars jsonutils.JSONObject) error {
+	switch obj := extraVars.(type) {
+	case *jsonutils.JSONString:
+		str, _ := obj.GetString()
+		return validateCronjobTemplate(str)
+	case *jsonutils.JSONDict:
+		values, _ := obj.GetMap()
+		for key, value := range values {
+			if err := validateCronjobExtraVarsTemplates(value); err != nil {
+				return errors.Wrapf(err, "extra_vars.%s", key)
+			}
+		}
+	case *jsonutils.JSONArray:
+		values, _ := obj.GetArray()
+		for i := range values {
+			if err := validateCronjobExtraVarsTemplates(values[i]); err != nil {
+				return errors.Wrapf(err, "index %d", i)
+			}
+		}
+	}
+	return nil
+}
+
+// renderCronjobExtraVars returns a copy of the extra vars with the templates
+// in the string values expanded.
+func renderCronjobExtraVars(extraVars jsonutils.JSONObject, vars SCronjobRunVars) jsonutils.JSONObject {
+	switch obj := extraVars.(type) {
+	case *jsonutils.JSONString:
+		str, _ := obj.GetString()
+		return jsonutils.NewString(cronjobVarPattern.ReplaceAllStringFunc(str, func(tmpl string) string {
+			name := cronjobVarPattern.FindStringSubmatch(tmpl)[1]
+			if value, ok := cronjobTemplateVars[name]; ok {
+				return value(vars)
+			}
+			return tmpl
+		}))
+	case *jsonutils.JSONDict:
+		values, _ := obj.GetMap()
+		result := jsonutils.NewDict()
+		for key, value := range values {
+			result.Set(key, renderCronjobExtraVars(value, vars))
+		}
+		return result
+	case *jsonutils.JSONArray:
+		values, _ := obj.GetArray()
+		result := jsonutils.NewArray()
+		for i := range values {
+			result.Add(renderCronjobExtraVars(values[i], vars))
+		}
+		return result
+	default:
+		return extraVars
+	}
+}
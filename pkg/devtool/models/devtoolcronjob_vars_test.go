@@ -0,0 +1,67 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"yunion.io/x/jsonutils"
+)
+
+func TestRenderCronjobExtraVars(t *testing.T) {
+	extraVars, err := jsonutils.ParseString(`{"table":"logs_{{ date }}","batch":"{{seq}}","hosts":["{{ hostname }}"],"keep":7}`)
+	if err != nil {
+		t.Fatalf("parse extra vars: %v", err)
+	}
+	if err := validateCronjobExtraVarsTemplates(extraVars); err != nil {
+		t.Fatalf("validate extra vars: %v", err)
+	}
+	vars := SCronjobRunVars{
+		Now:      time.Date(2024, 3, 5, 1, 2, 3, 0, time.UTC),
+		Hostname: "devtool-0",
+		Seq:      42,
+	}
+	got := renderCronjobExtraVars(extraVars, vars)
+	want := `{"batch":"42","hosts":["devtool-0"],"keep":7,"table":"logs_2024-03-05"}`
+	if got.String() != want {
+		t.Errorf("rendered %s, want %s", got.String(), want)
+	}
+	// the templates stay in the stored extra vars
+	if table, _ := extraVars.GetString("table"); table != "logs_{{ date }}" {
+		t.Errorf("extra vars changed by rendering: %s", extraVars)
+	}
+}
+
+func TestValidateCronjobExtraVarsTemplates(t *testing.T) {
+	for _, c := range []struct {
+		extraVars string
+		valid     bool
+	}{
+		{extraVars: `{"a":"{{ datetime }}-{{timestamp}}"}`, valid: true},
+		{extraVars: `{"a":{"b":["{{ cronjob_name }}"]}}`, valid: true},
+		{extraVars: `{"a":"{{ password }}"}`, valid: false},
+		{extraVars: `{"a":["{{ env.HOME }}"]}`, valid: false},
+		{extraVars: `{"a":"{{ date"}`, valid: false},
+	} {
+		extraVars, err := jsonutils.ParseString(c.extraVars)
+		if err != nil {
+			t.Fatalf("parse %s: %v", c.extraVars, err)
+		}
+		if err := validateCronjobExtraVarsTemplates(extraVars); (err == nil) != c.valid {
+			t.Errorf("validate %s: valid %v, got error %v", c.extraVars, c.valid, err)
+		}
+	}
+}
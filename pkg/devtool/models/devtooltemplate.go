@@ -20,8 +20,10 @@ import (
 	"yunion.io/x/jsonutils"
 	"yunion.io/x/log"
 
+	"yunion.io/x/onecloud/pkg/apis"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db/taskman"
+	"yunion.io/x/onecloud/pkg/devtool/options"
 	"yunion.io/x/onecloud/pkg/httperrors"
 	"yunion.io/x/onecloud/pkg/mcclient"
 	"yunion.io/x/onecloud/pkg/util/ansible"
@@ -53,6 +55,72 @@ func init() {
 	DevtoolTemplateManager.SetVirtualObject(DevtoolTemplateManager)
 }
 
+// validateTemplateSchedule checks the schedule of a template the same way as
+// the one of a cronjob, since it's copied to the cronjobs of the servers
+// bound to the template.
+func validateTemplateSchedule(schedule SVSCronjob, minInterval int64) error {
+	if err := validateCronjobSchedule(schedule.Day, schedule.Hour, schedule.Min, schedule.Sec, schedule.Interval, minInterval); err != nil {
+		return err
+	}
+	if _, err := loadCronjobTimeZone(schedule.TimeZone); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (manager *SDevtoolTemplateManager) ValidateCreateData(ctx context.Context, userCred mcclient.TokenCredential, ownerId mcclient.IIdentityProvider, query jsonutils.JSONObject, data *jsonutils.JSONDict) (*jsonutils.JSONDict, error) {
+	input := apis.VirtualResourceCreateInput{}
+	err := data.Unmarshal(&input)
+	if err != nil {
+		return nil, httperrors.NewInternalServerError("unmarshal VirtualResourceCreateInput fail %s", err)
+	}
+	input, err = manager.SVirtualResourceBaseManager.ValidateCreateData(ctx, userCred, ownerId, query, input)
+	if err != nil {
+		return nil, err
+	}
+	data.Update(jsonutils.Marshal(input))
+
+	schedule := SVSCronjob{}
+	err = data.Unmarshal(&schedule)
+	if err != nil {
+		return nil, httperrors.NewInputParameterError("invalid schedule: %v", err)
+	}
+	if err := validateTemplateSchedule(schedule, int64(options.Options.CronjobMinIntervalSeconds)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (obj *SDevtoolTemplate) ValidateUpdateData(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data *jsonutils.JSONDict) (*jsonutils.JSONDict, error) {
+	input := apis.VirtualResourceBaseUpdateInput{}
+	err := data.Unmarshal(&input)
+	if err != nil {
+		return nil, httperrors.NewInternalServerError("unmarshal VirtualResourceBaseUpdateInput fail %s", err)
+	}
+	input, err = obj.SVirtualResourceBase.ValidateUpdateData(ctx, userCred, query, input)
+	if err != nil {
+		return nil, err
+	}
+	data.Update(jsonutils.Marshal(input))
+
+	// the fields not updated keep the values of the template
+	schedule := obj.SVSCronjob
+	err = data.Unmarshal(&schedule)
+	if err != nil {
+		return nil, httperrors.NewInputParameterError("invalid schedule: %v", err)
+	}
+	// the minimal interval only applies to the interval updated, like the
+	// one of a cronjob
+	var minInterval int64
+	if data.Contains("interval") {
+		minInterval = int64(options.Options.CronjobMinIntervalSeconds)
+	}
+	if err := validateTemplateSchedule(schedule, minInterval); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 func (obj *SDevtoolTemplate) PerformBind(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) (jsonutils.JSONObject, error) {
 	// * get server id
 	// * get playbook struct and create obj
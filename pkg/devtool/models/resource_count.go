@@ -0,0 +1,39 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+)
+
+// countProjectResources counts the resources of the manager by project for
+// the scope resource count handler, the deleted and the pending deleted ones
+// aren't counted, since they are going away with the project.
+func countProjectResources(manager db.IModelManager) ([]db.SScopeResourceCount, error) {
+	q := manager.Query().IsFalse("pending_deleted")
+	return db.CalculateResourceCount(q, "tenant_id")
+}
+
+func (manager *SCronjobManager) GetResourceCount() ([]db.SScopeResourceCount, error) {
+	return countProjectResources(manager)
+}
+
+func (manager *SDevtoolTemplateManager) GetResourceCount() ([]db.SScopeResourceCount, error) {
+	return countProjectResources(manager)
+}
+
+func (manager *SScriptManager) GetResourceCount() ([]db.SScopeResourceCount, error) {
+	return countProjectResources(manager)
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"yunion.io/x/sqlchemy"
+	_ "yunion.io/x/sqlchemy/backends"
+
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/util/ansible"
+)
+
+var (
+	testDB     *sql.DB
+	testDBOnce sync.Once
+)
+
+// openTestDB backs the managers by the empty tables of an in-memory sqlite
+// database. The database is shared by the tests of the package, since the
+// table specs keep the database they are first synced to.
+func openTestDB(t *testing.T, managers ...db.IModelManager) {
+	testDBOnce.Do(func() {
+		conn, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatalf("open sqlite: %v", err)
+		}
+		// each connection of :memory: is a database of its own
+		conn.SetMaxOpenConns(1)
+		testDB = conn
+		sqlchemy.SetDBWithNameBackend(conn, sqlchemy.DefaultDB, sqlchemy.SQLiteBackend)
+	})
+	for _, manager := range managers {
+		if err := manager.TableSpec().Sync(); err != nil {
+			t.Fatalf("sync table of %s: %v", manager.Keyword(), err)
+		}
+		if _, err := testDB.Exec("DELETE FROM " + manager.TableSpec().Name()); err != nil {
+			t.Fatalf("clear table of %s: %v", manager.Keyword(), err)
+		}
+	}
+}
+
+func TestResourceCount(t *testing.T) {
+	for _, m := range []struct {
+		manager interface {
+			db.IModelManager
+			GetResourceCount() ([]db.SScopeResourceCount, error)
+		}
+		// newModel returns a model to insert and its virtual resource base
+		newModel func() (interface{}, *db.SVirtualResourceBase)
+	}{
+		{
+			manager: CronjobManager,
+			newModel: func() (interface{}, *db.SVirtualResourceBase) {
+				job := &SCronjob{AnsiblePlaybookID: "playbook"}
+				return job, &job.SVirtualResourceBase
+			},
+		},
+		{
+			manager: DevtoolTemplateManager,
+			newModel: func() (interface{}, *db.SVirtualResourceBase) {
+				template := &SDevtoolTemplate{Playbook: &ansible.Playbook{}}
+				return template, &template.SVirtualResourceBase
+			},
+		},
+		{
+			manager: ScriptManager,
+			newModel: func() (interface{}, *db.SVirtualResourceBase) {
+				script := &SScript{}
+				return script, &script.SVirtualResourceBase
+			},
+		},
+	} {
+		openTestDB(t, m.manager)
+		for _, c := range []struct {
+			id             string
+			projectId      string
+			deleted        bool
+			pendingDeleted bool
+		}{
+			{id: "res1", projectId: "p1"},
+			{id: "res2", projectId: "p1", deleted: true},
+			{id: "res3", projectId: "p2"},
+			{id: "res4", projectId: "p2", pendingDeleted: true},
+			{id: "res5", projectId: "p3", deleted: true},
+			{id: "res6", projectId: "p4", pendingDeleted: true},
+		} {
+			model, base := m.newModel()
+			base.Id = c.id
+			base.Name = c.id
+			base.ProjectId = c.projectId
+			base.Deleted = c.deleted
+			base.PendingDeleted = c.pendingDeleted
+			if err := m.manager.TableSpec().Insert(context.Background(), model); err != nil {
+				t.Fatalf("%s: insert %s: %v", m.manager.Keyword(), c.id, err)
+			}
+		}
+
+		cnts, err := m.manager.GetResourceCount()
+		if err != nil {
+			t.Fatalf("%s: GetResourceCount: %v", m.manager.Keyword(), err)
+		}
+		sort.Slice(cnts, func(i, j int) bool { return cnts[i].TenantId < cnts[j].TenantId })
+		want := []db.SScopeResourceCount{
+			{TenantId: "p1", ResCount: 1},
+			{TenantId: "p2", ResCount: 1},
+		}
+		if !reflect.DeepEqual(cnts, want) {
+			t.Errorf("%s: want %#v, got %#v", m.manager.Keyword(), want, cnts)
+		}
+	}
+}
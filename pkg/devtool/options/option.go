@@ -21,6 +21,8 @@ type DevToolOptions struct {
 	common_options.DBOptions
 
 	MonitorAgentUseMetadataService bool `help:"Monitor agent report metrics to metadata service on host" default:"true"`
+
+	CronjobMinIntervalSeconds int `help:"The minimal interval in seconds of the cronjobs running at intervals" default:"10"`
 }
 
 var (
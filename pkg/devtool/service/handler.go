@@ -17,6 +17,7 @@ package service
 import (
 	"yunion.io/x/onecloud/pkg/appsrv"
 	"yunion.io/x/onecloud/pkg/appsrv/dispatcher"
+	"yunion.io/x/onecloud/pkg/cloudcommon/cronman"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db/taskman"
 	"yunion.io/x/onecloud/pkg/devtool/models"
@@ -24,8 +25,11 @@ import (
 
 func InitHandlers(app *appsrv.Application) {
 	db.InitAllManagers()
+	db.RegistUserCredCacheUpdater()
+	db.AddScopeResourceCountHandler("", app)
 
 	taskman.AddTaskHandler("", app)
+	cronman.AddCronJobStatsHandler("", app)
 
 	for _, manager := range []db.IModelManager{
 		taskman.TaskManager,
@@ -51,6 +51,10 @@ func AddGuestTaskHandler(prefix string, app *appsrv.Application) {
 			fmt.Sprintf("%s/%s/<sid>/status", prefix, keyWord),
 			auth.Authenticate(getStatus))
 
+		app.AddHandler("GET",
+			fmt.Sprintf("%s/%s/<sid>/container-stats", prefix, keyWord),
+			auth.Authenticate(getContainerStats))
+
 		app.AddHandler("POST",
 			fmt.Sprintf("%s/%s/cpu-node-balance", prefix, keyWord),
 			auth.Authenticate(cpusetBalance))
@@ -151,6 +155,46 @@ func getStatus(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	hostutils.ResponseOk(ctx, w)
 }
 
+func getContainerStats(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	params, _, _ := appsrv.FetchEnv(ctx, w, r)
+	sid := params["<sid>"]
+	res, err := guestContainerStats(sid)
+	if err != nil {
+		hostutils.Response(ctx, w, err)
+		return
+	}
+	hostutils.Response(ctx, w, res)
+}
+
+// guestContainerStats returns the stats of the pod of a container guest,
+// the pod is keyed by guest id and the CRI identifiers are left out
+func guestContainerStats(sid string) (jsonutils.JSONObject, error) {
+	gm := guestman.GetGuestManager()
+	guest, ok := gm.GetServer(sid)
+	if !ok {
+		return nil, httperrors.NewNotFoundError("Guest %s not found", sid)
+	}
+	if _, ok := guest.(guestman.PodInstance); !ok {
+		return nil, httperrors.NewNotFoundError("Guest %s is not a container guest", sid)
+	}
+	provider := gm.GetHost().GetContainerStatsProvider()
+	if provider == nil {
+		return nil, httperrors.NewNotFoundError("container stats of guest %s not available", sid)
+	}
+	podStats, err := provider.GetPodStats(sid)
+	if err != nil {
+		if errors.Cause(err) == errors.ErrNotFound {
+			return nil, httperrors.NewNotFoundError("Guest %s has no running container", sid)
+		}
+		return nil, errors.Wrapf(err, "get pod stats of guest %s", sid)
+	}
+	ret := jsonutils.Marshal(podStats).(*jsonutils.JSONDict)
+	ret.Remove("podRef")
+	ret.Set("guest_id", jsonutils.NewString(sid))
+	ret.Set("guest_name", jsonutils.NewString(guest.GetDesc().Name))
+	return ret, nil
+}
+
 func cpusetBalance(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	hostutils.DelayTask(ctx, guestman.GetGuestManager().CpusetBalance, nil)
 	hostutils.ResponseOk(ctx, w)
@@ -69,6 +69,7 @@ import (
 	"yunion.io/x/onecloud/pkg/util/pod"
 	"yunion.io/x/onecloud/pkg/util/pod/logs"
 	"yunion.io/x/onecloud/pkg/util/pod/nerdctl"
+	"yunion.io/x/onecloud/pkg/util/pod/stats"
 	"yunion.io/x/onecloud/pkg/util/procutils"
 )
 
@@ -864,7 +865,13 @@ func (s *sPodGuestInstance) getOtherPods() []*sPodGuestInstance {
 }
 
 func (s *sPodGuestInstance) getCgroupParent() string {
-	return "/cloudpods"
+	return pod.POD_CGROUP_PARENT
+}
+
+// getContainerCgroupName returns the cgroup of the container relative to
+// the cgroup root.
+func (s *sPodGuestInstance) getContainerCgroupName(criId string) string {
+	return path.Join(s.getCgroupParent(), criId)
 }
 
 type localDirtyPodStartTask struct {
@@ -1077,6 +1084,10 @@ func (s *sPodGuestInstance) _startPod(ctx context.Context, userCred mcclient.Tok
 	if err := s.setCRIInfo(ctx, userCred, criId, podCfg); err != nil {
 		return nil, errors.Wrap(err, "setCRIId")
 	}
+	// the netns of the new sandbox isn't shaped yet
+	if err := s.syncPodBandwidthLimit(ctx, userCred, criId, pod.BandwidthLimit{}); err != nil {
+		return nil, errors.Wrap(err, "syncPodBandwidthLimit")
+	}
 	// set pod cgroup resources
 	if err := s.setPodCgroupResources(criId, s.GetDesc().Mem, s.GetDesc().Cpu); err != nil {
 		return nil, errors.Wrapf(err, "set pod %s cgroup memMB %d, cpu %d", criId, s.GetDesc().Mem, s.GetDesc().Cpu)
@@ -1114,6 +1125,7 @@ func (s *sPodGuestInstance) ensurePodRemoved(ctx context.Context, timeout int64)
 	}); err != nil {
 		return errors.Wrapf(err, "stop cri pod: %s", s.GetCRIId())
 	}*/
+	s.removePodBandwidthLimit(ctx)
 	criId := s.GetCRIId()
 	if criId != "" {
 		if err := s.getCRI().RemovePod(ctx, s.GetCRIId()); err != nil {
@@ -1206,6 +1218,7 @@ func (s *sPodGuestInstance) PostLoad(m *SGuestManager) error {
 }
 
 func (s *sPodGuestInstance) SyncConfig(ctx context.Context, guestDesc *desc.SGuestDesc, fwOnly, setUefiBootOrder bool) (jsonutils.JSONObject, error) {
+	applied := s.getAppliedBandwidthLimit()
 	if err := SaveDesc(s, guestDesc); err != nil {
 		return nil, errors.Wrap(err, "SaveDesc")
 	}
@@ -1219,6 +1232,12 @@ func (s *sPodGuestInstance) SyncConfig(ctx context.Context, guestDesc *desc.SGue
 	if err := SaveLiveDesc(s, s.Desc); err != nil {
 		return nil, errors.Wrap(err, "SaveLiveDesc")
 	}
+	if criId := s.GetCRIId(); criId != "" && s.IsRunning() {
+		userCred := hostutils.GetComputeSession(ctx).GetToken()
+		if err := s.syncPodBandwidthLimit(ctx, userCred, criId, applied); err != nil {
+			return nil, errors.Wrap(err, "syncPodBandwidthLimit")
+		}
+	}
 	return nil, nil
 }
 
@@ -1313,6 +1332,12 @@ func (s *sPodGuestInstance) StartContainer(ctx context.Context, userCred mcclien
 			return nil, errors.Wrap(err, "set cgroup pids.max")
 		}
 	}
+	if input.Spec.SimulateCpu && options.HostOptions.ContainerCpusetFromCpuMap {
+		if err := s.getHostCPUMap().ApplyCPUSet(ctrId, s.getContainerCgroupName(criId)); err != nil {
+			return nil, errors.Wrap(err, "apply cpuset of the container cpu map")
+		}
+	}
+	s.setContainerOOMScoreAdj(ctx, criId)
 	if err := s.doContainerStartPostLifecycle(ctx, criId, input); err != nil {
 		return nil, errors.Wrap(err, "do container lifecycle")
 	}
@@ -1408,6 +1433,38 @@ func (s *sPodGuestInstance) allocateCpuNumaPin() error {
 	return SaveLiveDesc(s, s.Desc)
 }
 
+// getPodQOSClass returns the qos class of the pod by its desc. Every
+// container of the pod is created with the memory limit and the cpu pinning
+// of the pod, so they all count as limited or pinned if the pod is.
+func (s *sPodGuestInstance) getPodQOSClass() stats.PodQOSClass {
+	desc := s.GetDesc()
+	containers := len(s.GetContainers())
+	pinned, memoryLimited := 0, 0
+	if len(desc.CpuNumaPin) > 0 || len(desc.VcpuPin) > 0 {
+		pinned = containers
+	}
+	if desc.Mem > 0 {
+		memoryLimited = containers
+	}
+	return stats.NewPodQOSClass(containers, pinned, memoryLimited)
+}
+
+// setContainerOOMScoreAdj sets the oom_score_adj of the started container by
+// the qos class of the pod, so best effort pods are killed first on out of
+// memory. Only the init process of the container is written, the processes
+// it forks inherit the value, while the exec sessions started by the runtime
+// keep the default of the kernel. It's best effort, the failure is logged.
+func (s *sPodGuestInstance) setContainerOOMScoreAdj(ctx context.Context, criId string) {
+	class := s.getPodQOSClass()
+	if class == stats.PodQOSUnknown {
+		return
+	}
+	adj := class.OOMScoreAdj()
+	if err := pod.SetContainerOOMScoreAdj(ctx, s.getCRI(), criId, adj); err != nil {
+		log.Warningf("set oom_score_adj %d of container %s of pod %s: %v", adj, criId, s.GetName(), err)
+	}
+}
+
 func (s *sPodGuestInstance) doContainerStartPostLifecycle(ctx context.Context, criId string, input *hostapi.ContainerCreateInput) error {
 	ls := input.Spec.Lifecyle
 	if ls == nil {
@@ -1741,6 +1798,79 @@ func (s *sPodGuestInstance) setContainerResourcesLimit(ctrId string, limit *apis
 	return nil
 }
 
+// getAppliedBandwidthLimit returns the bandwidth limit recorded in the desc
+// as applied to the sandbox of the pod.
+func (s *sPodGuestInstance) getAppliedBandwidthLimit() pod.BandwidthLimit {
+	limit, err := pod.ParseBandwidthLimit(s.GetSourceDesc().Metadata[computeapi.POD_METADATA_BANDWIDTH_LIMIT])
+	if err != nil {
+		log.Warningf("pod %s(%s): %v", s.GetName(), s.Id, err)
+	}
+	return limit
+}
+
+// syncPodBandwidthLimit shapes the network namespace of the pod sandbox to
+// the bandwidth limit of the pod, which is shared by all the containers of
+// the pod. The shaping applied before is removed if the pod has no limit.
+func (s *sPodGuestInstance) syncPodBandwidthLimit(ctx context.Context, userCred mcclient.TokenCredential, podId string, applied pod.BandwidthLimit) error {
+	input, err := s.getPodCreateParams()
+	if err != nil {
+		return errors.Wrap(err, "getPodCreateParams")
+	}
+	limit := pod.BandwidthLimit{}
+	if bw := input.BandwidthLimit; bw != nil {
+		limit = pod.NewBandwidthLimitMbps(bw.EgressMbps, bw.IngressMbps)
+	}
+	if limit != applied {
+		if err := pod.SetPodBandwidthLimit(ctx, s.getCRI(), podId, limit); err != nil {
+			return errors.Wrapf(err, "set bandwidth limit %#v", limit)
+		}
+	}
+	return s.setBandwidthLimitMetadata(ctx, userCred, limit)
+}
+
+// setBandwidthLimitMetadata records the bandwidth limit applied to the pod
+// sandbox in the desc, where the stats of the pod report it from.
+func (s *sPodGuestInstance) setBandwidthLimitMetadata(ctx context.Context, userCred mcclient.TokenCredential, limit pod.BandwidthLimit) error {
+	limitStr := ""
+	if !limit.IsZero() {
+		limitStr = jsonutils.Marshal(limit).String()
+	}
+	if s.GetSourceDesc().Metadata[computeapi.POD_METADATA_BANDWIDTH_LIMIT] == limitStr {
+		return nil
+	}
+	if limitStr == "" {
+		delete(s.Desc.Metadata, computeapi.POD_METADATA_BANDWIDTH_LIMIT)
+	} else {
+		s.Desc.Metadata[computeapi.POD_METADATA_BANDWIDTH_LIMIT] = limitStr
+	}
+	session := auth.GetSession(ctx, userCred, options.HostOptions.Region)
+	if _, err := computemod.Servers.SetMetadata(session, s.GetId(), jsonutils.Marshal(map[string]string{
+		computeapi.POD_METADATA_BANDWIDTH_LIMIT: limitStr,
+	})); err != nil {
+		return errors.Wrapf(err, "set bandwidth_limit of pod %s", s.GetId())
+	}
+	return SaveDesc(s, s.Desc)
+}
+
+// removePodBandwidthLimit removes the shaping of the pod before its sandbox
+// is removed, it's best effort since the shaping goes with the netns anyway.
+func (s *sPodGuestInstance) removePodBandwidthLimit(ctx context.Context) {
+	if s.getAppliedBandwidthLimit().IsZero() {
+		return
+	}
+	podId := s.GetCRIId()
+	if p, _ := s.getPod(ctx); p != nil {
+		podId = p.GetId()
+	}
+	if err := pod.SetPodBandwidthLimit(ctx, s.getCRI(), podId, pod.BandwidthLimit{}); err != nil {
+		log.Warningf("remove bandwidth limit of pod %s(%s): %v", s.GetName(), s.Id, err)
+	}
+	userCred := hostutils.GetComputeSession(ctx).GetToken()
+	if err := s.setBandwidthLimitMetadata(ctx, userCred, pod.BandwidthLimit{}); err != nil {
+		log.Warningf("clear bandwidth limit of pod %s(%s): %v", s.GetName(), s.Id, err)
+	}
+}
+
 func (s *sPodGuestInstance) getDefaultCPUPeriod() int64 {
 	return 100000
 }
@@ -2754,16 +2884,18 @@ func (s *sPodGuestInstance) CommitContainer(ctx context.Context, userCred mcclie
 	log.Infof("container %s was commited to %s", ctrId, imgRepo)
 
 	// 2. push to repository
-	if err := PushContainerdImage(&hostapi.ContainerPushImageInput{
+	digest, err := PushContainerdImage(&hostapi.ContainerPushImageInput{
 		Image: imgRepo,
 		Auth:  input.Auth,
-	}); err != nil {
+	})
+	if err != nil {
 		return nil, errors.Wrapf(err, "push container %s image", ctrId)
 	}
-	log.Infof("container %s was pushed to %s", ctrId, imgRepo)
+	log.Infof("container %s was pushed to %s@%s", ctrId, imgRepo, digest)
 
 	return jsonutils.Marshal(map[string]interface{}{
 		"image_repository": imgRepo,
+		"image_digest":     digest,
 	}), nil
 }
 
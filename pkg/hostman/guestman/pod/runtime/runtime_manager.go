@@ -307,6 +307,43 @@ func GetSandboxIDByPodUID(cri pod.CRI, podUID string, state *runtimeapi.PodSandb
 	return sandboxIDs, nil
 }
 
+// ContainerRef references a running container of a guest in the CRI runtime
+type ContainerRef struct {
+	Id           string
+	PodSandboxId string
+	Name         string
+}
+
+// FindContainersByGuestId lists the running containers labeled with the guest id,
+// an empty slice is returned when the guest has no running containers
+func FindContainersByGuestId(ctx context.Context, cri pod.CRI, guestId string) ([]ContainerRef, error) {
+	ctrs, err := cri.ListContainers(ctx, pod.ListContainerOptions{
+		State: "running",
+		Labels: map[string]string{
+			PodUIDLabel: guestId,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "ListContainers of guest %s", guestId)
+	}
+	refs := make([]ContainerRef, 0, len(ctrs))
+	for _, ctr := range ctrs {
+		name := GetContainerName(ctr.GetLabels())
+		if name == "" && ctr.GetMetadata() != nil {
+			name = ctr.GetMetadata().GetName()
+		}
+		refs = append(refs, ContainerRef{
+			Id:           ctr.GetId(),
+			PodSandboxId: ctr.GetPodSandboxId(),
+			Name:         name,
+		})
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+	return refs, nil
+}
+
 func (m *runtimeManager) getSandboxIDByPodUID(podUID string, state *runtimeapi.PodSandboxState) ([]string, error) {
 	return GetSandboxIDByPodUID(m.cri, podUID, state)
 }
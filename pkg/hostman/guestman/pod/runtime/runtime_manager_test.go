@@ -0,0 +1,118 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"yunion.io/x/onecloud/pkg/util/pod"
+)
+
+type fakeCRI struct {
+	pod.CRI
+
+	containers []*runtimeapi.Container
+}
+
+func (f *fakeCRI) ListContainers(ctx context.Context, opts pod.ListContainerOptions) ([]*runtimeapi.Container, error) {
+	ret := make([]*runtimeapi.Container, 0)
+	for _, ctr := range f.containers {
+		if opts.State == "running" && ctr.State != runtimeapi.ContainerState_CONTAINER_RUNNING {
+			continue
+		}
+		matched := true
+		for k, v := range opts.Labels {
+			if ctr.Labels[k] != v {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			ret = append(ret, ctr)
+		}
+	}
+	return ret, nil
+}
+
+func TestFindContainersByGuestId(t *testing.T) {
+	cri := &fakeCRI{
+		containers: []*runtimeapi.Container{
+			{
+				Id:           "c2",
+				PodSandboxId: "s1",
+				Metadata:     &runtimeapi.ContainerMetadata{Name: "meta-web"},
+				Labels:       map[string]string{PodUIDLabel: "guest1", ContainerNameLabel: "web"},
+				State:        runtimeapi.ContainerState_CONTAINER_RUNNING,
+			},
+			{
+				Id:           "c1",
+				PodSandboxId: "s1",
+				Metadata:     &runtimeapi.ContainerMetadata{Name: "app"},
+				Labels:       map[string]string{PodUIDLabel: "guest1"},
+				State:        runtimeapi.ContainerState_CONTAINER_RUNNING,
+			},
+			{
+				Id:           "c3",
+				PodSandboxId: "s1",
+				Labels:       map[string]string{PodUIDLabel: "guest1", ContainerNameLabel: "exited"},
+				State:        runtimeapi.ContainerState_CONTAINER_EXITED,
+			},
+			{
+				Id:           "c4",
+				PodSandboxId: "s2",
+				Labels:       map[string]string{PodUIDLabel: "guest2", ContainerNameLabel: "db"},
+				State:        runtimeapi.ContainerState_CONTAINER_RUNNING,
+			},
+		},
+	}
+	cases := []struct {
+		guestId string
+		want    []ContainerRef
+	}{
+		{
+			guestId: "guest1",
+			want: []ContainerRef{
+				{Id: "c1", PodSandboxId: "s1", Name: "app"},
+				{Id: "c2", PodSandboxId: "s1", Name: "web"},
+			},
+		},
+		{
+			guestId: "guest2",
+			want: []ContainerRef{
+				{Id: "c4", PodSandboxId: "s2", Name: "db"},
+			},
+		},
+		{
+			guestId: "guest3",
+			want:    []ContainerRef{},
+		},
+	}
+	for _, c := range cases {
+		got, err := FindContainersByGuestId(context.Background(), cri, c.guestId)
+		if err != nil {
+			t.Fatalf("FindContainersByGuestId(%s): %v", c.guestId, err)
+		}
+		if got == nil {
+			t.Errorf("FindContainersByGuestId(%s) returns nil slice", c.guestId)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("FindContainersByGuestId(%s) = %#v, want %#v", c.guestId, got, c.want)
+		}
+	}
+}
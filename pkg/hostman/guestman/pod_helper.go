@@ -108,7 +108,9 @@ func trimPullImageError(err string) string {
 	return strings.Join(filterLines, "\n")
 }
 
-func PushContainerdImage(input *hostapi.ContainerPushImageInput) error {
+// PushContainerdImage pushes the image and returns the digest of the
+// manifest pushed.
+func PushContainerdImage(input *hostapi.ContainerPushImageInput) (string, error) {
 	opt := &image.PushOptions{
 		RepoCommonOptions: image.RepoCommonOptions{
 			SkipVerify: true,
@@ -119,19 +121,20 @@ func PushContainerdImage(input *hostapi.ContainerPushImageInput) error {
 		opt.Password = input.Auth.Password
 	}
 	imgTool := NewContainerdImageTool()
-	err := imgTool.Push(input.Image, opt)
+	digest, err := imgTool.Push(input.Image, opt)
 	errs := make([]error, 0)
 	if err != nil {
 		// try http protocol
 		errs = append(errs, errors.Wrap(err, "pushImageByCtrCmd: %s"))
 		opt.PlainHttp = true
 		log.Infof("try push image %s by http", input.Image)
-		if err := imgTool.Push(input.Image, opt); err != nil {
+		digest, err = imgTool.Push(input.Image, opt)
+		if err != nil {
 			errs = append(errs, errors.Wrapf(err, "pushImageByCtrCmd by http"))
-			return errors.NewAggregate(errs)
+			return "", errors.NewAggregate(errs)
 		}
 	}
-	return nil
+	return digest, nil
 }
 
 type ContainerVolumeKey struct {
@@ -53,6 +53,10 @@ func AddHostHandler(prefix string, app *appsrv.Application) {
 				auth.Authenticate(hostActions(f)),
 			)
 		}
+		app.AddHandler("GET",
+			fmt.Sprintf("%s/%s/<sid>/container-stats-snapshots", prefix, keyword),
+			auth.Authenticate(hostActions(hostContainerStatsSnapshots)),
+		)
 	}
 }
 
@@ -102,6 +106,19 @@ func hostRestart(ctx context.Context, hostId string, body jsonutils.JSONObject)
 	return nil, nil
 }
 
+// hostContainerStatsSnapshots returns the latest container stats retained
+// for post-mortem debugging, along with the counters of the provider.
+func hostContainerStatsSnapshots(ctx context.Context, hostId string, body jsonutils.JSONObject) (interface{}, error) {
+	provider := hostinfo.Instance().GetContainerStatsProvider()
+	if provider == nil {
+		return nil, httperrors.NewNotFoundError("container stats provider not found")
+	}
+	return jsonutils.Marshal(map[string]interface{}{
+		"snapshots":      provider.ListStatsSnapshots(),
+		"provider_stats": provider.GetProviderStats(),
+	}), nil
+}
+
 func hostProbeIsolatedDevices(ctx context.Context, hostId string, body jsonutils.JSONObject) (interface{}, error) {
 	_, err := hostinfo.Instance().ProbeSyncIsolatedDevices(hostId, body)
 	return nil, err
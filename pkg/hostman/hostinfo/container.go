@@ -17,16 +17,20 @@ package hostinfo
 import (
 	"context"
 	"path"
+	"path/filepath"
 	"time"
 
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"yunion.io/x/jsonutils"
 	"yunion.io/x/log"
 	"yunion.io/x/pkg/errors"
-	"yunion.io/x/pkg/utils"
 
 	apis "yunion.io/x/onecloud/pkg/apis/compute"
 	hostapi "yunion.io/x/onecloud/pkg/apis/host"
 	"yunion.io/x/onecloud/pkg/hostman/isolated_device"
 	"yunion.io/x/onecloud/pkg/hostman/options"
+	"yunion.io/x/onecloud/pkg/util/fileutils2"
 	"yunion.io/x/onecloud/pkg/util/pod"
 	"yunion.io/x/onecloud/pkg/util/pod/cadvisor"
 	"yunion.io/x/onecloud/pkg/util/pod/stats"
@@ -53,9 +57,76 @@ func (h *SHostInfo) initContainerCPUMap(topo *hostapi.HostTopology) error {
 		return errors.Wrap(err, "NewHostContainerCPUMap")
 	}
 	h.containerCPUMap = cm
+	h.reconcileContainerCPUMap(cm)
 	return nil
 }
 
+// reconcileContainerCPUMap frees the cpus of the containers removed while
+// the host agent is down, and pins the running ones again.
+func (h *SHostInfo) reconcileContainerCPUMap(cm *pod.HostContainerCPUMap) {
+	existing, liveCgroups, err := h.listContainerCgroups(context.Background())
+	if err != nil {
+		// nothing is freed without knowing the existing containers
+		log.Errorf("skip reconciling container cpu map: %v", err)
+		return
+	}
+	result, err := cm.Reconcile(existing, liveCgroups, options.HostOptions.ContainerCpusetFromCpuMap)
+	if err != nil {
+		log.Errorf("reconcile container cpu map: %v", err)
+	}
+	if result != nil {
+		log.Infof("reconcile container cpu map: %d freed, %d reapplied, %d drifted", len(result.Freed), len(result.Reapplied), len(result.Drifted))
+	}
+}
+
+// listContainerCgroups returns the containers of the pods which still exist
+// in the runtime in any state, and the cgroups of the running ones, by
+// container id. The container ids are mapped to the cri ids by the containers
+// file of each pod, e.g. $servers_path/$pod_id/containers.
+func (h *SHostInfo) listContainerCgroups(ctx context.Context) (map[string]bool, map[string]string, error) {
+	ctrs, err := h.cri.ListContainers(ctx, pod.ListContainerOptions{})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "list containers")
+	}
+	states := make(map[string]runtimeapi.ContainerState)
+	for _, ctr := range ctrs {
+		states[ctr.GetId()] = ctr.GetState()
+	}
+	ctrFiles, err := filepath.Glob(path.Join(options.HostOptions.ServersPath, "*", "containers"))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "find pod containers files")
+	}
+	existing := make(map[string]bool)
+	liveCgroups := make(map[string]string)
+	for _, ctrFile := range ctrFiles {
+		content, err := fileutils2.FileGetContents(ctrFile)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "read %s", ctrFile)
+		}
+		obj, err := jsonutils.ParseString(content)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "parse %s", ctrFile)
+		}
+		podCtrs := make(map[string]struct {
+			CRIId string `json:"cri_id"`
+		})
+		if err := obj.Unmarshal(podCtrs); err != nil {
+			return nil, nil, errors.Wrapf(err, "unmarshal %s", ctrFile)
+		}
+		for ctrId, ctr := range podCtrs {
+			state, ok := states[ctr.CRIId]
+			if ctr.CRIId == "" || !ok {
+				continue
+			}
+			existing[ctrId] = true
+			if state == runtimeapi.ContainerState_CONTAINER_RUNNING {
+				liveCgroups[ctrId] = path.Join(pod.POD_CGROUP_PARENT, ctr.CRIId)
+			}
+		}
+	}
+	return existing, liveCgroups, nil
+}
+
 func (h *SHostInfo) startContainerStatsProvider(cri pod.CRI) error {
 	ca, err := cadvisor.New(nil, "/opt/cloud/workspace", []string{"cloudpods"})
 	if err != nil {
@@ -64,10 +135,55 @@ func (h *SHostInfo) startContainerStatsProvider(cri pod.CRI) error {
 	if err := ca.Start(); err != nil {
 		return errors.Wrap(err, "start cadvisor")
 	}
-	h.containerStatsProvider = stats.NewCRIContainerStatsProvider(ca, cri.GetRuntimeClient(), cri.GetImageClient())
+	var createLatencyBuckets []time.Duration
+	if options.HostOptions.ContainerStatsCreateLatency {
+		createLatencyBuckets = stats.DefaultCreateLatencyBuckets
+	}
+	h.containerStatsProvider = stats.NewCRIContainerStatsProvider(ca, cri.GetRuntimeClient(), cri.GetImageClient(), stats.CRIStatsProviderConfig{
+		ListContainerStatsPerPodThreshold: options.HostOptions.ContainerStatsPerPodThreshold,
+		StatsSnapshotCount:                options.HostOptions.ContainerStatsSnapshotCount,
+		StatsSnapshotFile:                 options.HostOptions.ContainerStatsSnapshotFile,
+		ListPodStatsCacheTTL:              time.Duration(options.HostOptions.ContainerStatsCacheTTLMs) * time.Millisecond,
+		MaxCPUUsageCacheEntries:           options.HostOptions.ContainerStatsCpuCacheMaxEntries,
+		CreateLatencyBuckets:              createLatencyBuckets,
+		MaxContainersPerCollection:        options.HostOptions.ContainerStatsMaxContainers,
+		HostId:                            h.GetHostId,
+		// the pod logs are under the home dir of the pod guest
+		PodLogsDirectory: func(podUID string) string {
+			return path.Join(options.HostOptions.ServersPath, podUID, "logs")
+		},
+		PodBandwidthLimit: func(podUID string) (uint64, uint64, bool) {
+			limit, err := getPodBandwidthLimit(podUID)
+			if err != nil {
+				log.Warningf("get bandwidth limit of pod %s: %v", podUID, err)
+				return 0, 0, false
+			}
+			return limit.EgressBps, limit.IngressBps, !limit.IsZero()
+		},
+	})
+	log.Infof("Container runtime stats capabilities: %s", h.containerStatsProvider.RuntimeCapabilities())
 	return nil
 }
 
+// getPodBandwidthLimit returns the bandwidth limit applied to the pod, which
+// is recorded in the metadata of its desc, e.g. $servers_path/$pod_id/source-desc.
+func getPodBandwidthLimit(podUID string) (pod.BandwidthLimit, error) {
+	descFile := path.Join(options.HostOptions.ServersPath, podUID, "source-desc")
+	if !fileutils2.Exists(descFile) {
+		return pod.BandwidthLimit{}, nil
+	}
+	content, err := fileutils2.FileGetContents(descFile)
+	if err != nil {
+		return pod.BandwidthLimit{}, errors.Wrapf(err, "read %s", descFile)
+	}
+	obj, err := jsonutils.ParseString(content)
+	if err != nil {
+		return pod.BandwidthLimit{}, errors.Wrapf(err, "parse %s", descFile)
+	}
+	limitStr, _ := obj.GetString("metadata", apis.POD_METADATA_BANDWIDTH_LIMIT)
+	return pod.ParseBandwidthLimit(limitStr)
+}
+
 func (h *SHostInfo) GetCRI() pod.CRI {
 	return h.cri
 }
@@ -87,8 +203,9 @@ type INvidiaGpuIndexMemoryInterface interface {
 
 func (h *SHostInfo) GetNvidiaGpuIndexMemoryMap() map[string]int {
 	res := map[string]int{}
-	for i := range h.containerNvidiaGpus {
-		iDev, ok := h.containerNvidiaGpus[i].(INvidiaGpuIndexMemoryInterface)
+	nvDevs := h.GetContainerGpus(apis.CONTAINER_GPU_VENDOR_NVIDIA)
+	for i := range nvDevs {
+		iDev, ok := nvDevs[i].(INvidiaGpuIndexMemoryInterface)
 		if !ok {
 			continue
 		}
@@ -99,50 +216,47 @@ func (h *SHostInfo) GetNvidiaGpuIndexMemoryMap() map[string]int {
 	return res
 }
 
-func (h *SHostInfo) HasContainerVastaitechGpu() bool {
-	if h.hasVastaitechGpus != nil {
-		return *h.hasVastaitechGpus
-	}
-	hasVastaitechGpus := false
-	devs := h.IsolatedDeviceMan.GetDevices()
-	for i := range devs {
-		if devs[i].GetDeviceType() == apis.CONTAINER_DEV_VASTAITECH_GPU {
-			hasVastaitechGpus = true
+// GetContainerGpus returns the container gpus of the vendor, the isolated
+// devices are scanned once and cached for all the vendors until
+// InvalidateGpuCache is called
+func (h *SHostInfo) GetContainerGpus(vendor apis.ContainerGPUVendor) []isolated_device.IDevice {
+	h.containerGpusLock.Lock()
+	defer h.containerGpusLock.Unlock()
+
+	if h.containerGpus == nil {
+		gpus := make(map[apis.ContainerGPUVendor][]isolated_device.IDevice)
+		devs := h.IsolatedDeviceMan.GetDevices()
+		for i := range devs {
+			if v := apis.GPUVendor(devs[i].GetDeviceType()); v != apis.CONTAINER_GPU_VENDOR_UNKNOWN {
+				gpus[v] = append(gpus[v], devs[i])
+			}
 		}
+		h.containerGpus = gpus
 	}
-	h.hasVastaitechGpus = &hasVastaitechGpus
-	return *h.hasVastaitechGpus
+	return h.containerGpus[vendor]
+}
+
+// InvalidateGpuCache drops the cached container gpus, the next query
+// rescans the isolated devices
+func (h *SHostInfo) InvalidateGpuCache() {
+	h.containerGpusLock.Lock()
+	defer h.containerGpusLock.Unlock()
+
+	h.containerGpus = nil
+}
+
+func (h *SHostInfo) HasContainerGpu(vendor apis.ContainerGPUVendor) bool {
+	return len(h.GetContainerGpus(vendor)) > 0
+}
+
+func (h *SHostInfo) HasContainerVastaitechGpu() bool {
+	return h.HasContainerGpu(apis.CONTAINER_GPU_VENDOR_VASTAITECH)
 }
 
 func (h *SHostInfo) HasContainerCphAmdGpu() bool {
-	if h.hasCphAmdGpus != nil {
-		return *h.hasCphAmdGpus
-	}
-	hasCphAmdGpus := false
-	devs := h.IsolatedDeviceMan.GetDevices()
-	for i := range devs {
-		if devs[i].GetDeviceType() == apis.CONTAINER_DEV_CPH_AMD_GPU {
-			hasCphAmdGpus = true
-		}
-	}
-	h.hasCphAmdGpus = &hasCphAmdGpus
-	return *h.hasCphAmdGpus
+	return h.HasContainerGpu(apis.CONTAINER_GPU_VENDOR_CPH_AMD)
 }
 
 func (h *SHostInfo) HasContainerNvidiaGpu() bool {
-	if h.hasNvidiaGpus != nil {
-		return *h.hasNvidiaGpus
-	}
-	hasNvidiaGpus := false
-	nvDevs := make([]isolated_device.IDevice, 0)
-	devs := h.IsolatedDeviceMan.GetDevices()
-	for i := range devs {
-		if utils.IsInStringArray(devs[i].GetDeviceType(), apis.NVIDIA_GPU_TYPES) {
-			hasNvidiaGpus = true
-			nvDevs = append(nvDevs, devs[i])
-		}
-	}
-	h.hasNvidiaGpus = &hasNvidiaGpus
-	h.containerNvidiaGpus = nvDevs
-	return *h.hasNvidiaGpus
+	return h.HasContainerGpu(apis.CONTAINER_GPU_VENDOR_NVIDIA)
 }
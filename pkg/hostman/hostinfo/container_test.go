@@ -0,0 +1,83 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hostinfo
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+	"yunion.io/x/onecloud/pkg/hostman/isolated_device"
+)
+
+type fakeGpuDevice struct {
+	isolated_device.IDevice
+	devType string
+}
+
+func (d *fakeGpuDevice) GetDeviceType() string {
+	return d.devType
+}
+
+type fakeIsolatedDeviceManager struct {
+	isolated_device.IsolatedDeviceManager
+	devices []isolated_device.IDevice
+	scans   int32
+}
+
+func (m *fakeIsolatedDeviceManager) GetDevices() []isolated_device.IDevice {
+	atomic.AddInt32(&m.scans, 1)
+	return m.devices
+}
+
+func TestContainerGpuCacheConcurrent(t *testing.T) {
+	man := &fakeIsolatedDeviceManager{
+		devices: []isolated_device.IDevice{
+			&fakeGpuDevice{devType: api.CONTAINER_DEV_NVIDIA_GPU},
+			&fakeGpuDevice{devType: api.CONTAINER_DEV_VASTAITECH_GPU},
+		},
+	}
+	h := &SHostInfo{IsolatedDeviceMan: man}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !h.HasContainerNvidiaGpu() {
+				t.Errorf("nvidia gpu not found")
+			}
+			if !h.HasContainerVastaitechGpu() {
+				t.Errorf("vastaitech gpu not found")
+			}
+			if h.HasContainerCphAmdGpu() {
+				t.Errorf("unexpected cph amd gpu")
+			}
+		}()
+	}
+	wg.Wait()
+	if scans := atomic.LoadInt32(&man.scans); scans != 1 {
+		t.Errorf("devices scanned %d times, want 1", scans)
+	}
+
+	h.InvalidateGpuCache()
+	if got := len(h.GetContainerGpus(api.CONTAINER_GPU_VENDOR_NVIDIA)); got != 1 {
+		t.Errorf("nvidia gpus = %d, want 1", got)
+	}
+	if scans := atomic.LoadInt32(&man.scans); scans != 2 {
+		t.Errorf("devices scanned %d times after invalidation, want 2", scans)
+	}
+}
@@ -128,10 +128,10 @@ type SHostInfo struct {
 	containerCPUMap                *pod.HostContainerCPUMap
 	containerStatsProvider         stats.ContainerStatsProvider
 	containerCpufreqSimulateConfig *jsonutils.JSONDict
-	containerNvidiaGpus            []isolated_device.IDevice
-	hasNvidiaGpus                  *bool
-	hasVastaitechGpus              *bool
-	hasCphAmdGpus                  *bool
+	// containerGpus caches the container gpus of each vendor, it is nil
+	// until the isolated devices are scanned by the first query
+	containerGpus     map[api.ContainerGPUVendor][]isolated_device.IDevice
+	containerGpusLock sync.Mutex
 }
 
 func (h *SHostInfo) GetContainerDeviceConfigurationFilePath() string {
@@ -112,6 +112,7 @@ func (p *SHostPingTask) payload() api.SHostPingInput {
 	data = storageman.GatherHostStorageStats(p.masterHostStorages)
 	data.WithData = true
 	data.QgaRunningGuestIds = guestman.GetGuestManager().GetQgaRunningGuests()
+	data.DiskSaveInFlight = hostutils.DiskSaveInFlightCount()
 	info, err := mem.VirtualMemory()
 	if err != nil {
 		return data
@@ -699,10 +699,10 @@ func (m *SGuestMonitor) getCadvisorDiskIoMetrics(cur stats.DiskIoStats, prev map
 
 func (m *SGuestMonitor) PodMetrics(prevUsage *GuestMetrics) *PodMetrics {
 	stat := m.podStat
-	curTime := stat.CPU.Time.Time
+	curTime := cpuStatsTime(stat.CPU)
 	podCpu := &PodCpuMetric{
 		PodMetricMeta:        NewPodMetricMeta(curTime),
-		CpuUsageSecondsTotal: float64(*stat.CPU.UsageCoreNanoSeconds) / float64(time.Second),
+		CpuUsageSecondsTotal: cpuUsageSeconds(stat.CPU),
 	}
 	hasPrevUsage := prevUsage != nil && prevUsage.PodMetrics != nil
 	if hasPrevUsage {
@@ -710,27 +710,29 @@ func (m *SGuestMonitor) PodMetrics(prevUsage *GuestMetrics) *PodMetrics {
 		val := (podCpu.CpuUsageSecondsTotal - pmPodCpu.CpuUsageSecondsTotal) / podCpu.Time.Sub(pmPodCpu.Time).Seconds() * 100
 		podCpu.CpuUsageRate = &val
 	}
+	podWorkingSet, podUsage := memoryStatsBytes(stat.Memory)
 	podMemory := &PodMemoryMetric{
-		MemoryWorkingSetBytes: float64(*stat.Memory.WorkingSetBytes),
-		MemoryWorkingSetRate:  (float64(*stat.Memory.WorkingSetBytes) / float64(m.MemMB*1024*1024)) * 100,
-		MemoryUsageBytes:      float64(*stat.Memory.UsageBytes),
-		MemoryUsageRate:       (float64(*stat.Memory.UsageBytes) / float64(m.MemMB*1024*1024)) * 100,
+		MemoryWorkingSetBytes: podWorkingSet,
+		MemoryWorkingSetRate:  (podWorkingSet / float64(m.MemMB*1024*1024)) * 100,
+		MemoryUsageBytes:      podUsage,
+		MemoryUsageRate:       (podUsage / float64(m.MemMB*1024*1024)) * 100,
 	}
 
 	containers := make([]*ContainerMetrics, 0)
 	for _, ctr := range stat.Containers {
-		ctrMeta := NewContainerMetricMeta(m.Id, "", ctr.Name, ctr.CPU.Time.Time)
+		ctrMeta := NewContainerMetricMeta(m.Id, "", ctr.Name, cpuStatsTime(ctr.CPU))
+		ctrWorkingSet, ctrUsage := memoryStatsBytes(ctr.Memory)
 		cm := &ContainerMetrics{
 			ContainerCpu: &ContainerCpuMetric{
 				ContainerMetricMeta:  ctrMeta,
-				CpuUsageSecondsTotal: float64(*ctr.CPU.UsageCoreNanoSeconds) / float64(time.Second),
+				CpuUsageSecondsTotal: cpuUsageSeconds(ctr.CPU),
 			},
 			ContainerMemory: &ContainerMemoryMetric{
 				ContainerMetricMeta:   ctrMeta,
-				MemoryWorkingSetBytes: float64(*ctr.Memory.WorkingSetBytes),
-				MemoryWorkingSetRate:  (float64(*ctr.Memory.WorkingSetBytes) / float64(m.MemMB*1024*1024)) * 100,
-				MemoryUsageBytes:      float64(*ctr.Memory.UsageBytes),
-				MemoryUsageRate:       (float64(*ctr.Memory.UsageBytes) / float64(m.MemMB*1024*1024)) * 100,
+				MemoryWorkingSetBytes: ctrWorkingSet,
+				MemoryWorkingSetRate:  (ctrWorkingSet / float64(m.MemMB*1024*1024)) * 100,
+				MemoryUsageBytes:      ctrUsage,
+				MemoryUsageRate:       (ctrUsage / float64(m.MemMB*1024*1024)) * 100,
 			},
 		}
 		var prevCtrM *ContainerMetrics
@@ -797,6 +799,39 @@ func (m *SGuestMonitor) PodMetrics(prevUsage *GuestMetrics) *PodMetrics {
 	return pm
 }
 
+// cpuStatsTime is the time of the cpu stats, now if the stats aren't
+// reported, e.g. by a sandboxed runtime.
+func cpuStatsTime(cpu *stats.CPUStats) time.Time {
+	if cpu == nil {
+		return time.Now()
+	}
+	return cpu.Time.Time
+}
+
+// cpuUsageSeconds is 0 if the usage isn't reported.
+func cpuUsageSeconds(cpu *stats.CPUStats) float64 {
+	if cpu == nil || cpu.UsageCoreNanoSeconds == nil {
+		return 0
+	}
+	return float64(*cpu.UsageCoreNanoSeconds) / float64(time.Second)
+}
+
+// memoryStatsBytes returns the working set and the usage, which are 0 if
+// they aren't reported, e.g. the usage of a sandboxed container.
+func memoryStatsBytes(memory *stats.MemoryStats) (float64, float64) {
+	if memory == nil {
+		return 0, 0
+	}
+	var workingSet, usage float64
+	if memory.WorkingSetBytes != nil {
+		workingSet = float64(*memory.WorkingSetBytes)
+	}
+	if memory.UsageBytes != nil {
+		usage = float64(*memory.UsageBytes)
+	}
+	return workingSet, usage
+}
+
 func (m *SGuestMonitor) getPodCphAmdGpuMetrics() []*PodCphAmdGpuMetrics {
 	if len(m.cphAmdGpuMetrics) == 0 {
 		return nil
@@ -45,6 +45,7 @@ import (
 	"yunion.io/x/onecloud/pkg/util/cgrouputils/cpuset"
 	"yunion.io/x/onecloud/pkg/util/fileutils2"
 	"yunion.io/x/onecloud/pkg/util/pod"
+	"yunion.io/x/onecloud/pkg/util/pod/stats"
 )
 
 type SContainerCpufreqSimulateConfig struct {
@@ -97,6 +98,7 @@ type IHost interface {
 	GetContainerRuntimeEndpoint() string
 	GetCRI() pod.CRI
 	GetContainerCPUMap() *pod.HostContainerCPUMap
+	GetContainerStatsProvider() stats.ContainerStatsProvider
 	GetContainerCpufreqSimulateConfig() *jsonutils.JSONDict
 
 	OnCatalogChanged(catalog mcclient.KeystoneServiceCatalogV3)
@@ -253,6 +255,7 @@ var (
 	k8sWm       *workmanager.SWorkManager
 
 	imagePreCacheW *workmanager.SWorkManager
+	diskSaveW      *workmanager.SWorkManager
 
 	ParamsError = fmt.Errorf("Delay task parse params error")
 )
@@ -277,6 +280,17 @@ func DelayBackupTask(ctx context.Context, task workmanager.DelayTaskFunc, params
 	backupW.DelayTask(ctx, task, params)
 }
 
+// DelayDiskSaveTask runs the disk save operations of all the guests on the
+// host with at most DiskSaveWorkerCount concurrency, the rest are queued.
+func DelayDiskSaveTask(ctx context.Context, task workmanager.DelayTaskFunc, params interface{}) {
+	diskSaveW.DelayTask(ctx, task, params)
+}
+
+// DiskSaveInFlightCount returns the count of the running disk save operations.
+func DiskSaveInFlightCount() int {
+	return diskSaveW.ActiveWorkerCount()
+}
+
 func DelayKubeTask(ctx context.Context, task workmanager.DelayTaskFunc, params interface{}) {
 	k8sWm.DelayTask(ctx, task, params)
 }
@@ -295,6 +309,7 @@ func DelayTaskWithWorker(
 func InitWorkerManager() {
 	InitWorkerManagerWithCount(options.HostOptions.DefaultRequestWorkerCount)
 	initImageCacheWorkerManager()
+	initDiskSaveWorkerManager()
 }
 
 func initImageCacheWorkerManager() {
@@ -302,6 +317,10 @@ func initImageCacheWorkerManager() {
 	imagePreCacheW = workmanager.NewWorkManger("ImagePrefetchCacheDelayTaskWorkers", TaskFailed, TaskComplete, options.HostOptions.ImageCacheWorkerCount)
 }
 
+func initDiskSaveWorkerManager() {
+	diskSaveW = workmanager.NewWorkManger("DiskSaveDelayTaskWorkers", TaskFailed, TaskComplete, options.HostOptions.DiskSaveWorkerCount)
+}
+
 func initBackupWorkerManager() {
 	backupW = workmanager.NewWorkManger("BackupDelayTaskWorkers", TaskFailed, TaskComplete, options.HostOptions.DefaultRequestWorkerCount)
 }
@@ -171,6 +171,7 @@ type SHostOptions struct {
 	ImageCacheWorkerCount     int `default:"8" help:"default request worker count"`
 	ContainerStartWorkerCount int `default:"1" help:"container start worker count"`
 	ContainerStopWorkerCount  int `default:"1" help:"container stop worker count"`
+	DiskSaveWorkerCount       int `default:"4" help:"max concurrent disk save operations of the host, the others are queued"`
 
 	AllowSwitchVMs bool `help:"allow machines run as switch (spoof mac)" default:"true"`
 	AllowRouterVMs bool `help:"allow machines run as router (spoof ip)" default:"true"`
@@ -237,6 +238,14 @@ type SHostOptions struct {
 	ContainerDeviceConfigFile                string `help:"container device configuration file path"`
 	LxcfsPath                                string `help:"lxcfs directory path" default:"/var/lib/lxcfs"`
 	ContainerSystemCpufreqSimulateConfigFile string `help:"container system cpu simulate config file path" default:"/etc/yunion/container_cpufreq_simulate.conf"`
+	ContainerStatsPerPodThreshold            int    `help:"request container stats per pod when the host has more containers than this and the runtime supports the pod filter, 0 means disabled" default:"0"`
+	ContainerStatsSnapshotCount              int    `help:"number of the latest container stats snapshots retained for debugging, 0 means disabled" default:"3"`
+	ContainerStatsSnapshotFile               string `help:"file the latest container stats snapshot is written to, empty means disabled"`
+	ContainerStatsCacheTTLMs                 int    `help:"milliseconds a container stats listing is shared among the callers, 0 means disabled" default:"0"`
+	ContainerStatsCpuCacheMaxEntries         int    `help:"max number of the cached container cpu usage records, the ones with the oldest samples are evicted first, 0 means unbounded" default:"0"`
+	ContainerStatsCreateLatency              bool   `help:"collect the histogram of the latency from the creation of a container to its first stats" default:"false"`
+	ContainerStatsMaxContainers              int    `help:"max number of the running containers whose stats are collected at a time, the pods are collected in turn when there are more, 0 means unlimited" default:"0"`
+	ContainerCpusetFromCpuMap                bool   `help:"pin the containers simulating the system cpus to the host cpus allocated to them by the container cpu map" default:"false"`
 
 	EnableCudaMPS        bool   `help:"enable cuda mps" default:"false"`
 	CudaMPSPipeDirectory string `help:"cuda mps pipe dir" default:"/tmp/nvidia-mps/pipe"`
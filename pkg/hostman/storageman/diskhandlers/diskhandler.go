@@ -233,7 +233,7 @@ func saveToGlance(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		DiskInfo: diskInfo.(*jsonutils.JSONDict),
 	}
 
-	hostutils.DelayTask(ctx, storage.SaveToGlance, info)
+	hostutils.DelayDiskSaveTask(ctx, storage.SaveToGlance, info)
 	hostutils.ResponseOk(ctx, w)
 }
 
@@ -341,7 +341,7 @@ func diskSavePrepare(ctx context.Context, userCred mcclient.TokenCredential, sto
 	if err != nil {
 		return nil, httperrors.NewMissingParameterError("disk")
 	}
-	hostutils.DelayTask(ctx, disk.PrepareSaveToGlance, diskInfo)
+	hostutils.DelayDiskSaveTask(ctx, disk.PrepareSaveToGlance, diskInfo)
 	return nil, nil
 }
 
@@ -41,6 +41,8 @@ type PodCreateOptions struct {
 	ShutdownBehavior string `help:"Behavior after VM server shutdown" metavar:"<SHUTDOWN_BEHAVIOR>" choices:"stop|terminate|stop_release_gpu"`
 	PodUid           int64  `help:"UID of pod" default:"0"`
 	PodGid           int64  `help:"GID of pod" default:"0"`
+	EgressMbps       int    `help:"Egress bandwidth limit of pod in Mbps"`
+	IngressMbps      int    `help:"Ingress bandwidth limit of pod in Mbps"`
 
 	ContainerCreateCommonOptions
 }
@@ -222,6 +224,12 @@ func (o *PodCreateOptions) Params() (*computeapi.ServerCreateInput, error) {
 	if o.Gid != 0 {
 		params.Pod.SecurityContext.RunAsGroup = &o.Gid
 	}
+	if o.EgressMbps != 0 || o.IngressMbps != 0 {
+		params.Pod.BandwidthLimit = &computeapi.PodBandwidthLimit{
+			EgressMbps:  o.EgressMbps,
+			IngressMbps: o.IngressMbps,
+		}
+	}
 
 	if options.BoolV(o.AllowDelete) {
 		disableDelete := false
@@ -1004,8 +1004,10 @@ func (o *ServerSaveImageOptions) Description() string {
 
 type ServerSaveGuestImageOptions struct {
 	ServerIdOptions
-	IMAGE     string `help:"Image name" json:"name"`
-	AutoStart *bool  `help:"Auto start server after image saved"`
+	IMAGE          string   `help:"Image name" json:"name"`
+	AutoStart      *bool    `help:"Auto start server after image saved"`
+	ExcludeDiskIds []string `help:"Id of the data disk not to save" json:"exclude_disk_ids"`
+	Quiesce        bool     `help:"Save the running server with its filesystems frozen by the guest agent"`
 }
 
 func (o *ServerSaveGuestImageOptions) Params() (jsonutils.JSONObject, error) {
@@ -100,6 +100,19 @@ func (rl *STopicAddResourceInput) Params() (jsonutils.JSONObject, error) {
 	return options.ListStructToParams(rl)
 }
 
+type TopicBackfillOptions struct {
+	ID            string `help:"Id or Name of topic"`
+	RESOURCE_TYPE string `help:"Resource type the topic is rolled out to"`
+}
+
+func (opt *TopicBackfillOptions) GetId() string {
+	return opt.ID
+}
+
+func (opt *TopicBackfillOptions) Params() (jsonutils.JSONObject, error) {
+	return options.StructToParams(opt)
+}
+
 type TopicCreateOptions struct {
 	NAME        string
 	Enabled     bool
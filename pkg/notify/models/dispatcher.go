@@ -0,0 +1,319 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/util/stringutils"
+
+	api "yunion.io/x/onecloud/pkg/apis/notify"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/mcclient/auth"
+	"yunion.io/x/onecloud/pkg/notify/options"
+)
+
+const (
+	ErrDispatchQueueFull = errors.Error("event dispatch queue is full")
+	ErrDispatcherStopped = errors.Error("event dispatcher stopped")
+	// ErrEventNotifyPartlyFailed is returned by the send of an event
+	// notified to some of the contact types only, it isn't retried since
+	// the retry notifies the other contact types again
+	ErrEventNotifyPartlyFailed = errors.Error("event notify partly failed")
+)
+
+var (
+	dispatchRetryBaseWait = 2 * time.Second
+	dispatchRetryMaxWait  = 2 * time.Minute
+)
+
+// SDispatchTopicStats is the delivery metrics of the events of a topic
+type SDispatchTopicStats struct {
+	TopicId string `json:"topic_id"`
+	// Enqueued is the number of events accepted by the queue
+	Enqueued int64 `json:"enqueued"`
+	// Dropped is the number of events rejected because the queue is full
+	Dropped int64 `json:"dropped"`
+	// Delivered is the number of events sent successfully
+	Delivered int64 `json:"delivered"`
+	// Retried is the number of failed sends which are retried
+	Retried int64 `json:"retried"`
+	// Failed is the number of events given up after all the retries, or
+	// notified to some of the contact types only
+	Failed int64 `json:"failed"`
+
+	LastError     string    `json:"last_error,omitempty"`
+	LastDelivered time.Time `json:"last_delivered,omitempty"`
+}
+
+type sDispatchJob struct {
+	// id is the id of the delivery status of the job
+	id       string
+	topicIds []string
+	userCred mcclient.TokenCredential
+	input    api.NotificationManagerEventNotifyInput
+	// attempt is the number of the sends of the job
+	attempt int
+}
+
+type tDispatchSendFunc func(ctx context.Context, userCred mcclient.TokenCredential, input api.NotificationManagerEventNotifyInput) error
+
+// SEventDispatcher sends the event notifications of the resources
+// asynchronously, the failed sends are enqueued again after an exponential
+// backoff. The delivery status of each notification is recorded by
+// statuses, which is nil if it isn't recorded.
+type SEventDispatcher struct {
+	queue      chan *sDispatchJob
+	maxRetries int
+	send       tDispatchSendFunc
+	statuses   *SEventDispatchStatusManager
+
+	statsLock sync.Mutex
+	stats     map[string]*SDispatchTopicStats
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+var EventDispatcher *SEventDispatcher
+
+func InitEventDispatcher() {
+	EventDispatcher = newEventDispatcher(
+		options.Options.EventDispatchQueueSize,
+		options.Options.EventDispatchWorkerCount,
+		options.Options.EventDispatchMaxRetries,
+		sendEventNotify,
+		EventDispatchStatusManager,
+	)
+}
+
+func newEventDispatcher(queueSize, workerCount, maxRetries int, send tDispatchSendFunc, statuses *SEventDispatchStatusManager) *SEventDispatcher {
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+	if workerCount <= 0 {
+		workerCount = 1
+	}
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	d := &SEventDispatcher{
+		queue:      make(chan *sDispatchJob, queueSize),
+		maxRetries: maxRetries,
+		send:       send,
+		statuses:   statuses,
+		stats:      make(map[string]*SDispatchTopicStats),
+		stop:       make(chan struct{}),
+	}
+	for i := 0; i < workerCount; i++ {
+		d.wg.Add(1)
+		go d.run()
+	}
+	return d
+}
+
+func sendEventNotify(ctx context.Context, userCred mcclient.TokenCredential, input api.NotificationManagerEventNotifyInput) error {
+	output, err := NotificationManager.eventNotify(ctx, userCred, input)
+	if err != nil {
+		return err
+	}
+	return eventNotifyFailedListError(input.Event, output.FailedList)
+}
+
+// eventNotifyFailedListError returns ErrEventNotifyPartlyFailed with the
+// reasons of the contact types of failedList, nil if it's empty
+func eventNotifyFailedListError(event string, failedList []api.FailedElem) error {
+	if len(failedList) == 0 {
+		return nil
+	}
+	reasons := make([]string, 0, len(failedList))
+	for _, failed := range failedList {
+		log.Warningf("event %s notify by %s failed: %s", event, failed.ContactType, failed.Reason)
+		reasons = append(reasons, fmt.Sprintf("%s: %s", failed.ContactType, failed.Reason))
+	}
+	return errors.Wrap(ErrEventNotifyPartlyFailed, strings.Join(reasons, "; "))
+}
+
+// Dispatch enqueues the notification of the event to the enabled topics
+// subscribing the resource type of the event, it returns ErrDispatchQueueFull
+// without blocking if the queue is full
+func (d *SEventDispatcher) Dispatch(ctx context.Context, userCred mcclient.TokenCredential, input api.NotificationManagerEventNotifyInput) error {
+	topics, err := TopicResourceManager.FetchTopicsByEvent(input.Event)
+	if err != nil {
+		return errors.Wrap(err, "FetchTopicsByEvent")
+	}
+	topicIds := make([]string, 0, len(topics))
+	for i := range topics {
+		topicIds = append(topicIds, topics[i].Id)
+	}
+	return d.enqueue(ctx, &sDispatchJob{topicIds: topicIds, userCred: userCred, input: input})
+}
+
+func (d *SEventDispatcher) enqueue(ctx context.Context, job *sDispatchJob) error {
+	select {
+	case <-d.stop:
+		return ErrDispatcherStopped
+	default:
+	}
+	job.id = stringutils.UUID4()
+	// the status is recorded before the job is taken by a worker
+	d.setStatus(ctx, job, api.EVENT_DISPATCH_STATUS_QUEUED, nil)
+	select {
+	case d.queue <- job:
+		d.updateStats(job.topicIds, func(s *SDispatchTopicStats) { s.Enqueued++ })
+		return nil
+	default:
+		d.setStatus(ctx, job, api.EVENT_DISPATCH_STATUS_DROPPED, ErrDispatchQueueFull)
+		d.updateStats(job.topicIds, func(s *SDispatchTopicStats) { s.Dropped++ })
+		return ErrDispatchQueueFull
+	}
+}
+
+// requeue enqueues the job to retry again, the job is given up if the queue
+// is full or the dispatcher is stopped
+func (d *SEventDispatcher) requeue(job *sDispatchJob) {
+	var err error
+	select {
+	case <-d.stop:
+		err = ErrDispatcherStopped
+	default:
+		select {
+		case d.queue <- job:
+			return
+		default:
+			err = ErrDispatchQueueFull
+		}
+	}
+	log.Errorf("event %s notify retry %d given up: %s", job.input.Event, job.attempt, err)
+	d.failed(job, err)
+}
+
+func (d *SEventDispatcher) setStatus(ctx context.Context, job *sDispatchJob, status string, results error) {
+	if d.statuses == nil {
+		return
+	}
+	if err := d.statuses.setStatus(ctx, job, status, results); err != nil {
+		log.Errorf("record event %s dispatch status %s: %s", job.input.Event, status, err)
+	}
+}
+
+func (d *SEventDispatcher) failed(job *sDispatchJob, err error) {
+	d.setStatus(context.Background(), job, api.EVENT_DISPATCH_STATUS_FAILED, err)
+	d.updateStats(job.topicIds, func(s *SDispatchTopicStats) {
+		s.Failed++
+		s.LastError = err.Error()
+	})
+}
+
+func (d *SEventDispatcher) run() {
+	defer d.wg.Done()
+	for {
+		select {
+		case job := <-d.queue:
+			d.process(job)
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// process sends the notification of the job once, the failed send is
+// enqueued again after the backoff, so the worker isn't held by the backoff
+func (d *SEventDispatcher) process(job *sDispatchJob) {
+	ctx := context.Background()
+	userCred := job.userCred
+	if userCred == nil {
+		userCred = auth.AdminCredential()
+	}
+	err := d.send(ctx, userCred, job.input)
+	job.attempt++
+	if err == nil {
+		now := time.Now()
+		d.setStatus(ctx, job, api.EVENT_DISPATCH_STATUS_DELIVERED, nil)
+		d.updateStats(job.topicIds, func(s *SDispatchTopicStats) {
+			s.Delivered++
+			s.LastDelivered = now
+		})
+		return
+	}
+	if job.attempt > d.maxRetries || errors.Cause(err) == ErrEventNotifyPartlyFailed {
+		log.Errorf("event %s notify failed after %d attempts: %s", job.input.Event, job.attempt, err)
+		d.failed(job, err)
+		return
+	}
+	wait := dispatchRetryWait(job.attempt - 1)
+	log.Warningf("event %s notify failed: %s, retry %d in %s", job.input.Event, err, job.attempt, wait)
+	d.setStatus(ctx, job, api.EVENT_DISPATCH_STATUS_RETRYING, err)
+	d.updateStats(job.topicIds, func(s *SDispatchTopicStats) {
+		s.Retried++
+		s.LastError = err.Error()
+	})
+	time.AfterFunc(wait, func() { d.requeue(job) })
+}
+
+// dispatchRetryWait returns the backoff before the retry after the attempt
+func dispatchRetryWait(attempt int) time.Duration {
+	wait := dispatchRetryBaseWait
+	for i := 0; i < attempt && wait < dispatchRetryMaxWait; i++ {
+		wait *= 2
+	}
+	if wait > dispatchRetryMaxWait {
+		wait = dispatchRetryMaxWait
+	}
+	return wait
+}
+
+func (d *SEventDispatcher) updateStats(topicIds []string, update func(s *SDispatchTopicStats)) {
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	for _, id := range topicIds {
+		s, ok := d.stats[id]
+		if !ok {
+			s = &SDispatchTopicStats{TopicId: id}
+			d.stats[id] = s
+		}
+		update(s)
+	}
+}
+
+// Stats returns the delivery metrics of each topic
+func (d *SEventDispatcher) Stats() []SDispatchTopicStats {
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	ret := make([]SDispatchTopicStats, 0, len(d.stats))
+	for _, s := range d.stats {
+		ret = append(ret, *s)
+	}
+	return ret
+}
+
+// QueueLength returns the number of events waiting to be sent, the ones
+// waiting for the backoff to retry aren't counted
+func (d *SEventDispatcher) QueueLength() int {
+	return len(d.queue)
+}
+
+// Stop stops the workers, the events still in the queue or waiting to retry
+// are dropped
+func (d *SEventDispatcher) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+}
@@ -0,0 +1,229 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"yunion.io/x/pkg/errors"
+
+	api "yunion.io/x/onecloud/pkg/apis/notify"
+	"yunion.io/x/onecloud/pkg/mcclient"
+)
+
+// waitDispatchStats waits until the stats of the topic satisfy done
+func waitDispatchStats(t *testing.T, d *SEventDispatcher, topicId string, done func(s SDispatchTopicStats) bool) SDispatchTopicStats {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, s := range d.Stats() {
+			if s.TopicId == topicId && done(s) {
+				return s
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for the stats of topic %s: %#v", topicId, d.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEventDispatcherRetry(t *testing.T) {
+	baseWait := dispatchRetryBaseWait
+	dispatchRetryBaseWait = time.Millisecond
+	defer func() { dispatchRetryBaseWait = baseWait }()
+
+	var calls int32
+	send := func(ctx context.Context, userCred mcclient.TokenCredential, input api.NotificationManagerEventNotifyInput) error {
+		switch input.Event {
+		case "flaky":
+			// succeeds on the third attempt
+			if atomic.AddInt32(&calls, 1) < 3 {
+				return errors.Error("smtp unavailable")
+			}
+			return nil
+		case "partial":
+			return eventNotifyFailedListError(input.Event, []api.FailedElem{{ContactType: api.EMAIL, Reason: "bad address"}})
+		}
+		return errors.Error("always fails")
+	}
+	d := newEventDispatcher(8, 2, 3, send, nil)
+	defer d.Stop()
+
+	for _, job := range []*sDispatchJob{
+		{topicIds: []string{"flaky"}, userCred: &mcclient.SSimpleToken{}, input: api.NotificationManagerEventNotifyInput{Event: "flaky"}},
+		{topicIds: []string{"partial"}, userCred: &mcclient.SSimpleToken{}, input: api.NotificationManagerEventNotifyInput{Event: "partial"}},
+		{topicIds: []string{"broken"}, userCred: &mcclient.SSimpleToken{}, input: api.NotificationManagerEventNotifyInput{Event: "broken"}},
+	} {
+		if err := d.enqueue(context.Background(), job); err != nil {
+			t.Fatalf("enqueue %s: %v", job.input.Event, err)
+		}
+	}
+
+	s := waitDispatchStats(t, d, "flaky", func(s SDispatchTopicStats) bool { return s.Delivered > 0 })
+	if s.Enqueued != 1 || s.Retried != 2 || s.Failed != 0 || s.LastDelivered.IsZero() {
+		t.Errorf("unexpected stats of the flaky topic %#v", s)
+	}
+	// the partly failed notify isn't retried
+	s = waitDispatchStats(t, d, "partial", func(s SDispatchTopicStats) bool { return s.Failed > 0 })
+	if s.Retried != 0 || s.Delivered != 0 || s.LastError == "" {
+		t.Errorf("unexpected stats of the partial topic %#v", s)
+	}
+	// given up after the max retries
+	s = waitDispatchStats(t, d, "broken", func(s SDispatchTopicStats) bool { return s.Failed > 0 })
+	if s.Retried != 3 || s.Delivered != 0 {
+		t.Errorf("unexpected stats of the broken topic %#v", s)
+	}
+}
+
+func TestEventDispatcherQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	send := func(ctx context.Context, userCred mcclient.TokenCredential, input api.NotificationManagerEventNotifyInput) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}
+	d := newEventDispatcher(1, 1, 0, send, nil)
+	job := func() *sDispatchJob {
+		return &sDispatchJob{topicIds: []string{"topic"}, userCred: &mcclient.SSimpleToken{}}
+	}
+
+	// the first is taken by the worker, the second waits in the queue
+	if err := d.enqueue(context.Background(), job()); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	<-started
+	if err := d.enqueue(context.Background(), job()); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := d.enqueue(context.Background(), job()); err != ErrDispatchQueueFull {
+		t.Errorf("expect ErrDispatchQueueFull, got %v", err)
+	}
+	if n := d.QueueLength(); n != 1 {
+		t.Errorf("queue length %d, want 1", n)
+	}
+	close(release)
+
+	s := waitDispatchStats(t, d, "topic", func(s SDispatchTopicStats) bool { return s.Delivered == 2 })
+	if s.Enqueued != 2 || s.Dropped != 1 {
+		t.Errorf("unexpected stats %#v", s)
+	}
+	d.Stop()
+	if err := d.enqueue(context.Background(), job()); err != ErrDispatcherStopped {
+		t.Errorf("expect ErrDispatcherStopped, got %v", err)
+	}
+}
+
+func TestEventDispatcherBackoff(t *testing.T) {
+	baseWait := dispatchRetryBaseWait
+	dispatchRetryBaseWait = time.Hour
+	defer func() { dispatchRetryBaseWait = baseWait }()
+
+	send := func(ctx context.Context, userCred mcclient.TokenCredential, input api.NotificationManagerEventNotifyInput) error {
+		if input.Event == "broken" {
+			return errors.Error("smtp unavailable")
+		}
+		return nil
+	}
+	d := newEventDispatcher(8, 1, 3, send, nil)
+	defer d.Stop()
+
+	for _, event := range []string{"broken", "ok"} {
+		job := &sDispatchJob{topicIds: []string{event}, userCred: &mcclient.SSimpleToken{}, input: api.NotificationManagerEventNotifyInput{Event: event}}
+		if err := d.enqueue(context.Background(), job); err != nil {
+			t.Fatalf("enqueue %s: %v", event, err)
+		}
+	}
+	// the only worker isn't held by the backoff of the broken event
+	waitDispatchStats(t, d, "ok", func(s SDispatchTopicStats) bool { return s.Delivered > 0 })
+	s := waitDispatchStats(t, d, "broken", func(s SDispatchTopicStats) bool { return s.Retried > 0 })
+	if s.Retried != 1 || s.Failed != 0 {
+		t.Errorf("unexpected stats of the broken topic %#v", s)
+	}
+}
+
+func TestEventDispatcherStatus(t *testing.T) {
+	openTestDB(t, EventDispatchStatusManager)
+	baseWait := dispatchRetryBaseWait
+	dispatchRetryBaseWait = time.Millisecond
+	defer func() { dispatchRetryBaseWait = baseWait }()
+
+	var calls int32
+	send := func(ctx context.Context, userCred mcclient.TokenCredential, input api.NotificationManagerEventNotifyInput) error {
+		if input.Event == "flaky" && atomic.AddInt32(&calls, 1) < 2 {
+			return errors.Error("smtp unavailable")
+		}
+		if input.Event == "broken" {
+			return errors.Error("always fails")
+		}
+		return nil
+	}
+	d := newEventDispatcher(8, 1, 1, send, EventDispatchStatusManager)
+	defer d.Stop()
+
+	jobs := map[string]*sDispatchJob{}
+	for _, event := range []string{"flaky", "broken"} {
+		jobs[event] = &sDispatchJob{topicIds: []string{event, "all"}, userCred: &mcclient.SSimpleToken{}, input: api.NotificationManagerEventNotifyInput{Event: event}}
+		if err := d.enqueue(context.Background(), jobs[event]); err != nil {
+			t.Fatalf("enqueue %s: %v", event, err)
+		}
+	}
+	waitDispatchStats(t, d, "all", func(s SDispatchTopicStats) bool { return s.Delivered+s.Failed == 2 })
+
+	for event, want := range map[string]SEventDispatchStatus{
+		"flaky":  {Event: "flaky", TopicIds: "flaky,all", Status: api.EVENT_DISPATCH_STATUS_DELIVERED, Attempts: 2},
+		"broken": {Event: "broken", TopicIds: "broken,all", Status: api.EVENT_DISPATCH_STATUS_FAILED, Attempts: 2, Results: "always fails"},
+	} {
+		got := SEventDispatchStatus{}
+		if err := EventDispatchStatusManager.Query().Equals("id", jobs[event].id).First(&got); err != nil {
+			t.Fatalf("fetch status of %s: %v", event, err)
+		}
+		if got.Event != want.Event || got.TopicIds != want.TopicIds || got.Status != want.Status || got.Attempts != want.Attempts || got.Results != want.Results {
+			t.Errorf("status of %s = %#v, want %#v", event, got, want)
+		}
+	}
+}
+
+func TestEventDispatcherDispatchNoTopic(t *testing.T) {
+	openTestDB(t, TopicManager, TopicResourceManager, TopicActionManager)
+	d := newEventDispatcher(1, 1, 0, func(ctx context.Context, userCred mcclient.TokenCredential, input api.NotificationManagerEventNotifyInput) error {
+		return nil
+	}, nil)
+	defer d.Stop()
+
+	err := d.Dispatch(context.Background(), &mcclient.SSimpleToken{}, api.NotificationManagerEventNotifyInput{Event: "server/create"})
+	if errors.Cause(err) != errors.ErrNotFound {
+		t.Errorf("expect ErrNotFound, got %v", err)
+	}
+	if n := d.QueueLength(); n != 0 {
+		t.Errorf("queue length %d, want 0", n)
+	}
+}
+
+func TestEventNotifyFailedListError(t *testing.T) {
+	if err := eventNotifyFailedListError("event", nil); err != nil {
+		t.Errorf("expect nil, got %v", err)
+	}
+	err := eventNotifyFailedListError("event", []api.FailedElem{
+		{ContactType: api.EMAIL, Reason: "bad address"},
+		{ContactType: api.WEBHOOK, Reason: "timeout"},
+	})
+	if errors.Cause(err) != ErrEventNotifyPartlyFailed {
+		t.Errorf("expect ErrEventNotifyPartlyFailed, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+)
+
+type SEventDispatchStatusManager struct {
+	db.SModelBaseManager
+}
+
+// SEventDispatchStatus is the delivery status of an event notification
+// dispatched by EventDispatcher
+type SEventDispatchStatus struct {
+	db.SModelBase
+
+	Id string `width:"36" charset:"ascii" primary:"true" list:"user"`
+
+	Event    string `width:"64" charset:"ascii" list:"user"`
+	TopicIds string `width:"256" charset:"ascii" list:"user"`
+
+	Status   string `width:"16" charset:"ascii" default:"queued" list:"user"`
+	Attempts int    `list:"user"`
+	Results  string `list:"user" charset:"utf8"`
+
+	UpdatedAt time.Time `list:"user"`
+}
+
+var EventDispatchStatusManager *SEventDispatchStatusManager
+
+func init() {
+	EventDispatchStatusManager = &SEventDispatchStatusManager{
+		SModelBaseManager: db.NewModelBaseManager(SEventDispatchStatus{}, "event_dispatch_status_tbl", "event_dispatch_status", "event_dispatch_statuses"),
+	}
+	EventDispatchStatusManager.SetVirtualObject(EventDispatchStatusManager)
+}
+
+func (manager *SEventDispatchStatusManager) setStatus(ctx context.Context, job *sDispatchJob, status string, results error) error {
+	eds := SEventDispatchStatus{
+		Id:        job.id,
+		Event:     job.input.Event,
+		TopicIds:  strings.Join(job.topicIds, ","),
+		Status:    status,
+		Attempts:  job.attempt,
+		UpdatedAt: time.Now(),
+	}
+	if results != nil {
+		eds.Results = results.Error()
+	}
+	err := manager.TableSpec().InsertOrUpdate(ctx, &eds)
+	if err != nil {
+		return errors.Wrapf(err, "InsertOrUpdate %s", job.id)
+	}
+	return nil
+}
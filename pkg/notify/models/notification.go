@@ -197,8 +197,28 @@ func (n *SNotification) PostCreate(ctx context.Context, userCred mcclient.TokenC
 	task.ScheduleRun(nil)
 }
 
-// TODO: support project and domain
+// PerformEventNotify sends the event notification synchronously and returns
+// the contact types failed. With async the notification is dispatched by
+// EventDispatcher instead, which sends it in the background and retries the
+// failures, it's sent synchronously if the dispatch queue is full or the
+// dispatcher is stopped.
 func (nm *SNotificationManager) PerformEventNotify(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input api.NotificationManagerEventNotifyInput) (api.NotificationManagerEventNotifyOutput, error) {
+	if input.Async && EventDispatcher != nil {
+		err := EventDispatcher.Dispatch(ctx, userCred, input)
+		switch errors.Cause(err) {
+		case nil:
+			return api.NotificationManagerEventNotifyOutput{}, nil
+		case ErrDispatchQueueFull, ErrDispatcherStopped:
+			log.Warningf("dispatch event %s: %s, notify synchronously", input.Event, err)
+		default:
+			return api.NotificationManagerEventNotifyOutput{}, errors.Wrap(err, "Dispatch")
+		}
+	}
+	return nm.eventNotify(ctx, userCred, input)
+}
+
+// TODO: support project and domain
+func (nm *SNotificationManager) eventNotify(ctx context.Context, userCred mcclient.TokenCredential, input api.NotificationManagerEventNotifyInput) (api.NotificationManagerEventNotifyOutput, error) {
 	var output api.NotificationManagerEventNotifyOutput
 	// contact type
 	contactTypes := input.ContactTypes
@@ -939,9 +939,28 @@ func (t *STopic) PreCheckPerformAction(
 			return httperrors.NewForbiddenError("only allow admin to perform enable operations")
 		}
 	}
+	if action == "backfill" {
+		if !db.IsAdminAllowPerform(ctx, userCred, t, action) {
+			return httperrors.NewForbiddenError("only allow admin to perform backfill operations")
+		}
+	}
 	return nil
 }
 
+// PerformBackfill rolls the topic out to all the resources of a type
+func (t *STopic) PerformBackfill(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input api.TopicBackfillInput) (jsonutils.JSONObject, error) {
+	if len(input.ResourceType) == 0 {
+		return nil, httperrors.NewMissingParameterError("resource_type")
+	}
+	created, err := TopicResourceManager.BackfillTopicForResourceType(t.Id, input.ResourceType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "BackfillTopicForResourceType %s", input.ResourceType)
+	}
+	ret := jsonutils.NewDict()
+	ret.Set("created", jsonutils.NewInt(int64(created)))
+	return ret, nil
+}
+
 func (topic *STopic) CreateEvent(ctx context.Context, resType, action, message string) (*SEvent, error) {
 	eve := &SEvent{
 		Message:      message,
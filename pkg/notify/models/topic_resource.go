@@ -29,7 +29,13 @@
 package models
 
 import (
+	"context"
+
+	"yunion.io/x/pkg/errors"
+
+	api "yunion.io/x/onecloud/pkg/apis/notify"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db/lockman"
 )
 
 type STopicResourceManager struct {
@@ -57,3 +63,65 @@ type STopicResource struct {
 	ResourceId string `width:"64" nullable:"false" create:"required" update:"user" list:"user"`
 	TopicId    string `width:"64" nullable:"false" create:"required" update:"user" list:"user"`
 }
+
+// BackfillTopicForResourceType makes the topic apply to all the existing and
+// future resources of the resource type. Topics are bound to resource types
+// rather than individual resources, so a single topic_resource row covers the
+// whole fleet of the type; the number of rows created, 0 if the topic already
+// covers the type, is returned.
+func (manager *STopicResourceManager) BackfillTopicForResourceType(topicId, resourceType string) (int, error) {
+	if len(resourceType) == 0 {
+		return 0, errors.Wrap(errors.ErrInvalidFormat, "empty resource type")
+	}
+	topicObj, err := TopicManager.FetchById(topicId)
+	if err != nil {
+		return 0, errors.Wrapf(err, "fetch topic %s", topicId)
+	}
+	ctx := context.Background()
+	lockman.LockObject(ctx, topicObj)
+	defer lockman.ReleaseObject(ctx, topicObj)
+
+	if manager.Query().Equals("topic_id", topicId).Equals("resource_id", resourceType).Count() > 0 {
+		return 0, nil
+	}
+	err = manager.TableSpec().Insert(ctx, &STopicResource{
+		ResourceId: resourceType,
+		TopicId:    topicId,
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "insert topic %s resource %s", topicId, resourceType)
+	}
+	return 1, nil
+}
+
+// FetchTopicsByEvent returns the enabled topics subscribing the event by the
+// resource type of the event
+func (manager *STopicResourceManager) FetchTopicsByEvent(eventStr string) ([]STopic, error) {
+	event, err := parseEvent(eventStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse event %q", eventStr)
+	}
+	q := TopicManager.Query().Equals("results", event.Result() == api.ResultSucceed)
+	resourceQ := manager.Query("topic_id").Equals("resource_id", event.ResourceType()).SubQuery()
+	q = q.In("id", resourceQ)
+	actionQ := TopicActionManager.Query("topic_id").Equals("action_id", event.Action()).SubQuery()
+	q = q.In("id", actionQ)
+	topics := []STopic{}
+	err = db.FetchModelObjects(TopicManager, q, &topics)
+	if err != nil {
+		return nil, errors.Wrap(err, "FetchModelObjects")
+	}
+	if len(topics) == 0 {
+		return nil, errors.Wrapf(errors.ErrNotFound, "topic %s", eventStr)
+	}
+	ret := make([]STopic, 0, len(topics))
+	for i := range topics {
+		if topics[i].Enabled.IsTrue() {
+			ret = append(ret, topics[i])
+		}
+	}
+	if len(ret) == 0 {
+		return nil, errors.Wrapf(errors.ErrInvalidStatus, "topic %s disabled", eventStr)
+	}
+	return ret, nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/tristate"
+	"yunion.io/x/sqlchemy"
+	_ "yunion.io/x/sqlchemy/backends"
+
+	api "yunion.io/x/onecloud/pkg/apis/notify"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db/lockman"
+)
+
+var (
+	testDB     *sql.DB
+	testDBOnce sync.Once
+)
+
+// openTestDB backs the managers by the empty tables of an in-memory sqlite
+// database. The database is shared by the tests of the package, since the
+// table specs keep the database they are first synced to.
+func openTestDB(t *testing.T, managers ...db.IModelManager) {
+	testDBOnce.Do(func() {
+		conn, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatalf("open sqlite: %v", err)
+		}
+		// each connection of :memory: is a database of its own
+		conn.SetMaxOpenConns(1)
+		testDB = conn
+		sqlchemy.SetDBWithNameBackend(conn, sqlchemy.DefaultDB, sqlchemy.SQLiteBackend)
+		lockman.Init(lockman.NewInMemoryLockManager())
+	})
+	for _, manager := range managers {
+		if err := manager.TableSpec().Sync(); err != nil {
+			t.Fatalf("sync table of %s: %v", manager.Keyword(), err)
+		}
+		if _, err := testDB.Exec("DELETE FROM " + manager.TableSpec().Name()); err != nil {
+			t.Fatalf("clear table of %s: %v", manager.Keyword(), err)
+		}
+	}
+}
+
+// insertTestTopic inserts the topic subscribing the actions of the resource
+// types
+func insertTestTopic(t *testing.T, id string, enabled bool, resourceTypes []string, actions []api.SAction) *STopic {
+	topic := &STopic{Type: "resource", Results: tristate.True}
+	topic.Id = id
+	topic.Name = id
+	topic.Enabled = tristate.NewFromBool(enabled)
+	topic.SetModelManager(TopicManager, topic)
+	if err := TopicManager.TableSpec().Insert(context.Background(), topic); err != nil {
+		t.Fatalf("insert topic %s: %v", id, err)
+	}
+	ctx := context.Background()
+	for _, resourceType := range resourceTypes {
+		if err := TopicResourceManager.TableSpec().Insert(ctx, &STopicResource{ResourceId: resourceType, TopicId: id}); err != nil {
+			t.Fatalf("insert resource %s of topic %s: %v", resourceType, id, err)
+		}
+	}
+	for _, action := range actions {
+		if err := TopicActionManager.TableSpec().Insert(ctx, &STopicAction{ActionId: string(action), TopicId: id}); err != nil {
+			t.Fatalf("insert action %s of topic %s: %v", action, id, err)
+		}
+	}
+	return topic
+}
+
+func TestBackfillTopicForResourceType(t *testing.T) {
+	openTestDB(t, TopicManager, TopicResourceManager)
+	topic := insertTestTopic(t, "topic1", true, nil, nil)
+
+	for _, c := range []struct {
+		name         string
+		resourceType string
+		want         int
+	}{
+		{name: "insert", resourceType: "server", want: 1},
+		{name: "already covered", resourceType: "server", want: 0},
+		{name: "insert another type", resourceType: "disk", want: 1},
+	} {
+		got, err := TopicResourceManager.BackfillTopicForResourceType(topic.Id, c.resourceType)
+		if err != nil {
+			t.Fatalf("%s: backfill %s: %v", c.name, c.resourceType, err)
+		}
+		if got != c.want {
+			t.Errorf("%s: backfill %s created %d, want %d", c.name, c.resourceType, got, c.want)
+		}
+	}
+	resources, err := topic.GetResources()
+	if err != nil {
+		t.Fatalf("get resources: %v", err)
+	}
+	if len(resources) != 2 {
+		t.Errorf("topic resources = %d, want 2", len(resources))
+	}
+	if _, err := TopicResourceManager.BackfillTopicForResourceType("missing", "server"); err == nil {
+		t.Errorf("expect an error backfilling a missing topic")
+	}
+}
+
+func TestFetchTopicsByEvent(t *testing.T) {
+	openTestDB(t, TopicManager, TopicResourceManager, TopicActionManager)
+	insertTestTopic(t, "server-create", true, []string{"server"}, []api.SAction{api.ActionCreate})
+	insertTestTopic(t, "server-disk-create", true, []string{"server", "disk"}, []api.SAction{api.ActionCreate})
+	insertTestTopic(t, "disk-create-disabled", false, []string{"disk", "eip"}, []api.SAction{api.ActionCreate})
+
+	for _, c := range []struct {
+		event   string
+		want    []string
+		wantErr error
+	}{
+		{event: "server/create", want: []string{"server-create", "server-disk-create"}},
+		// the disabled topic is skipped
+		{event: "disk/create", want: []string{"server-disk-create"}},
+		{event: "eip/create", wantErr: errors.ErrInvalidStatus},
+		{event: "server/delete", wantErr: errors.ErrNotFound},
+	} {
+		topics, err := TopicResourceManager.FetchTopicsByEvent(c.event)
+		if c.wantErr != nil {
+			if errors.Cause(err) != c.wantErr {
+				t.Errorf("%s: expect %v, got %v", c.event, c.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: fetch topics: %v", c.event, err)
+		}
+		got := []string{}
+		for i := range topics {
+			got = append(got, topics[i].Id)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: topics = %v, want %v", c.event, got, c.want)
+		}
+	}
+}
@@ -37,6 +37,10 @@ type NotifyOption struct {
 
 	SyncReceiverIntervalMinutes int  `help:"interval to sync receivers from keystone, in minutes" default:"30"`
 	EnableWatchUser             bool `help:"use etcd to watch user" default:"false"`
+
+	EventDispatchQueueSize   int `help:"max number of event notifications waiting to be dispatched" default:"1024"`
+	EventDispatchWorkerCount int `help:"number of workers dispatching event notifications" default:"2"`
+	EventDispatchMaxRetries  int `help:"max retries of a failed event notification dispatch" default:"3"`
 }
 
 var Options NotifyOption
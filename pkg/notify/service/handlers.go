@@ -15,6 +15,11 @@
 package service
 
 import (
+	"context"
+	"net/http"
+
+	"yunion.io/x/jsonutils"
+
 	"yunion.io/x/onecloud/pkg/appsrv"
 	"yunion.io/x/onecloud/pkg/appsrv/dispatcher"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db"
@@ -32,6 +37,7 @@ func InitHandlers(app *appsrv.Application) {
 
 	models.InitEventLog()
 	models.InitEmailQueue()
+	models.InitEventDispatcher()
 
 	db.RegistUserCredCacheUpdater()
 
@@ -39,6 +45,8 @@ func InitHandlers(app *appsrv.Application) {
 
 	db.AddScopeResourceCountHandler(API_VERSION, app)
 
+	app.AddDefaultHandler("GET", "/event_dispatch_stats", appsrv.WhitelistFilter(eventDispatchStatsHandler), "event_dispatch_stats")
+
 	for _, manager := range []db.IModelManager{
 		taskman.TaskManager,
 		taskman.SubTaskManager,
@@ -53,6 +61,7 @@ func InitHandlers(app *appsrv.Application) {
 		models.VerificationManager,
 		models.EventManager,
 		models.EmailQueueStatusManager,
+		models.EventDispatchStatusManager,
 		models.TopicActionManager,
 		models.TopicResourceManager,
 	} {
@@ -89,3 +98,10 @@ func InitHandlers(app *appsrv.Application) {
 		dispatcher.AddJointModelDispatcher(API_VERSION, app, handler)
 	}
 }
+
+func eventDispatchStatsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	result := jsonutils.NewDict()
+	result.Add(jsonutils.NewInt(int64(models.EventDispatcher.QueueLength())), "queue_length")
+	result.Add(jsonutils.Marshal(models.EventDispatcher.Stats()), "topics")
+	appsrv.SendJSON(w, result)
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pod
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/util/procutils"
+)
+
+const (
+	// PodNetworkInterface is the interface of the pod sandbox shaped.
+	PodNetworkInterface = "eth0"
+
+	// bandwidthMinBurstBytes is the minimal burst, a burst smaller than the
+	// mtu stalls the traffic.
+	bandwidthMinBurstBytes = 32 * 1024
+	// bandwidthLatency is the max time a packet waits in the tbf queue.
+	bandwidthLatency = "50ms"
+)
+
+// BandwidthLimit is the bandwidth limit of a pod in bits per second, 0 means
+// unlimited.
+//
+// The bandwidth of a pod is shaped by tc inside the network namespace of its
+// sandbox, which is shared by all the containers of the pod:
+//
+//   - the egress is limited by a tbf qdisc as the root qdisc of the interface
+//   - the ingress is policed by a u32 filter on the ingress qdisc, the packets
+//     above the rate are dropped
+//
+// The host requires tc of iproute2 and nsenter of util-linux, and the kernel
+// modules sch_tbf, sch_ingress, cls_u32 and act_police. tc works on the
+// network namespace instead of a cgroup, so the limit works the same on the
+// cgroup v1 and v2 hosts, and net_cls isn't required.
+type BandwidthLimit struct {
+	EgressBps  uint64 `json:"egress_bps"`
+	IngressBps uint64 `json:"ingress_bps"`
+}
+
+func (l BandwidthLimit) IsZero() bool {
+	return l.EgressBps == 0 && l.IngressBps == 0
+}
+
+// NewBandwidthLimitMbps returns the limit of the rates in Mbps.
+func NewBandwidthLimitMbps(egressMbps, ingressMbps int) BandwidthLimit {
+	return BandwidthLimit{
+		EgressBps:  uint64(egressMbps) * 1000 * 1000,
+		IngressBps: uint64(ingressMbps) * 1000 * 1000,
+	}
+}
+
+// ParseBandwidthLimit parses the limit recorded in the metadata of the pod,
+// an empty string is a zero limit.
+func ParseBandwidthLimit(data string) (BandwidthLimit, error) {
+	limit := BandwidthLimit{}
+	if data == "" {
+		return limit, nil
+	}
+	obj, err := jsonutils.ParseString(data)
+	if err != nil {
+		return limit, errors.Wrapf(err, "parse bandwidth limit %q", data)
+	}
+	if err := obj.Unmarshal(&limit); err != nil {
+		return limit, errors.Wrapf(err, "unmarshal bandwidth limit %q", data)
+	}
+	return limit, nil
+}
+
+// SetPodBandwidthLimit shapes the network interface in the netns of the pod
+// sandbox to the limit, the previous limit is replaced. A zero limit removes
+// the shaping.
+func SetPodBandwidthLimit(ctx context.Context, cri CRI, podId string, limit BandwidthLimit) error {
+	pid, err := getPodSandboxPid(ctx, cri, podId)
+	if err != nil {
+		return errors.Wrapf(err, "get pid of pod %s", podId)
+	}
+	for _, args := range newBandwidthTcCommands(PodNetworkInterface, limit) {
+		if err := runNetnsTc(pid, args); err != nil {
+			return errors.Wrapf(err, "pod %s", podId)
+		}
+	}
+	return nil
+}
+
+// newBandwidthTcCommands returns the tc arguments shaping the interface to
+// the limit. The ingress qdisc is always deleted first, since its filter
+// can't be replaced in place.
+func newBandwidthTcCommands(ifname string, limit BandwidthLimit) [][]string {
+	cmds := [][]string{}
+	if limit.EgressBps > 0 {
+		cmds = append(cmds, []string{"qdisc", "replace", "dev", ifname, "root", "tbf",
+			"rate", fmt.Sprintf("%dbit", limit.EgressBps),
+			"burst", fmt.Sprintf("%d", bandwidthBurstBytes(limit.EgressBps)),
+			"latency", bandwidthLatency})
+	} else {
+		cmds = append(cmds, []string{"qdisc", "del", "dev", ifname, "root"})
+	}
+	cmds = append(cmds, []string{"qdisc", "del", "dev", ifname, "ingress"})
+	if limit.IngressBps > 0 {
+		cmds = append(cmds,
+			[]string{"qdisc", "add", "dev", ifname, "handle", "ffff:", "ingress"},
+			[]string{"filter", "add", "dev", ifname, "parent", "ffff:", "protocol", "all", "prio", "1",
+				"u32", "match", "u32", "0", "0",
+				"police", "rate", fmt.Sprintf("%dbit", limit.IngressBps),
+				"burst", fmt.Sprintf("%d", bandwidthBurstBytes(limit.IngressBps)),
+				"drop", "flowid", ":1"})
+	}
+	return cmds
+}
+
+// bandwidthBurstBytes is the traffic of 10ms at the rate.
+func bandwidthBurstBytes(bps uint64) uint64 {
+	burst := bps / 8 / 100
+	if burst < bandwidthMinBurstBytes {
+		return bandwidthMinBurstBytes
+	}
+	return burst
+}
+
+func runNetnsTc(pid int64, args []string) error {
+	cmdArgs := append([]string{"-t", fmt.Sprintf("%d", pid), "-n", "tc"}, args...)
+	out, err := procutils.NewRemoteCommandAsFarAsPossible("nsenter", cmdArgs...).Output()
+	if err != nil {
+		// deleting the qdisc not existing is fine
+		if args[1] == "del" && isTcNotExistError(string(out)) {
+			return nil
+		}
+		return errors.Wrapf(err, "tc %s: %s", strings.Join(args, " "), out)
+	}
+	return nil
+}
+
+func isTcNotExistError(out string) bool {
+	for _, msg := range []string{
+		"Cannot delete qdisc with handle of zero",
+		"Cannot find specified qdisc on specified device",
+		"No such file or directory",
+		"Invalid handle",
+	} {
+		if strings.Contains(out, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// getPodSandboxPid returns the pid of the pause process of the sandbox from
+// the verbose info, whose netns is the one of the pod.
+func getPodSandboxPid(ctx context.Context, cri CRI, podId string) (int64, error) {
+	resp, err := cri.GetRuntimeClient().PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{
+		PodSandboxId: podId,
+		Verbose:      true,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "PodSandboxStatus")
+	}
+	pid, err := parseVerboseInfoPid(resp.GetInfo())
+	if err != nil {
+		return 0, errors.Wrapf(err, "pod sandbox %s", podId)
+	}
+	return pid, nil
+}
+
+// parseVerboseInfoPid returns the pid in the verbose info of the status of
+// a pod sandbox or a container.
+func parseVerboseInfoPid(info map[string]string) (int64, error) {
+	infoStr := info["info"]
+	if infoStr == "" {
+		return 0, errors.Wrap(errors.ErrEmpty, "info of status")
+	}
+	infoObj, err := jsonutils.ParseString(infoStr)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid info: %s", infoStr)
+	}
+	pid, err := infoObj.Int("pid")
+	if err != nil {
+		return 0, errors.Wrapf(err, "get pid from %s", infoObj)
+	}
+	if pid <= 0 {
+		return 0, errors.Wrapf(errors.ErrNotFound, "pid %d", pid)
+	}
+	return pid, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pod
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewBandwidthTcCommands(t *testing.T) {
+	join := func(cmds [][]string) []string {
+		ret := make([]string, 0, len(cmds))
+		for _, cmd := range cmds {
+			ret = append(ret, strings.Join(cmd, " "))
+		}
+		return ret
+	}
+	cases := []struct {
+		limit BandwidthLimit
+		want  []string
+	}{
+		{
+			limit: BandwidthLimit{EgressBps: 100 * 1000 * 1000, IngressBps: 1000 * 1000},
+			want: []string{
+				"qdisc replace dev eth0 root tbf rate 100000000bit burst 125000 latency 50ms",
+				"qdisc del dev eth0 ingress",
+				"qdisc add dev eth0 handle ffff: ingress",
+				"filter add dev eth0 parent ffff: protocol all prio 1 u32 match u32 0 0 police rate 1000000bit burst 32768 drop flowid :1",
+			},
+		},
+		{
+			limit: BandwidthLimit{},
+			want: []string{
+				"qdisc del dev eth0 root",
+				"qdisc del dev eth0 ingress",
+			},
+		},
+	}
+	for _, c := range cases {
+		got := join(newBandwidthTcCommands("eth0", c.limit))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("limit %#v: want %q, got %q", c.limit, c.want, got)
+		}
+	}
+}
+
+func TestParseBandwidthLimit(t *testing.T) {
+	cases := []struct {
+		data    string
+		want    BandwidthLimit
+		wantErr bool
+	}{
+		{data: "", want: BandwidthLimit{}},
+		{data: `{"egress_bps":100000000,"ingress_bps":0}`, want: NewBandwidthLimitMbps(100, 0)},
+		{data: "[1]", wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := ParseBandwidthLimit(c.data)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("data %q: want error", c.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("data %q: %v", c.data, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("data %q: want %#v, got %#v", c.data, c.want, got)
+		}
+	}
+}
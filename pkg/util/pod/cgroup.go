@@ -25,6 +25,9 @@ import (
 
 const (
 	CGROUP_PATH_SYSFS = "/sys/fs/cgroup"
+
+	// POD_CGROUP_PARENT is the cgroup parent of the pods
+	POD_CGROUP_PARENT = "/cloudpods"
 )
 
 type CgroupUtil interface {
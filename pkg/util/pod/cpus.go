@@ -164,28 +164,38 @@ var (
 	hostContainerCPUMapLock = sync.Mutex{}
 )
 
+const (
+	// HostContainerCPUMapVersion is the current format version of the cpu map state file
+	HostContainerCPUMapVersion = 1
+)
+
 type HostContainerCPUMap struct {
+	// Version is the format version of the state file, files written
+	// before versioning was introduced have no version and are treated as 0
+	Version   int                          `json:"version"`
 	Map       map[string]*HostContainerCPU `json:"map"`
 	stateFile string
+
+	// newCPUSetTask writes the cpuset of the container cgroups
+	newCPUSetTask cpusetTaskFactory
+}
+
+// hostContainerCPUMapMigrations upgrades the state loaded from version i to
+// version i+1
+var hostContainerCPUMapMigrations = []func(hm *HostContainerCPUMap) error{
+	// 0 -> 1: unversioned file, the layout of map is unchanged
+	func(hm *HostContainerCPUMap) error {
+		if hm.Map == nil {
+			hm.Map = make(map[string]*HostContainerCPU)
+		}
+		return nil
+	},
 }
 
 func NewHostContainerCPUMap(topo *hostapi.HostTopology, stateFile string) (*HostContainerCPUMap, error) {
 	ret := make(map[string]*HostContainerCPU)
 	if fileutils2.Exists(stateFile) {
-		content, err := fileutils2.FileGetContents(stateFile)
-		if err != nil {
-			return nil, errors.Wrapf(err, "get file contents: %s", stateFile)
-		}
-		obj, err := jsonutils.ParseString(content)
-		if err != nil {
-			return nil, errors.Wrapf(err, "parse to json: %s", content)
-		}
-		hm := new(HostContainerCPUMap)
-		if err := obj.Unmarshal(hm); err != nil {
-			return nil, errors.Wrap(err, "unmarshal to HostContainerCPUMap")
-		}
-		hm.stateFile = stateFile
-		return hm, nil
+		return loadHostContainerCPUMap(stateFile)
 	}
 	nodes := topo.Nodes
 	for _, node := range nodes {
@@ -195,7 +205,39 @@ func NewHostContainerCPUMap(topo *hostapi.HostTopology, stateFile string) (*Host
 			}
 		}
 	}
-	return &HostContainerCPUMap{Map: ret, stateFile: stateFile}, nil
+	return &HostContainerCPUMap{Version: HostContainerCPUMapVersion, Map: ret, stateFile: stateFile}, nil
+}
+
+func loadHostContainerCPUMap(stateFile string) (*HostContainerCPUMap, error) {
+	content, err := fileutils2.FileGetContents(stateFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get file contents: %s", stateFile)
+	}
+	obj, err := jsonutils.ParseString(content)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse to json: %s", content)
+	}
+	hm := new(HostContainerCPUMap)
+	if err := obj.Unmarshal(hm); err != nil {
+		return nil, errors.Wrap(err, "unmarshal to HostContainerCPUMap")
+	}
+	hm.stateFile = stateFile
+	if hm.Version == HostContainerCPUMapVersion {
+		return hm, nil
+	}
+	if hm.Version < 0 || hm.Version > HostContainerCPUMapVersion {
+		return nil, errors.Wrapf(errors.ErrInvalidFormat, "unsupported state file %s version %d", stateFile, hm.Version)
+	}
+	for hm.Version < HostContainerCPUMapVersion {
+		if err := hostContainerCPUMapMigrations[hm.Version](hm); err != nil {
+			return nil, errors.Wrapf(err, "migrate state file %s from version %d", stateFile, hm.Version)
+		}
+		hm.Version++
+	}
+	if err := hm.dumpToFile(); err != nil {
+		return nil, errors.Wrapf(err, "save migrated state file %s", stateFile)
+	}
+	return hm, nil
 }
 
 func (hm *HostContainerCPUMap) dumpToFile() error {
@@ -0,0 +1,69 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pod
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/util/fileutils2"
+)
+
+func TestNewHostContainerCPUMapMigrateUnversioned(t *testing.T) {
+	dir, err := os.MkdirTemp("", "cpumap")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	stateFile := filepath.Join(dir, "cpu_map.json")
+	oldContent := `{"map":{"0":{"index":0,"containers":{"ctr1":[{"container_id":"ctr1","index":0}]}},"1":{"index":1,"containers":{}}}}`
+	if err := fileutils2.FilePutContents(stateFile, oldContent, false); err != nil {
+		t.Fatalf("write state file: %v", err)
+	}
+
+	hm, err := NewHostContainerCPUMap(nil, stateFile)
+	if err != nil {
+		t.Fatalf("load unversioned state file: %v", err)
+	}
+	if hm.Version != HostContainerCPUMapVersion {
+		t.Errorf("version = %d, want %d", hm.Version, HostContainerCPUMapVersion)
+	}
+	if len(hm.Map) != 2 || !hm.Map["0"].HasContainer("ctr1") {
+		t.Errorf("cpu assignments lost after migration: %s", jsonutils.Marshal(hm.Map))
+	}
+
+	content, err := fileutils2.FileGetContents(stateFile)
+	if err != nil {
+		t.Fatalf("read state file: %v", err)
+	}
+	obj, err := jsonutils.ParseString(content)
+	if err != nil {
+		t.Fatalf("parse state file: %v", err)
+	}
+	if ver, _ := obj.Int("version"); ver != HostContainerCPUMapVersion {
+		t.Errorf("state file version = %d, want %d", ver, HostContainerCPUMapVersion)
+	}
+
+	if err := fileutils2.FilePutContents(stateFile, `{"version":99,"map":{}}`, false); err != nil {
+		t.Fatalf("write state file: %v", err)
+	}
+	if _, err := NewHostContainerCPUMap(nil, stateFile); err == nil {
+		t.Errorf("expect error loading state file of unknown version")
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pod
+
+import (
+	"sort"
+	"strings"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/util/cgrouputils"
+	"yunion.io/x/onecloud/pkg/util/cgrouputils/cgroup"
+	"yunion.io/x/onecloud/pkg/util/cgrouputils/cpuset"
+)
+
+// cpusetTaskFactory creates the cgroup task writing the cpuset of a cgroup,
+// it's cgrouputils.NewCGroupCPUSetTask unless replaced by the tests.
+type cpusetTaskFactory func(pid, name, cpuset, mems string) cgroup.ICGroupTask
+
+func (hm *HostContainerCPUMap) getCPUSetTaskFactory() cpusetTaskFactory {
+	if hm.newCPUSetTask != nil {
+		return hm.newCPUSetTask
+	}
+	return cgrouputils.NewCGroupCPUSetTask
+}
+
+// ContainerCPUs returns the host cpus allocated to the container.
+func (hm *HostContainerCPUMap) ContainerCPUs(ctrId string) cpuset.CPUSet {
+	hostContainerCPUMapLock.Lock()
+	defer hostContainerCPUMapLock.Unlock()
+
+	return hm.containerCPUs(ctrId)
+}
+
+func (hm *HostContainerCPUMap) containerCPUs(ctrId string) cpuset.CPUSet {
+	b := cpuset.NewBuilder()
+	for _, hc := range hm.Map {
+		if hc.HasContainer(ctrId) {
+			b.Add(hc.Index)
+		}
+	}
+	return b.Result()
+}
+
+// ApplyCPUSet writes the host cpus allocated to the container to the
+// cpuset.cpus of its cgroup, e.g. /cloudpods/$cri_id, so the container is
+// pinned to the cpus it sees. Nothing is written if no cpu is allocated.
+// errors.ErrNotFound is returned if the cgroup doesn't exist, e.g. the
+// container isn't running.
+func (hm *HostContainerCPUMap) ApplyCPUSet(ctrId string, cgroupName string) error {
+	cpus := hm.ContainerCPUs(ctrId)
+	if cpus.IsEmpty() {
+		return nil
+	}
+	task := hm.getCPUSetTaskFactory()("", cgroupName, cpus.String(), "")
+	if !task.TaskIsExist() {
+		return errors.Wrapf(errors.ErrNotFound, "cgroup %s of container %s", cgroupName, ctrId)
+	}
+	if !task.Configure() {
+		return errors.Errorf("set cpuset %s of cgroup %s of container %s", cpus.String(), cgroupName, ctrId)
+	}
+	return nil
+}
+
+// ReapplyCPUSets applies the persisted allocations of the containers to
+// their cgroups again, e.g. on host restart. cgroupName returns the cgroup
+// of a container, false if it's unknown. The containers without a cgroup
+// are skipped.
+func (hm *HostContainerCPUMap) ReapplyCPUSets(cgroupName func(ctrId string) (string, bool)) error {
+	errs := make([]error, 0)
+	for _, ctrId := range hm.containerIds() {
+		name, ok := cgroupName(ctrId)
+		if !ok {
+			continue
+		}
+		if err := hm.ApplyCPUSet(ctrId, name); err != nil {
+			if errors.Cause(err) == errors.ErrNotFound {
+				log.Infof("skip applying cpuset of container %s: %v", ctrId, err)
+				continue
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.NewAggregate(errs)
+}
+
+// CPUMapReconcileResult is the drift fixed by Reconcile.
+type CPUMapReconcileResult struct {
+	// Freed are the containers no longer existing whose allocations are
+	// freed.
+	Freed []string
+	// Reapplied are the running containers whose cpuset is applied again.
+	Reapplied []string
+	// Drifted are the running containers whose cgroup cpuset didn't match
+	// the allocation before it's applied again.
+	Drifted []string
+}
+
+// Reconcile brings the persisted map, the cpuset cgroups and the running
+// containers in line on the host agent startup, since containers may exit or
+// be removed while the agent is down. existing are the containers of the pods
+// which still exist in the runtime in any state, liveCgroups are the cgroups
+// of the running ones, both by container id.
+//
+// The allocations of the containers no longer existing are freed, the ones
+// stopped or only created keep their cpus for the next start. With enforce, the
+// cgroup cpuset of each running container is compared with its allocation,
+// the drift is logged, and the allocation is applied again; without it the
+// cpuset is left to the runtime, which doesn't follow the map.
+func (hm *HostContainerCPUMap) Reconcile(existing map[string]bool, liveCgroups map[string]string, enforce bool) (*CPUMapReconcileResult, error) {
+	result := &CPUMapReconcileResult{
+		Freed:     []string{},
+		Reapplied: []string{},
+		Drifted:   []string{},
+	}
+	errs := make([]error, 0)
+	for _, ctrId := range hm.containerIds() {
+		if !existing[ctrId] {
+			result.Freed = append(result.Freed, ctrId)
+			continue
+		}
+		name, ok := liveCgroups[ctrId]
+		if !ok || !enforce {
+			continue
+		}
+		cpus := hm.ContainerCPUs(ctrId)
+		task := hm.getCPUSetTaskFactory()("", name, cpus.String(), "")
+		if !task.TaskIsExist() {
+			log.Warningf("cgroup %s of running container %s not found", name, ctrId)
+			continue
+		}
+		actual, err := cpuset.Parse(strings.TrimSpace(task.GetParam("cpuset.cpus")))
+		if err != nil || !actual.Equals(cpus) {
+			log.Warningf("container %s is allocated cpus %q but its cgroup %s has %q", ctrId, cpus.String(), name, actual.String())
+			result.Drifted = append(result.Drifted, ctrId)
+		}
+		if !task.Configure() {
+			errs = append(errs, errors.Errorf("set cpuset %s of cgroup %s of container %s", cpus.String(), name, ctrId))
+			continue
+		}
+		result.Reapplied = append(result.Reapplied, ctrId)
+	}
+	if len(result.Freed) > 0 {
+		log.Infof("free cpus of the containers no longer existing: %v", result.Freed)
+		if err := hm.deleteContainers(result.Freed); err != nil {
+			errs = append(errs, errors.Wrap(err, "free cpus of the containers no longer existing"))
+		}
+	}
+	return result, errors.NewAggregate(errs)
+}
+
+// deleteContainers frees the allocations of the containers and saves the
+// map once.
+func (hm *HostContainerCPUMap) deleteContainers(ctrIds []string) error {
+	hostContainerCPUMapLock.Lock()
+	defer hostContainerCPUMapLock.Unlock()
+
+	for _, cm := range hm.Map {
+		for _, ctrId := range ctrIds {
+			cm.DeleteContainer(ctrId)
+		}
+	}
+	return hm.dumpToFile()
+}
+
+// containerIds returns the containers allocated cpus in the map.
+func (hm *HostContainerCPUMap) containerIds() []string {
+	hostContainerCPUMapLock.Lock()
+	defer hostContainerCPUMapLock.Unlock()
+
+	ids := make(map[string]struct{})
+	for _, hc := range hm.Map {
+		for ctrId := range hc.Containers {
+			ids[ctrId] = struct{}{}
+		}
+	}
+	ret := make([]string, 0, len(ids))
+	for id := range ids {
+		ret = append(ret, id)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,196 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pod
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/util/cgrouputils/cgroup"
+	"yunion.io/x/onecloud/pkg/util/fileutils2"
+)
+
+// fakeCPUSetTask writes the cpuset to a cgroup filesystem under root.
+type fakeCPUSetTask struct {
+	cgroup.ICGroupTask
+	root   string
+	name   string
+	cpuset string
+}
+
+func (t *fakeCPUSetTask) path() string {
+	return filepath.Join(t.root, "cpuset", t.name)
+}
+
+func (t *fakeCPUSetTask) TaskIsExist() bool {
+	return fileutils2.Exists(t.path())
+}
+
+func (t *fakeCPUSetTask) GetParam(name string) string {
+	content, _ := fileutils2.FileGetContents(filepath.Join(t.path(), name))
+	return content
+}
+
+func (t *fakeCPUSetTask) Configure() bool {
+	return fileutils2.FilePutContents(filepath.Join(t.path(), "cpuset.cpus"), t.cpuset, false) == nil
+}
+
+func TestHostContainerCPUMapReapplyCPUSets(t *testing.T) {
+	dir, err := os.MkdirTemp("", "cpuset")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the state persisted before the host restarts
+	stateFile := filepath.Join(dir, "cpu_map.json")
+	content := `{"version":1,"map":{
+		"0":{"index":0,"containers":{}},
+		"1":{"index":1,"containers":{"ctr2":[{"container_id":"ctr2","index":0}]}},
+		"2":{"index":2,"containers":{"ctr1":[{"container_id":"ctr1","index":0}],"ctr3":[{"container_id":"ctr3","index":0}]}},
+		"3":{"index":3,"containers":{"ctr1":[{"container_id":"ctr1","index":1}]}}}}`
+	if err := fileutils2.FilePutContents(stateFile, content, false); err != nil {
+		t.Fatalf("write state file: %v", err)
+	}
+	hm, err := NewHostContainerCPUMap(nil, stateFile)
+	if err != nil {
+		t.Fatalf("load state file: %v", err)
+	}
+
+	cgRoot := filepath.Join(dir, "cgroup")
+	hm.newCPUSetTask = func(pid, name, cpuset, mems string) cgroup.ICGroupTask {
+		return &fakeCPUSetTask{root: cgRoot, name: name, cpuset: cpuset}
+	}
+	// ctr3 isn't running, so it has no cgroup
+	cgroups := map[string]string{
+		"ctr1": "cloudpods/cri1",
+		"ctr2": "cloudpods/cri2",
+		"ctr3": "cloudpods/cri3",
+	}
+	for _, ctrId := range []string{"ctr1", "ctr2"} {
+		if err := os.MkdirAll(filepath.Join(cgRoot, "cpuset", cgroups[ctrId]), 0755); err != nil {
+			t.Fatalf("create cgroup: %v", err)
+		}
+	}
+
+	if err := hm.ReapplyCPUSets(func(ctrId string) (string, bool) {
+		name, ok := cgroups[ctrId]
+		return name, ok
+	}); err != nil {
+		t.Fatalf("reapply cpusets: %v", err)
+	}
+	for ctrId, want := range map[string]string{"ctr1": "2-3", "ctr2": "1"} {
+		got, err := fileutils2.FileGetContents(filepath.Join(cgRoot, "cpuset", cgroups[ctrId], "cpuset.cpus"))
+		if err != nil {
+			t.Fatalf("read cpuset of %s: %v", ctrId, err)
+		}
+		if got != want {
+			t.Errorf("cpuset of %s = %q, want %q", ctrId, got, want)
+		}
+	}
+	if fileutils2.Exists(filepath.Join(cgRoot, "cpuset", cgroups["ctr3"])) {
+		t.Errorf("cgroup of the stopped container created")
+	}
+	if err := hm.ApplyCPUSet("ctr3", cgroups["ctr3"]); errors.Cause(err) != errors.ErrNotFound {
+		t.Errorf("expect ErrNotFound applying to a missing cgroup, got %v", err)
+	}
+	// nothing to apply without allocated cpus
+	if err := hm.ApplyCPUSet("ctr4", "cloudpods/cri4"); err != nil {
+		t.Errorf("apply without allocated cpus: %v", err)
+	}
+}
+
+func TestHostContainerCPUMapReconcile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "cpuset")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// ctr1 and ctr2 are running, ctr3 was removed while the agent is down
+	// and ctr4 is stopped
+	stateFile := filepath.Join(dir, "cpu_map.json")
+	content := `{"version":1,"map":{
+		"0":{"index":0,"containers":{"ctr3":[{"container_id":"ctr3","index":0}]}},
+		"1":{"index":1,"containers":{"ctr2":[{"container_id":"ctr2","index":0}]}},
+		"2":{"index":2,"containers":{"ctr1":[{"container_id":"ctr1","index":0}]}},
+		"3":{"index":3,"containers":{"ctr1":[{"container_id":"ctr1","index":1}]}},
+		"4":{"index":4,"containers":{"ctr4":[{"container_id":"ctr4","index":0}]}}}}`
+	if err := fileutils2.FilePutContents(stateFile, content, false); err != nil {
+		t.Fatalf("write state file: %v", err)
+	}
+	hm, err := NewHostContainerCPUMap(nil, stateFile)
+	if err != nil {
+		t.Fatalf("load state file: %v", err)
+	}
+	cgRoot := filepath.Join(dir, "cgroup")
+	hm.newCPUSetTask = func(pid, name, cpuset, mems string) cgroup.ICGroupTask {
+		return &fakeCPUSetTask{root: cgRoot, name: name, cpuset: cpuset}
+	}
+	// the cgroup of ctr1 drifted to all the cpus, ctr2 matches
+	liveCgroups := map[string]string{
+		"ctr1": "cloudpods/cri1",
+		"ctr2": "cloudpods/cri2",
+	}
+	for ctrId, cpus := range map[string]string{"ctr1": "0-3", "ctr2": "1"} {
+		cgDir := filepath.Join(cgRoot, "cpuset", liveCgroups[ctrId])
+		if err := os.MkdirAll(cgDir, 0755); err != nil {
+			t.Fatalf("create cgroup: %v", err)
+		}
+		if err := fileutils2.FilePutContents(filepath.Join(cgDir, "cpuset.cpus"), cpus+"\n", false); err != nil {
+			t.Fatalf("write cpuset: %v", err)
+		}
+	}
+
+	existing := map[string]bool{"ctr1": true, "ctr2": true, "ctr4": true}
+	result, err := hm.Reconcile(existing, liveCgroups, true)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	want := &CPUMapReconcileResult{
+		Freed:     []string{"ctr3"},
+		Reapplied: []string{"ctr1", "ctr2"},
+		Drifted:   []string{"ctr1"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("reconcile result = %#v, want %#v", result, want)
+	}
+	got, err := fileutils2.FileGetContents(filepath.Join(cgRoot, "cpuset", liveCgroups["ctr1"], "cpuset.cpus"))
+	if err != nil {
+		t.Fatalf("read cpuset of ctr1: %v", err)
+	}
+	if got != "2-3" {
+		t.Errorf("cpuset of ctr1 = %q, want %q", got, "2-3")
+	}
+	// the freed allocation is persisted
+	reloaded, err := NewHostContainerCPUMap(nil, stateFile)
+	if err != nil {
+		t.Fatalf("reload state file: %v", err)
+	}
+	if cpus := reloaded.ContainerCPUs("ctr3"); !cpus.IsEmpty() {
+		t.Errorf("cpus of ctr3 = %s, want freed", cpus.String())
+	}
+	if cpus := reloaded.ContainerCPUs("ctr1"); cpus.String() != "2-3" {
+		t.Errorf("cpus of ctr1 = %s, want 2-3", cpus.String())
+	}
+	// the stopped container keeps its cpus for the next start
+	if cpus := reloaded.ContainerCPUs("ctr4"); cpus.String() != "4" {
+		t.Errorf("cpus of ctr4 = %s, want 4", cpus.String())
+	}
+}
@@ -15,7 +15,13 @@
 package image
 
 import (
+	"context"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
 
 	"yunion.io/x/pkg/errors"
 
@@ -24,28 +30,87 @@ import (
 
 type ImageTool interface {
 	Pull(image string, opt *PullOptions) (string, error)
-	Push(image string, opt *PushOptions) error
+	// PullContext is Pull killing the ctr process once ctx is done.
+	PullContext(ctx context.Context, image string, opt *PullOptions) (string, error)
+	// Push pushes the image and returns the digest of the manifest pushed,
+	// e.g. sha256:0d17b...
+	Push(image string, opt *PushOptions) (string, error)
+	// PushContext is Push killing the ctr process once ctx is done.
+	PushContext(ctx context.Context, image string, opt *PushOptions) (string, error)
+	// Inspect returns the info of the image stored locally, errors.ErrNotFound
+	// is returned if the image isn't pulled.
+	Inspect(image string) (*ImageInfo, error)
+	// Remove removes the local image, errors.ErrNotFound is returned if the
+	// image doesn't exist.
+	Remove(image string, opt *RemoveOptions) error
+	ListNamespaces() ([]string, error)
+	Usage() ([]ImageUsage, error)
+	// NormalizeRef canonicalizes an image reference with the normalizer of
+	// the tool, the same way as Pull and Push do.
+	NormalizeRef(image string) (string, error)
 }
 
 type imageTool struct {
-	address   string
-	namespace string
+	address    string
+	namespace  string
+	normalizer RefNormalizer
 }
 
 func NewImageTool(address, namespace string) ImageTool {
+	return NewImageToolWithNormalizer(address, namespace, NormalizeImageRef)
+}
+
+// NewImageToolWithNormalizer returns an ImageTool canonicalizing the image
+// references with normalizer, nil means NormalizeImageRef.
+func NewImageToolWithNormalizer(address, namespace string, normalizer RefNormalizer) ImageTool {
+	if normalizer == nil {
+		normalizer = NormalizeImageRef
+	}
 	return &imageTool{
-		address:   address,
-		namespace: namespace,
+		address:    address,
+		namespace:  namespace,
+		normalizer: normalizer,
 	}
 }
 
-func (i imageTool) newCtrCmd(args ...string) *procutils.Command {
+func (i imageTool) NormalizeRef(image string) (string, error) {
+	if i.normalizer == nil {
+		return NormalizeImageRef(image)
+	}
+	return i.normalizer(image)
+}
+
+// ctrArgs prepends the address and the namespace of the tool to the args
+// of the ctr subcommand.
+func (i imageTool) ctrArgs(args ...string) []string {
 	reqArgs := []string{"--address", i.address}
 	if i.namespace != "" {
 		reqArgs = append(reqArgs, "--namespace", i.namespace)
 	}
-	args = append(reqArgs, args...)
-	return procutils.NewRemoteCommandAsFarAsPossible("ctr", args...)
+	return append(reqArgs, args...)
+}
+
+func (i imageTool) newCtrCmd(ctx context.Context, args ...string) *procutils.Command {
+	return procutils.NewRemoteCommandContextAsFarAsPossible(ctx, ctrBinary, i.ctrArgs(args...)...)
+}
+
+// ctrOutput runs ctr with the args and returns the combined output,
+// ErrToolNotFound is returned if ctr isn't installed on the host.
+func (i imageTool) ctrOutput(args ...string) ([]byte, error) {
+	return i.ctrOutputContext(context.Background(), args...)
+}
+
+// ctrOutputContext is ctrOutput killing ctr once ctx is done, the error of
+// ctx is returned then.
+func (i imageTool) ctrOutputContext(ctx context.Context, args ...string) ([]byte, error) {
+	if err := checkTool(ctrBinary); err != nil {
+		return nil, err
+	}
+	out, err := i.newCtrCmd(ctx, args...).Output()
+	if err != nil && ctx.Err() != nil {
+		return out, errors.Wrapf(ctx.Err(), "run %s", ctrBinary)
+	}
+	return out, err
 }
 
 type RepoCommonOptions struct {
@@ -53,10 +118,65 @@ type RepoCommonOptions struct {
 	PlainHttp  bool
 	Username   string
 	Password   string
+	// Timeout bounds the pull or the push, zero means no timeout.
+	Timeout time.Duration
+	// HostsDir is the directory of the containerd registry host configs,
+	// e.g. /etc/containerd/certs.d, passed as --hosts-dir so the mirrors
+	// configured for the registry of the image are used.
+	HostsDir string
+	// Mirror replaces the registry host of the image reference for this
+	// call, e.g. mirror.local:5000. The pulled image is tagged back with
+	// the original reference, and the local image of the original reference
+	// is pushed to the mirror. When HostsDir is also set, Mirror takes
+	// precedence: the reference is rewritten first, so only the host config
+	// of the mirror in HostsDir applies.
+	Mirror string
+}
+
+// remoteRef returns the reference of the image in the registry, which is
+// the image rewritten to Mirror if it's set.
+func (opt RepoCommonOptions) remoteRef(image string) (string, error) {
+	if opt.Mirror == "" {
+		return image, nil
+	}
+	return rewriteRegistryHost(image, opt.Mirror)
+}
+
+// rewriteRegistryHost replaces the registry host of the image with mirror,
+// e.g. docker.io/library/nginx:latest -> mirror.local:5000/library/nginx:latest
+func rewriteRegistryHost(image, mirror string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", errors.Wrapf(errors.ErrInvalidFormat, "image reference %q: %v", image, err)
+	}
+	ref := strings.TrimSuffix(mirror, "/") + "/" + reference.Path(named)
+	if tagged, ok := named.(reference.Tagged); ok {
+		ref += ":" + tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		ref += "@" + digested.Digest().String()
+	}
+	rewritten, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", errors.Wrapf(errors.ErrInvalidFormat, "mirror %q of image %q: %v", mirror, image, err)
+	}
+	return rewritten.String(), nil
+}
+
+// withTimeout returns ctx with the deadline of opt.Timeout if it's set.
+func (opt RepoCommonOptions) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if opt.Timeout > 0 {
+		return context.WithTimeout(ctx, opt.Timeout)
+	}
+	return context.WithCancel(ctx)
 }
 
 type PullOptions struct {
 	RepoCommonOptions
+	// VerifySignature verifies the signature of the pulled image, the pull
+	// fails with ErrSignatureVerification if it isn't signed with the key.
+	// It's off when nil.
+	VerifySignature *SignatureVerifyOptions
 }
 
 func (i imageTool) newRepoCommonArgs(opt RepoCommonOptions) []string {
@@ -70,19 +190,54 @@ func (i imageTool) newRepoCommonArgs(opt RepoCommonOptions) []string {
 	if opt.Username != "" && opt.Password != "" {
 		args = append(args, "--user", fmt.Sprintf("%s:%s", opt.Username, opt.Password))
 	}
+	if opt.HostsDir != "" {
+		args = append(args, "--hosts-dir", opt.HostsDir)
+	}
 	return args
 }
 
 func (i imageTool) Pull(image string, opt *PullOptions) (string, error) {
+	return i.PullContext(context.Background(), image, opt)
+}
+
+func (i imageTool) PullContext(ctx context.Context, image string, opt *PullOptions) (string, error) {
+	ctx, cancel := opt.withTimeout(ctx)
+	defer cancel()
+
+	image, err := i.NormalizeRef(image)
+	if err != nil {
+		return "", err
+	}
+	if opt.VerifySignature != nil {
+		if err := opt.VerifySignature.validate(); err != nil {
+			return "", err
+		}
+	}
+	remote, err := opt.remoteRef(image)
+	if err != nil {
+		return "", err
+	}
 	args := []string{}
 	args = append(args, []string{"images", "pull"}...)
 	args = append(args, i.newRepoCommonArgs(opt.RepoCommonOptions)...)
-	args = append(args, []string{image}...)
+	args = append(args, []string{remote}...)
 
-	cmd := i.newCtrCmd(args...)
-	out, err := cmd.Output()
+	out, err := i.ctrOutputContext(ctx, args...)
 	if err != nil {
-		return "", errors.Wrapf(err, "pull imageTool: %s", out)
+		return "", errors.Wrapf(err, "pull %s: %s", remote, out)
+	}
+	if opt.VerifySignature != nil {
+		if err := i.verifySignature(ctx, remote, opt.RepoCommonOptions, opt.VerifySignature); err != nil {
+			i.removeUnverified(remote)
+			return "", errors.Wrapf(err, "verify signature of %s", remote)
+		}
+	}
+	if remote != image {
+		// the image is run by its original reference
+		out, err := i.ctrOutputContext(ctx, "images", "tag", "--force", remote, image)
+		if err != nil {
+			return "", errors.Wrapf(err, "tag %s as %s: %s", remote, image, out)
+		}
 	}
 	return image, nil
 }
@@ -91,16 +246,92 @@ type PushOptions struct {
 	RepoCommonOptions
 }
 
-func (i imageTool) Push(image string, opt *PushOptions) error {
+func (i imageTool) Push(image string, opt *PushOptions) (string, error) {
+	return i.PushContext(context.Background(), image, opt)
+}
+
+func (i imageTool) PushContext(ctx context.Context, image string, opt *PushOptions) (string, error) {
+	ctx, cancel := opt.withTimeout(ctx)
+	defer cancel()
+
+	image, err := i.NormalizeRef(image)
+	if err != nil {
+		return "", err
+	}
+	remote, err := opt.remoteRef(image)
+	if err != nil {
+		return "", err
+	}
 	args := []string{}
 	args = append(args, []string{"images", "push"}...)
 	args = append(args, i.newRepoCommonArgs(opt.RepoCommonOptions)...)
-	args = append(args, []string{image}...)
+	args = append(args, []string{remote}...)
+	if remote != image {
+		// push the local image to the mirror
+		args = append(args, image)
+	}
+
+	out, err := i.ctrOutputContext(ctx, args...)
+	if err != nil {
+		return "", errors.Wrapf(err, "push %s: %s", remote, out)
+	}
+	digest, err := parsePushDigest(string(out))
+	if err != nil {
+		return "", errors.Wrapf(err, "push %s: %s", remote, out)
+	}
+	return digest, nil
+}
+
+// pushDigestRegexp matches the progress of the manifest or the index pushed
+// by `ctr images push`, e.g.
+//
+//	manifest-sha256:0d17b...: done           |++++++++++++++++++++++++++++++++++++++|
+var pushDigestRegexp = regexp.MustCompile(`(index|manifest)-(sha256:[0-9a-f]{64})`)
+
+// parsePushDigest finds the digest of the pushed image in the output of
+// `ctr images push`. The index of a multi-platform image is the image
+// pushed, its manifests are of the platforms.
+func parsePushDigest(out string) (string, error) {
+	manifest := ""
+	for _, match := range pushDigestRegexp.FindAllStringSubmatch(out, -1) {
+		if match[1] == "index" {
+			return match[2], nil
+		}
+		if manifest == "" {
+			manifest = match[2]
+		}
+	}
+	if manifest == "" {
+		return "", errors.Wrap(errors.ErrNotFound, "digest of the pushed image")
+	}
+	return manifest, nil
+}
 
-	cmd := i.newCtrCmd(args...)
-	out, err := cmd.Output()
+type RemoveOptions struct {
+	// Sync waits until the content of the image is garbage collected
+	Sync bool
+}
+
+func newRemoveArgs(image string, opt *RemoveOptions) []string {
+	args := []string{"images", "rm"}
+	if opt != nil && opt.Sync {
+		args = append(args, "--sync")
+	}
+	return append(args, image)
+}
+
+func (i imageTool) Remove(image string, opt *RemoveOptions) error {
+	image, err := i.NormalizeRef(image)
+	if err != nil {
+		return err
+	}
+	out, err := i.ctrOutput(newRemoveArgs(image, opt)...)
 	if err != nil {
-		return errors.Wrapf(err, "push %s: %s", image, out)
+		return errors.Wrapf(err, "remove %s: %s", image, out)
+	}
+	// ctr only warns of the missing image
+	if strings.Contains(string(out), "image not found") {
+		return errors.Wrapf(errors.ErrNotFound, "image %s", image)
 	}
 	return nil
 }
@@ -0,0 +1,152 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"encoding/json"
+	"runtime"
+	"strings"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+)
+
+// ImageInfo is the info of an image stored locally.
+type ImageInfo struct {
+	Ref string
+	// MediaType is the media type of the manifest or the index of the image
+	MediaType string
+	// Digest is the digest of the manifest or the index of the image,
+	// e.g. sha256:0d17b...
+	Digest string
+	// Size is the size of the content of the image reported by ctr
+	Size int64
+	// Created is the creation time in the image config, it's zero when the
+	// config can't be resolved, e.g. the platform isn't in the index.
+	Created time.Time
+}
+
+func (i imageTool) Inspect(image string) (*ImageInfo, error) {
+	image, err := i.NormalizeRef(image)
+	if err != nil {
+		return nil, err
+	}
+	out, err := i.ctrOutput("images", "ls", "name=="+image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list image %s: %s", image, out)
+	}
+	info, err := parseImageListOutput(string(out), image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "image %s", image)
+	}
+	created, err := i.imageCreated(info.Digest)
+	if err != nil {
+		log.Warningf("get created time of image %s: %v", image, err)
+	} else {
+		info.Created = created
+	}
+	return info, nil
+}
+
+// parseImageListOutput finds the image ref in the output of `ctr images ls`,
+// e.g.
+//
+//	REF                              TYPE                                                 DIGEST                                                                  SIZE    PLATFORMS   LABELS
+//	docker.io/library/busybox:latest application/vnd.oci.image.index.v1+json sha256:768e5c6f5cb6db0794eec98dc7a967f40631746c32232b78a3105fb946f3ab83 2.1 MiB linux/amd64 -
+func parseImageListOutput(out string, ref string) (*ImageInfo, error) {
+	for _, line := range splitLines(out) {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != ref {
+			continue
+		}
+		sizeStr := fields[3]
+		// the unit may be separated by a space
+		if len(fields) > 4 && !isNumber(fields[4]) && !strings.Contains(fields[4], "/") && fields[4] != "-" {
+			sizeStr += fields[4]
+		}
+		size, err := parseHumanSize(sizeStr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse line %q", line)
+		}
+		return &ImageInfo{
+			Ref:       fields[0],
+			MediaType: fields[1],
+			Digest:    fields[2],
+			Size:      size,
+		}, nil
+	}
+	return nil, errors.ErrNotFound
+}
+
+// imageContent is the part of the index, the manifest and the config of an
+// image needed to find the creation time.
+type imageContent struct {
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform *struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+		} `json:"platform"`
+	} `json:"manifests"`
+	Config *struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Created *time.Time `json:"created"`
+}
+
+// imageCreated follows the index and the manifest of the host platform to
+// the config of the image, which has the creation time.
+func (i imageTool) imageCreated(digest string) (time.Time, error) {
+	// index -> manifest -> config
+	for depth := 0; depth < 3; depth++ {
+		out, err := i.ctrOutput("content", "get", digest)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "get content %s: %s", digest, out)
+		}
+		content := imageContent{}
+		if err := json.Unmarshal(out, &content); err != nil {
+			return time.Time{}, errors.Wrapf(err, "unmarshal content %s", digest)
+		}
+		next, created, err := nextImageContent(content, runtime.GOOS, runtime.GOARCH)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "content %s", digest)
+		}
+		if next == "" {
+			return created, nil
+		}
+		digest = next
+	}
+	return time.Time{}, errors.Wrap(errors.ErrInvalidFormat, "config not found")
+}
+
+// nextImageContent returns the digest of the content referenced next on the
+// way to the config, or the creation time if the content is the config.
+func nextImageContent(content imageContent, os, arch string) (string, time.Time, error) {
+	switch {
+	case content.Config != nil:
+		return content.Config.Digest, time.Time{}, nil
+	case len(content.Manifests) > 0:
+		for _, m := range content.Manifests {
+			if m.Platform != nil && m.Platform.OS == os && m.Platform.Architecture == arch {
+				return m.Digest, time.Time{}, nil
+			}
+		}
+		return "", time.Time{}, errors.Wrapf(errors.ErrNotFound, "manifest of platform %s/%s", os, arch)
+	case content.Created != nil:
+		return "", *content.Created, nil
+	}
+	return "", time.Time{}, errors.Wrap(errors.ErrInvalidFormat, "neither index, manifest nor config")
+}
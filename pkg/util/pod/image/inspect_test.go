@@ -0,0 +1,72 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"yunion.io/x/pkg/errors"
+)
+
+func TestParseImageListOutput(t *testing.T) {
+	const digest = "sha256:768e5c6f5cb6db0794eec98dc7a967f40631746c32232b78a3105fb946f3ab83"
+	out := "REF                              TYPE                                       DIGEST  SIZE    PLATFORMS   LABELS\n" +
+		"docker.io/library/busybox:latest application/vnd.oci.image.index.v1+json " + digest + " 2.1 MiB linux/amd64,linux/arm64 -\n" +
+		"docker.io/library/nginx:latest   application/vnd.oci.image.index.v1+json " + digest + " 67.3MiB linux/amd64 -\n"
+
+	mib := float64(1 << 20)
+	info, err := parseImageListOutput(out, "docker.io/library/busybox:latest")
+	if err != nil {
+		t.Fatalf("busybox: %v", err)
+	}
+	if info.Digest != digest || info.MediaType != "application/vnd.oci.image.index.v1+json" || info.Size != int64(2.1*mib) {
+		t.Errorf("busybox: got %#v", info)
+	}
+	info, err = parseImageListOutput(out, "docker.io/library/nginx:latest")
+	if err != nil || info.Size != int64(67.3*mib) {
+		t.Errorf("nginx: got %#v, %v", info, err)
+	}
+	if _, err := parseImageListOutput(out, "docker.io/library/redis:latest"); errors.Cause(err) != errors.ErrNotFound {
+		t.Errorf("redis: want ErrNotFound, got %v", err)
+	}
+}
+
+func TestNextImageContent(t *testing.T) {
+	parse := func(s string) imageContent {
+		content := imageContent{}
+		if err := json.Unmarshal([]byte(s), &content); err != nil {
+			t.Fatalf("unmarshal %s: %v", s, err)
+		}
+		return content
+	}
+	index := parse(`{"manifests":[{"digest":"sha256:arm","platform":{"architecture":"arm64","os":"linux"}},{"digest":"sha256:amd","platform":{"architecture":"amd64","os":"linux"}}]}`)
+	if next, _, err := nextImageContent(index, "linux", "amd64"); err != nil || next != "sha256:amd" {
+		t.Errorf("index: got %s, %v", next, err)
+	}
+	if _, _, err := nextImageContent(index, "linux", "riscv64"); errors.Cause(err) != errors.ErrNotFound {
+		t.Errorf("index of other platforms: want ErrNotFound, got %v", err)
+	}
+	manifest := parse(`{"config":{"digest":"sha256:config"},"layers":[]}`)
+	if next, _, err := nextImageContent(manifest, "linux", "amd64"); err != nil || next != "sha256:config" {
+		t.Errorf("manifest: got %s, %v", next, err)
+	}
+	config := parse(`{"architecture":"amd64","created":"2024-01-02T03:04:05Z"}`)
+	next, created, err := nextImageContent(config, "linux", "amd64")
+	if err != nil || next != "" || !created.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("config: got %s, %s, %v", next, created, err)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"strings"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+)
+
+// ResolveNamespace returns the containerd namespace an ImageTool should use.
+// A non-empty namespace is returned as is. Otherwise the namespaces of the
+// containerd at address are listed, the first existing one of preferred is
+// chosen, and if none of them exists the namespace holding the most images.
+func ResolveNamespace(address, namespace string, preferred []string) (string, error) {
+	if namespace != "" {
+		return namespace, nil
+	}
+	tool := imageTool{address: address}
+	namespaces, err := tool.ListNamespaces()
+	if err != nil {
+		return "", errors.Wrap(err, "list namespaces")
+	}
+	if len(namespaces) == 0 {
+		return "", errors.Wrapf(errors.ErrNotFound, "no namespace found in containerd %s", address)
+	}
+	for _, ns := range preferred {
+		for _, exist := range namespaces {
+			if ns == exist {
+				return ns, nil
+			}
+		}
+	}
+	chosen, maxCount := "", -1
+	for _, ns := range namespaces {
+		nsTool := imageTool{address: address, namespace: ns}
+		images, err := nsTool.listImageRefs()
+		if err != nil {
+			return "", errors.Wrapf(err, "list images of namespace %s", ns)
+		}
+		if len(images) > maxCount {
+			chosen, maxCount = ns, len(images)
+		}
+	}
+	log.Infof("containerd namespace %s with %d images is chosen from %v", chosen, maxCount, namespaces)
+	return chosen, nil
+}
+
+// ListNamespaces returns the namespaces of the containerd.
+func (i imageTool) ListNamespaces() ([]string, error) {
+	tool := imageTool{address: i.address}
+	out, err := tool.ctrOutput("namespaces", "ls", "-q")
+	if err != nil {
+		return nil, errors.Wrapf(err, "list namespaces: %s", out)
+	}
+	return splitLines(string(out)), nil
+}
+
+func (i imageTool) listImageRefs() ([]string, error) {
+	out, err := i.ctrOutput("images", "ls", "-q")
+	if err != nil {
+		return nil, errors.Wrapf(err, "list images: %s", out)
+	}
+	return splitLines(string(out)), nil
+}
+
+func splitLines(out string) []string {
+	ret := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			ret = append(ret, line)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"strings"
+
+	"github.com/docker/distribution/reference"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// RefNormalizer canonicalizes an image reference, so the different forms of
+// the same image are referenced by the same name.
+type RefNormalizer func(ref string) (string, error)
+
+// NormalizeImageRef is the default RefNormalizer, which follows the docker
+// convention as ctr requires a fully qualified reference:
+//
+//	nginx                  -> docker.io/library/nginx:latest
+//	library/nginx:1.25     -> docker.io/library/nginx:1.25
+//	registry:5000/app      -> registry:5000/app:latest
+//	nginx@sha256:...       -> docker.io/library/nginx@sha256:...
+//
+// A digest reference is never tagged, the tag of a reference with both a
+// tag and a digest is dropped since the digest pins the image.
+func NormalizeImageRef(ref string) (string, error) {
+	named, err := reference.ParseDockerRef(strings.TrimSpace(ref))
+	if err != nil {
+		return "", errors.Wrapf(errors.ErrInvalidFormat, "image reference %q: %v", ref, err)
+	}
+	return named.String(), nil
+}
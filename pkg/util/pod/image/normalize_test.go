@@ -0,0 +1,58 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"testing"
+
+	"yunion.io/x/pkg/errors"
+)
+
+func TestNormalizeImageRef(t *testing.T) {
+	const digest = "sha256:7cc4b5aefd1d0cadf8d97d4350462ba51c694ebca145b08d7d41b41acc8db5aa"
+	cases := []struct {
+		ref  string
+		want string
+	}{
+		{"nginx", "docker.io/library/nginx:latest"},
+		{"nginx:1.25", "docker.io/library/nginx:1.25"},
+		{"library/nginx", "docker.io/library/nginx:latest"},
+		{"docker.io/library/nginx:latest", "docker.io/library/nginx:latest"},
+		{"docker.io/nginx", "docker.io/library/nginx:latest"},
+		{"yunion/host:v3.11", "docker.io/yunion/host:v3.11"},
+		{"registry.cn-beijing.aliyuncs.com/yunionio/host", "registry.cn-beijing.aliyuncs.com/yunionio/host:latest"},
+		{"localhost/app", "localhost/app:latest"},
+		{"10.0.0.1:5000/app:v1", "10.0.0.1:5000/app:v1"},
+		{" nginx ", "docker.io/library/nginx:latest"},
+		{"nginx@" + digest, "docker.io/library/nginx@" + digest},
+		{"nginx:1.25@" + digest, "docker.io/library/nginx@" + digest},
+	}
+	for _, c := range cases {
+		got, err := NormalizeImageRef(c.ref)
+		if err != nil {
+			t.Errorf("normalize %q: %v", c.ref, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("normalize %q: want %q, got %q", c.ref, c.want, got)
+		}
+	}
+
+	for _, ref := range []string{"", "Nginx", "nginx:", "nginx@sha256:abc"} {
+		if _, err := NormalizeImageRef(ref); errors.Cause(err) != errors.ErrInvalidFormat {
+			t.Errorf("normalize %q: expect ErrInvalidFormat, got %v", ref, err)
+		}
+	}
+}
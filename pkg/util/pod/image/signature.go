@@ -0,0 +1,147 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/util/procutils"
+)
+
+// cosignBinary is the cli verifying the image signatures.
+const cosignBinary = "cosign"
+
+const (
+	// cosignRegistryUsernameEnv and cosignRegistryPasswordEnv pass the
+	// registry credentials to cosign, which keeps them off the command line
+	// seen by ps.
+	cosignRegistryUsernameEnv = "COSIGN_REGISTRY_USERNAME"
+	cosignRegistryPasswordEnv = "COSIGN_REGISTRY_PASSWORD"
+)
+
+// ErrSignatureVerification is returned when the signature of a pulled image
+// can't be verified with the public key.
+const ErrSignatureVerification = errors.Error("image signature verification failed")
+
+// SignatureVerifyOptions are the trust settings the signature of an image is
+// verified with by cosign.
+type SignatureVerifyOptions struct {
+	// PublicKey is the path or the KMS URI of the public key the images are
+	// signed with, e.g. /etc/cosign/cosign.pub or hashivault://image-signing
+	PublicKey string
+}
+
+func (o *SignatureVerifyOptions) validate() error {
+	if strings.TrimSpace(o.PublicKey) == "" {
+		return errors.Wrap(errors.ErrEmpty, "public key of the signature verification")
+	}
+	return nil
+}
+
+// verifySignature verifies the signature of the pulled image by its digest,
+// so the image verified is the one pulled even if the tag has been moved
+// since.
+func (i imageTool) verifySignature(ctx context.Context, image string, repoOpt RepoCommonOptions, opt *SignatureVerifyOptions) error {
+	if err := checkTool(cosignBinary); err != nil {
+		return err
+	}
+	ref, err := i.digestRef(image)
+	if err != nil {
+		return errors.Wrapf(err, "digest of image %s", image)
+	}
+	cmd := procutils.NewRemoteCommandContextAsFarAsPossible(ctx, cosignBinary, newCosignVerifyArgs(ref, repoOpt, opt)...)
+	if env := newCosignVerifyEnv(repoOpt); len(env) > 0 {
+		cmd.SetEnv(env)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return errors.Wrapf(ctx.Err(), "cosign verify %s", ref)
+		}
+		return errors.Wrapf(ErrSignatureVerification, "cosign verify %s: %v: %s", ref, err, out)
+	}
+	return nil
+}
+
+func newCosignVerifyArgs(ref string, repoOpt RepoCommonOptions, opt *SignatureVerifyOptions) []string {
+	args := []string{"verify", "--key", opt.PublicKey}
+	if repoOpt.PlainHttp {
+		args = append(args, "--allow-http-registry")
+	}
+	if repoOpt.SkipVerify {
+		args = append(args, "--allow-insecure-registry")
+	}
+	return append(args, ref)
+}
+
+func newCosignVerifyEnv(repoOpt RepoCommonOptions) []string {
+	if repoOpt.Username == "" || repoOpt.Password == "" {
+		return nil
+	}
+	return []string{
+		cosignRegistryUsernameEnv + "=" + repoOpt.Username,
+		cosignRegistryPasswordEnv + "=" + repoOpt.Password,
+	}
+}
+
+// digestRef returns the reference of the pulled image by digest, e.g.
+// docker.io/library/nginx@sha256:...
+func (i imageTool) digestRef(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", errors.Wrapf(errors.ErrInvalidFormat, "image reference %q: %v", image, err)
+	}
+	if _, ok := named.(reference.Canonical); ok {
+		return named.String(), nil
+	}
+	out, err := i.ctrOutput("images", "ls", "name=="+image)
+	if err != nil {
+		return "", errors.Wrapf(err, "list image %s: %s", image, out)
+	}
+	digest, err := parseImageDigest(string(out), image)
+	if err != nil {
+		return "", err
+	}
+	return reference.TrimNamed(named).String() + "@" + digest, nil
+}
+
+// parseImageDigest finds the digest of the image in the output of
+// `ctr images ls`, e.g.
+//
+//	REF                            TYPE                                                      DIGEST          SIZE     PLATFORMS LABELS
+//	docker.io/library/nginx:latest application/vnd.docker.distribution.manifest.list.v2+json sha256:0d17b... 67.3 MiB linux/386 -
+func parseImageDigest(out string, image string) (string, error) {
+	for _, line := range splitLines(out) {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[0] == image {
+			return fields[2], nil
+		}
+	}
+	return "", errors.Wrapf(errors.ErrNotFound, "image %s", image)
+}
+
+// removeUnverified removes the image whose signature failed the
+// verification, so it's not run by its reference.
+func (i imageTool) removeUnverified(image string) {
+	if out, err := i.ctrOutput(newRemoveArgs(image, nil)...); err != nil {
+		log.Errorf("remove unverified image %s: %v: %s", image, err, out)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"reflect"
+	"testing"
+
+	"yunion.io/x/pkg/errors"
+)
+
+func TestParseImageDigest(t *testing.T) {
+	const out = `REF                            TYPE                                                      DIGEST                                                                  SIZE     PLATFORMS          LABELS
+docker.io/library/nginx:1.25   application/vnd.docker.distribution.manifest.list.v2+json sha256:1111111111111111111111111111111111111111111111111111111111111111 67.3 MiB linux/386,linux/amd64 -
+docker.io/library/nginx:latest application/vnd.docker.distribution.manifest.list.v2+json sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31 67.3 MiB linux/386,linux/amd64 -
+`
+	digest, err := parseImageDigest(out, "docker.io/library/nginx:latest")
+	if err != nil {
+		t.Fatalf("parse digest: %v", err)
+	}
+	if want := "sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31"; digest != want {
+		t.Errorf("want %s, got %s", want, digest)
+	}
+	if _, err := parseImageDigest(out, "docker.io/library/redis:latest"); errors.Cause(err) != errors.ErrNotFound {
+		t.Errorf("expect ErrNotFound, got %v", err)
+	}
+}
+
+func TestNewCosignVerifyArgs(t *testing.T) {
+	const ref = "registry:5000/app@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31"
+	repoOpt := RepoCommonOptions{
+		PlainHttp: true,
+		Username:  "admin",
+		Password:  "secret",
+	}
+	args := newCosignVerifyArgs(ref, repoOpt, &SignatureVerifyOptions{PublicKey: "/etc/cosign/cosign.pub"})
+	want := []string{"verify", "--key", "/etc/cosign/cosign.pub", "--allow-http-registry", ref}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("want %v, got %v", want, args)
+	}
+	// the credentials are passed by the env instead of the args
+	env := newCosignVerifyEnv(repoOpt)
+	wantEnv := []string{"COSIGN_REGISTRY_USERNAME=admin", "COSIGN_REGISTRY_PASSWORD=secret"}
+	if !reflect.DeepEqual(env, wantEnv) {
+		t.Errorf("want env %v, got %v", wantEnv, env)
+	}
+	if env := newCosignVerifyEnv(RepoCommonOptions{}); env != nil {
+		t.Errorf("want no env without the credentials, got %v", env)
+	}
+
+	if err := (&SignatureVerifyOptions{}).validate(); errors.Cause(err) != errors.ErrEmpty {
+		t.Errorf("expect ErrEmpty without the public key, got %v", err)
+	}
+}
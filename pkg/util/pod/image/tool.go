@@ -0,0 +1,82 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/util/procutils"
+)
+
+// ctrBinary is the containerd cli the ImageTool runs.
+const ctrBinary = "ctr"
+
+// ErrToolNotFound is returned when the binary of the ImageTool isn't
+// installed on the host.
+const ErrToolNotFound = errors.Error("image tool not found")
+
+// toolCheckRetryPeriod is the period a missing binary is cached for, so
+// the binary installed afterwards is found without restarting the process.
+var toolCheckRetryPeriod = time.Minute
+
+type toolCheck struct {
+	lock      sync.Mutex
+	found     bool
+	err       error
+	checkedAt time.Time
+}
+
+// toolChecks are the results of checkTool by binary name.
+var toolChecks sync.Map
+
+// checkTool verifies that the binary can be executed on the host, where the
+// commands are run as far as possible. A binary found is kept for the
+// lifetime of the process, a missing one is probed again after
+// toolCheckRetryPeriod.
+func checkTool(name string) error {
+	v, _ := toolChecks.LoadOrStore(name, &toolCheck{})
+	check := v.(*toolCheck)
+	check.lock.Lock()
+	defer check.lock.Unlock()
+
+	if check.found {
+		return nil
+	}
+	if check.err != nil && time.Since(check.checkedAt) < toolCheckRetryPeriod {
+		return check.err
+	}
+	check.err = probeTool(name)
+	check.found = check.err == nil
+	check.checkedAt = time.Now()
+	return check.err
+}
+
+func probeTool(name string) error {
+	out, err := procutils.NewRemoteCommandAsFarAsPossible(name, "--version").Output()
+	if err == nil {
+		return nil
+	}
+	if strings.Contains(err.Error(), "executable file not found") || strings.Contains(err.Error(), "no such file or directory") {
+		return errors.Wrapf(ErrToolNotFound, "%s is not found in the PATH of the host, install it or add its directory to PATH", name)
+	}
+	// the binary is there, the failures of the commands tell the rest
+	log.Warningf("probe %s --version: %v: %s", name, err, out)
+	return nil
+}
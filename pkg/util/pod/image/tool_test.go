@@ -0,0 +1,152 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"yunion.io/x/pkg/errors"
+)
+
+func TestCheckTool(t *testing.T) {
+	const missing = "no-such-image-tool"
+	err := checkTool(missing)
+	if errors.Cause(err) != ErrToolNotFound {
+		t.Fatalf("expect ErrToolNotFound, got %v", err)
+	}
+	if !strings.Contains(err.Error(), missing) || !strings.Contains(err.Error(), "PATH") {
+		t.Errorf("expect the binary name and PATH guidance, got %v", err)
+	}
+	// the missing result is cached until the retry period passes
+	v, ok := toolChecks.Load(missing)
+	if !ok || v.(*toolCheck).err != err {
+		t.Fatalf("expect the result cached")
+	}
+	if again := checkTool(missing); again != err {
+		t.Errorf("expect the cached result, got %v", again)
+	}
+	check := v.(*toolCheck)
+	check.checkedAt = time.Now().Add(-toolCheckRetryPeriod)
+	if again := checkTool(missing); again == err || errors.Cause(again) != ErrToolNotFound {
+		t.Errorf("expect probed again, got %v", again)
+	}
+
+	if err := checkTool("sh"); err != nil {
+		t.Errorf("check sh: %v", err)
+	}
+}
+
+func TestParsePushDigest(t *testing.T) {
+	const (
+		index    = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		manifest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+		layer    = "sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	)
+	out := "layer-" + layer + ": done           |++++++++++++++++++++++++++++++++++++++|\n" +
+		"manifest-" + manifest + ": done |++++++++++++++++++++++++++++++++++++++|\n" +
+		"elapsed: 0.9 s                                    total:  2.6 Ki (2.9 KiB/s)\n"
+	if digest, err := parsePushDigest(out); err != nil || digest != manifest {
+		t.Errorf("manifest: want %s, got %s, %v", manifest, digest, err)
+	}
+	out = "manifest-" + manifest + ": done\nindex-" + index + ": done\n"
+	if digest, err := parsePushDigest(out); err != nil || digest != index {
+		t.Errorf("index: want %s, got %s, %v", index, digest, err)
+	}
+	if _, err := parsePushDigest("layer-" + layer + ": done\n"); errors.Cause(err) != errors.ErrNotFound {
+		t.Errorf("no manifest: want ErrNotFound, got %v", err)
+	}
+}
+
+func TestRemoveArgs(t *testing.T) {
+	tool := imageTool{address: "/run/containerd/containerd.sock", namespace: "k8s.io"}
+	cases := []struct {
+		opt  *RemoveOptions
+		want string
+	}{
+		{
+			opt:  nil,
+			want: "--address /run/containerd/containerd.sock --namespace k8s.io images rm docker.io/library/busybox:latest",
+		},
+		{
+			opt:  &RemoveOptions{},
+			want: "--address /run/containerd/containerd.sock --namespace k8s.io images rm docker.io/library/busybox:latest",
+		},
+		{
+			opt:  &RemoveOptions{Sync: true},
+			want: "--address /run/containerd/containerd.sock --namespace k8s.io images rm --sync docker.io/library/busybox:latest",
+		},
+	}
+	for _, c := range cases {
+		got := strings.Join(tool.ctrArgs(newRemoveArgs("docker.io/library/busybox:latest", c.opt)...), " ")
+		if got != c.want {
+			t.Errorf("opt %#v: want %q, got %q", c.opt, c.want, got)
+		}
+	}
+}
+
+func TestRepoCommonOptionsWithTimeout(t *testing.T) {
+	ctx, cancel := RepoCommonOptions{}.withTimeout(context.Background())
+	if _, ok := ctx.Deadline(); ok {
+		t.Errorf("want no deadline without timeout")
+	}
+	cancel()
+	if ctx.Err() != context.Canceled {
+		t.Errorf("want cancelled, got %v", ctx.Err())
+	}
+
+	ctx, cancel = RepoCommonOptions{Timeout: time.Millisecond}.withTimeout(context.Background())
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Fatalf("want deadline with timeout")
+	}
+	<-ctx.Done()
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("want deadline exceeded, got %v", ctx.Err())
+	}
+}
+
+func TestRewriteRegistryHost(t *testing.T) {
+	const digest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	cases := []struct {
+		image  string
+		mirror string
+		want   string
+	}{
+		{"docker.io/library/nginx:latest", "mirror.local:5000", "mirror.local:5000/library/nginx:latest"},
+		{"registry.k8s.io/pause:3.9", "mirror.local/", "mirror.local/pause:3.9"},
+		{"docker.io/library/nginx@" + digest, "mirror.local", "mirror.local/library/nginx@" + digest},
+	}
+	for _, c := range cases {
+		got, err := rewriteRegistryHost(c.image, c.mirror)
+		if err != nil || got != c.want {
+			t.Errorf("%s to %s: want %s, got %s, %v", c.image, c.mirror, c.want, got, err)
+		}
+	}
+	if _, err := rewriteRegistryHost("docker.io/library/nginx:latest", "Bad Mirror"); errors.Cause(err) != errors.ErrInvalidFormat {
+		t.Errorf("bad mirror: want ErrInvalidFormat, got %v", err)
+	}
+
+	opt := RepoCommonOptions{HostsDir: "/etc/containerd/certs.d"}
+	if ref, err := opt.remoteRef("docker.io/library/nginx:latest"); err != nil || ref != "docker.io/library/nginx:latest" {
+		t.Errorf("no mirror: got %s, %v", ref, err)
+	}
+	args := strings.Join(imageTool{}.newRepoCommonArgs(opt), " ")
+	if args != "--hosts-dir /etc/containerd/certs.d" {
+		t.Errorf("hosts dir args: got %q", args)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// ImageUsage is the on-disk usage of an image's unpacked layers.
+type ImageUsage struct {
+	Ref string
+	// Size is the total size of all the layers of the image.
+	Size int64
+	// UniqueSize is the size of the layers only used by this image, which is
+	// freed when the image is removed. It's 0 when all the layers are shared.
+	UniqueSize int64
+	// SharedSize is the size of the layers also used by other images.
+	SharedSize int64
+}
+
+// Shared tells whether the image has layers used by other images.
+func (u ImageUsage) Shared() bool {
+	return u.SharedSize > 0
+}
+
+type layerUsage struct {
+	id   string
+	size int64
+}
+
+// Usage returns the on-disk usage of every image in the namespace.
+func (i imageTool) Usage() ([]ImageUsage, error) {
+	refs, err := i.listImageRefs()
+	if err != nil {
+		return nil, err
+	}
+	imageLayers := make(map[string][]layerUsage, len(refs))
+	for _, ref := range refs {
+		out, err := i.ctrOutput("images", "usage", ref)
+		if err != nil {
+			return nil, errors.Wrapf(err, "usage of image %s: %s", ref, out)
+		}
+		layers, err := parseImageUsageOutput(string(out))
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse usage of image %s", ref)
+		}
+		imageLayers[ref] = layers
+	}
+	return computeImageUsages(refs, imageLayers), nil
+}
+
+// computeImageUsages splits the size of each image into unique and shared
+// parts by counting the images referencing each layer.
+func computeImageUsages(refs []string, imageLayers map[string][]layerUsage) []ImageUsage {
+	layerRefCount := make(map[string]int)
+	for _, layers := range imageLayers {
+		for _, layer := range layers {
+			layerRefCount[layer.id]++
+		}
+	}
+	ret := make([]ImageUsage, 0, len(refs))
+	for _, ref := range refs {
+		usage := ImageUsage{Ref: ref}
+		for _, layer := range imageLayers[ref] {
+			usage.Size += layer.size
+			if layerRefCount[layer.id] > 1 {
+				usage.SharedSize += layer.size
+			} else {
+				usage.UniqueSize += layer.size
+			}
+		}
+		ret = append(ret, usage)
+	}
+	return ret
+}
+
+// parseImageUsageOutput parses the output of `ctr images usage`, e.g.
+//
+//	REF                                                                     SIZE      INODES
+//	sha256:ded7a220bb058e28ee3254fbba04ca90b679070424424761a53a043b93b612bf 7.3 MiB   1443
+func parseImageUsageOutput(out string) ([]layerUsage, error) {
+	ret := []layerUsage{}
+	for _, line := range splitLines(out) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] == "REF" {
+			continue
+		}
+		sizeStr := fields[1]
+		// the unit may be separated by a space
+		if len(fields) > 2 && !isNumber(fields[2]) {
+			sizeStr += fields[2]
+		}
+		size, err := parseHumanSize(sizeStr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse line %q", line)
+		}
+		ret = append(ret, layerUsage{id: fields[0], size: size})
+	}
+	return ret, nil
+}
+
+var humanSizeRegexp = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([a-zA-Z]*)$`)
+
+var humanSizeUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+func parseHumanSize(s string) (int64, error) {
+	matches := humanSizeRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if matches == nil {
+		return 0, errors.Wrapf(errors.ErrInvalidFormat, "size %q", s)
+	}
+	num, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parse number %q", matches[1])
+	}
+	unit, ok := humanSizeUnits[strings.ToLower(matches[2])]
+	if !ok {
+		return 0, errors.Wrapf(errors.ErrInvalidFormat, "unit of size %q", s)
+	}
+	return int64(num * unit), nil
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
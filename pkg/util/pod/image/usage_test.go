@@ -0,0 +1,59 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseImageUsageOutput(t *testing.T) {
+	out := `REF                                                                     SIZE      INODES
+sha256:aaa 7.5 MiB   1443
+sha256:bbb 4.0KiB   3
+sha256:ccc 0B   1
+`
+	got, err := parseImageUsageOutput(out)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := []layerUsage{
+		{id: "sha256:aaa", size: 7.5 * (1 << 20)},
+		{id: "sha256:bbb", size: 4096},
+		{id: "sha256:ccc", size: 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestComputeImageUsages(t *testing.T) {
+	refs := []string{"base", "app", "alias"}
+	imageLayers := map[string][]layerUsage{
+		"base":  {{id: "l1", size: 100}},
+		"app":   {{id: "l1", size: 100}, {id: "l2", size: 20}},
+		"alias": {{id: "l1", size: 100}},
+	}
+	want := []ImageUsage{
+		// fully shared with other images
+		{Ref: "base", Size: 100, UniqueSize: 0, SharedSize: 100},
+		{Ref: "app", Size: 120, UniqueSize: 20, SharedSize: 100},
+		{Ref: "alias", Size: 100, UniqueSize: 0, SharedSize: 100},
+	}
+	got := computeImageUsages(refs, imageLayers)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
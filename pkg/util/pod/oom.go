@@ -0,0 +1,68 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pod
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"yunion.io/x/pkg/errors"
+)
+
+const (
+	OOM_SCORE_ADJ_MIN = -1000
+	OOM_SCORE_ADJ_MAX = 1000
+)
+
+// procRoot is the mount point of procfs, it's replaced in tests
+var procRoot = "/proc"
+
+// SetOOMScoreAdj writes the oom_score_adj of the process, processes with a
+// higher value are killed first by the kernel on out of memory.
+func SetOOMScoreAdj(pid int, adj int) error {
+	if pid <= 0 {
+		return errors.Wrapf(errors.ErrInvalidFormat, "invalid pid %d", pid)
+	}
+	if adj < OOM_SCORE_ADJ_MIN || adj > OOM_SCORE_ADJ_MAX {
+		return errors.Wrapf(errors.ErrInvalidFormat, "oom_score_adj %d out of range [%d, %d]", adj, OOM_SCORE_ADJ_MIN, OOM_SCORE_ADJ_MAX)
+	}
+	fp := filepath.Join(procRoot, strconv.Itoa(pid), "oom_score_adj")
+	if err := os.WriteFile(fp, []byte(fmt.Sprintf("%d", adj)), 0644); err != nil {
+		return errors.Wrapf(err, "write %s", fp)
+	}
+	return nil
+}
+
+// SetContainerOOMScoreAdj writes the oom_score_adj of the init process of the
+// container, the processes forked by it afterwards inherit the value.
+func SetContainerOOMScoreAdj(ctx context.Context, cri CRI, ctrId string, adj int) error {
+	resp, err := cri.GetRuntimeClient().ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{
+		ContainerId: ctrId,
+		Verbose:     true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "ContainerStatus")
+	}
+	pid, err := parseVerboseInfoPid(resp.GetInfo())
+	if err != nil {
+		return errors.Wrapf(err, "container %s", ctrId)
+	}
+	return SetOOMScoreAdj(int(pid), adj)
+}
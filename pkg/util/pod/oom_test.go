@@ -0,0 +1,71 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pod
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"yunion.io/x/pkg/errors"
+)
+
+func TestSetOOMScoreAdj(t *testing.T) {
+	dir, err := os.MkdirTemp("", "proc")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "42"), 0755); err != nil {
+		t.Fatalf("create fake pid dir: %v", err)
+	}
+	oldRoot := procRoot
+	procRoot = dir
+	defer func() { procRoot = oldRoot }()
+
+	if err := SetOOMScoreAdj(42, -998); err != nil {
+		t.Fatalf("SetOOMScoreAdj: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "42", "oom_score_adj"))
+	if err != nil {
+		t.Fatalf("read oom_score_adj: %v", err)
+	}
+	if string(content) != "-998" {
+		t.Errorf("oom_score_adj = %q, want -998", content)
+	}
+
+	for _, adj := range []int{-1001, 1001} {
+		if err := SetOOMScoreAdj(42, adj); err == nil {
+			t.Errorf("expect error for oom_score_adj %d", adj)
+		}
+	}
+	if err := SetOOMScoreAdj(43, 0); err == nil {
+		t.Errorf("expect error for missing pid")
+	}
+}
+
+func TestParseVerboseInfoPid(t *testing.T) {
+	pid, err := parseVerboseInfoPid(map[string]string{"info": `{"pid":1234,"sandboxID":"abc"}`})
+	if err != nil || pid != 1234 {
+		t.Errorf("want pid 1234, got %d, %v", pid, err)
+	}
+	if _, err := parseVerboseInfoPid(nil); errors.Cause(err) != errors.ErrEmpty {
+		t.Errorf("expect ErrEmpty without info, got %v", err)
+	}
+	// the pid is 0 once the process exited
+	if _, err := parseVerboseInfoPid(map[string]string{"info": `{"pid":0}`}); errors.Cause(err) != errors.ErrNotFound {
+		t.Errorf("expect ErrNotFound for exited process, got %v", err)
+	}
+}
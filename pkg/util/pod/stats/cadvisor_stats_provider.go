@@ -43,12 +43,18 @@ func buildPodRef(containerLabels map[string]string) PodReference {
 	return PodReference{Name: podName, Namespace: podNamespace, UID: podUID}
 }
 
-func getCadvisorContainerInfo(ca cadvisor.Interface) (map[string]cadvisorapiv2.ContainerInfo, error) {
-	infos, err := ca.ContainerInfoV2("/", cadvisorapiv2.RequestOptions{
+// defaultCadvisorRequestOptions returns the options of the container info
+// request of all the cgroups.
+func defaultCadvisorRequestOptions() cadvisorapiv2.RequestOptions {
+	return cadvisorapiv2.RequestOptions{
 		IdType:    cadvisorapiv2.TypeName,
 		Count:     2, // 2 samples are needed to compute "instantaneous" CPU
 		Recursive: true,
-	})
+	}
+}
+
+func getCadvisorContainerInfo(ca cadvisor.Interface, opts cadvisorapiv2.RequestOptions) (map[string]cadvisorapiv2.ContainerInfo, error) {
+	infos, err := ca.ContainerInfoV2("/", opts)
 	if err != nil {
 		if _, ok := infos["/"]; ok {
 			// If the failure is partial, log it and return a best-effort
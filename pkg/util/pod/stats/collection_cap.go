@@ -0,0 +1,95 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// capCollectionContainers keeps the containers of whole pods, at most
+// MaxContainersPerCollection of them, of the running containers listed by a
+// collection, see capContainersByPod. The pods left out are collected first by
+// the next collection, so every pod is collected in turn. The single pod
+// listing of a non-empty sandboxID isn't capped, only the host-wide listing
+// records the observed and the skipped container counts for GetProviderStats.
+func (p *criStatsProvider) capCollectionContainers(ctx context.Context, sandboxID string, containers []*runtimeapi.Container) []*runtimeapi.Container {
+	if sandboxID != "" {
+		return containers
+	}
+	atomic.StoreUint64(&p.observedContainers, uint64(len(containers)))
+	max := p.config.MaxContainersPerCollection
+	if max <= 0 || len(containers) <= max {
+		atomic.StoreUint64(&p.skippedContainers, 0)
+		return containers
+	}
+
+	p.collectionCursorLock.Lock()
+	defer p.collectionCursorLock.Unlock()
+
+	ret, next := capContainersByPod(containers, max, p.collectionCursor)
+	p.collectionCursor = next
+	logger := p.loggerFromContext(ctx)
+	if len(ret) > max {
+		logger.Info("Pod has more containers than the max, collect it alone", "podSandboxId", ret[0].PodSandboxId, "containerCount", len(ret), "maxContainers", max)
+	}
+	logger.Info("Too many containers, collect the stats of the pods in turn", "containerCount", len(containers), "collectedCount", len(ret), "maxContainers", max)
+	atomic.StoreUint64(&p.skippedContainers, uint64(len(containers)-len(ret)))
+	return ret
+}
+
+// capContainersByPod returns the containers of the pods that fit in max
+// containers, a pod is either collected or left out as a whole. The pods are
+// taken in the order of their sandbox ids from the one of cursor, wrapping
+// around, and a pod that doesn't fit is skipped. A pod with more containers
+// than max never fits, so it's collected alone, beyond max, when its turn
+// comes as the pod of cursor. The returned cursor is the sandbox id of the
+// first pod left out, which is where the next collection starts.
+func capContainersByPod(containers []*runtimeapi.Container, max int, cursor string) ([]*runtimeapi.Container, string) {
+	podContainers := make(map[string][]*runtimeapi.Container)
+	sandboxIDs := make([]string, 0)
+	for _, c := range containers {
+		if _, ok := podContainers[c.PodSandboxId]; !ok {
+			sandboxIDs = append(sandboxIDs, c.PodSandboxId)
+		}
+		podContainers[c.PodSandboxId] = append(podContainers[c.PodSandboxId], c)
+	}
+	sort.Strings(sandboxIDs)
+	first := sort.SearchStrings(sandboxIDs, cursor)
+
+	ret := make([]*runtimeapi.Container, 0, max)
+	next := ""
+	for i := range sandboxIDs {
+		id := sandboxIDs[(first+i)%len(sandboxIDs)]
+		if i == 0 && len(podContainers[id]) > max {
+			ret = append(ret, podContainers[id]...)
+			if len(sandboxIDs) > 1 {
+				next = sandboxIDs[(first+1)%len(sandboxIDs)]
+			}
+			break
+		}
+		if len(ret)+len(podContainers[id]) > max {
+			if next == "" {
+				next = id
+			}
+			continue
+		}
+		ret = append(ret, podContainers[id]...)
+	}
+	return ret, next
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"reflect"
+	"testing"
+
+	cadvisorapiv1 "github.com/google/cadvisor/info/v1"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestCapContainersByPod(t *testing.T) {
+	containers := []*runtimeapi.Container{
+		{Id: "a1", PodSandboxId: "a"},
+		{Id: "b1", PodSandboxId: "b"},
+		{Id: "a2", PodSandboxId: "a"},
+		{Id: "c1", PodSandboxId: "c"},
+		{Id: "d1", PodSandboxId: "d"},
+		{Id: "d2", PodSandboxId: "d"},
+	}
+	ids := func(cs []*runtimeapi.Container) []string {
+		ret := []string{}
+		for _, c := range cs {
+			ret = append(ret, c.Id)
+		}
+		return ret
+	}
+	cases := []struct {
+		cursor     string
+		want       []string
+		wantCursor string
+	}{
+		{cursor: "", want: []string{"a1", "a2", "b1"}, wantCursor: "c"},
+		// the pods left out go first
+		{cursor: "c", want: []string{"c1", "d1", "d2"}, wantCursor: "a"},
+		// a doesn't fit after d and is skipped, b still fits
+		{cursor: "d", want: []string{"d1", "d2", "b1"}, wantCursor: "a"},
+		// the pod of the cursor is gone
+		{cursor: "bb", want: []string{"c1", "d1", "d2"}, wantCursor: "a"},
+	}
+	for _, c := range cases {
+		got, cursor := capContainersByPod(containers, 3, c.cursor)
+		if !reflect.DeepEqual(ids(got), c.want) || cursor != c.wantCursor {
+			t.Errorf("cursor %q: got %v next %q, want %v next %q", c.cursor, ids(got), cursor, c.want, c.wantCursor)
+		}
+	}
+}
+
+func TestCapContainersByPodOversized(t *testing.T) {
+	containers := []*runtimeapi.Container{
+		{Id: "a1", PodSandboxId: "a"},
+		{Id: "b1", PodSandboxId: "b"},
+		{Id: "b2", PodSandboxId: "b"},
+		{Id: "b3", PodSandboxId: "b"},
+		{Id: "c1", PodSandboxId: "c"},
+	}
+	ids := func(cs []*runtimeapi.Container) []string {
+		ret := []string{}
+		for _, c := range cs {
+			ret = append(ret, c.Id)
+		}
+		return ret
+	}
+	// b has more containers than max, it's skipped until it's the pod of the
+	// cursor and then collected alone
+	cursor := ""
+	collected := map[string]bool{}
+	for _, want := range [][]string{
+		{"a1", "c1"},
+		{"b1", "b2", "b3"},
+		{"c1", "a1"},
+	} {
+		got, next := capContainersByPod(containers, 2, cursor)
+		if !reflect.DeepEqual(ids(got), want) {
+			t.Errorf("cursor %q: got %v, want %v", cursor, ids(got), want)
+		}
+		for _, id := range ids(got) {
+			collected[id] = true
+		}
+		cursor = next
+	}
+	if len(collected) != len(containers) {
+		t.Errorf("got %v collected, want all the %d containers", collected, len(containers))
+	}
+	// a single oversized pod is collected every time
+	got, next := capContainersByPod(containers[1:4], 2, "")
+	if len(got) != 3 || next != "" {
+		t.Errorf("single oversized pod: got %v next %q, want b1-b3 and no cursor", ids(got), next)
+	}
+}
+
+func TestCapCollectionContainers(t *testing.T) {
+	rt := newTestPodsRuntimeService(5)
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, rt, nil, CRIStatsProviderConfig{MaxContainersPerCollection: 3})
+
+	collected := map[string]int{}
+	for i := 0; i < 2; i++ {
+		stats, err := p.ListPodStats()
+		if err != nil {
+			t.Fatalf("ListPodStats: %v", err)
+		}
+		if len(stats) != 3 {
+			t.Errorf("collection %d: got %d pods, want 3", i, len(stats))
+		}
+		for _, ps := range stats {
+			collected[ps.PodRef.Name]++
+		}
+	}
+	// the 2 pods left out by the first collection are collected by the second
+	if len(collected) != 5 {
+		t.Errorf("got pods %v collected, want all the 5", collected)
+	}
+
+	// the single pod listing keeps the counts of the host-wide one
+	if _, err := p.GetPodStats("uid1"); err != nil {
+		t.Fatalf("GetPodStats: %v", err)
+	}
+	stats := p.GetProviderStats()
+	if stats.ObservedContainers != 5 || stats.SkippedContainers != 2 {
+		t.Errorf("got observed %d skipped %d, want 5 and 2", stats.ObservedContainers, stats.SkippedContainers)
+	}
+}
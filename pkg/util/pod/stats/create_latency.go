@@ -0,0 +1,151 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultCreateLatencyBuckets are the upper bounds of the buckets of the
+// container create latency histogram for CRIStatsProviderConfig.
+var DefaultCreateLatencyBuckets = []time.Duration{
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+}
+
+// LatencyHistogram is a cumulative histogram of latencies, the same layout
+// as a prometheus histogram.
+type LatencyHistogram struct {
+	// Buckets are ordered by the upper bound, the count of a bucket
+	// includes the latencies of the lower buckets. The +Inf bucket is Count.
+	Buckets []LatencyBucket `json:"buckets"`
+	// Count is the number of the latencies observed.
+	Count uint64 `json:"count"`
+	// SumSeconds is the sum of the latencies observed.
+	SumSeconds float64 `json:"sum_seconds"`
+}
+
+type LatencyBucket struct {
+	// UpperBoundSeconds is the inclusive upper bound of the bucket.
+	UpperBoundSeconds float64 `json:"le"`
+	Count             uint64  `json:"count"`
+}
+
+// createLatencyCollector measures the latency from the creation of a
+// container in CRI to its first cadvisor stats collected by the provider,
+// which surfaces a slow runtime, e.g. a slow image unpack or cgroup setup.
+// Only the containers whose creation events are received are measured, so
+// the containers existing before the provider started aren't. The latency
+// is observed at the collection the stats are first seen, so it's up to a
+// collection period longer than the actual one.
+type createLatencyCollector struct {
+	lock sync.Mutex
+	// bounds are the sorted upper bounds of the buckets.
+	bounds []time.Duration
+	// counts are the counts of the buckets, not cumulative.
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+	// pending are the created containers not seen with stats yet, keyed by
+	// the container id with the time of the creation event.
+	pending map[string]time.Time
+}
+
+func newCreateLatencyCollector(bounds []time.Duration) *createLatencyCollector {
+	sorted := append([]time.Duration{}, bounds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &createLatencyCollector{
+		bounds:  sorted,
+		counts:  make([]uint64, len(sorted)),
+		pending: make(map[string]time.Time),
+	}
+}
+
+func (c *createLatencyCollector) onCreated(containerID string, at time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.pending[containerID] = at
+}
+
+func (c *createLatencyCollector) onDeleted(containerID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.pending, containerID)
+}
+
+// observeStats observes the latency of a pending container since createdAt,
+// the CRI creation time of the container, once its stats are seen at now.
+func (c *createLatencyCollector) observeStats(containerID string, createdAt, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.pending[containerID]; !ok {
+		return
+	}
+	delete(c.pending, containerID)
+	if createdAt.IsZero() || now.Before(createdAt) {
+		return
+	}
+	latency := now.Sub(createdAt)
+	c.count++
+	c.sum += latency
+	for i, bound := range c.bounds {
+		if latency <= bound {
+			c.counts[i]++
+			break
+		}
+	}
+}
+
+// expire drops the pending containers whose creation events are older than
+// period, the events of the cgroups not of the CRI containers are never
+// matched by the stats.
+func (c *createLatencyCollector) expire(now time.Time, period time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for id, at := range c.pending {
+		if now.Sub(at) > period {
+			delete(c.pending, id)
+		}
+	}
+}
+
+func (c *createLatencyCollector) histogram() *LatencyHistogram {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	h := &LatencyHistogram{
+		Buckets:    make([]LatencyBucket, len(c.bounds)),
+		Count:      c.count,
+		SumSeconds: c.sum.Seconds(),
+	}
+	cumulative := uint64(0)
+	for i, bound := range c.bounds {
+		cumulative += c.counts[i]
+		h.Buckets[i] = LatencyBucket{UpperBoundSeconds: bound.Seconds(), Count: cumulative}
+	}
+	return h
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCreateLatencyCollector(t *testing.T) {
+	c := newCreateLatencyCollector([]time.Duration{10 * time.Second, time.Second})
+	created := time.Unix(1000, 0)
+
+	c.onCreated("fast", created)
+	c.onCreated("slow", created)
+	c.onCreated("slower", created)
+	c.onCreated("deleted", created)
+	c.onDeleted("deleted")
+
+	c.observeStats("fast", created, created.Add(500*time.Millisecond))
+	// observed once only
+	c.observeStats("fast", created, created.Add(time.Minute))
+	c.observeStats("slow", created, created.Add(5*time.Second))
+	c.observeStats("slower", created, created.Add(20*time.Second))
+	// not created since the provider started
+	c.observeStats("existing", created, created.Add(time.Second))
+	c.observeStats("deleted", created, created.Add(time.Second))
+
+	want := &LatencyHistogram{
+		Buckets: []LatencyBucket{
+			{UpperBoundSeconds: 1, Count: 1},
+			{UpperBoundSeconds: 10, Count: 2},
+		},
+		Count:      3,
+		SumSeconds: 25.5,
+	}
+	if got := c.histogram(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %#v, got %#v", want, got)
+	}
+
+	c.onCreated("old", created)
+	c.onCreated("new", created.Add(time.Minute))
+	c.expire(created.Add(90*time.Second), time.Minute)
+	if _, ok := c.pending["old"]; ok {
+		t.Errorf("want old expired")
+	}
+	if _, ok := c.pending["new"]; !ok {
+		t.Errorf("want new pending")
+	}
+}
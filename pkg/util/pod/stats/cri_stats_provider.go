@@ -18,18 +18,20 @@ import (
 	"context"
 	"fmt"
 	"path"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/go-logr/logr"
 	cadvisorfs "github.com/google/cadvisor/fs"
 	cadvisorapiv1 "github.com/google/cadvisor/info/v1"
 	cadvisorapiv2 "github.com/google/cadvisor/info/v2"
+	"golang.org/x/sync/errgroup"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
-	"k8s.io/klog/v2"
 
 	"yunion.io/x/pkg/errors"
 
@@ -39,11 +41,194 @@ import (
 var (
 	// defaultCachePeriod is the default cache period for each cpuUsage.
 	defaultCachePeriod = 10 * time.Minute
+	// machineInfoRetryPeriod is the period a failure of getting the machine
+	// info is cached for, so cadvisor isn't asked on every cpu sample while
+	// it's initializing.
+	machineInfoRetryPeriod = 30 * time.Second
+	// maxUsageNanoCoresSlack is the tolerance applied to the theoretical
+	// maximum of cpu usage (num cpus * 1e9 nano cores) before a computed
+	// usage is considered caused by clock skew and discarded.
+	maxUsageNanoCoresSlack = 1.5
+	// defaultMinCPUUsageSampleInterval is the default minimum interval
+	// between two cpu samples a usageNanoCores is computed from.
+	defaultMinCPUUsageSampleInterval = 2 * time.Second
+	// defaultStatsSnapshotMaxBytes bounds the total size of the retained
+	// stats snapshots.
+	defaultStatsSnapshotMaxBytes = 16 << 20
 )
 
+// ErrImageServiceUnavailable is returned by the image filesystem stats when
+// the provider is created without an image service.
+const ErrImageServiceUnavailable = errors.Error("image service unavailable")
+
+// CRIStatsProviderConfig holds the tunables of the CRI stats provider.
+type CRIStatsProviderConfig struct {
+	// ListContainerStatsPerPodThreshold is the container count above which
+	// container stats are requested per pod sandbox instead of a single
+	// ListContainerStats call, which may exceed the gRPC max message size on
+	// a very dense host. The CRI filter takes a single pod sandbox or
+	// container id, so there's no request of N containers. A runtime not
+	// honouring the pod sandbox filter is still asked in a single call, and
+	// it is logged once. Zero disables it.
+	ListContainerStatsPerPodThreshold int
+	// StablePodIdentity keys PodReference.UID on namespace/name instead of
+	// the sandbox UID, so a pod keeps the same identity when its sandbox is
+	// recreated and monitoring history is continuous. The current sandbox
+	// UID is still exposed as PodReference.SandboxUID. The tradeoff is that
+	// a pod deleted and recreated with the same name and namespace is also
+	// seen as the same pod, which is the same assumption removeTerminatedPods
+	// makes when picking one sandbox per namespace/name.
+	StablePodIdentity bool
+	// ProcessStatsRateWindow enables the fd and process count rates of pods
+	// when it's positive. The rates are computed against the previous sample
+	// which is at least this window old. Zero disables the rates.
+	ProcessStatsRateWindow time.Duration
+	// PodCPUPinning tells whether the cpus of a pod are exclusively pinned,
+	// known is false when the pod is unknown to the cpu map. See PodQOSClass.
+	PodCPUPinning func(podUID string) (exclusive bool, known bool)
+	// SandboxedRuntimeHandlers are the runtime handlers whose pods run
+	// inside a vm or a user space kernel, e.g. kata and gvisor, nil means
+	// defaultSandboxedRuntimeHandlers. cadvisor can't read the cgroups of
+	// such pods, so their stats come from CRI and SandboxMetrics, and the
+	// stats neither reports are nil. A pod sandbox may also be marked by
+	// SandboxedRuntimeAnnotation.
+	SandboxedRuntimeHandlers []string
+	// SandboxMetrics is the metrics api of the sandboxed runtimes, nil means
+	// the stats of the sandboxed pods are summed from CRI only.
+	SandboxMetrics SandboxMetricsSource
+	// PodBandwidthLimit returns the bandwidth limit configured on a pod in
+	// bits per second, 0 means unlimited, ok is false if the pod isn't
+	// limited. The limit is reported in PodStats.Network.
+	PodBandwidthLimit func(podUID string) (egressBps uint64, ingressBps uint64, ok bool)
+	// MinCPUUsageSampleInterval is the minimum interval between the cached
+	// and the new cpu sample for usageNanoCores to be recomputed, a sample
+	// closer than it returns the cached usage, since a tiny interval yields
+	// a noisy usage. Zero means defaultMinCPUUsageSampleInterval and a
+	// negative value disables the guard.
+	MinCPUUsageSampleInterval time.Duration
+	// IncludeInfraContainer lists the pause container of a pod, if the
+	// runtime reports it, in PodStats.Containers. The pause container is
+	// always summed into the pod totals, by default it's left out of the
+	// container list so only the user containers are shown.
+	IncludeInfraContainer bool
+	// StatsSnapshotCount is the number of the latest ListPodStats results
+	// retained in memory for debugging, zero disables the retention.
+	StatsSnapshotCount int
+	// StatsSnapshotMaxBytes bounds the total json size of the retained
+	// snapshots, zero means defaultStatsSnapshotMaxBytes.
+	StatsSnapshotMaxBytes int
+	// StatsSnapshotFile is overwritten with the latest snapshot if set.
+	StatsSnapshotFile string
+	// CadvisorRequestOptions are the options of the cadvisor container info
+	// request, nil means defaultCadvisorRequestOptions. Count should be at
+	// least 2 for the instantaneous cpu usage. The samples are collected by
+	// the cadvisor housekeeping every 10s, up to 15s for an idle container,
+	// so they can be that old. A MaxAge shorter than that triggers an on
+	// demand housekeeping of the containers with older samples, which gives
+	// fresher stats at the cost of reading the cgroups on every listing.
+	CadvisorRequestOptions *cadvisorapiv2.RequestOptions
+	// CRIListRetries is the number of retries of the top level CRI list
+	// requests failed with Unavailable or DeadlineExceeded, zero means
+	// defaultCRIListRetries and a negative value disables the retries.
+	CRIListRetries int
+	// PodLogsDirectory returns the log directory of the pod sandbox, which
+	// is the LogDirectory of its PodSandboxConfig. The usage of the logs is
+	// counted in the ephemeral storage of the pod if it's set.
+	PodLogsDirectory func(podUID string) string
+	// ListPodStatsCacheTTL shares a ListPodStats result among the callers
+	// within the ttl, and a single collection among the concurrent callers,
+	// for the read heavy callers like a UI polling the stats. Zero disables
+	// the cache so every call collects fresh stats.
+	ListPodStatsCacheTTL time.Duration
+	// HostId returns the id of the host added to the log lines of the
+	// provider, so the logs of a fleet can be correlated by the host. An
+	// empty id, e.g. before the host is registered, is left out.
+	HostId func() string
+	// StaleStatsThreshold is the time the cpu and memory timestamps of a
+	// container may stay unchanged across the collections before its stats
+	// are flagged as ContainerStats.Stale. Zero means
+	// defaultStaleStatsThreshold and a negative value disables the check.
+	StaleStatsThreshold time.Duration
+	// CachePeriod is how long the cached cpu usage, process stats and
+	// staleness records of a container or pod are kept after their last
+	// sample, zero means defaultCachePeriod.
+	CachePeriod time.Duration
+	// CacheCleanupInterval is the minimal interval between two scans
+	// purging the records out of CachePeriod, so a collection on a host
+	// with many containers doesn't scan all the caches. A record may be
+	// kept up to CachePeriod + CacheCleanupInterval, but never purged
+	// before CachePeriod. Zero means CachePeriod and a negative value scans
+	// on every collection.
+	CacheCleanupInterval time.Duration
+	// MaxCPUUsageCacheEntries bounds the number of the cached cpu usage
+	// records, the records with the oldest sample are evicted first when
+	// it's exceeded. Zero means unbounded, the records are only purged by
+	// CachePeriod.
+	MaxCPUUsageCacheEntries int
+	// CreateLatencyBuckets enables the histogram of the latency from the
+	// creation of a container to its first cadvisor stats, reported in
+	// ProviderStats.ContainerCreateLatency, with the upper bounds of the
+	// buckets. Only the containers created while the cadvisor events are
+	// watched are measured. Nil disables the histogram.
+	CreateLatencyBuckets []time.Duration
+	// MaxContainersPerCollection bounds the number of the containers whose
+	// stats are collected by a host-wide listing, so the memory of the
+	// provider stays bounded on an extremely dense host. When there are more
+	// running containers, the containers of whole pods are collected, the
+	// rest of the pods are left out of the result and collected first by the
+	// next listing, and a warning is logged. A pod with more containers than
+	// the max is collected alone on its turn. It's better combined with
+	// ListContainerStatsPerPodThreshold so only the stats of the collected
+	// containers are requested. Zero means unlimited.
+	MaxContainersPerCollection int
+	// Logger receives the log lines of the provider, the zero value means
+	// klog. The levels of the lines are the klog verbosities.
+	Logger logr.Logger
+}
+
+func (c CRIStatsProviderConfig) withDefaults() CRIStatsProviderConfig {
+	if c.MinCPUUsageSampleInterval == 0 {
+		c.MinCPUUsageSampleInterval = defaultMinCPUUsageSampleInterval
+	}
+	if c.StatsSnapshotMaxBytes == 0 {
+		c.StatsSnapshotMaxBytes = defaultStatsSnapshotMaxBytes
+	}
+	if c.CRIListRetries == 0 {
+		c.CRIListRetries = defaultCRIListRetries
+	}
+	if c.CadvisorRequestOptions == nil {
+		opts := defaultCadvisorRequestOptions()
+		c.CadvisorRequestOptions = &opts
+	}
+	if c.CachePeriod == 0 {
+		c.CachePeriod = defaultCachePeriod
+	}
+	if c.CacheCleanupInterval == 0 {
+		c.CacheCleanupInterval = c.CachePeriod
+	}
+	if c.StaleStatsThreshold == 0 {
+		c.StaleStatsThreshold = defaultStaleStatsThreshold
+	}
+	if c.Logger.GetSink() == nil {
+		c.Logger = newKlogLogger()
+	}
+	return c
+}
+
 type cpuUsageRecord struct {
 	stats          *runtimeapi.CpuUsage
 	usageNanoCores *uint64
+	// resetReported is set once a drop of the cpu usage below the sample
+	// has been reported.
+	resetReported bool
+}
+
+type processStatsRecord struct {
+	time             time.Time
+	processCount     uint64
+	fdCount          uint64
+	processCountRate *float64
+	fdCountRate      *float64
 }
 
 // criStatsProvider implements the ContainerStatsProvider interface by getting
@@ -59,17 +244,79 @@ type criStatsProvider struct {
 	// imageService is used to get the stats of the image filesystem.
 	imageService runtimeapi.ImageServiceClient
 
+	config CRIStatsProviderConfig
+	// logger is the Logger of the config.
+	logger logr.Logger
+	// collections is the atomic counter of the stats collections, which
+	// gives the collection ids in the log lines.
+	collections uint64
+	// machineInfo is fetched from cadvisor lazily and refreshed after
+	// defaultCachePeriod, the cpu count of it bounds the plausible cpu usage
+	// of a container. A failure is cached for machineInfoRetryPeriod.
+	machineInfo          *cadvisorapiv1.MachineInfo
+	machineInfoUpdatedAt time.Time
+	machineInfoErr       error
+	machineInfoErrAt     time.Time
+	machineInfoLock      sync.Mutex
+	// perPodUnsupportedOnce logs once that the container stats can't be
+	// requested per pod sandbox.
+	perPodUnsupportedOnce sync.Once
+
 	// cpuUsageCache caches the cpu usage for containers.
 	cpuUsageCache map[string]*cpuUsageRecord
-	mutex         sync.RWMutex
+	// processStatsCache caches the previous process stats sample of pods.
+	processStatsCache map[string]*processStatsRecord
+	// statsStalenessCache tracks the stats timestamps of containers.
+	statsStalenessCache map[string]*statsStalenessRecord
+	// cachesCleanedAt is when the caches are last scanned for the outdated
+	// records.
+	cachesCleanedAt time.Time
+	mutex           sync.RWMutex
+
+	// eventWatcher invalidates the caches on container creation and deletion.
+	eventWatcher *containerEventWatcher
+	// createLatency is nil unless CreateLatencyBuckets is set.
+	createLatency *createLatencyCollector
+
+	// snapshots retains the latest ListPodStats results, nil if disabled.
+	snapshots *statsSnapshotRing
+	// listCache caches the ListPodStats result, nil if disabled.
+	listCache *podStatsCache
+
+	// criListRetries and criListRetryFailures are counted atomically.
+	criListRetries       uint64
+	criListRetryFailures uint64
+	// observedContainers and skippedContainers are the running containers
+	// of the latest collection and the ones left out by the cap, they're
+	// stored atomically.
+	observedContainers uint64
+	skippedContainers  uint64
+	// collectionCursor is the sandbox id of the first pod left out by the
+	// cap of the latest collection.
+	collectionCursor     string
+	collectionCursorLock sync.Mutex
+
+	// capabilities are detected from the runtime version on first use.
+	capabilities     RuntimeCapabilities
+	capabilitiesOnce sync.Once
 }
 
 func NewCRIContainerStatsProvider(
 	cadvisor cadvisor.Interface,
 	runtimeService runtimeapi.RuntimeServiceClient,
 	imageService runtimeapi.ImageServiceClient,
+	config CRIStatsProviderConfig,
 ) ContainerStatsProvider {
-	return newCRIStatsProvider(cadvisor, runtimeService, imageService)
+	p := newCRIStatsProvider(cadvisor, runtimeService, imageService, config)
+	watcher, err := startContainerEventWatcher(cadvisor, p.onContainerEvent)
+	if err != nil {
+		// Stats are still correct without the watcher, the caches are just
+		// cleaned up later by cleanupOutdatedCaches.
+		p.hostLogger().Info("Failed to watch cadvisor container events", "err", err)
+	} else {
+		p.eventWatcher = watcher
+	}
+	return p
 }
 
 // newCRIStatsProvider returns a ContainerStatsProvider implementation that
@@ -78,16 +325,51 @@ func newCRIStatsProvider(
 	cadvisor cadvisor.Interface,
 	runtimeService runtimeapi.RuntimeServiceClient,
 	imageService runtimeapi.ImageServiceClient,
-) ContainerStatsProvider {
-	return &criStatsProvider{
+	config CRIStatsProviderConfig,
+) *criStatsProvider {
+	p := &criStatsProvider{
 		cadvisor:       cadvisor,
 		runtimeService: runtimeService,
 		imageService:   imageService,
+		config:         config.withDefaults(),
 		cpuUsageCache:  make(map[string]*cpuUsageRecord),
+
+		processStatsCache:   make(map[string]*processStatsRecord),
+		statsStalenessCache: make(map[string]*statsStalenessRecord),
+	}
+	p.logger = p.config.Logger
+	if imageService == nil {
+		// The cpu and memory stats don't need the image service, only the
+		// image filesystem stats are left out.
+		p.hostLogger().Info("CRI stats provider created without image service, image filesystem stats are disabled")
+	}
+	if p.config.StatsSnapshotCount > 0 {
+		p.snapshots = newStatsSnapshotRing(p.config.StatsSnapshotCount, p.config.StatsSnapshotMaxBytes, p.config.StatsSnapshotFile)
 	}
+	if p.config.ListPodStatsCacheTTL > 0 {
+		p.listCache = newPodStatsCache(p.config.ListPodStatsCacheTTL)
+	}
+	if p.config.CreateLatencyBuckets != nil {
+		p.createLatency = newCreateLatencyCollector(p.config.CreateLatencyBuckets)
+	}
+	return p
+}
+
+// RuntimeCapabilities returns the stats capabilities of the runtime, the
+// runtime version is only queried once.
+func (p *criStatsProvider) RuntimeCapabilities() RuntimeCapabilities {
+	p.capabilitiesOnce.Do(func() {
+		p.capabilities = detectRuntimeCapabilities(p.runtimeService)
+	})
+	return p.capabilities
 }
 
 func (p *criStatsProvider) ListPodStats() ([]PodStats, error) {
+	if p.listCache != nil {
+		return p.listCache.list(func() ([]PodStats, error) {
+			return p.listPodStats(false)
+		})
+	}
 	// Don't update CPU nano core usage.
 	return p.listPodStats(false)
 }
@@ -107,64 +389,102 @@ func (p *criStatsProvider) ListPodStatsAndUpdateCPUNanoCoreUsage() ([]PodStats,
 	return p.listPodStats(true)
 }
 
-func (p *criStatsProvider) listPodStats(updateCPUNanoCoreUsage bool) ([]PodStats, error) {
-	// Gets node root filesystem information, which will be used to populate
-	// the available and capacity bytes/inodes in container stats.
-	rootFsInfo, err := p.cadvisor.RootFsInfo()
+// GetPodStats returns the stats of the pod whose sandbox has the uid. The
+// sandbox is resolved first, so only the containers of that sandbox are
+// listed instead of every container on the host.
+func (p *criStatsProvider) GetPodStats(podUID string) (*PodStats, error) {
+	resp, err := p.listPodSandbox(context.Background(), &runtimeapi.ListPodSandboxRequest{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get rootFs info: %v", err)
+		return nil, errors.Wrap(err, "failed to list all pod sandboxes")
 	}
+	for _, s := range removeTerminatedPods(resp.Items) {
+		if s.GetMetadata().GetUid() == podUID {
+			return p.getPodStatsBySandbox(s.Id, false)
+		}
+	}
+	return nil, errors.Wrapf(errors.ErrNotFound, "pod %s", podUID)
+}
 
-	csResp, err := p.runtimeService.ListContainers(context.Background(), &runtimeapi.ListContainersRequest{})
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to list all containers")
+// getPodStatsBySandbox returns the stats of a single pod sandbox, the
+// containers and their stats are listed with the sandbox id filter.
+func (p *criStatsProvider) getPodStatsBySandbox(sandboxID string, updateCPUNanoCoreUsage bool) (*PodStats, error) {
+	result, err := p.listPodStatsWithFilter(sandboxID, updateCPUNanoCoreUsage)
+	if len(result) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.Wrapf(errors.ErrNotFound, "pod sandbox %s", sandboxID)
 	}
-	containers := csResp.Containers
+	return &result[0], err
+}
 
-	// Creates pod sandbox map.
-	podSandboxMap := make(map[string]*runtimeapi.PodSandbox)
-	resp, err := p.runtimeService.ListPodSandbox(context.Background(), &runtimeapi.ListPodSandboxRequest{})
+// listPodStats returns the stats of all the pods, see listPodStatsWithFilter
+// for the partial result returned along with an error.
+func (p *criStatsProvider) listPodStats(updateCPUNanoCoreUsage bool) ([]PodStats, error) {
+	result, err := p.listPodStatsWithFilter("", updateCPUNanoCoreUsage)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to list all pod sandboxes")
+		return result, err
 	}
-	podSandboxes := removeTerminatedPods(resp.Items)
-	for _, s := range podSandboxes {
-		podSandboxMap[s.Id] = s
+	if p.snapshots != nil {
+		p.snapshots.add(time.Now(), result)
 	}
-	// fsIDtoInfo is a map from filesystem id to its stats. This will be used
-	// as a cache to avoid querying cAdvisor for the filesystem stats with the
-	// same filesystem id many times.
-	fsIDtoInfo := make(map[runtimeapi.FilesystemIdentifier]*cadvisorapiv2.FsInfo)
+	return result, nil
+}
 
-	// sandboxIDToPodStats is a temporary map from sandbox ID to its pod stats.
-	sandboxIDToPodStats := make(map[string]*PodStats)
+// ListStatsSnapshots returns the retained ListPodStats results from the
+// oldest to the latest, empty if the retention is disabled.
+func (p *criStatsProvider) ListStatsSnapshots() []StatsSnapshot {
+	if p.snapshots == nil {
+		return []StatsSnapshot{}
+	}
+	return p.snapshots.list()
+}
 
-	cstsResp, err := p.runtimeService.ListContainerStats(context.Background(), &runtimeapi.ListContainerStatsRequest{})
+// listPodStatsWithFilter returns the pod stats of the sandbox, or of all
+// the sandboxes when sandboxID is empty. The containers and the sandboxes
+// must be listed, while the failures of the other sources only leave their
+// stats out, so the stats built from the rest are returned along with the
+// aggregated error of the failures. A caller wanting the complete stats
+// should check the error, a best-effort caller may use the partial result.
+func (p *criStatsProvider) listPodStatsWithFilter(sandboxID string, updateCPUNanoCoreUsage bool) ([]PodStats, error) {
+	start := time.Now()
+	ctx, logger := p.newCollectionLogger(context.Background())
+	src, errs, err := p.fetchPodStatsSources(ctx, sandboxID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list all container stats: %v", err)
+		return nil, err
 	}
+	rootFsInfo := src.rootFsInfo
+	containerStats := src.containerStats
+	allInfos := src.allInfos
+	caInfos := getCRICadvisorStats(allInfos)
+	containerNetworkStats := src.containerNetworkStats
 
-	containers = removeTerminatedContainers(containers)
+	// Creates pod sandbox map.
+	podSandboxMap := make(map[string]*runtimeapi.PodSandbox)
+	for _, s := range src.podSandboxes {
+		podSandboxMap[s.Id] = s
+	}
 	// Creates container map.
 	containerMap := make(map[string]*runtimeapi.Container)
-	for _, c := range containers {
+	for _, c := range src.containers {
 		containerMap[c.Id] = c
 	}
+	// fsIDtoInfo is a map from filesystem id to its stats. This will be used
+	// as a cache to avoid querying cAdvisor for the filesystem stats with the
+	// same filesystem id many times.
+	fsIDtoInfo := make(map[runtimeapi.FilesystemIdentifier]*cadvisorapiv2.FsInfo)
+	imageLayers := p.newContainerImageLayers(ctx, fsIDtoInfo)
 
-	allInfos, err := getCadvisorContainerInfo(p.cadvisor)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch cadvisor stats: %v", err)
-	}
-	caInfos := getCRICadvisorStats(allInfos)
-
-	// get network stats for containers.
-	// This is only used on Windows. For other platforms, (nil, nil) should be returned.
-	containerNetworkStats, err := p.listContainerNetworkStats()
-	if err != nil {
-		return nil, fmt.Errorf("failed to list container network stats: %v", err)
-	}
-
-	for _, stats := range cstsResp.Stats {
+	// sandboxIDToPodStats is a temporary map from sandbox ID to its pod stats.
+	sandboxIDToPodStats := make(map[string]*PodStats)
+	// sandboxIDToQOS accumulates the qos inputs of the containers of each pod.
+	sandboxIDToQOS := make(map[string]*podQOSState)
+	// sandboxIDToLimits accumulates the limits of the containers of each pod.
+	sandboxIDToLimits := make(map[string]*podLimitState)
+	// sandboxedIDs are the sandboxed pods, see isSandboxedPod.
+	sandboxedIDs := make(map[string]*runtimeapi.PodSandbox)
+
+	for _, stats := range containerStats {
 		containerID := stats.Attributes.Id
 		container, found := containerMap[containerID]
 		if !found {
@@ -181,41 +501,97 @@ func (p *criStatsProvider) listPodStats(updateCPUNanoCoreUsage bool) ([]PodStats
 		// container belongs to.
 		ps, found := sandboxIDToPodStats[podSandboxID]
 		if !found {
-			ps = buildPodStats(podSandbox)
+			ps = p.buildPodStats(podSandbox)
 			sandboxIDToPodStats[podSandboxID] = ps
 		}
 
+		// cadvisor is skipped for a sandboxed pod, see isSandboxedPod
+		podInfos, podCaInfos := allInfos, caInfos
+		sandboxed := p.isSandboxedPod(podSandbox)
+		if sandboxed {
+			sandboxedIDs[podSandboxID] = podSandbox
+			podInfos, podCaInfos = nil, nil
+		}
+
 		// Fill available stats for full set of required pod stats
-		cs := p.makeContainerStats(stats, container, &rootFsInfo, fsIDtoInfo, podSandbox.GetMetadata(), updateCPUNanoCoreUsage, allInfos)
-		p.addPodNetworkStats(ps, podSandboxID, caInfos, cs, containerNetworkStats[podSandboxID])
-		p.addPodCPUMemoryStats(ps, types.UID(podSandbox.Metadata.Uid), allInfos, cs)
-		p.addDiskIoStats(ps, types.UID(podSandboxID), allInfos, cs)
-		p.addProcessStats(ps, types.UID(podSandboxID), allInfos, cs)
+		cs := p.makeContainerStats(stats, container, &rootFsInfo, fsIDtoInfo, imageLayers, podSandbox.GetMetadata(), updateCPUNanoCoreUsage, podInfos)
+		if sandboxed {
+			clearUnreportedSandboxedStats(cs, stats)
+		}
+		p.updateStatsStaleness(logger, stats, cs, start)
+		p.addPodNetworkStats(ps, podSandboxID, containerID, podCaInfos, cs, containerNetworkStats[podSandboxID])
+		p.addPodCPUMemoryStats(ps, types.UID(podSandbox.Metadata.Uid), podInfos, cs)
+		if !sandboxed {
+			p.addDiskIoStats(ps, types.UID(podSandboxID), podInfos, cs)
+		}
+		p.addProcessStats(ps, types.UID(podSandboxID), podInfos, cs)
+		if !p.config.IncludeInfraContainer && isInfraContainer(container) {
+			continue
+		}
 
 		// If cadvisor stats is available for the container, use it to populate
 		// container stats
-		caStats, caFound := caInfos[containerID]
+		caStats, caFound := podCaInfos[containerID]
+		qos, found := sandboxIDToQOS[podSandboxID]
+		if !found {
+			qos = &podQOSState{}
+			sandboxIDToQOS[podSandboxID] = qos
+		}
+		if caFound {
+			p.addContainerQOS(qos, podSandbox.Metadata.Uid, &caStats)
+		} else {
+			p.addContainerQOS(qos, podSandbox.Metadata.Uid, nil)
+		}
+		limits, found := sandboxIDToLimits[podSandboxID]
+		if !found {
+			limits = &podLimitState{}
+			sandboxIDToLimits[podSandboxID] = limits
+		}
+		if caFound {
+			p.addContainerLimits(limits, &caStats)
+		} else {
+			p.addContainerLimits(limits, nil)
+		}
 		if !caFound {
-			klog.V(5).Infof("Unable to find cadvisor stats for %q", containerID)
+			logger.V(5).Info("Unable to find cadvisor stats", "containerId", containerID)
 		} else {
 			p.addCadvisorContainerStats(cs, &caStats)
+			if p.createLatency != nil {
+				p.createLatency.observeStats(containerID, time.Unix(0, container.CreatedAt), start)
+			}
 		}
 		ps.Containers = append(ps.Containers, *cs)
 	}
+	for podSandboxID, podSandbox := range sandboxedIDs {
+		if err := p.addSandboxMetrics(ctx, sandboxIDToPodStats[podSandboxID], podSandbox); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	now := time.Now()
+	for _, ps := range sandboxIDToPodStats {
+		p.addProcessStatsRates(ps, now)
+	}
 	// cleanup outdated caches.
-	p.cleanupOutdatedCaches()
+	p.cleanupOutdatedCachesIfDue()
 
 	result := make([]PodStats, 0, len(sandboxIDToPodStats))
-	for _, s := range sandboxIDToPodStats {
-		//p.makePodStorageStats(s, &rootFsInfo)
+	for sandboxID, s := range sandboxIDToPodStats {
+		s.QOSClass = sandboxIDToQOS[sandboxID].class()
+		sandboxIDToLimits[sandboxID].apply(s)
+		p.addPodBandwidthLimit(s, podSandboxMap[sandboxID].GetMetadata().GetUid())
+		if src.rootFsFound {
+			p.makePodStorageStats(logger, s, &rootFsInfo)
+		}
 		result = append(result, *s)
 	}
-	return result, nil
+	logger.V(5).Info("Collected pod stats", "sandboxId", sandboxID, "podCount", len(result), "containerCount", len(containerStats), "errorCount", len(errs), "duration", time.Since(start))
+	return result, errors.NewAggregate(errs)
 }
 
 func (p *criStatsProvider) ListPodCPUAndMemoryStats() ([]PodStats, error) {
-	ctx := context.Background()
-	containersResp, err := p.runtimeService.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+	start := time.Now()
+	ctx, logger := p.newCollectionLogger(context.Background())
+	containersResp, err := p.listContainers(ctx, &runtimeapi.ListContainersRequest{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list all containers: %v", err)
 	}
@@ -223,7 +599,7 @@ func (p *criStatsProvider) ListPodCPUAndMemoryStats() ([]PodStats, error) {
 
 	// Creates pod sandbox map.
 	podSandboxMap := make(map[string]*runtimeapi.PodSandbox)
-	resp, err := p.runtimeService.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
+	resp, err := p.listPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list all pod sandboxes: %v", err)
 	}
@@ -235,26 +611,30 @@ func (p *criStatsProvider) ListPodCPUAndMemoryStats() ([]PodStats, error) {
 
 	// sandboxIDToPodStats is a temporary map from sandbox ID to its pod stats.
 	sandboxIDToPodStats := make(map[string]*PodStats)
+	// sandboxIDToQOS accumulates the qos inputs of the containers of each pod.
+	sandboxIDToQOS := make(map[string]*podQOSState)
+	// sandboxIDToLimits accumulates the limits of the containers of each pod.
+	sandboxIDToLimits := make(map[string]*podLimitState)
 
-	containerStatResp, err := p.runtimeService.ListContainerStats(ctx, &runtimeapi.ListContainerStatsRequest{})
+	containers = p.capCollectionContainers(ctx, "", removeTerminatedContainers(containers))
+	containerStats, err := p.listContainerStats(ctx, "", containers)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list all container stats: %v", err)
+		return nil, err
 	}
 
-	containers = removeTerminatedContainers(containers)
 	// Creates container map.
 	containerMap := make(map[string]*runtimeapi.Container)
 	for _, c := range containers {
 		containerMap[c.Id] = c
 	}
 
-	allInfos, err := getCadvisorContainerInfo(p.cadvisor)
+	allInfos, err := getCadvisorContainerInfo(p.cadvisor, *p.config.CadvisorRequestOptions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch cadvisor stats: %v", err)
 	}
 	caInfos := getCRICadvisorStats(allInfos)
 
-	for _, stats := range containerStatResp.Stats {
+	for _, stats := range containerStats {
 		containerID := stats.Attributes.Id
 		container, found := containerMap[containerID]
 		if !found {
@@ -271,85 +651,302 @@ func (p *criStatsProvider) ListPodCPUAndMemoryStats() ([]PodStats, error) {
 		// container belongs to.
 		ps, found := sandboxIDToPodStats[podSandboxID]
 		if !found {
-			ps = buildPodStats(podSandbox)
+			ps = p.buildPodStats(podSandbox)
 			sandboxIDToPodStats[podSandboxID] = ps
 		}
 
 		// Fill available CPU and memory stats for full set of required pod stats
 		cs := p.makeContainerCPUAndMemoryStats(stats, container, allInfos)
+		p.updateStatsStaleness(logger, stats, cs, start)
 		p.addPodCPUMemoryStats(ps, types.UID(podSandbox.Metadata.Uid), allInfos, cs)
 		p.addDiskIoStats(ps, types.UID(podSandboxID), allInfos, cs)
 		p.addProcessStats(ps, types.UID(podSandboxID), allInfos, cs)
+		if !p.config.IncludeInfraContainer && isInfraContainer(container) {
+			continue
+		}
 
 		// If cadvisor stats is available for the container, use it to populate
 		// container stats
 		caStats, caFound := caInfos[containerID]
+		qos, found := sandboxIDToQOS[podSandboxID]
+		if !found {
+			qos = &podQOSState{}
+			sandboxIDToQOS[podSandboxID] = qos
+		}
+		if caFound {
+			p.addContainerQOS(qos, podSandbox.Metadata.Uid, &caStats)
+		} else {
+			p.addContainerQOS(qos, podSandbox.Metadata.Uid, nil)
+		}
+		limits, found := sandboxIDToLimits[podSandboxID]
+		if !found {
+			limits = &podLimitState{}
+			sandboxIDToLimits[podSandboxID] = limits
+		}
+		if caFound {
+			p.addContainerLimits(limits, &caStats)
+		} else {
+			p.addContainerLimits(limits, nil)
+		}
 		if !caFound {
-			klog.V(4).Infof("Unable to find cadvisor stats for %q", containerID)
+			logger.V(4).Info("Unable to find cadvisor stats", "containerId", containerID)
 		} else {
 			p.addCadvisorContainerStats(cs, &caStats)
 		}
 		ps.Containers = append(ps.Containers, *cs)
 	}
 	// cleanup outdated caches.
-	p.cleanupOutdatedCaches()
+	p.cleanupOutdatedCachesIfDue()
+
+	result := make([]PodStats, 0, len(sandboxIDToPodStats))
+	for sandboxID, s := range sandboxIDToPodStats {
+		s.QOSClass = sandboxIDToQOS[sandboxID].class()
+		sandboxIDToLimits[sandboxID].apply(s)
+		result = append(result, *s)
+	}
+	logger.V(5).Info("Collected pod cpu and memory stats", "podCount", len(result), "containerCount", len(containerStats), "duration", time.Since(start))
+	return result, nil
+}
+
+// ListPodCPUStats returns the cpu stats of all the running pods. Memory, fs,
+// network and process stats are skipped and cadvisor is not queried, the pod
+// cpu usage is the sum of its containers' usage reported by CRI.
+func (p *criStatsProvider) ListPodCPUStats() ([]PodStats, error) {
+	start := time.Now()
+	ctx, logger := p.newCollectionLogger(context.Background())
+	containersResp, err := p.listContainers(ctx, &runtimeapi.ListContainersRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list all containers: %v", err)
+	}
+	containers := containersResp.Containers
+
+	// Creates pod sandbox map.
+	podSandboxMap := make(map[string]*runtimeapi.PodSandbox)
+	resp, err := p.listPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list all pod sandboxes: %v", err)
+	}
+	podSandboxes := removeTerminatedPods(resp.Items)
+	for _, s := range podSandboxes {
+		podSandboxMap[s.Id] = s
+	}
+
+	// sandboxIDToPodStats is a temporary map from sandbox ID to its pod stats.
+	sandboxIDToPodStats := make(map[string]*PodStats)
+
+	containers = p.capCollectionContainers(ctx, "", removeTerminatedContainers(containers))
+	containerStats, err := p.listContainerStats(ctx, "", containers)
+	if err != nil {
+		return nil, err
+	}
+
+	// Creates container map.
+	containerMap := make(map[string]*runtimeapi.Container)
+	for _, c := range containers {
+		containerMap[c.Id] = c
+	}
+
+	for _, stats := range containerStats {
+		containerID := stats.Attributes.Id
+		container, found := containerMap[containerID]
+		if !found {
+			continue
+		}
+
+		podSandboxID := container.PodSandboxId
+		podSandbox, found := podSandboxMap[podSandboxID]
+		if !found {
+			continue
+		}
+
+		ps, found := sandboxIDToPodStats[podSandboxID]
+		if !found {
+			ps = p.buildPodStats(podSandbox)
+			sandboxIDToPodStats[podSandboxID] = ps
+		}
+
+		cs := p.makeContainerCPUStats(stats, container)
+		p.addPodCPUStats(ps, cs)
+		if !p.config.IncludeInfraContainer && isInfraContainer(container) {
+			continue
+		}
+		ps.Containers = append(ps.Containers, *cs)
+	}
 
 	result := make([]PodStats, 0, len(sandboxIDToPodStats))
 	for _, s := range sandboxIDToPodStats {
 		result = append(result, *s)
 	}
+	logger.V(5).Info("Collected pod cpu stats", "podCount", len(result), "containerCount", len(containerStats), "duration", time.Since(start))
 	return result, nil
 }
 
+// makeContainerCPUStats only fills the cpu stats of a container from CRI.
+func (p *criStatsProvider) makeContainerCPUStats(
+	stats *runtimeapi.ContainerStats,
+	container *runtimeapi.Container,
+) *ContainerStats {
+	result := &ContainerStats{
+		Name: stats.Attributes.Metadata.Name,
+		// The StartTime in the summary API is the container creation time.
+		StartTime: metav1.NewTime(time.Unix(0, container.CreatedAt)),
+		CPU:       &CPUStats{},
+	}
+	if stats.Cpu != nil {
+		result.CPU.Time = metav1.NewTime(time.Unix(0, stats.Cpu.Timestamp))
+		if stats.Cpu.UsageCoreNanoSeconds != nil {
+			result.CPU.UsageCoreNanoSeconds = &stats.Cpu.UsageCoreNanoSeconds.Value
+		}
+		result.RestartedSinceLastSample = p.isCPUCounterReset(stats)
+		usageNanoCores := p.getContainerUsageNanoCores(stats)
+		if usageNanoCores != nil {
+			result.CPU.UsageNanoCores = usageNanoCores
+		}
+	} else {
+		result.CPU.Time = metav1.NewTime(time.Unix(0, time.Now().UnixNano()))
+		result.CPU.UsageCoreNanoSeconds = uint64Ptr(0)
+		result.CPU.UsageNanoCores = uint64Ptr(0)
+	}
+	return result
+}
+
+// addPodCPUStats sums the cpu stats of the container into the pod.
+func (p *criStatsProvider) addPodCPUStats(ps *PodStats, cs *ContainerStats) {
+	if cs.CPU == nil {
+		return
+	}
+	if ps.CPU == nil {
+		ps.CPU = &CPUStats{}
+	}
+	ps.CPU.Time = cs.CPU.Time
+	usageCoreNanoSeconds := getUint64Value(cs.CPU.UsageCoreNanoSeconds) + getUint64Value(ps.CPU.UsageCoreNanoSeconds)
+	usageNanoCores := getUint64Value(cs.CPU.UsageNanoCores) + getUint64Value(ps.CPU.UsageNanoCores)
+	ps.CPU.UsageCoreNanoSeconds = &usageCoreNanoSeconds
+	ps.CPU.UsageNanoCores = &usageNanoCores
+}
+
+// listContainerStatsConcurrency is the number of the ListContainerStats
+// requests in flight when the container stats are requested per pod sandbox.
+const listContainerStatsConcurrency = 4
+
+// listContainerStats returns the stats of the given containers. When the
+// number of containers exceeds the configured threshold and the runtime
+// honours the pod sandbox filter, the stats are requested per pod sandbox,
+// at most listContainerStatsConcurrency at a time, and merged, so a single
+// response never carries the stats of every container on the host. The
+// stats of a failed pod sandbox are left out, the ones of the others are
+// returned along with the aggregated error of the failures. A
+// non-empty sandboxID narrows the unbatched request to that pod sandbox if
+// the runtime supports the filter, the caller drops the stats of the other
+// containers otherwise.
+func (p *criStatsProvider) listContainerStats(ctx context.Context, sandboxID string, containers []*runtimeapi.Container) ([]*runtimeapi.ContainerStats, error) {
+	threshold := p.config.ListContainerStatsPerPodThreshold
+	sandboxFilter := p.RuntimeCapabilities().SandboxStatsFilter
+	perPod := threshold > 0 && len(containers) > threshold && sandboxID == ""
+	if perPod && !sandboxFilter {
+		p.perPodUnsupportedOnce.Do(func() {
+			p.hostLogger().Info("Runtime ignores the pod sandbox filter, list container stats in a single call",
+				"containerCount", len(containers), "perPodThreshold", threshold)
+		})
+	}
+	if !perPod || !sandboxFilter {
+		req := &runtimeapi.ListContainerStatsRequest{}
+		if sandboxID != "" && sandboxFilter {
+			req.Filter = &runtimeapi.ContainerStatsFilter{PodSandboxId: sandboxID}
+		}
+		resp, err := p.runtimeService.ListContainerStats(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list all container stats: %v", err)
+		}
+		return resp.Stats, nil
+	}
+
+	sandboxIDs := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, c := range containers {
+		if !seen[c.PodSandboxId] {
+			seen[c.PodSandboxId] = true
+			sandboxIDs = append(sandboxIDs, c.PodSandboxId)
+		}
+	}
+	sandboxStats := make([][]*runtimeapi.ContainerStats, len(sandboxIDs))
+	sandboxErrs := make([]error, len(sandboxIDs))
+	g := errgroup.Group{}
+	g.SetLimit(listContainerStatsConcurrency)
+	for i := range sandboxIDs {
+		i := i
+		g.Go(func() error {
+			resp, err := p.runtimeService.ListContainerStats(ctx, &runtimeapi.ListContainerStatsRequest{
+				Filter: &runtimeapi.ContainerStatsFilter{PodSandboxId: sandboxIDs[i]},
+			})
+			if err != nil {
+				sandboxErrs[i] = errors.Wrapf(err, "list container stats of pod sandbox %s", sandboxIDs[i])
+				return nil
+			}
+			sandboxStats[i] = resp.Stats
+			return nil
+		})
+	}
+	g.Wait()
+
+	result := make([]*runtimeapi.ContainerStats, 0, len(containers))
+	errs := make([]error, 0)
+	for i := range sandboxIDs {
+		if sandboxErrs[i] != nil {
+			errs = append(errs, sandboxErrs[i])
+			continue
+		}
+		result = append(result, sandboxStats[i]...)
+	}
+	p.loggerFromContext(ctx).V(5).Info("Listed stats of containers per pod sandbox", "sandboxCount", len(sandboxIDs), "containerCount", len(containers))
+	return result, errors.NewAggregate(errs)
+}
+
 func (p *criStatsProvider) ImageFsStats() (FsStats, error) {
+	if p.imageService == nil {
+		return FsStats{}, ErrImageServiceUnavailable
+	}
 	//TODO implement me
 	panic("implement me")
 }
 
 func (p *criStatsProvider) ImageFsDevice() (string, error) {
+	if p.imageService == nil {
+		return "", ErrImageServiceUnavailable
+	}
 	//TODO implement me
 	panic("implement me")
 }
 
 // buildPodStats returns a PodStats that identifies the Pod managing cinfo
-func buildPodStats(podSandbox *runtimeapi.PodSandbox) *PodStats {
+func (p *criStatsProvider) buildPodStats(podSandbox *runtimeapi.PodSandbox) *PodStats {
+	podRef := PodReference{
+		Name:       podSandbox.Metadata.Name,
+		UID:        podSandbox.Metadata.Uid,
+		Namespace:  podSandbox.Metadata.Namespace,
+		SandboxUID: podSandbox.Metadata.Uid,
+		Attempt:    podSandbox.Metadata.Attempt,
+	}
+	if p.config.StablePodIdentity {
+		podRef.UID = stablePodUID(podRef.Namespace, podRef.Name)
+	}
 	return &PodStats{
-		PodRef: PodReference{
-			Name:      podSandbox.Metadata.Name,
-			UID:       podSandbox.Metadata.Uid,
-			Namespace: podSandbox.Metadata.Namespace,
-		},
+		PodRef: podRef,
 		// The StartTime in the summary API is the pod creation time.
 		StartTime: metav1.NewTime(time.Unix(0, podSandbox.CreatedAt)),
+		Ready:     podSandbox.State == runtimeapi.PodSandboxState_SANDBOX_READY,
 	}
 }
 
-/*func (p *criStatsProvider) makePodStorageStats(s *PodStats, rootFsInfo *cadvisorapiv2.FsInfo) {
-	podNs := s.PodRef.Namespace
-	podName := s.PodRef.Name
-	podUID := types.UID(s.PodRef.UID)
-	vstats, found := p.resourceAnalyzer.GetPodVolumeStats(podUID)
-	if !found {
-		return
-	}
-	podLogDir := kuberuntime.BuildPodLogsDirectory(podNs, podName, podUID)
-	logStats, err := p.getPodLogStats(podLogDir, rootFsInfo)
-	if err != nil {
-		klog.Errorf("Unable to fetch pod log stats for path %s: %v ", podLogDir, err)
-		// If people do in-place upgrade, there might be pods still using
-		// the old log path. For those pods, no pod log stats is returned.
-		// We should continue generating other stats in that case.
-		// calcEphemeralStorage tolerants logStats == nil.
-	}
-	ephemeralStats := make([]statsapi.VolumeStats, len(vstats.EphemeralVolumes))
-	copy(ephemeralStats, vstats.EphemeralVolumes)
-	s.VolumeStats = append(append([]statsapi.VolumeStats{}, vstats.EphemeralVolumes...), vstats.PersistentVolumes...)
-	s.EphemeralStorage = calcEphemeralStorage(s.Containers, ephemeralStats, rootFsInfo, logStats, true)
-}*/
+// stablePodUID returns the pod identity which survives sandbox recreation.
+func stablePodUID(namespace, name string) string {
+	return namespace + "/" + name
+}
 
 func (p *criStatsProvider) addPodNetworkStats(
 	ps *PodStats,
 	podSandboxID string,
+	containerID string,
 	caInfos map[string]cadvisorapiv2.ContainerInfo,
 	cs *ContainerStats,
 	netStats *NetworkStats,
@@ -370,8 +967,32 @@ func (p *criStatsProvider) addPodNetworkStats(
 		return
 	}
 
-	// TODO: sum Pod network stats from container stats.
-	klog.V(4).Infof("Unable to find network stats for sandbox %q", podSandboxID)
+	// Sum the pod network stats from the container stats.
+	if caContainer, found := caInfos[containerID]; found {
+		if networkStats := cadvisorInfoToNetworkStats(&caContainer); networkStats != nil {
+			ps.Network = mergeNetworkStats(ps.Network, networkStats)
+			return
+		}
+	}
+	p.hostLogger().V(4).Info("Unable to find network stats", "sandboxId", podSandboxID, "containerId", containerID)
+}
+
+// addPodBandwidthLimit reports the bandwidth limit configured on the pod
+// along with its network stats.
+func (p *criStatsProvider) addPodBandwidthLimit(ps *PodStats, podUID string) {
+	if p.config.PodBandwidthLimit == nil || ps.Network == nil {
+		return
+	}
+	egressBps, ingressBps, ok := p.config.PodBandwidthLimit(podUID)
+	if !ok {
+		return
+	}
+	if egressBps > 0 {
+		ps.Network.EgressLimitBps = &egressBps
+	}
+	if ingressBps > 0 {
+		ps.Network.IngressLimitBps = &ingressBps
+	}
 }
 
 func (p *criStatsProvider) addPodCPUMemoryStats(
@@ -412,6 +1033,9 @@ func (p *criStatsProvider) addPodCPUMemoryStats(
 		usageBytes := getUint64Value(cs.Memory.UsageBytes) + getUint64Value(ps.Memory.UsageBytes)
 		workingSetBytes := getUint64Value(cs.Memory.WorkingSetBytes) + getUint64Value(ps.Memory.WorkingSetBytes)
 		rSSBytes := getUint64Value(cs.Memory.RSSBytes) + getUint64Value(ps.Memory.RSSBytes)
+		// the swap stats stay nil unless a container reports them
+		ps.Memory.SwapUsageBytes = addUsage(ps.Memory.SwapUsageBytes, cs.Memory.SwapUsageBytes)
+		ps.Memory.SwapAvailableBytes = addUsage(ps.Memory.SwapAvailableBytes, cs.Memory.SwapAvailableBytes)
 		pageFaults := getUint64Value(cs.Memory.PageFaults) + getUint64Value(ps.Memory.PageFaults)
 		majorPageFaults := getUint64Value(cs.Memory.MajorPageFaults) + getUint64Value(ps.Memory.MajorPageFaults)
 		ps.Memory.AvailableBytes = &availableBytes
@@ -461,29 +1085,69 @@ func (p *criStatsProvider) addProcessStats(
 	}
 }
 
+// addProcessStatsRates fills the fd and process count rates of the pod from
+// the previous sample cached per pod.
+func (p *criStatsProvider) addProcessStatsRates(ps *PodStats, now time.Time) {
+	window := p.config.ProcessStatsRateWindow
+	if window <= 0 || ps.ProcessStats == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	id := ps.PodRef.UID
+	cached, ok := p.processStatsCache[id]
+	if !ok {
+		p.processStatsCache[id] = &processStatsRecord{
+			time:         now,
+			processCount: ps.ProcessStats.ProcessCount,
+			fdCount:      ps.ProcessStats.FdCount,
+		}
+		return
+	}
+	elapsed := now.Sub(cached.time)
+	if elapsed < window {
+		// Keep the rates of the last window until a new window elapsed.
+		ps.ProcessStats.ProcessCountRate = cached.processCountRate
+		ps.ProcessStats.FdCountRate = cached.fdCountRate
+		return
+	}
+	seconds := elapsed.Seconds()
+	processCountRate := (float64(ps.ProcessStats.ProcessCount) - float64(cached.processCount)) / seconds
+	fdCountRate := (float64(ps.ProcessStats.FdCount) - float64(cached.fdCount)) / seconds
+	ps.ProcessStats.ProcessCountRate = &processCountRate
+	ps.ProcessStats.FdCountRate = &fdCountRate
+	p.processStatsCache[id] = &processStatsRecord{
+		time:             now,
+		processCount:     ps.ProcessStats.ProcessCount,
+		fdCount:          ps.ProcessStats.FdCount,
+		processCountRate: &processCountRate,
+		fdCountRate:      &fdCountRate,
+	}
+}
+
 // getFsInfo returns the information of the filesystem with the specified
 // fsID. If any error occurs, this function logs the error and returns
 // nil.
 func (p *criStatsProvider) getFsInfo(fsID *runtimeapi.FilesystemIdentifier) *cadvisorapiv2.FsInfo {
 	if fsID == nil {
-		klog.V(2).Infof("Failed to get filesystem info: fsID is nil.")
+		p.hostLogger().V(2).Info("Failed to get filesystem info: fsID is nil")
 		return nil
 	}
 	mountpoint := fsID.GetMountpoint()
 	fsInfo, err := p.cadvisor.GetDirFsInfo(mountpoint)
 	if err != nil {
-		msg := fmt.Sprintf("Failed to get the info of the filesystem with mountpoint %q: %v.", mountpoint, err)
 		if err == cadvisorfs.ErrNoSuchDevice {
-			klog.V(2).Info(msg)
+			p.hostLogger().V(2).Info("Failed to get the info of the filesystem", "mountpoint", mountpoint, "err", err)
 		} else {
-			klog.Error(msg)
+			p.hostLogger().Error(err, "Failed to get the info of the filesystem", "mountpoint", mountpoint)
 		}
 		return nil
 	}
 	return &fsInfo
 }
 
-func (p *criStatsProvider) makeContainerStats(stats *runtimeapi.ContainerStats, container *runtimeapi.Container, rootFsInfo *cadvisorapiv2.FsInfo, fsIDtoInfo map[runtimeapi.FilesystemIdentifier]*cadvisorapiv2.FsInfo, meta *runtimeapi.PodSandboxMetadata, updateCPUNanoCoreUsage bool, infos map[string]cadvisorapiv2.ContainerInfo) *ContainerStats {
+func (p *criStatsProvider) makeContainerStats(stats *runtimeapi.ContainerStats, container *runtimeapi.Container, rootFsInfo *cadvisorapiv2.FsInfo, fsIDtoInfo map[runtimeapi.FilesystemIdentifier]*cadvisorapiv2.FsInfo, imageLayers *containerImageLayers, meta *runtimeapi.PodSandboxMetadata, updateCPUNanoCoreUsage bool, infos map[string]cadvisorapiv2.ContainerInfo) *ContainerStats {
 	result := &ContainerStats{
 		Name: stats.Attributes.Metadata.Name,
 		// The StartTime in the summary API is the container creation time.
@@ -505,6 +1169,8 @@ func (p *criStatsProvider) makeContainerStats(stats *runtimeapi.ContainerStats,
 		if stats.Cpu.UsageCoreNanoSeconds != nil {
 			result.CPU.UsageCoreNanoSeconds = &stats.Cpu.UsageCoreNanoSeconds.Value
 		}
+		// checked before the cache is reset by the update
+		result.RestartedSinceLastSample = p.isCPUCounterReset(stats)
 		var usageNanoCores *uint64
 		if updateCPUNanoCoreUsage {
 			usageNanoCores = p.getAndUpdateContainerUsageNanoCores(stats)
@@ -527,6 +1193,7 @@ func (p *criStatsProvider) makeContainerStats(stats *runtimeapi.ContainerStats,
 		if cStats != nil && cStats.Memory != nil {
 			result.Memory.UsageBytes = &cStats.Memory.Usage
 			result.Memory.RSSBytes = &cStats.Memory.RSS
+			p.addContainerSwapStats(result.Memory, stats.Attributes.GetId(), cStats.Memory, infos)
 		}
 	} else {
 		result.Memory.Time = metav1.NewTime(time.Unix(0, time.Now().UnixNano()))
@@ -542,7 +1209,7 @@ func (p *criStatsProvider) makeContainerStats(stats *runtimeapi.ContainerStats,
 		}
 	}
 	fsID := stats.GetWritableLayer().GetFsId()
-	if fsID != nil {
+	if fsID != nil && p.imageService != nil && p.RuntimeCapabilities().WritableLayerFsId {
 		imageFsInfo, found := fsIDtoInfo[*fsID]
 		if !found {
 			imageFsInfo = p.getFsInfo(fsID)
@@ -559,6 +1226,7 @@ func (p *criStatsProvider) makeContainerStats(stats *runtimeapi.ContainerStats,
 			result.Rootfs.Inodes = imageFsInfo.Inodes
 		}
 	}
+	result.ImageFs = imageLayers.imageFsStats(container)
 	// NOTE: This doesn't support the old pod log path, `/var/log/pods/UID`. For containers
 	// using old log path, empty log stats are returned. This is fine, because we don't
 	// officially support in-place upgrade anyway.
@@ -613,6 +1281,7 @@ func (p *criStatsProvider) makeContainerCPUAndMemoryStats(
 			result.CPU.UsageCoreNanoSeconds = &stats.Cpu.UsageCoreNanoSeconds.Value
 		}
 
+		result.RestartedSinceLastSample = p.isCPUCounterReset(stats)
 		usageNanoCores := p.getContainerUsageNanoCores(stats)
 		if usageNanoCores != nil {
 			result.CPU.UsageNanoCores = usageNanoCores
@@ -630,6 +1299,7 @@ func (p *criStatsProvider) makeContainerCPUAndMemoryStats(
 		if cStats != nil && cStats.Memory != nil {
 			result.Memory.UsageBytes = &cStats.Memory.Usage
 			result.Memory.RSSBytes = &cStats.Memory.RSS
+			p.addContainerSwapStats(result.Memory, stats.Attributes.GetId(), cStats.Memory, infos)
 		}
 	} else {
 		result.Memory.Time = metav1.NewTime(time.Unix(0, time.Now().UnixNano()))
@@ -657,6 +1327,43 @@ func (p *criStatsProvider) getContainerUsageNanoCores(stats *runtimeapi.Containe
 	return &latestUsage
 }
 
+// addContainerSwapStats fills the swap stats of the container from cadvisor,
+// the spec of the container is only looked up when swap is used.
+func (p *criStatsProvider) addContainerSwapStats(memory *MemoryStats, containerID string, cstat *cadvisorapiv1.MemoryStats, infos map[string]cadvisorapiv2.ContainerInfo) {
+	if cstat.Swap == 0 {
+		return
+	}
+	var spec *cadvisorapiv2.ContainerSpec
+	if info := getContainerInfoById(containerID, infos); info != nil {
+		spec = &info.Spec
+	}
+	setSwapStats(memory, spec, cstat)
+}
+
+// isCPUCounterReset tells whether the cumulative cpu usage of the container
+// dropped below the cached sample, which typically means the container was
+// restarted in place. The reset is reported once by whichever caller sees it
+// first, the non-updating listings keep the cached sample until
+// getAndUpdateContainerUsageNanoCores replaces it.
+func (p *criStatsProvider) isCPUCounterReset(stats *runtimeapi.ContainerStats) bool {
+	if stats.GetAttributes() == nil || stats.GetCpu().GetUsageCoreNanoSeconds() == nil {
+		return false
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	cached, ok := p.cpuUsageCache[stats.Attributes.Id]
+	if !ok || cached.stats.UsageCoreNanoSeconds == nil || cached.resetReported {
+		return false
+	}
+	if stats.Cpu.UsageCoreNanoSeconds.Value >= cached.stats.UsageCoreNanoSeconds.Value {
+		return false
+	}
+	cached.resetReported = true
+	return true
+}
+
 // getContainerUsageNanoCores computes usageNanoCores based on the given and
 // the cached usageCoreNanoSeconds, updates the cache with the computed
 // usageNanoCores, and returns the usageNanoCores.
@@ -665,6 +1372,8 @@ func (p *criStatsProvider) getAndUpdateContainerUsageNanoCores(stats *runtimeapi
 		return nil
 	}
 	id := stats.Attributes.Id
+	// resolved before taking the lock, which may ask cadvisor
+	maxUsage := p.maxUsageNanoCores()
 	usage, err := func() (*uint64, error) {
 		p.mutex.Lock()
 		defer p.mutex.Unlock()
@@ -682,8 +1391,21 @@ func (p *criStatsProvider) getAndUpdateContainerUsageNanoCores(stats *runtimeapi
 		if nanoSeconds <= 0 {
 			return nil, fmt.Errorf("zero or negative interval (%v - %v)", newStats.Timestamp, cachedStats.Timestamp)
 		}
+		if nanoSeconds < p.config.MinCPUUsageSampleInterval.Nanoseconds() {
+			// Too close to the cached sample, keep the baseline so that the
+			// next usage is computed over a long enough interval.
+			return cached.usageNanoCores, nil
+		}
 		usageNanoCores := uint64(float64(newStats.UsageCoreNanoSeconds.Value-cachedStats.UsageCoreNanoSeconds.Value) /
 			float64(nanoSeconds) * float64(time.Second/time.Nanosecond))
+		if usageNanoCores > maxUsage {
+			// A tiny interval caused by clock adjustment yields an impossible rate,
+			// keep the previous usage and only move the baseline forward.
+			p.hostLogger().Info("Discard implausible cpu usage, clock skew?", "containerId", id,
+				"usageNanoCores", usageNanoCores, "maxUsageNanoCores", maxUsage, "intervalNanoSeconds", nanoSeconds)
+			p.cpuUsageCache[id] = &cpuUsageRecord{stats: newStats, usageNanoCores: cached.usageNanoCores}
+			return cached.usageNanoCores, nil
+		}
 
 		// Update cache with new value.
 		usageToUpdate := usageNanoCores
@@ -694,25 +1416,174 @@ func (p *criStatsProvider) getAndUpdateContainerUsageNanoCores(stats *runtimeapi
 
 	if err != nil {
 		// This should not happen. Log now to raise visibility
-		klog.Errorf("failed updating cpu usage nano core: %v", err)
+		p.hostLogger().Error(err, "Failed updating cpu usage nano core", "containerId", id)
 	}
 	return usage
 }
 
+func (p *criStatsProvider) maxUsageNanoCores() uint64 {
+	return uint64(float64(p.getNumCPUs()) * float64(time.Second/time.Nanosecond) * maxUsageNanoCoresSlack)
+}
+
+// getMachineInfo returns the cached machine info of cadvisor, an error is
+// returned when cadvisor hasn't finished initializing.
+func (p *criStatsProvider) getMachineInfo() (*cadvisorapiv1.MachineInfo, error) {
+	p.machineInfoLock.Lock()
+	defer p.machineInfoLock.Unlock()
+
+	if p.machineInfo != nil && time.Since(p.machineInfoUpdatedAt) < defaultCachePeriod {
+		return p.machineInfo, nil
+	}
+	if p.machineInfoErr != nil && time.Since(p.machineInfoErrAt) < machineInfoRetryPeriod {
+		return nil, p.machineInfoErr
+	}
+	info, err := p.fetchMachineInfo()
+	if err != nil {
+		p.machineInfoErr = err
+		p.machineInfoErrAt = time.Now()
+		return nil, err
+	}
+	p.machineInfo = info
+	p.machineInfoUpdatedAt = time.Now()
+	p.machineInfoErr = nil
+	return info, nil
+}
+
+func (p *criStatsProvider) fetchMachineInfo() (*cadvisorapiv1.MachineInfo, error) {
+	if p.cadvisor == nil {
+		return nil, errors.Error("cadvisor is not available")
+	}
+	info, err := p.cadvisor.MachineInfo()
+	if err != nil {
+		return nil, errors.Wrap(err, "get cadvisor machine info")
+	}
+	if info == nil || info.NumCores <= 0 {
+		return nil, errors.Error("cadvisor machine info is not ready")
+	}
+	return info, nil
+}
+
+// getNumCPUs returns the cpu count from cadvisor machine info, and degrades
+// to the cpu count seen by the process when the machine info is unavailable.
+func (p *criStatsProvider) getNumCPUs() int {
+	info, err := p.getMachineInfo()
+	if err != nil {
+		p.hostLogger().V(4).Info("Use runtime cpu count", "err", err)
+		return runtime.NumCPU()
+	}
+	return info.NumCores
+}
+
+// onContainerEvent handles the cadvisor container creation and deletion
+// events.
+func (p *criStatsProvider) onContainerEvent(containerID string, eventType cadvisorapiv1.EventType, at time.Time) {
+	p.invalidateContainerCaches(containerID)
+	if p.createLatency == nil {
+		return
+	}
+	if eventType == cadvisorapiv1.EventContainerCreation {
+		p.createLatency.onCreated(containerID, at)
+	} else {
+		p.createLatency.onDeleted(containerID)
+	}
+}
+
+// invalidateContainerCaches drops the cached entries of a created or deleted
+// container so that the next listing doesn't compute with stale data.
+func (p *criStatsProvider) invalidateContainerCaches(containerID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.cpuUsageCache, containerID)
+	delete(p.statsStalenessCache, containerID)
+}
+
+// Close stops watching the cadvisor events.
+func (p *criStatsProvider) Close() error {
+	if p.eventWatcher != nil {
+		p.eventWatcher.stop()
+		p.eventWatcher = nil
+	}
+	return nil
+}
+
+// cleanupOutdatedCachesIfDue is called on each collection, the caches are
+// only scanned once per CacheCleanupInterval, the cost of which is amortized
+// across the collections. The bound of MaxCPUUsageCacheEntries is still
+// enforced on each collection, checking it is O(1) while within the bound.
+func (p *criStatsProvider) cleanupOutdatedCachesIfDue() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.cachesCleanedAt) < p.config.CacheCleanupInterval {
+		p.evictCPUUsageCache()
+		return
+	}
+	p.cleanupOutdatedCachesLocked(now)
+}
+
 func (p *criStatsProvider) cleanupOutdatedCaches() {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
+	p.cleanupOutdatedCachesLocked(time.Now())
+}
+
+// cleanupOutdatedCachesLocked purges the records whose last sample is out of
+// CachePeriod. The caller must hold the mutex.
+func (p *criStatsProvider) cleanupOutdatedCachesLocked(now time.Time) {
+	p.cachesCleanedAt = now
+
 	for k, v := range p.cpuUsageCache {
 		if v == nil {
 			delete(p.cpuUsageCache, k)
 			continue
 		}
 
-		if time.Since(time.Unix(0, v.stats.Timestamp)) > defaultCachePeriod {
+		if now.Sub(time.Unix(0, v.stats.Timestamp)) > p.config.CachePeriod {
 			delete(p.cpuUsageCache, k)
 		}
 	}
+	p.evictCPUUsageCache()
+
+	for k, v := range p.processStatsCache {
+		if v == nil || now.Sub(v.time) > p.config.CachePeriod {
+			delete(p.processStatsCache, k)
+		}
+	}
+
+	// a stale record is kept as long as the container is seen, otherwise
+	// the staleness would be reset
+	for k, v := range p.statsStalenessCache {
+		if v == nil || now.Sub(v.seenAt) > p.config.CachePeriod {
+			delete(p.statsStalenessCache, k)
+		}
+	}
+
+	if p.createLatency != nil {
+		p.createLatency.expire(now, p.config.CachePeriod)
+	}
+}
+
+// evictCPUUsageCache evicts the cpu usage records with the oldest samples
+// until the cache fits MaxCPUUsageCacheEntries, e.g. on a host with many
+// short-lived containers. The caller must hold the mutex.
+func (p *criStatsProvider) evictCPUUsageCache() {
+	maxEntries := p.config.MaxCPUUsageCacheEntries
+	if maxEntries <= 0 || len(p.cpuUsageCache) <= maxEntries {
+		return
+	}
+	ids := make([]string, 0, len(p.cpuUsageCache))
+	for id := range p.cpuUsageCache {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return p.cpuUsageCache[ids[i]].stats.Timestamp < p.cpuUsageCache[ids[j]].stats.Timestamp
+	})
+	for _, id := range ids[:len(ids)-maxEntries] {
+		delete(p.cpuUsageCache, id)
+	}
 }
 
 // removeTerminatedPods returns pods with terminated ones removed.
@@ -721,6 +1592,8 @@ func (p *criStatsProvider) cleanupOutdatedCaches() {
 // This is needed because:
 // 1) PodSandbox may be recreated;
 // 2) Pod may be recreated with the same name and namespace.
+// When none of the sandboxes is ready the newest one is kept, and
+// PodStats.Ready of that pod is false so callers can skip it.
 func removeTerminatedPods(pods []*runtimeapi.PodSandbox) []*runtimeapi.PodSandbox {
 	podMap := make(map[PodReference][]*runtimeapi.PodSandbox)
 	// Sort order by create time
@@ -756,6 +1629,12 @@ func removeTerminatedPods(pods []*runtimeapi.PodSandbox) []*runtimeapi.PodSandbo
 	return result
 }
 
+// isInfraContainer tells whether the container is the pause container of
+// its pod sandbox.
+func isInfraContainer(container *runtimeapi.Container) bool {
+	return container.Id == container.PodSandboxId || IsInfraContainer(container.Labels)
+}
+
 // removeTerminatedContainers removes all terminated containers since they should
 // not be used for usage calculations.
 func removeTerminatedContainers(containers []*runtimeapi.Container) []*runtimeapi.Container {
@@ -840,37 +1719,3 @@ func getCRICadvisorStats(infos map[string]cadvisorapiv2.ContainerInfo) map[strin
 	result.Time = maxUpdateTime(&result.Time, &logMetrics.Time)
 	return result, nil
 }*/
-
-// getPodLogStats gets stats for logs under the pod log directory. Container logs usually exist
-// under the container log directory. However, for some container runtimes, e.g. kata, gvisor,
-// they may want to keep some pod level logs, in that case they can put those logs directly under
-// the pod log directory. And kubelet will take those logs into account as part of pod ephemeral
-// storage.
-/*func (p *criStatsProvider) getPodLogStats(path string, rootFsInfo *cadvisorapiv2.FsInfo) (*statsapi.FsStats, error) {
-	files, err := p.osInterface.ReadDir(path)
-	if err != nil {
-		return nil, err
-	}
-	result := &statsapi.FsStats{
-		Time:           metav1.NewTime(rootFsInfo.Timestamp),
-		AvailableBytes: &rootFsInfo.Available,
-		CapacityBytes:  &rootFsInfo.Capacity,
-		InodesFree:     rootFsInfo.InodesFree,
-		Inodes:         rootFsInfo.Inodes,
-	}
-	for _, f := range files {
-		if f.IsDir() {
-			continue
-		}
-		// Only include *files* under pod log directory.
-		fpath := filepath.Join(path, f.Name())
-		fstats, err := p.getPathFsStats(fpath, rootFsInfo)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get fsstats for %q: %v", fpath, err)
-		}
-		result.UsedBytes = addUsage(result.UsedBytes, fstats.UsedBytes)
-		result.InodesUsed = addUsage(result.InodesUsed, fstats.InodesUsed)
-		result.Time = maxUpdateTime(&result.Time, &fstats.Time)
-	}
-	return result, nil
-}*/
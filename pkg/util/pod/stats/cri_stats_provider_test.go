@@ -0,0 +1,1268 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cadvisorfs "github.com/google/cadvisor/fs"
+	cadvisorapiv1 "github.com/google/cadvisor/info/v1"
+	cadvisorapiv2 "github.com/google/cadvisor/info/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/util/pod/cadvisor"
+)
+
+type fakeCadvisor struct {
+	cadvisor.Interface
+
+	machineInfo    *cadvisorapiv1.MachineInfo
+	machineInfoErr error
+	// machineInfoCalls is the count of MachineInfo calls
+	machineInfoCalls int
+	// requestOptions are the options of the last ContainerInfoV2 call
+	requestOptions cadvisorapiv2.RequestOptions
+	// infos are returned by ContainerInfoV2 besides the root cgroup
+	infos map[string]cadvisorapiv2.ContainerInfo
+	// containerInfoErr fails ContainerInfoV2
+	containerInfoErr error
+	// latency is added to RootFsInfo and ContainerInfoV2
+	latency time.Duration
+	// dirFsInfos are returned by GetDirFsInfo by mountpoint
+	dirFsInfos map[string]cadvisorapiv2.FsInfo
+}
+
+func (f *fakeCadvisor) MachineInfo() (*cadvisorapiv1.MachineInfo, error) {
+	f.machineInfoCalls++
+	return f.machineInfo, f.machineInfoErr
+}
+
+func (f *fakeCadvisor) RootFsInfo() (cadvisorapiv2.FsInfo, error) {
+	time.Sleep(f.latency)
+	return cadvisorapiv2.FsInfo{}, nil
+}
+
+func (f *fakeCadvisor) GetDirFsInfo(path string) (cadvisorapiv2.FsInfo, error) {
+	if info, ok := f.dirFsInfos[path]; ok {
+		return info, nil
+	}
+	return cadvisorapiv2.FsInfo{}, cadvisorfs.ErrNoSuchDevice
+}
+
+func (f *fakeCadvisor) ContainerInfoV2(name string, options cadvisorapiv2.RequestOptions) (map[string]cadvisorapiv2.ContainerInfo, error) {
+	time.Sleep(f.latency)
+	f.requestOptions = options
+	if f.containerInfoErr != nil {
+		return nil, f.containerInfoErr
+	}
+	infos := map[string]cadvisorapiv2.ContainerInfo{"/": {}}
+	for k, v := range f.infos {
+		infos[k] = v
+	}
+	return infos, nil
+}
+
+type fakeRuntimeService struct {
+	runtimeapi.RuntimeServiceClient
+
+	sandboxes      []*runtimeapi.PodSandbox
+	containers     []*runtimeapi.Container
+	containerStats []*runtimeapi.ContainerStats
+	version        runtimeapi.VersionResponse
+	// listContainersErrs are returned by the next ListContainers calls
+	listContainersErrs []error
+
+	// listedContainers is the number of containers returned by the last
+	// ListContainers call
+	listedContainers int
+	// latency is added to the list requests
+	latency time.Duration
+	// statsRequests is the number of the ListContainerStats calls
+	statsRequests int32
+}
+
+func (f *fakeRuntimeService) Version(ctx context.Context, in *runtimeapi.VersionRequest, opts ...grpc.CallOption) (*runtimeapi.VersionResponse, error) {
+	return &f.version, nil
+}
+
+func (f *fakeRuntimeService) ListPodSandbox(ctx context.Context, in *runtimeapi.ListPodSandboxRequest, opts ...grpc.CallOption) (*runtimeapi.ListPodSandboxResponse, error) {
+	time.Sleep(f.latency)
+	if id := in.GetFilter().GetId(); id != "" {
+		items := []*runtimeapi.PodSandbox{}
+		for _, s := range f.sandboxes {
+			if s.Id == id {
+				items = append(items, s)
+			}
+		}
+		return &runtimeapi.ListPodSandboxResponse{Items: items}, nil
+	}
+	return &runtimeapi.ListPodSandboxResponse{Items: f.sandboxes}, nil
+}
+
+func (f *fakeRuntimeService) ListContainers(ctx context.Context, in *runtimeapi.ListContainersRequest, opts ...grpc.CallOption) (*runtimeapi.ListContainersResponse, error) {
+	time.Sleep(f.latency)
+	if len(f.listContainersErrs) > 0 {
+		err := f.listContainersErrs[0]
+		f.listContainersErrs = f.listContainersErrs[1:]
+		return nil, err
+	}
+	containers := []*runtimeapi.Container{}
+	for _, c := range f.containers {
+		if id := in.GetFilter().GetPodSandboxId(); id != "" && c.PodSandboxId != id {
+			continue
+		}
+		containers = append(containers, c)
+	}
+	f.listedContainers = len(containers)
+	return &runtimeapi.ListContainersResponse{Containers: containers}, nil
+}
+
+func (f *fakeRuntimeService) ListContainerStats(ctx context.Context, in *runtimeapi.ListContainerStatsRequest, opts ...grpc.CallOption) (*runtimeapi.ListContainerStatsResponse, error) {
+	time.Sleep(f.latency)
+	atomic.AddInt32(&f.statsRequests, 1)
+	sandboxID := in.GetFilter().GetPodSandboxId()
+	if sandboxID == "" {
+		return &runtimeapi.ListContainerStatsResponse{Stats: f.containerStats}, nil
+	}
+	ids := make(map[string]bool)
+	for _, c := range f.containers {
+		if c.PodSandboxId == sandboxID {
+			ids[c.Id] = true
+		}
+	}
+	stats := []*runtimeapi.ContainerStats{}
+	for _, s := range f.containerStats {
+		if ids[s.Attributes.Id] {
+			stats = append(stats, s)
+		}
+	}
+	return &runtimeapi.ListContainerStatsResponse{Stats: stats}, nil
+}
+
+func newTestCPUStats(id string, ts time.Time, usage uint64) *runtimeapi.ContainerStats {
+	return &runtimeapi.ContainerStats{
+		Attributes: &runtimeapi.ContainerAttributes{Id: id},
+		Cpu: &runtimeapi.CpuUsage{
+			Timestamp:            ts.UnixNano(),
+			UsageCoreNanoSeconds: &runtimeapi.UInt64Value{Value: usage},
+		},
+	}
+}
+
+func TestGetAndUpdateContainerUsageNanoCoresClockSkew(t *testing.T) {
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, nil, nil, CRIStatsProviderConfig{
+		MinCPUUsageSampleInterval: -1,
+	})
+
+	now := time.Now()
+	if usage := p.getAndUpdateContainerUsageNanoCores(newTestCPUStats("c1", now, 0)); usage != nil {
+		t.Fatalf("first sample should not compute usage, got %d", *usage)
+	}
+	// 2 cores busy for 1 second
+	now = now.Add(time.Second)
+	usage := p.getAndUpdateContainerUsageNanoCores(newTestCPUStats("c1", now, 2e9))
+	if usage == nil || *usage != 2e9 {
+		t.Fatalf("expected usage 2e9, got %v", usage)
+	}
+
+	// 1 more core second within 1 microsecond is impossible on 4 cpus
+	now = now.Add(time.Microsecond)
+	usage = p.getAndUpdateContainerUsageNanoCores(newTestCPUStats("c1", now, 3e9))
+	if usage == nil || *usage != 2e9 {
+		t.Fatalf("expected previous usage 2e9 to be kept, got %v", usage)
+	}
+
+	// baseline moved forward, the next sane sample is computed normally
+	now = now.Add(time.Second)
+	usage = p.getAndUpdateContainerUsageNanoCores(newTestCPUStats("c1", now, 4e9))
+	if usage == nil || *usage != 1e9 {
+		t.Fatalf("expected usage 1e9, got %v", usage)
+	}
+}
+
+func TestListPodStatsRestartedSinceLastSample(t *testing.T) {
+	rt := newTestPodsRuntimeService(1)
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, rt, nil, CRIStatsProviderConfig{})
+
+	now := time.Now()
+	// the counter drops on the third sample as the container restarted
+	for i, usage := range []uint64{1e9, 2e9, 5e8, 1e9} {
+		// the cached sample refers to the previous one
+		rt.containerStats[0].Cpu = &runtimeapi.CpuUsage{
+			Timestamp:            now.Add(time.Duration(i) * 3 * time.Second).UnixNano(),
+			UsageCoreNanoSeconds: &runtimeapi.UInt64Value{Value: usage},
+		}
+		result, err := p.ListPodStatsAndUpdateCPUNanoCoreUsage()
+		if err != nil {
+			t.Fatalf("ListPodStatsAndUpdateCPUNanoCoreUsage: %v", err)
+		}
+		restarted := result[0].Containers[0].RestartedSinceLastSample
+		if restarted != (i == 2) {
+			t.Errorf("sample %d: restarted %v", i, restarted)
+		}
+	}
+}
+
+func TestListPodStatsSwap(t *testing.T) {
+	now := time.Now()
+	newInfo := func(swap, swapLimit uint64) cadvisorapiv2.ContainerInfo {
+		return cadvisorapiv2.ContainerInfo{
+			Spec: cadvisorapiv2.ContainerSpec{
+				HasMemory: true,
+				Memory:    cadvisorapiv2.MemorySpec{Limit: 1 << 30, SwapLimit: swapLimit},
+			},
+			Stats: []*cadvisorapiv2.ContainerStats{{
+				Timestamp: now,
+				Memory:    &cadvisorapiv1.MemoryStats{Usage: 100 << 20, Swap: swap},
+			}},
+		}
+	}
+	rt := newTestPodsRuntimeService(2)
+	for _, cs := range rt.containerStats {
+		cs.Memory = &runtimeapi.MemoryUsage{Timestamp: now.UnixNano(), WorkingSetBytes: &runtimeapi.UInt64Value{Value: 50 << 20}}
+	}
+	ca := &fakeCadvisor{
+		machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4},
+		infos: map[string]cadvisorapiv2.ContainerInfo{
+			"/ctr0": newInfo(10<<20, 64<<20),
+			// swap isn't accounted
+			"/ctr1": newInfo(0, 0),
+		},
+	}
+	p := newCRIStatsProvider(ca, rt, nil, CRIStatsProviderConfig{})
+
+	result, err := p.ListPodStats()
+	if err != nil {
+		t.Fatalf("ListPodStats: %v", err)
+	}
+	for _, ps := range result {
+		for _, memory := range []*MemoryStats{ps.Memory, ps.Containers[0].Memory} {
+			switch ps.PodRef.Name {
+			case "pod0":
+				if getUint64Value(memory.SwapUsageBytes) != 10<<20 || getUint64Value(memory.SwapAvailableBytes) != 54<<20 {
+					t.Errorf("unexpected swap of pod0: %v, %v", memory.SwapUsageBytes, memory.SwapAvailableBytes)
+				}
+			case "pod1":
+				if memory.SwapUsageBytes != nil || memory.SwapAvailableBytes != nil {
+					t.Errorf("expect no swap of pod1: %v, %v", memory.SwapUsageBytes, memory.SwapAvailableBytes)
+				}
+			}
+		}
+	}
+}
+
+func TestListPodCPUStatsRestartedReportedOnce(t *testing.T) {
+	rt := newTestPodsRuntimeService(1)
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, rt, nil, CRIStatsProviderConfig{})
+
+	now := time.Now()
+	setUsage := func(i int, usage uint64) {
+		rt.containerStats[0].Cpu = &runtimeapi.CpuUsage{
+			Timestamp:            now.Add(time.Duration(i) * 3 * time.Second).UnixNano(),
+			UsageCoreNanoSeconds: &runtimeapi.UInt64Value{Value: usage},
+		}
+	}
+	setUsage(0, 2e9)
+	if _, err := p.ListPodStatsAndUpdateCPUNanoCoreUsage(); err != nil {
+		t.Fatalf("ListPodStatsAndUpdateCPUNanoCoreUsage: %v", err)
+	}
+
+	// the container restarted, the non-updating listings don't replace the
+	// cached sample but report the reset only once
+	setUsage(1, 5e8)
+	for i, list := range []func() ([]PodStats, error){p.ListPodCPUStats, p.ListPodCPUStats, p.ListPodStats, p.ListPodCPUAndMemoryStats} {
+		result, err := list()
+		if err != nil {
+			t.Fatalf("listing %d: %v", i, err)
+		}
+		restarted := result[0].Containers[0].RestartedSinceLastSample
+		if restarted != (i == 0) {
+			t.Errorf("listing %d: restarted %v", i, restarted)
+		}
+	}
+
+	// reported already, the updating listing moves the sample forward
+	result, err := p.ListPodStatsAndUpdateCPUNanoCoreUsage()
+	if err != nil {
+		t.Fatalf("ListPodStatsAndUpdateCPUNanoCoreUsage: %v", err)
+	}
+	if result[0].Containers[0].RestartedSinceLastSample {
+		t.Error("reset reported twice")
+	}
+}
+
+func TestCleanupOutdatedCachesBounds(t *testing.T) {
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, nil, nil, CRIStatsProviderConfig{
+		CachePeriod:             time.Minute,
+		MaxCPUUsageCacheEntries: 2,
+	})
+
+	now := time.Now()
+	// c0 is out of the cache period, c1 is the oldest of the rest
+	for i, age := range []time.Duration{2 * time.Minute, 30 * time.Second, 20 * time.Second, 10 * time.Second, 0} {
+		p.getAndUpdateContainerUsageNanoCores(newTestCPUStats(fmt.Sprintf("c%d", i), now.Add(-age), 0))
+	}
+	p.cleanupOutdatedCaches()
+
+	ids := []string{}
+	for id := range p.cpuUsageCache {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if strings.Join(ids, ",") != "c3,c4" {
+		t.Errorf("expect the latest c3,c4 cached, got %v", ids)
+	}
+}
+
+func TestCleanupOutdatedCachesIfDue(t *testing.T) {
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, nil, nil, CRIStatsProviderConfig{
+		CachePeriod:             time.Minute,
+		MaxCPUUsageCacheEntries: 3,
+	})
+	cachedIds := func() string {
+		ids := []string{}
+		for id := range p.cpuUsageCache {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return strings.Join(ids, ",")
+	}
+
+	now := time.Now()
+	// the first collection scans the caches
+	p.getAndUpdateContainerUsageNanoCores(newTestCPUStats("c0", now.Add(-2*time.Minute), 0))
+	p.cleanupOutdatedCachesIfDue()
+	if ids := cachedIds(); ids != "" {
+		t.Fatalf("expect outdated c0 purged on the first collection, got %v", ids)
+	}
+
+	// the outdated c0 is kept until the next scan
+	p.getAndUpdateContainerUsageNanoCores(newTestCPUStats("c0", now.Add(-2*time.Minute), 0))
+	p.getAndUpdateContainerUsageNanoCores(newTestCPUStats("c1", now, 0))
+	p.cleanupOutdatedCachesIfDue()
+	if ids := cachedIds(); ids != "c0,c1" {
+		t.Errorf("expect c0,c1 cached before the next scan, got %v", ids)
+	}
+
+	// the scan is due, the live c1 isn't purged
+	p.cachesCleanedAt = now.Add(-2 * time.Minute)
+	p.cleanupOutdatedCachesIfDue()
+	if ids := cachedIds(); ids != "c1" {
+		t.Errorf("expect the live c1 cached, got %v", ids)
+	}
+
+	// the bound is enforced on each collection
+	for i, age := range []time.Duration{30 * time.Second, 20 * time.Second, 10 * time.Second} {
+		p.getAndUpdateContainerUsageNanoCores(newTestCPUStats(fmt.Sprintf("c%d", i+2), now.Add(-age), 0))
+	}
+	p.cleanupOutdatedCachesIfDue()
+	if ids := cachedIds(); ids != "c1,c3,c4" {
+		t.Errorf("expect the latest c1,c3,c4 cached, got %v", ids)
+	}
+}
+
+func TestGetAndUpdateContainerUsageNanoCoresMinInterval(t *testing.T) {
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, nil, nil, CRIStatsProviderConfig{})
+
+	now := time.Now()
+	p.getAndUpdateContainerUsageNanoCores(newTestCPUStats("c1", now, 0))
+	now = now.Add(defaultMinCPUUsageSampleInterval)
+	usage := p.getAndUpdateContainerUsageNanoCores(newTestCPUStats("c1", now, 2e9))
+	if usage == nil || *usage != 1e9 {
+		t.Fatalf("expected usage 1e9, got %v", usage)
+	}
+
+	// a rapid call returns the cached usage instead of a noisy delta
+	now = now.Add(100 * time.Millisecond)
+	usage = p.getAndUpdateContainerUsageNanoCores(newTestCPUStats("c1", now, 2.4e9))
+	if usage == nil || *usage != 1e9 {
+		t.Fatalf("expected cached usage 1e9, got %v", usage)
+	}
+
+	// the baseline is kept, the next usage is computed over the whole interval
+	now = now.Add(defaultMinCPUUsageSampleInterval - 100*time.Millisecond)
+	usage = p.getAndUpdateContainerUsageNanoCores(newTestCPUStats("c1", now, 6e9))
+	if usage == nil || *usage != 2e9 {
+		t.Fatalf("expected usage 2e9, got %v", usage)
+	}
+}
+
+func TestAddCadvisorContainerStatsHugePagesAndNuma(t *testing.T) {
+	p := newCRIStatsProvider(nil, nil, nil, CRIStatsProviderConfig{})
+	hugetlb := map[string]cadvisorapiv1.HugetlbStats{
+		"2MB": {Usage: 4 << 20, MaxUsage: 8 << 20},
+		"1GB": {Usage: 1 << 30, MaxUsage: 1 << 30, Failcnt: 1},
+	}
+	info := &cadvisorapiv2.ContainerInfo{
+		Spec: cadvisorapiv2.ContainerSpec{
+			HasCpu:     true,
+			HasMemory:  true,
+			HasHugetlb: true,
+		},
+		Stats: []*cadvisorapiv2.ContainerStats{
+			{
+				Timestamp: time.Now(),
+				Cpu:       &cadvisorapiv1.CpuStats{},
+				Memory: &cadvisorapiv1.MemoryStats{
+					Usage: 1 << 30,
+					ContainerData: cadvisorapiv1.MemoryStatsMemoryData{
+						NumaStats: cadvisorapiv1.MemoryNumaStats{
+							File: map[uint8]uint64{0: 10, 1: 20},
+							Anon: map[uint8]uint64{1: 30},
+						},
+					},
+				},
+				Hugetlb: &hugetlb,
+			},
+		},
+	}
+
+	cs := &ContainerStats{}
+	p.addCadvisorContainerStats(cs, info)
+	if cs.Memory == nil {
+		t.Fatal("memory stats should be set")
+	}
+	wantHugePages := map[string]HugePageStats{
+		"2MB": {UsageBytes: 4 << 20, MaxUsageBytes: 8 << 20},
+		"1GB": {UsageBytes: 1 << 30, MaxUsageBytes: 1 << 30, Failcnt: 1},
+	}
+	if !reflect.DeepEqual(cs.Memory.HugePages, wantHugePages) {
+		t.Errorf("hugepages: want %v, got %v", wantHugePages, cs.Memory.HugePages)
+	}
+	wantNuma := []NumaNodeMemoryStats{
+		{Node: 0, FilePages: 10},
+		{Node: 1, FilePages: 20, AnonPages: 30},
+	}
+	if !reflect.DeepEqual(cs.Memory.NumaNodes, wantNuma) {
+		t.Errorf("numa nodes: want %v, got %v", wantNuma, cs.Memory.NumaNodes)
+	}
+
+	// stats are left nil when cadvisor doesn't report them
+	info.Spec.HasHugetlb = false
+	info.Stats[0].Memory.ContainerData.NumaStats = cadvisorapiv1.MemoryNumaStats{}
+	cs = &ContainerStats{}
+	p.addCadvisorContainerStats(cs, info)
+	if cs.Memory.HugePages != nil || cs.Memory.NumaNodes != nil {
+		t.Errorf("expect nil hugepages and numa nodes, got %v %v", cs.Memory.HugePages, cs.Memory.NumaNodes)
+	}
+}
+
+func TestRemoveTerminatedPodsNotReady(t *testing.T) {
+	newSandbox := func(id, name string, createdAt int64, state runtimeapi.PodSandboxState) *runtimeapi.PodSandbox {
+		return &runtimeapi.PodSandbox{
+			Id: id,
+			Metadata: &runtimeapi.PodSandboxMetadata{
+				Name:      name,
+				Namespace: "default",
+				Uid:       id,
+			},
+			CreatedAt: createdAt,
+			State:     state,
+		}
+	}
+	pods := []*runtimeapi.PodSandbox{
+		// only not ready sandboxes
+		newSandbox("crashed-1", "crashed", 1, runtimeapi.PodSandboxState_SANDBOX_NOTREADY),
+		newSandbox("crashed-2", "crashed", 2, runtimeapi.PodSandboxState_SANDBOX_NOTREADY),
+		// the ready one is preferred even if it's older
+		newSandbox("mixed-1", "mixed", 1, runtimeapi.PodSandboxState_SANDBOX_READY),
+		newSandbox("mixed-2", "mixed", 2, runtimeapi.PodSandboxState_SANDBOX_NOTREADY),
+	}
+
+	p := newCRIStatsProvider(nil, nil, nil, CRIStatsProviderConfig{})
+	ready := make(map[string]bool)
+	for _, pod := range removeTerminatedPods(pods) {
+		ps := p.buildPodStats(pod)
+		ready[pod.Id] = ps.Ready
+	}
+	want := map[string]bool{
+		"crashed-2": false,
+		"mixed-1":   true,
+	}
+	if !reflect.DeepEqual(ready, want) {
+		t.Errorf("want %v, got %v", want, ready)
+	}
+}
+
+func TestGetMachineInfoUnavailable(t *testing.T) {
+	ca := &fakeCadvisor{}
+	p := newCRIStatsProvider(ca, nil, nil, CRIStatsProviderConfig{})
+
+	// cadvisor returns no machine info while initializing
+	if _, err := p.getMachineInfo(); err == nil {
+		t.Fatal("expect error for nil machine info")
+	}
+	if p.getNumCPUs() != runtime.NumCPU() {
+		t.Errorf("expect fallback to runtime cpu count %d, got %d", runtime.NumCPU(), p.getNumCPUs())
+	}
+	// the failure is cached until the retry period passes
+	if ca.machineInfoCalls != 1 {
+		t.Errorf("expect cadvisor asked once, got %d", ca.machineInfoCalls)
+	}
+	p.machineInfoErrAt = time.Now().Add(-machineInfoRetryPeriod)
+	ca.machineInfoErr = errors.Error("not initialized")
+	if _, err := p.getMachineInfo(); err == nil {
+		t.Fatal("expect error from cadvisor")
+	}
+	if ca.machineInfoCalls != 2 {
+		t.Errorf("expect cadvisor asked again after the retry period, got %d", ca.machineInfoCalls)
+	}
+
+	// fetched lazily once it's ready, and cached afterwards
+	p.machineInfoErrAt = time.Now().Add(-machineInfoRetryPeriod)
+	ca.machineInfoErr = nil
+	ca.machineInfo = &cadvisorapiv1.MachineInfo{NumCores: 64}
+	if n := p.getNumCPUs(); n != 64 {
+		t.Errorf("expect 64 cpus, got %d", n)
+	}
+	ca.machineInfo = nil
+	if n := p.getNumCPUs(); n != 64 {
+		t.Errorf("expect cached 64 cpus, got %d", n)
+	}
+}
+
+func TestListPodStatsWithoutImageService(t *testing.T) {
+	now := time.Now()
+	rt := &fakeRuntimeService{
+		sandboxes: []*runtimeapi.PodSandbox{
+			{
+				Id:        "sandbox1",
+				Metadata:  &runtimeapi.PodSandboxMetadata{Name: "pod1", Uid: "uid1", Namespace: "ns1"},
+				State:     runtimeapi.PodSandboxState_SANDBOX_READY,
+				CreatedAt: now.UnixNano(),
+			},
+		},
+		containers: []*runtimeapi.Container{
+			{
+				Id:           "ctr1",
+				PodSandboxId: "sandbox1",
+				Metadata:     &runtimeapi.ContainerMetadata{Name: "ctr1"},
+				State:        runtimeapi.ContainerState_CONTAINER_RUNNING,
+				CreatedAt:    now.UnixNano(),
+			},
+		},
+		containerStats: []*runtimeapi.ContainerStats{
+			{
+				Attributes: &runtimeapi.ContainerAttributes{Id: "ctr1", Metadata: &runtimeapi.ContainerMetadata{Name: "ctr1"}},
+				Cpu: &runtimeapi.CpuUsage{
+					Timestamp:            now.UnixNano(),
+					UsageCoreNanoSeconds: &runtimeapi.UInt64Value{Value: 1e9},
+				},
+				Memory: &runtimeapi.MemoryUsage{
+					Timestamp:       now.UnixNano(),
+					WorkingSetBytes: &runtimeapi.UInt64Value{Value: 1024},
+				},
+				// GetDirFsInfo of the fake cadvisor panics, so the image
+				// filesystem must not be looked up
+				WritableLayer: &runtimeapi.FilesystemUsage{
+					Timestamp: now.UnixNano(),
+					FsId:      &runtimeapi.FilesystemIdentifier{Mountpoint: "/var/lib/containerd"},
+				},
+			},
+		},
+	}
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, rt, nil, CRIStatsProviderConfig{})
+
+	result, err := p.ListPodStats()
+	if err != nil {
+		t.Fatalf("ListPodStats: %v", err)
+	}
+	if len(result) != 1 || len(result[0].Containers) != 1 {
+		t.Fatalf("expect 1 pod with 1 container, got %#v", result)
+	}
+	ctr := result[0].Containers[0]
+	if ctr.CPU == nil || ctr.CPU.UsageCoreNanoSeconds == nil || *ctr.CPU.UsageCoreNanoSeconds != 1e9 {
+		t.Errorf("unexpected cpu stats %#v", ctr.CPU)
+	}
+	if ctr.Memory == nil || ctr.Memory.WorkingSetBytes == nil || *ctr.Memory.WorkingSetBytes != 1024 {
+		t.Errorf("unexpected memory stats %#v", ctr.Memory)
+	}
+	if ctr.Rootfs != nil && ctr.Rootfs.CapacityBytes != nil {
+		t.Errorf("image filesystem stats should be skipped without image service")
+	}
+
+	if _, err := p.ImageFsStats(); errors.Cause(err) != ErrImageServiceUnavailable {
+		t.Errorf("ImageFsStats: expect ErrImageServiceUnavailable, got %v", err)
+	}
+}
+
+func newTestPodsRuntimeService(pods int) *fakeRuntimeService {
+	now := time.Now()
+	rt := &fakeRuntimeService{}
+	for i := 0; i < pods; i++ {
+		sandboxID := fmt.Sprintf("sandbox%d", i)
+		ctrID := fmt.Sprintf("ctr%d", i)
+		rt.sandboxes = append(rt.sandboxes, &runtimeapi.PodSandbox{
+			Id:        sandboxID,
+			Metadata:  &runtimeapi.PodSandboxMetadata{Name: fmt.Sprintf("pod%d", i), Uid: fmt.Sprintf("uid%d", i), Namespace: "ns"},
+			State:     runtimeapi.PodSandboxState_SANDBOX_READY,
+			CreatedAt: now.UnixNano(),
+		})
+		rt.containers = append(rt.containers, &runtimeapi.Container{
+			Id:           ctrID,
+			PodSandboxId: sandboxID,
+			Metadata:     &runtimeapi.ContainerMetadata{Name: "ctr"},
+			State:        runtimeapi.ContainerState_CONTAINER_RUNNING,
+			CreatedAt:    now.UnixNano(),
+		})
+		rt.containerStats = append(rt.containerStats, &runtimeapi.ContainerStats{
+			Attributes: &runtimeapi.ContainerAttributes{Id: ctrID, Metadata: &runtimeapi.ContainerMetadata{Name: "ctr"}},
+			Cpu: &runtimeapi.CpuUsage{
+				Timestamp:            now.UnixNano(),
+				UsageCoreNanoSeconds: &runtimeapi.UInt64Value{Value: 1e9},
+			},
+		})
+	}
+	return rt
+}
+
+func TestGetPodStatsFiltersBySandbox(t *testing.T) {
+	rt := newTestPodsRuntimeService(3)
+	// a terminated container of the pod is still filtered out
+	rt.containers = append(rt.containers, &runtimeapi.Container{
+		Id:           "exited",
+		PodSandboxId: "sandbox1",
+		Metadata:     &runtimeapi.ContainerMetadata{Name: "exited"},
+		State:        runtimeapi.ContainerState_CONTAINER_EXITED,
+	})
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, rt, nil, CRIStatsProviderConfig{})
+
+	ps, err := p.GetPodStats("uid1")
+	if err != nil {
+		t.Fatalf("GetPodStats: %v", err)
+	}
+	if ps.PodRef.SandboxUID != "uid1" || len(ps.Containers) != 1 {
+		t.Fatalf("unexpected pod stats %#v", ps)
+	}
+	if rt.listedContainers != 2 {
+		t.Errorf("listed %d containers, want the 2 of sandbox1", rt.listedContainers)
+	}
+
+	if _, err := p.GetPodStats("missing"); errors.Cause(err) != errors.ErrNotFound {
+		t.Errorf("expect ErrNotFound, got %v", err)
+	}
+}
+
+func TestListPodStatsPartialResult(t *testing.T) {
+	ca := &fakeCadvisor{
+		machineInfo:      &cadvisorapiv1.MachineInfo{NumCores: 4},
+		containerInfoErr: errors.Error("cadvisor unavailable"),
+	}
+	p := newCRIStatsProvider(ca, newTestPodsRuntimeService(2), nil, CRIStatsProviderConfig{
+		ListPodStatsCacheTTL: time.Minute,
+	})
+
+	// the stats from CRI are still returned without cadvisor
+	result, err := p.ListPodStats()
+	if err == nil {
+		t.Fatalf("expect the cadvisor error")
+	}
+	if _, ok := err.(errors.Aggregate); !ok || !strings.Contains(err.Error(), "cadvisor unavailable") {
+		t.Errorf("expect an aggregated cadvisor error, got %v", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("expect the partial stats of 2 pods, got %d", len(result))
+	}
+	for _, ps := range result {
+		if ps.CPU == nil || getUint64Value(ps.CPU.UsageCoreNanoSeconds) != 1e9 {
+			t.Errorf("expect the cpu stats from CRI, got %#v", ps.CPU)
+		}
+	}
+
+	// the partial result isn't cached
+	ca.containerInfoErr = nil
+	if _, err := p.ListPodStats(); err != nil {
+		t.Fatalf("ListPodStats: %v", err)
+	}
+	if misses := p.GetProviderStats().ListPodStatsCacheMisses; misses != 2 {
+		t.Errorf("expect 2 collections, got %d", misses)
+	}
+
+	// the pods can't be built without the containers
+	p.runtimeService.(*fakeRuntimeService).listContainersErrs = []error{errors.Error("list failed")}
+	if _, err := p.GetPodStats("uid0"); err == nil {
+		t.Errorf("expect the list containers error")
+	}
+}
+
+// BenchmarkListPodStatsLatency shows the collection latency when every
+// request takes 5ms, the sources fetched one after another take 25ms while
+// the concurrent fetching takes about 10ms, the containers and their stats
+// being the longest chain.
+func BenchmarkListPodStatsLatency(b *testing.B) {
+	rt := newTestPodsRuntimeService(100)
+	rt.latency = 5 * time.Millisecond
+	ca := &fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}, latency: 5 * time.Millisecond}
+	p := newCRIStatsProvider(ca, rt, nil, CRIStatsProviderConfig{})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.listPodStats(false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCleanupOutdatedCaches shows the cache maintenance of a collection
+// on a host with 5000 containers, the scan of all the caches versus the scan
+// amortized over CacheCleanupInterval.
+func BenchmarkCleanupOutdatedCaches(b *testing.B) {
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, nil, nil, CRIStatsProviderConfig{})
+	now := time.Now()
+	for i := 0; i < 5000; i++ {
+		id := fmt.Sprintf("c%d", i)
+		p.getAndUpdateContainerUsageNanoCores(newTestCPUStats(id, now, 0))
+		p.processStatsCache[id] = &processStatsRecord{time: now}
+		p.statsStalenessCache[id] = &statsStalenessRecord{seenAt: now}
+	}
+
+	b.Run("every collection", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p.cleanupOutdatedCaches()
+		}
+	})
+	b.Run("amortized", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p.cleanupOutdatedCachesIfDue()
+		}
+	})
+}
+
+func BenchmarkGetPodStats(b *testing.B) {
+	rt := newTestPodsRuntimeService(500)
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, rt, nil, CRIStatsProviderConfig{})
+
+	b.Run("sandbox filter", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := p.GetPodStats("uid250"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("full scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			result, err := p.listPodStats(false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			found := false
+			for j := range result {
+				if result[j].PodRef.SandboxUID == "uid250" {
+					found = true
+					break
+				}
+			}
+			if !found {
+				b.Fatal("pod uid250 not found")
+			}
+		}
+	})
+}
+
+func TestListPodStatsInfraContainer(t *testing.T) {
+	rt := newTestPodsRuntimeService(1)
+	rt.containers = append(rt.containers, &runtimeapi.Container{
+		Id:           "pause",
+		PodSandboxId: "sandbox0",
+		Metadata:     &runtimeapi.ContainerMetadata{Name: "pause"},
+		Labels:       map[string]string{ContainerdKindLabel: ContainerdKindSandbox},
+		State:        runtimeapi.ContainerState_CONTAINER_RUNNING,
+	})
+	pauseStats := newTestCPUStats("pause", time.Now(), 1e8)
+	pauseStats.Attributes.Metadata = &runtimeapi.ContainerMetadata{Name: "pause"}
+	rt.containerStats = append(rt.containerStats, pauseStats)
+
+	for _, include := range []bool{false, true} {
+		p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, rt, nil, CRIStatsProviderConfig{
+			IncludeInfraContainer: include,
+		})
+		result, err := p.ListPodStats()
+		if err != nil {
+			t.Fatalf("ListPodStats: %v", err)
+		}
+		if len(result) != 1 {
+			t.Fatalf("expect 1 pod, got %d", len(result))
+		}
+		wantContainers := 1
+		if include {
+			wantContainers = 2
+		}
+		if got := len(result[0].Containers); got != wantContainers {
+			t.Errorf("include %v: got %d containers, want %d", include, got, wantContainers)
+		}
+		// the pause container is summed into the pod totals either way
+		cpu := result[0].CPU
+		if cpu == nil || cpu.UsageCoreNanoSeconds == nil || *cpu.UsageCoreNanoSeconds != 1.1e9 {
+			t.Errorf("include %v: unexpected pod cpu stats %#v", include, cpu)
+		}
+	}
+}
+
+func TestRuntimeCapabilities(t *testing.T) {
+	for _, c := range []struct {
+		runtimeVersion string
+		want           bool
+	}{
+		{runtimeVersion: "v1.0.3", want: false},
+		{runtimeVersion: "v1.7.2-k3s1", want: true},
+	} {
+		rt := newTestPodsRuntimeService(2)
+		rt.version = runtimeapi.VersionResponse{RuntimeName: "containerd", RuntimeVersion: c.runtimeVersion}
+		p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, rt, nil, CRIStatsProviderConfig{})
+
+		caps := p.RuntimeCapabilities()
+		if caps.WritableLayerFsId != c.want || caps.SandboxStatsFilter != c.want {
+			t.Errorf("%s: unexpected capabilities %s", c.runtimeVersion, caps)
+		}
+		// the stats of the other sandbox are dropped without the filter
+		ps, err := p.GetPodStats("uid1")
+		if err != nil {
+			t.Fatalf("%s: GetPodStats: %v", c.runtimeVersion, err)
+		}
+		if len(ps.Containers) != 1 || ps.Containers[0].Name != "ctr" {
+			t.Errorf("%s: unexpected containers %#v", c.runtimeVersion, ps.Containers)
+		}
+	}
+
+	// unknown runtimes are assumed to support all the capabilities
+	caps := newRuntimeCapabilities(&runtimeapi.VersionResponse{RuntimeName: "cri-o", RuntimeVersion: "0.1"})
+	if !caps.WritableLayerFsId || !caps.SandboxStatsFilter {
+		t.Errorf("unexpected capabilities of unknown runtime %s", caps)
+	}
+}
+
+func TestAddCadvisorContainerStatsCPUUsagePercent(t *testing.T) {
+	p := newCRIStatsProvider(nil, nil, nil, CRIStatsProviderConfig{})
+	newInfo := func(usageNanoCores uint64, spec cadvisorapiv2.CpuSpec) *cadvisorapiv2.ContainerInfo {
+		return &cadvisorapiv2.ContainerInfo{
+			Spec: cadvisorapiv2.ContainerSpec{HasCpu: true, Cpu: spec},
+			Stats: []*cadvisorapiv2.ContainerStats{
+				{
+					Timestamp: time.Now(),
+					Cpu:       &cadvisorapiv1.CpuStats{},
+					CpuInst:   &cadvisorapiv2.CpuInstStats{Usage: cadvisorapiv2.CpuInstUsage{Total: usageNanoCores}},
+				},
+			},
+		}
+	}
+	percent := func(v *float64) string {
+		if v == nil {
+			return "nil"
+		}
+		return fmt.Sprintf("%.0f", *v)
+	}
+
+	for _, c := range []struct {
+		name           string
+		usageNanoCores uint64
+		spec           cadvisorapiv2.CpuSpec
+		wantLimit      string
+		wantRequest    string
+	}{
+		{
+			name:           "throttled",
+			usageNanoCores: 2.2e9,
+			spec:           cadvisorapiv2.CpuSpec{Quota: 200000, Period: 100000, Limit: 512},
+			wantLimit:      "110",
+			wantRequest:    "440",
+		},
+		{
+			name:           "well under",
+			usageNanoCores: 0.5e9,
+			spec:           cadvisorapiv2.CpuSpec{Quota: 400000, Period: 100000, Limit: 2048},
+			wantLimit:      "12",
+			wantRequest:    "25",
+		},
+		{
+			name:           "no limit",
+			usageNanoCores: 1e9,
+			spec:           cadvisorapiv2.CpuSpec{Limit: defaultCPUShares},
+			wantLimit:      "nil",
+			wantRequest:    "nil",
+		},
+	} {
+		cs := &ContainerStats{}
+		p.addCadvisorContainerStats(cs, newInfo(c.usageNanoCores, c.spec))
+		if got := percent(cs.CPU.UsageLimitPercent); got != c.wantLimit {
+			t.Errorf("%s: limit percent %s, want %s", c.name, got, c.wantLimit)
+		}
+		if got := percent(cs.CPU.UsageRequestPercent); got != c.wantRequest {
+			t.Errorf("%s: request percent %s, want %s", c.name, got, c.wantRequest)
+		}
+	}
+}
+
+func TestCadvisorRequestOptions(t *testing.T) {
+	maxAge := 5 * time.Second
+	for _, c := range []struct {
+		opts *cadvisorapiv2.RequestOptions
+		want cadvisorapiv2.RequestOptions
+	}{
+		{
+			opts: nil,
+			want: defaultCadvisorRequestOptions(),
+		},
+		{
+			opts: &cadvisorapiv2.RequestOptions{IdType: cadvisorapiv2.TypeName, Count: 3, Recursive: true, MaxAge: &maxAge},
+			want: cadvisorapiv2.RequestOptions{IdType: cadvisorapiv2.TypeName, Count: 3, Recursive: true, MaxAge: &maxAge},
+		},
+	} {
+		ca := &fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}
+		p := newCRIStatsProvider(ca, newTestPodsRuntimeService(1), nil, CRIStatsProviderConfig{
+			CadvisorRequestOptions: c.opts,
+		})
+		if _, err := p.ListPodStats(); err != nil {
+			t.Fatalf("ListPodStats: %v", err)
+		}
+		if !reflect.DeepEqual(ca.requestOptions, c.want) {
+			t.Errorf("request options %#v, want %#v", ca.requestOptions, c.want)
+		}
+	}
+}
+
+func TestListPodStatsRetryTransientError(t *testing.T) {
+	backoff := criListRetryBackoff
+	criListRetryBackoff = time.Millisecond
+	defer func() { criListRetryBackoff = backoff }()
+
+	rt := newTestPodsRuntimeService(1)
+	rt.listContainersErrs = []error{status.Error(codes.Unavailable, "runtime restarting")}
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, rt, nil, CRIStatsProviderConfig{})
+	result, err := p.ListPodStats()
+	if err != nil {
+		t.Fatalf("ListPodStats should succeed on retry: %v", err)
+	}
+	if len(result) != 1 {
+		t.Errorf("expect 1 pod, got %d", len(result))
+	}
+	if stats := p.GetProviderStats(); stats.CRIListRetries != 1 || stats.CRIListRetryFailures != 0 {
+		t.Errorf("unexpected provider stats %#v", stats)
+	}
+
+	// non transient errors are not retried
+	rt.listContainersErrs = []error{status.Error(codes.Internal, "broken")}
+	if _, err := p.ListPodStats(); status.Code(errors.Cause(err)) != codes.Internal {
+		t.Errorf("expect the internal error, got %v", err)
+	}
+	// retries are bounded
+	rt.listContainersErrs = []error{
+		status.Error(codes.DeadlineExceeded, "timeout"),
+		status.Error(codes.DeadlineExceeded, "timeout"),
+		status.Error(codes.DeadlineExceeded, "timeout"),
+	}
+	if _, err := p.ListPodStats(); err == nil {
+		t.Errorf("expect error after the retries are exhausted")
+	}
+	if stats := p.GetProviderStats(); stats.CRIListRetries != 3 || stats.CRIListRetryFailures != 1 {
+		t.Errorf("unexpected provider stats %#v", stats)
+	}
+}
+
+func TestRetryCRIListContextDone(t *testing.T) {
+	backoff := criListRetryBackoff
+	criListRetryBackoff = time.Hour
+	defer func() { criListRetryBackoff = backoff }()
+
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, nil, nil, CRIStatsProviderConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := p.retryCRIList(ctx, "ListContainers", func() error {
+		calls++
+		cancel()
+		return status.Error(codes.Unavailable, "runtime restarting")
+	})
+	if errors.Cause(err) != context.Canceled {
+		t.Errorf("expect the backoff stopped by the context, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expect 1 call, got %d", calls)
+	}
+}
+
+func TestListPodStatsLimits(t *testing.T) {
+	newInfo := func(name string, cpu cadvisorapiv2.CpuSpec, memoryLimit uint64) cadvisorapiv2.ContainerInfo {
+		return cadvisorapiv2.ContainerInfo{
+			Spec: cadvisorapiv2.ContainerSpec{
+				Labels: map[string]string{
+					KubernetesPodNameLabel:       "pod0",
+					KubernetesPodNamespaceLabel:  "ns",
+					KubernetesContainerNameLabel: name,
+				},
+				HasCpu:    true,
+				Cpu:       cpu,
+				HasMemory: true,
+				Memory:    cadvisorapiv2.MemorySpec{Limit: memoryLimit},
+			},
+		}
+	}
+
+	for _, c := range []struct {
+		name       string
+		ctr1Memory uint64
+		wantCPU    string
+		wantMemory string
+	}{
+		{name: "limited", ctr1Memory: 512 << 20, wantCPU: "6.0", wantMemory: "1610612736"},
+		{name: "unlimited memory", ctr1Memory: math.MaxUint64, wantCPU: "6.0", wantMemory: "nil"},
+	} {
+		rt := newTestPodsRuntimeService(1)
+		rt.containers = append(rt.containers, &runtimeapi.Container{
+			Id:           "ctr1",
+			PodSandboxId: "sandbox0",
+			Metadata:     &runtimeapi.ContainerMetadata{Name: "ctr1"},
+			State:        runtimeapi.ContainerState_CONTAINER_RUNNING,
+		})
+		ctr1Stats := newTestCPUStats("ctr1", time.Now(), 1e9)
+		ctr1Stats.Attributes.Metadata = &runtimeapi.ContainerMetadata{Name: "ctr1"}
+		rt.containerStats = append(rt.containerStats, ctr1Stats)
+		ca := &fakeCadvisor{
+			machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 8},
+			infos: map[string]cadvisorapiv2.ContainerInfo{
+				// 2 cores of cfs quota
+				"/cloudpods/ctr0": newInfo("ctr0", cadvisorapiv2.CpuSpec{Quota: 200000, Period: 100000, Mask: "0-7"}, 1<<30),
+				// 4 cpus allocated from the cpu map
+				"/cloudpods/ctr1": newInfo("ctr1", cadvisorapiv2.CpuSpec{Mask: "0-3"}, c.ctr1Memory),
+			},
+		}
+		p := newCRIStatsProvider(ca, rt, nil, CRIStatsProviderConfig{})
+		result, err := p.ListPodStats()
+		if err != nil {
+			t.Fatalf("%s: ListPodStats: %v", c.name, err)
+		}
+		if len(result) != 1 {
+			t.Fatalf("%s: expect 1 pod, got %d", c.name, len(result))
+		}
+		gotCPU, gotMemory := "nil", "nil"
+		if v := result[0].CPULimitCores; v != nil {
+			gotCPU = fmt.Sprintf("%.1f", *v)
+		}
+		if v := result[0].MemoryLimitBytes; v != nil {
+			gotMemory = fmt.Sprintf("%d", *v)
+		}
+		if gotCPU != c.wantCPU || gotMemory != c.wantMemory {
+			t.Errorf("%s: got cpu limit %s memory limit %s, want %s %s", c.name, gotCPU, gotMemory, c.wantCPU, c.wantMemory)
+		}
+	}
+}
+
+func TestListPodStatsNetworkFromContainers(t *testing.T) {
+	now := time.Now()
+	newInfo := func(name string, ts time.Time, ifaces ...cadvisorapiv1.InterfaceStats) cadvisorapiv2.ContainerInfo {
+		return cadvisorapiv2.ContainerInfo{
+			Spec: cadvisorapiv2.ContainerSpec{
+				Labels: map[string]string{
+					KubernetesPodNameLabel:       "pod0",
+					KubernetesPodNamespaceLabel:  "ns",
+					KubernetesContainerNameLabel: name,
+				},
+				HasNetwork: true,
+			},
+			Stats: []*cadvisorapiv2.ContainerStats{{
+				Timestamp: ts,
+				Network:   &cadvisorapiv2.NetworkStats{Interfaces: ifaces},
+			}},
+		}
+	}
+
+	rt := newTestPodsRuntimeService(1)
+	rt.containers = append(rt.containers, &runtimeapi.Container{
+		Id:           "ctr1",
+		PodSandboxId: "sandbox0",
+		Metadata:     &runtimeapi.ContainerMetadata{Name: "ctr1"},
+		State:        runtimeapi.ContainerState_CONTAINER_RUNNING,
+	})
+	ctr1Stats := newTestCPUStats("ctr1", now, 1e9)
+	ctr1Stats.Attributes.Metadata = &runtimeapi.ContainerMetadata{Name: "ctr1"}
+	rt.containerStats = append(rt.containerStats, ctr1Stats)
+	// no cadvisor entry of the sandbox, the containers share the network
+	// namespace, ctr1 is sampled later
+	ca := &fakeCadvisor{
+		infos: map[string]cadvisorapiv2.ContainerInfo{
+			"/cloudpods/ctr0": newInfo("ctr0", now.Add(-time.Second),
+				cadvisorapiv1.InterfaceStats{Name: "eth0", RxBytes: 100, TxBytes: 10},
+				cadvisorapiv1.InterfaceStats{Name: "bond0", RxBytes: 1000, TxBytes: 100},
+			),
+			"/cloudpods/ctr1": newInfo("ctr1", now,
+				cadvisorapiv1.InterfaceStats{Name: "eth0", RxBytes: 200, TxBytes: 20},
+				cadvisorapiv1.InterfaceStats{Name: "bond0", RxBytes: 2000, TxBytes: 200},
+				cadvisorapiv1.InterfaceStats{Name: "net1", RxBytes: 5, TxBytes: 5},
+			),
+		},
+	}
+	p := newCRIStatsProvider(ca, rt, nil, CRIStatsProviderConfig{})
+	result, err := p.ListPodStats()
+	if err != nil {
+		t.Fatalf("ListPodStats: %v", err)
+	}
+	if len(result) != 1 || result[0].Network == nil {
+		t.Fatalf("expect network stats of 1 pod, got %#v", result)
+	}
+	network := result[0].Network
+	if network.Name != "eth0" || *network.RxBytes != 200 || *network.TxBytes != 20 {
+		t.Errorf("default interface %s rx %d tx %d", network.Name, *network.RxBytes, *network.TxBytes)
+	}
+	got := map[string]uint64{}
+	for _, iface := range network.Interfaces {
+		got[iface.Name] = *iface.RxBytes
+	}
+	want := map[string]uint64{"eth0": 200, "bond0": 2000, "net1": 5}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("interfaces rx bytes %v, want %v", got, want)
+	}
+}
+
+type fakeSandboxMetrics struct {
+	metrics *SandboxMetrics
+}
+
+func (f *fakeSandboxMetrics) PodSandboxMetrics(ctx context.Context, podSandbox *runtimeapi.PodSandbox) (*SandboxMetrics, error) {
+	return f.metrics, nil
+}
+
+func TestListPodStatsSandboxedRuntime(t *testing.T) {
+	now := time.Now()
+	rt := newTestPodsRuntimeService(2)
+	rt.sandboxes[0].RuntimeHandler = "kata"
+	// cadvisor reads the cgroups of the sandbox processes of the kata pod
+	newInfo := func(pod string) cadvisorapiv2.ContainerInfo {
+		return cadvisorapiv2.ContainerInfo{
+			Spec: cadvisorapiv2.ContainerSpec{
+				Labels: map[string]string{
+					KubernetesPodNameLabel:       pod,
+					KubernetesPodNamespaceLabel:  "ns",
+					KubernetesContainerNameLabel: "ctr",
+				},
+				HasNetwork: true,
+			},
+			Stats: []*cadvisorapiv2.ContainerStats{{
+				Timestamp: now,
+				Network: &cadvisorapiv2.NetworkStats{Interfaces: []cadvisorapiv1.InterfaceStats{
+					{Name: "eth0", RxBytes: 100, TxBytes: 10},
+				}},
+				Processes: &cadvisorapiv1.ProcessStats{ProcessCount: 3},
+			}},
+		}
+	}
+	ca := &fakeCadvisor{
+		infos: map[string]cadvisorapiv2.ContainerInfo{
+			"/cloudpods/ctr0": newInfo("pod0"),
+			"/cloudpods/ctr1": newInfo("pod1"),
+		},
+	}
+
+	podStats := func(result []PodStats, name string) PodStats {
+		for _, ps := range result {
+			if ps.PodRef.Name == name {
+				return ps
+			}
+		}
+		t.Fatalf("stats of %s not found in %#v", name, result)
+		return PodStats{}
+	}
+
+	p := newCRIStatsProvider(ca, rt, nil, CRIStatsProviderConfig{})
+	result, err := p.ListPodStats()
+	if err != nil {
+		t.Fatalf("ListPodStats: %v", err)
+	}
+	kata := podStats(result, "pod0")
+	if kata.Network != nil || kata.ProcessStats != nil || kata.DiskIo != nil {
+		t.Errorf("expect the stats unavailable of the kata pod nil, got network %#v, process %#v, diskio %#v", kata.Network, kata.ProcessStats, kata.DiskIo)
+	}
+	if kata.CPU == nil || *kata.CPU.UsageCoreNanoSeconds != 1e9 {
+		t.Errorf("expect the cpu of the kata pod from CRI, got %#v", kata.CPU)
+	}
+	if len(kata.Containers) != 1 || kata.Containers[0].Memory != nil || kata.Containers[0].ProcessStats != nil {
+		t.Errorf("expect the memory and process stats of the kata container nil, got %#v", kata.Containers)
+	}
+	runc := podStats(result, "pod1")
+	if runc.Network == nil || runc.ProcessStats == nil || runc.ProcessStats.ProcessCount != 3 {
+		t.Errorf("expect the cadvisor stats of the runc pod, got network %#v, process %#v", runc.Network, runc.ProcessStats)
+	}
+
+	// the runtime metrics are preferred
+	rxBytes := uint64(500)
+	p = newCRIStatsProvider(ca, rt, nil, CRIStatsProviderConfig{
+		SandboxMetrics: &fakeSandboxMetrics{metrics: &SandboxMetrics{
+			Network:      &NetworkStats{InterfaceStats: InterfaceStats{Name: "eth0", RxBytes: &rxBytes}},
+			ProcessStats: &ProcessStats{ProcessCount: 7},
+		}},
+	})
+	result, err = p.ListPodStats()
+	if err != nil {
+		t.Fatalf("ListPodStats: %v", err)
+	}
+	kata = podStats(result, "pod0")
+	if kata.Network == nil || *kata.Network.RxBytes != rxBytes {
+		t.Errorf("expect the network of the kata pod from the runtime, got %#v", kata.Network)
+	}
+	if kata.ProcessStats == nil || kata.ProcessStats.ProcessCount != 7 {
+		t.Errorf("expect the process stats of the kata pod from the runtime, got %#v", kata.ProcessStats)
+	}
+}
+
+func TestListContainerStatsPerSandbox(t *testing.T) {
+	for _, c := range []struct {
+		runtimeVersion string
+		threshold      int
+		wantRequests   int32
+	}{
+		// one request per pod sandbox, the 2 containers of sandbox0 share one
+		{runtimeVersion: "v1.7.2", threshold: 2, wantRequests: 5},
+		// below the threshold
+		{runtimeVersion: "v1.7.2", threshold: 10, wantRequests: 1},
+		{runtimeVersion: "v1.7.2", threshold: 0, wantRequests: 1},
+		// the runtime ignores the pod sandbox filter
+		{runtimeVersion: "v1.0.3", threshold: 2, wantRequests: 1},
+	} {
+		rt := newTestPodsRuntimeService(5)
+		rt.version = runtimeapi.VersionResponse{RuntimeName: "containerd", RuntimeVersion: c.runtimeVersion}
+		rt.containers = append(rt.containers, &runtimeapi.Container{
+			Id:           "sidecar",
+			PodSandboxId: "sandbox0",
+			Metadata:     &runtimeapi.ContainerMetadata{Name: "sidecar"},
+			State:        runtimeapi.ContainerState_CONTAINER_RUNNING,
+		})
+		rt.containerStats = append(rt.containerStats, &runtimeapi.ContainerStats{
+			Attributes: &runtimeapi.ContainerAttributes{Id: "sidecar", Metadata: &runtimeapi.ContainerMetadata{Name: "sidecar"}},
+		})
+		p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, rt, nil, CRIStatsProviderConfig{ListContainerStatsPerPodThreshold: c.threshold})
+
+		stats, err := p.listContainerStats(context.Background(), "", rt.containers)
+		if err != nil {
+			t.Fatalf("%s threshold %d: listContainerStats: %v", c.runtimeVersion, c.threshold, err)
+		}
+		if len(stats) != len(rt.containers) {
+			t.Errorf("%s threshold %d: got %d stats, want %d", c.runtimeVersion, c.threshold, len(stats), len(rt.containers))
+		}
+		if got := atomic.LoadInt32(&rt.statsRequests); got != c.wantRequests {
+			t.Errorf("%s threshold %d: got %d requests, want %d", c.runtimeVersion, c.threshold, got, c.wantRequests)
+		}
+	}
+}
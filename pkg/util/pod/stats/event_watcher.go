@@ -0,0 +1,98 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"github.com/google/cadvisor/events"
+	cadvisorapiv1 "github.com/google/cadvisor/info/v1"
+	"k8s.io/klog/v2"
+
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/util/pod/cadvisor"
+)
+
+// containerEventWatcher subscribes to the cadvisor container creation and
+// deletion events and calls onEvent with the container id, the event type
+// and the time of the event.
+type containerEventWatcher struct {
+	cadvisor cadvisor.Interface
+	channel  *events.EventChannel
+	onEvent  func(containerID string, eventType cadvisorapiv1.EventType, at time.Time)
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func startContainerEventWatcher(ca cadvisor.Interface, onEvent func(containerID string, eventType cadvisorapiv1.EventType, at time.Time)) (*containerEventWatcher, error) {
+	if ca == nil {
+		return nil, errors.Error("cadvisor is nil")
+	}
+	channel, err := ca.WatchEvents(&events.Request{
+		EventType: map[cadvisorapiv1.EventType]bool{
+			cadvisorapiv1.EventContainerCreation: true,
+			cadvisorapiv1.EventContainerDeletion: true,
+		},
+		ContainerName:        "/",
+		IncludeSubcontainers: true,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "watch cadvisor events")
+	}
+	w := &containerEventWatcher{
+		cadvisor: ca,
+		channel:  channel,
+		onEvent:  onEvent,
+		stopCh:   make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+func (w *containerEventWatcher) run() {
+	defer w.wg.Done()
+	ch := w.channel.GetChannel()
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if event == nil {
+				continue
+			}
+			// same container id convention as getCRICadvisorStats
+			containerID := path.Base(event.ContainerName)
+			klog.V(5).Infof("cadvisor event %s of container %s", event.EventType, containerID)
+			w.onEvent(containerID, event.EventType, event.Timestamp)
+		}
+	}
+}
+
+func (w *containerEventWatcher) stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+		w.cadvisor.CloseEventChannel(w.channel.GetWatchId())
+		w.wg.Wait()
+	})
+}
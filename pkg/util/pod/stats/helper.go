@@ -17,6 +17,7 @@ package stats
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -63,6 +64,7 @@ func cadvisorInfoToCPUandMemoryStats(info *cadvisorapiv2.ContainerInfo) (*CPUSta
 	if info.Spec.HasCpu {
 		if cstat.CpuInst != nil {
 			cpuStats.UsageNanoCores = &cstat.CpuInst.Usage.Total
+			setCPUUsagePercent(cpuStats, info.Spec.Cpu)
 		}
 		if cstat.Cpu != nil {
 			cpuStats.UsageCoreNanoSeconds = &cstat.Cpu.Usage.Total
@@ -84,15 +86,114 @@ func cadvisorInfoToCPUandMemoryStats(info *cadvisorapiv2.ContainerInfo) (*CPUSta
 			availableBytes := info.Spec.Memory.Limit - cstat.Memory.WorkingSet
 			memoryStats.AvailableBytes = &availableBytes
 		}
+		memoryStats.NumaNodes = cadvisorNumaStatsToNumaNodeMemoryStats(cstat.Memory.ContainerData.NumaStats)
+		setSwapStats(memoryStats, &info.Spec, cstat.Memory)
 	} else {
 		memoryStats = &MemoryStats{
 			Time:            metav1.NewTime(cstat.Timestamp),
 			WorkingSetBytes: uint64Ptr(0),
 		}
 	}
+	if info.Spec.HasHugetlb && cstat.Hugetlb != nil {
+		memoryStats.HugePages = cadvisorHugetlbToHugePageStats(*cstat.Hugetlb)
+	}
 	return cpuStats, memoryStats
 }
 
+// setSwapStats fills the swap stats from cadvisor, the CRI stats don't carry
+// swap. A zero swap usage, which is also reported when swap accounting is
+// disabled, leaves the stats nil.
+func setSwapStats(memoryStats *MemoryStats, spec *cadvisorapiv2.ContainerSpec, cstat *cadvisorapiv1.MemoryStats) {
+	if cstat == nil || cstat.Swap == 0 {
+		return
+	}
+	swapUsage := cstat.Swap
+	memoryStats.SwapUsageBytes = &swapUsage
+	if spec == nil || !spec.HasMemory || spec.Memory.SwapLimit == 0 || isMemoryUnlimited(spec.Memory.SwapLimit) {
+		return
+	}
+	var swapAvailable uint64
+	if spec.Memory.SwapLimit > swapUsage {
+		swapAvailable = spec.Memory.SwapLimit - swapUsage
+	}
+	memoryStats.SwapAvailableBytes = &swapAvailable
+}
+
+// cadvisorHugetlbToHugePageStats returns nil when no hugepage size is reported.
+func cadvisorHugetlbToHugePageStats(hugetlb map[string]cadvisorapiv1.HugetlbStats) map[string]HugePageStats {
+	if len(hugetlb) == 0 {
+		return nil
+	}
+	ret := make(map[string]HugePageStats, len(hugetlb))
+	for pageSize, stat := range hugetlb {
+		ret[pageSize] = HugePageStats{
+			UsageBytes:    stat.Usage,
+			MaxUsageBytes: stat.MaxUsage,
+			Failcnt:       stat.Failcnt,
+		}
+	}
+	return ret
+}
+
+// cadvisorNumaStatsToNumaNodeMemoryStats returns nil when cadvisor doesn't report numa stats.
+func cadvisorNumaStatsToNumaNodeMemoryStats(numaStats cadvisorapiv1.MemoryNumaStats) []NumaNodeMemoryStats {
+	nodes := make(map[uint8]*NumaNodeMemoryStats)
+	getNode := func(id uint8) *NumaNodeMemoryStats {
+		node, ok := nodes[id]
+		if !ok {
+			node = &NumaNodeMemoryStats{Node: id}
+			nodes[id] = node
+		}
+		return node
+	}
+	for id, pages := range numaStats.File {
+		getNode(id).FilePages = pages
+	}
+	for id, pages := range numaStats.Anon {
+		getNode(id).AnonPages = pages
+	}
+	for id, pages := range numaStats.Unevictable {
+		getNode(id).UnevictablePages = pages
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	ret := make([]NumaNodeMemoryStats, 0, len(nodes))
+	for _, node := range nodes {
+		ret = append(ret, *node)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Node < ret[j].Node })
+	return ret
+}
+
+const (
+	// defaultCPUShares are the cpu shares of a cgroup without cpu request,
+	// which can't be told from requesting exactly one cpu.
+	defaultCPUShares = 1024
+	// minCPUShares are the cpu shares set by kubelet for no cpu request.
+	minCPUShares = 2
+)
+
+// setCPUUsagePercent sets the usage percent of the cpu limit and request
+// from the cfs quota and the cpu shares of the cgroup, the percent is left
+// nil if the limit or the request is unknown.
+func setCPUUsagePercent(cpu *CPUStats, spec cadvisorapiv2.CpuSpec) {
+	if cpu.UsageNanoCores == nil {
+		return
+	}
+	usageCores := float64(*cpu.UsageNanoCores) / 1e9
+	if spec.Quota > 0 && spec.Period > 0 {
+		limitCores := float64(spec.Quota) / float64(spec.Period)
+		percent := usageCores / limitCores * 100
+		cpu.UsageLimitPercent = &percent
+	}
+	if spec.Limit > minCPUShares && spec.Limit != defaultCPUShares {
+		requestCores := float64(spec.Limit) / defaultCPUShares
+		percent := usageCores / requestCores * 100
+		cpu.UsageRequestPercent = &percent
+	}
+}
+
 // latestContainerStats returns the latest container stats from cadvisor, or nil if none exist
 func latestContainerStats(info *cadvisorapiv2.ContainerInfo) (*cadvisorapiv2.ContainerStats, bool) {
 	stats := info.Stats
@@ -154,6 +255,47 @@ func cadvisorInfoToNetworkStats(info *cadvisorapiv2.ContainerInfo) *NetworkStats
 	return &iStats
 }
 
+// mergeNetworkStats merges the network stats of a container of a pod into
+// the stats of the pod. The interfaces are keyed on the name, since the
+// containers of a pod share the network namespace, an interface seen by
+// several containers, e.g. a bond, is counted once with the latest sample
+// instead of being summed.
+func mergeNetworkStats(dst, src *NetworkStats) *NetworkStats {
+	if src == nil {
+		return dst
+	}
+	if dst == nil {
+		dst = &NetworkStats{}
+	}
+	for _, iface := range src.Interfaces {
+		found := false
+		for i := range dst.Interfaces {
+			if dst.Interfaces[i].Name != iface.Name {
+				continue
+			}
+			found = true
+			if src.Time.After(dst.Time.Time) {
+				dst.Interfaces[i] = iface
+			}
+			break
+		}
+		if !found {
+			dst.Interfaces = append(dst.Interfaces, iface)
+		}
+	}
+	if src.Time.After(dst.Time.Time) {
+		dst.Time = src.Time
+	}
+	dst.InterfaceStats = InterfaceStats{}
+	for _, iface := range dst.Interfaces {
+		if iface.Name == defaultNetworkInterfaceName {
+			dst.InterfaceStats = iface
+			break
+		}
+	}
+	return dst
+}
+
 // cadvisorInfoToUserDefinedMetrics returns the statsapi.UserDefinedMetric
 // converted from the container info from cadvisor.
 func cadvisorInfoToUserDefinedMetrics(info *cadvisorapiv2.ContainerInfo) []UserDefinedMetric {
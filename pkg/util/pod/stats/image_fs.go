@@ -0,0 +1,132 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"time"
+
+	cadvisorapiv2 "github.com/google/cadvisor/info/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// containerImageLayers looks up the read-only image layers of the containers
+// of a collection, each image and the image filesystem are only queried once
+// per collection.
+type containerImageLayers struct {
+	p   *criStatsProvider
+	ctx context.Context
+	// fsIDtoInfo is the filesystem cache of the collection.
+	fsIDtoInfo map[runtimeapi.FilesystemIdentifier]*cadvisorapiv2.FsInfo
+	// imageSizes are the sizes of the images by reference, 0 if unknown.
+	imageSizes map[string]uint64
+	// imageFs is the usage of the image filesystem, nil if unknown.
+	imageFs        *runtimeapi.FilesystemUsage
+	imageFsFetched bool
+}
+
+// newContainerImageLayers returns nil without the image service, so no
+// image layer is reported.
+func (p *criStatsProvider) newContainerImageLayers(ctx context.Context, fsIDtoInfo map[runtimeapi.FilesystemIdentifier]*cadvisorapiv2.FsInfo) *containerImageLayers {
+	if p.imageService == nil {
+		return nil
+	}
+	return &containerImageLayers{
+		p:          p,
+		ctx:        ctx,
+		fsIDtoInfo: fsIDtoInfo,
+		imageSizes: make(map[string]uint64),
+	}
+}
+
+// imageFsStats returns the stats of the image layers of the container. It's
+// nil when the runtime doesn't report the size of the image, which is
+// counted in the writable layer by some runtimes, so Rootfs isn't
+// duplicated.
+func (l *containerImageLayers) imageFsStats(container *runtimeapi.Container) *FsStats {
+	if l == nil {
+		return nil
+	}
+	size := l.imageSize(container)
+	if size == 0 {
+		return nil
+	}
+	result := &FsStats{
+		Time:      metav1.NewTime(time.Now()),
+		UsedBytes: &size,
+	}
+	imageFs := l.imageFilesystem()
+	if imageFs == nil {
+		return result
+	}
+	if imageFs.Timestamp > 0 {
+		result.Time = metav1.NewTime(time.Unix(0, imageFs.Timestamp))
+	}
+	if fsID := imageFs.GetFsId(); fsID != nil {
+		fsInfo, found := l.fsIDtoInfo[*fsID]
+		if !found {
+			fsInfo = l.p.getFsInfo(fsID)
+			l.fsIDtoInfo[*fsID] = fsInfo
+		}
+		if fsInfo != nil {
+			result.AvailableBytes = &fsInfo.Available
+			result.CapacityBytes = &fsInfo.Capacity
+			result.InodesFree = fsInfo.InodesFree
+			result.Inodes = fsInfo.Inodes
+		}
+	}
+	return result
+}
+
+func (l *containerImageLayers) imageSize(container *runtimeapi.Container) uint64 {
+	ref := container.GetImageRef()
+	if ref == "" {
+		ref = container.GetImage().GetImage()
+	}
+	if ref == "" {
+		return 0
+	}
+	if size, ok := l.imageSizes[ref]; ok {
+		return size
+	}
+	var size uint64
+	resp, err := l.p.imageService.ImageStatus(l.ctx, &runtimeapi.ImageStatusRequest{
+		Image: &runtimeapi.ImageSpec{Image: ref},
+	})
+	if err != nil {
+		l.p.loggerFromContext(l.ctx).V(4).Info("Unable to get image status", "image", ref, "err", err)
+	} else {
+		size = resp.GetImage().GetSize_()
+	}
+	l.imageSizes[ref] = size
+	return size
+}
+
+func (l *containerImageLayers) imageFilesystem() *runtimeapi.FilesystemUsage {
+	if l.imageFsFetched {
+		return l.imageFs
+	}
+	l.imageFsFetched = true
+	resp, err := l.p.imageService.ImageFsInfo(l.ctx, &runtimeapi.ImageFsInfoRequest{})
+	if err != nil {
+		l.p.loggerFromContext(l.ctx).V(4).Info("Unable to get image filesystem info", "err", err)
+		return nil
+	}
+	if filesystems := resp.GetImageFilesystems(); len(filesystems) > 0 {
+		l.imageFs = filesystems[0]
+	}
+	return l.imageFs
+}
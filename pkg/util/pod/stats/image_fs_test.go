@@ -0,0 +1,94 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"testing"
+
+	cadvisorapiv1 "github.com/google/cadvisor/info/v1"
+	cadvisorapiv2 "github.com/google/cadvisor/info/v2"
+	"google.golang.org/grpc"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+type fakeImageService struct {
+	runtimeapi.ImageServiceClient
+
+	imageSizes map[string]uint64
+	imageFs    *runtimeapi.FilesystemUsage
+	// imageStatusCalls counts the ImageStatus requests
+	imageStatusCalls int
+}
+
+func (f *fakeImageService) ImageStatus(ctx context.Context, in *runtimeapi.ImageStatusRequest, opts ...grpc.CallOption) (*runtimeapi.ImageStatusResponse, error) {
+	f.imageStatusCalls++
+	size, ok := f.imageSizes[in.GetImage().GetImage()]
+	if !ok {
+		return &runtimeapi.ImageStatusResponse{}, nil
+	}
+	return &runtimeapi.ImageStatusResponse{Image: &runtimeapi.Image{Id: in.GetImage().GetImage(), Size_: size}}, nil
+}
+
+func (f *fakeImageService) ImageFsInfo(ctx context.Context, in *runtimeapi.ImageFsInfoRequest, opts ...grpc.CallOption) (*runtimeapi.ImageFsInfoResponse, error) {
+	resp := &runtimeapi.ImageFsInfoResponse{}
+	if f.imageFs != nil {
+		resp.ImageFilesystems = []*runtimeapi.FilesystemUsage{f.imageFs}
+	}
+	return resp, nil
+}
+
+func TestListPodStatsImageFs(t *testing.T) {
+	rt := newTestPodsRuntimeService(3)
+	rt.containers[0].ImageRef = "sha256:app"
+	rt.containers[1].ImageRef = "sha256:app"
+	// the size of the image isn't reported
+	rt.containers[2].ImageRef = "sha256:unknown"
+	images := &fakeImageService{
+		imageSizes: map[string]uint64{"sha256:app": 1 << 20},
+		imageFs: &runtimeapi.FilesystemUsage{
+			Timestamp: 1,
+			FsId:      &runtimeapi.FilesystemIdentifier{Mountpoint: "/var/lib/containerd"},
+		},
+	}
+	ca := &fakeCadvisor{
+		machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4},
+		dirFsInfos: map[string]cadvisorapiv2.FsInfo{
+			"/var/lib/containerd": {Capacity: 100 << 30, Available: 60 << 30},
+		},
+	}
+	p := newCRIStatsProvider(ca, rt, images, CRIStatsProviderConfig{})
+
+	result, err := p.ListPodStats()
+	if err != nil {
+		t.Fatalf("ListPodStats: %v", err)
+	}
+	for _, ps := range result {
+		imageFs := ps.Containers[0].ImageFs
+		if ps.PodRef.Name == "pod2" {
+			if imageFs != nil {
+				t.Errorf("expect no image fs of unknown image, got %#v", imageFs)
+			}
+			continue
+		}
+		if imageFs == nil || getUint64Value(imageFs.UsedBytes) != 1<<20 || getUint64Value(imageFs.CapacityBytes) != 100<<30 {
+			t.Errorf("unexpected image fs of %s: %#v", ps.PodRef.Name, imageFs)
+		}
+	}
+	// the image of the same ref is queried once
+	if images.imageStatusCalls != 2 {
+		t.Errorf("expect 2 image status calls, got %d", images.imageStatusCalls)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	cadvisorapiv2 "github.com/google/cadvisor/info/v2"
+
+	"yunion.io/x/onecloud/pkg/util/cgrouputils/cpuset"
+)
+
+// podLimitState accumulates the cpu and memory limits of the containers of
+// a pod. The limit of the pod is unknown once the limit of any container is
+// unknown or unlimited.
+type podLimitState struct {
+	cpuCores      float64
+	cpuUnknown    bool
+	memoryBytes   uint64
+	memoryUnknown bool
+}
+
+// addContainerLimits records the limits of a container of the pod. The cpu
+// limit is the cfs quota of the container, or the size of its cpuset when
+// the cpus are allocated from the cpu map.
+func (p *criStatsProvider) addContainerLimits(state *podLimitState, caInfo *cadvisorapiv2.ContainerInfo) {
+	if caInfo == nil {
+		state.cpuUnknown = true
+		state.memoryUnknown = true
+		return
+	}
+
+	spec := caInfo.Spec
+	if !spec.HasMemory || isMemoryUnlimited(spec.Memory.Limit) {
+		state.memoryUnknown = true
+	} else {
+		state.memoryBytes += spec.Memory.Limit
+	}
+
+	if !spec.HasCpu {
+		state.cpuUnknown = true
+		return
+	}
+	if spec.Cpu.Quota > 0 && spec.Cpu.Period > 0 {
+		state.cpuCores += float64(spec.Cpu.Quota) / float64(spec.Cpu.Period)
+		return
+	}
+	cpus, err := cpuset.Parse(spec.Cpu.Mask)
+	if err != nil || cpus.IsEmpty() || cpus.Size() >= p.getNumCPUs() {
+		state.cpuUnknown = true
+		return
+	}
+	state.cpuCores += float64(cpus.Size())
+}
+
+// apply sets the known limits to the pod stats.
+func (s *podLimitState) apply(ps *PodStats) {
+	if s == nil {
+		return
+	}
+	if !s.cpuUnknown {
+		cores := s.cpuCores
+		ps.CPULimitCores = &cores
+	}
+	if !s.memoryUnknown {
+		bytes := s.memoryBytes
+		ps.MemoryLimitBytes = &bytes
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// podStatsCache shares a ListPodStats result among the callers within the
+// ttl, and a single collection among the concurrent callers on a miss.
+type podStatsCache struct {
+	ttl   time.Duration
+	group singleflight.Group
+
+	lock      sync.Mutex
+	result    []PodStats
+	updatedAt time.Time
+
+	// hits, misses and shared are counted atomically. A miss is a
+	// collection, shared counts the callers which got a collection result
+	// shared with the other concurrent callers.
+	hits   uint64
+	misses uint64
+	shared uint64
+}
+
+func newPodStatsCache(ttl time.Duration) *podStatsCache {
+	return &podStatsCache{ttl: ttl}
+}
+
+func (c *podStatsCache) get() ([]PodStats, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.result == nil || time.Since(c.updatedAt) >= c.ttl {
+		return nil, false
+	}
+	return c.result, true
+}
+
+func (c *podStatsCache) set(result []PodStats) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.result = result
+	c.updatedAt = time.Now()
+}
+
+// list returns the cached result or the one collected by collect. The
+// result is shared by the callers, only the slice is copied, so the pod
+// stats must not be modified. A partial result collected with an error is
+// returned along with the error but never cached.
+func (c *podStatsCache) list(collect func() ([]PodStats, error)) ([]PodStats, error) {
+	if result, ok := c.get(); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return copyPodStats(result), nil
+	}
+	v, err, shared := c.group.Do("", func() (interface{}, error) {
+		// the caller of the previous collection may have just refreshed it
+		if result, ok := c.get(); ok {
+			atomic.AddUint64(&c.hits, 1)
+			return result, nil
+		}
+		atomic.AddUint64(&c.misses, 1)
+		result, err := collect()
+		if err != nil {
+			return result, err
+		}
+		c.set(result)
+		return result, nil
+	})
+	if shared {
+		atomic.AddUint64(&c.shared, 1)
+	}
+	result, _ := v.([]PodStats)
+	if err != nil {
+		if result == nil {
+			return nil, err
+		}
+		return copyPodStats(result), err
+	}
+	return copyPodStats(result), nil
+}
+
+func copyPodStats(result []PodStats) []PodStats {
+	ret := make([]PodStats, len(result))
+	copy(ret, result)
+	return ret
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPodStatsCacheSingleFlight(t *testing.T) {
+	cache := newPodStatsCache(time.Minute)
+	var collections int32
+	release := make(chan struct{})
+	collect := func() ([]PodStats, error) {
+		n := atomic.AddInt32(&collections, 1)
+		<-release
+		return []PodStats{{PodRef: PodReference{Name: fmt.Sprintf("collection%d", n)}}}, nil
+	}
+
+	const callers = 50
+	results := make([][]PodStats, callers)
+	errs := make([]error, callers)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = cache.list(collect)
+		}(i)
+	}
+	// hold the collection until the other callers pile up behind it
+	for atomic.LoadInt32(&collections) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&collections); n != 1 {
+		t.Fatalf("expect a single collection, got %d", n)
+	}
+	for i := 0; i < callers; i++ {
+		if errs[i] != nil || len(results[i]) != 1 || results[i][0].PodRef.Name != "collection1" {
+			t.Fatalf("caller %d got %v, %v", i, results[i], errs[i])
+		}
+	}
+	if cache.misses != 1 || cache.hits+cache.shared < callers-1 {
+		t.Errorf("hits %d misses %d shared %d", cache.hits, cache.misses, cache.shared)
+	}
+	// the callers get their own slice of the shared result
+	results[0][0].PodRef.Name = "modified"
+	if results[1][0].PodRef.Name != "collection1" {
+		t.Errorf("result slice is shared among callers")
+	}
+}
+
+func TestPodStatsCacheExpiry(t *testing.T) {
+	cache := newPodStatsCache(20 * time.Millisecond)
+	collections := 0
+	var collectErr error
+	collect := func() ([]PodStats, error) {
+		collections++
+		if collectErr != nil {
+			return nil, collectErr
+		}
+		return []PodStats{}, nil
+	}
+
+	cache.list(collect)
+	cache.list(collect)
+	if collections != 1 {
+		t.Fatalf("expect the result cached within ttl, got %d collections", collections)
+	}
+	time.Sleep(30 * time.Millisecond)
+	// a failed collection is not cached
+	collectErr = fmt.Errorf("runtime unavailable")
+	if _, err := cache.list(collect); err == nil {
+		t.Fatalf("expect the collection error")
+	}
+	collectErr = nil
+	if _, err := cache.list(collect); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if collections != 3 {
+		t.Errorf("expect a collection after expiry and after an error, got %d collections", collections)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
+)
+
+// klogSink writes the log lines of the provider to klog with the structured
+// logging calls, the level of a line is the klog verbosity.
+type klogSink struct {
+	// depth is the number of the frames between the caller of the logger
+	// and the sink
+	depth  int
+	name   string
+	values []interface{}
+}
+
+func newKlogLogger() logr.Logger {
+	return logr.New(&klogSink{})
+}
+
+func (s *klogSink) Init(info logr.RuntimeInfo) {
+	s.depth = info.CallDepth + 1
+}
+
+func (s *klogSink) Enabled(level int) bool {
+	return klog.V(klog.Level(level)).Enabled()
+}
+
+func (s *klogSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	klog.InfoSDepth(s.depth, s.message(msg), s.keysAndValues(keysAndValues)...)
+}
+
+func (s *klogSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	klog.ErrorSDepth(s.depth, err, s.message(msg), s.keysAndValues(keysAndValues)...)
+}
+
+func (s *klogSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	ret := *s
+	ret.values = s.keysAndValues(keysAndValues)
+	return &ret
+}
+
+func (s *klogSink) WithName(name string) logr.LogSink {
+	ret := *s
+	if ret.name != "" {
+		name = ret.name + "/" + name
+	}
+	ret.name = name
+	return &ret
+}
+
+func (s *klogSink) WithCallDepth(depth int) logr.LogSink {
+	ret := *s
+	ret.depth += depth
+	return &ret
+}
+
+func (s *klogSink) message(msg string) string {
+	if s.name == "" {
+		return msg
+	}
+	return s.name + ": " + msg
+}
+
+// keysAndValues returns a new slice, the values of the sink are shared by
+// the loggers derived from it.
+func (s *klogSink) keysAndValues(keysAndValues []interface{}) []interface{} {
+	ret := make([]interface{}, 0, len(s.values)+len(keysAndValues))
+	ret = append(ret, s.values...)
+	return append(ret, keysAndValues...)
+}
+
+// hostLogger returns the logger of the provider with the host id, which is
+// looked up on every call since the host may register after the provider
+// is created.
+func (p *criStatsProvider) hostLogger() logr.Logger {
+	if p.config.HostId != nil {
+		if hostId := p.config.HostId(); hostId != "" {
+			return p.logger.WithValues("hostId", hostId)
+		}
+	}
+	return p.logger
+}
+
+// newCollectionLogger returns the logger of a stats collection, the log
+// lines of the collection are correlated by the collection id. The logger
+// is also carried by the returned context for the CRI requests.
+func (p *criStatsProvider) newCollectionLogger(ctx context.Context) (context.Context, logr.Logger) {
+	collectionId := atomic.AddUint64(&p.collections, 1)
+	logger := p.hostLogger().WithValues("collectionId", collectionId)
+	return logr.NewContext(ctx, logger), logger
+}
+
+// loggerFromContext returns the collection logger carried by the context,
+// or the host logger outside of a collection.
+func (p *criStatsProvider) loggerFromContext(ctx context.Context) logr.Logger {
+	if logger, err := logr.FromContext(ctx); err == nil {
+		return logger
+	}
+	return p.hostLogger()
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	cadvisorapiv1 "github.com/google/cadvisor/info/v1"
+)
+
+func TestListPodStatsLogContext(t *testing.T) {
+	lines := []string{}
+	logger := funcr.New(func(prefix, args string) {
+		lines = append(lines, args)
+	}, funcr.Options{Verbosity: 5})
+	hostId := ""
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, newTestPodsRuntimeService(2), nil, CRIStatsProviderConfig{
+		HostId: func() string { return hostId },
+		Logger: logger,
+	})
+
+	for i, id := range []string{"", "host0"} {
+		hostId = id
+		lines = lines[:0]
+		if _, err := p.ListPodStats(); err != nil {
+			t.Fatalf("ListPodStats: %v", err)
+		}
+		var collected string
+		for _, line := range lines {
+			if strings.Contains(line, `"Collected pod stats"`) {
+				collected = line
+			}
+		}
+		if collected == "" {
+			t.Fatalf("no collection log line in %v", lines)
+		}
+		for _, field := range []string{`"podCount"=2`, fmt.Sprintf(`"collectionId"=%d`, i+1)} {
+			if !strings.Contains(collected, field) {
+				t.Errorf("%s not found in %s", field, collected)
+			}
+		}
+		// the host id is left out until the host is registered
+		if hasHostId := strings.Contains(collected, `"hostId"="host0"`); hasHostId != (id != "") {
+			t.Errorf("host id %q of %s", id, collected)
+		}
+	}
+}
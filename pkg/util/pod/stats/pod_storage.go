@@ -0,0 +1,146 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/go-logr/logr"
+	cadvisorapiv2 "github.com/google/cadvisor/info/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// makePodStorageStats fills the ephemeral storage of the pod with the
+// rootfs usage of its containers plus the usage of its log directory. The
+// volume stats are not collected since there is no volume stats analyzer.
+func (p *criStatsProvider) makePodStorageStats(logger logr.Logger, s *PodStats, rootFsInfo *cadvisorapiv2.FsInfo) {
+	var logStats *FsStats
+	if p.config.PodLogsDirectory != nil {
+		podUID := s.PodRef.SandboxUID
+		if podUID == "" {
+			podUID = s.PodRef.UID
+		}
+		podLogDir := p.config.PodLogsDirectory(podUID)
+		var err error
+		logStats, err = getPodLogStats(podLogDir, rootFsInfo)
+		if err != nil {
+			// the log usage is left out, the ephemeral storage of the
+			// containers is still reported
+			logger.Error(err, "Unable to fetch pod log stats", "path", podLogDir)
+		}
+	}
+	s.EphemeralStorage = calcEphemeralStorage(s.Containers, rootFsInfo, logStats)
+}
+
+// getPodLogStats gets the usage of the files under the pod log directory,
+// which holds the logs of all the containers of the pod. The stats are nil
+// if the directory doesn't exist, e.g. the pod hasn't started yet.
+func getPodLogStats(path string, rootFsInfo *cadvisorapiv2.FsInfo) (*FsStats, error) {
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var usedBytes, inodesUsed uint64
+	err := filepath.WalkDir(path, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// a log file rotated away during the walk
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		inodesUsed++
+		usedBytes += diskUsage(info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := &FsStats{
+		Time:           metav1.NewTime(rootFsInfo.Timestamp),
+		AvailableBytes: &rootFsInfo.Available,
+		CapacityBytes:  &rootFsInfo.Capacity,
+		InodesFree:     rootFsInfo.InodesFree,
+		Inodes:         rootFsInfo.Inodes,
+		UsedBytes:      &usedBytes,
+		InodesUsed:     &inodesUsed,
+	}
+	return result, nil
+}
+
+// diskUsage returns the allocated bytes of the file like du, so a sparse
+// or preallocated log file is counted by its real usage.
+func diskUsage(info fs.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Blocks) * 512
+	}
+	return uint64(info.Size())
+}
+
+// calcEphemeralStorage sums the rootfs usage of the containers and the
+// usage of the pod logs, the latter may be nil.
+func calcEphemeralStorage(containers []ContainerStats, rootFsInfo *cadvisorapiv2.FsInfo, podLogStats *FsStats) *FsStats {
+	result := &FsStats{
+		Time:           metav1.NewTime(rootFsInfo.Timestamp),
+		AvailableBytes: &rootFsInfo.Available,
+		CapacityBytes:  &rootFsInfo.Capacity,
+		InodesFree:     rootFsInfo.InodesFree,
+		Inodes:         rootFsInfo.Inodes,
+	}
+	for i := range containers {
+		rootfs := containers[i].Rootfs
+		if rootfs == nil {
+			continue
+		}
+		result.UsedBytes = addUsage(result.UsedBytes, rootfs.UsedBytes)
+		result.InodesUsed = addUsage(result.InodesUsed, rootfs.InodesUsed)
+		result.Time = maxUpdateTime(&result.Time, &rootfs.Time)
+	}
+	if podLogStats != nil {
+		result.UsedBytes = addUsage(result.UsedBytes, podLogStats.UsedBytes)
+		result.InodesUsed = addUsage(result.InodesUsed, podLogStats.InodesUsed)
+		result.Time = maxUpdateTime(&result.Time, &podLogStats.Time)
+	}
+	return result
+}
+
+func addUsage(first, second *uint64) *uint64 {
+	if first == nil {
+		return second
+	} else if second == nil {
+		return first
+	}
+	total := *first + *second
+	return &total
+}
+
+func maxUpdateTime(first, second *metav1.Time) metav1.Time {
+	if first.Before(second) {
+		return *second
+	}
+	return *first
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestListPodStatsEphemeralStorage(t *testing.T) {
+	logsRoot := t.TempDir()
+	// the log of the container of uid0, uid1 has no log directory
+	logDir := filepath.Join(logsRoot, "uid0", "logs")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(logDir, "ctr0.log"), bytes.Repeat([]byte("x"), 8192), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rt := newTestPodsRuntimeService(2)
+	for _, stats := range rt.containerStats {
+		stats.WritableLayer = &runtimeapi.FilesystemUsage{
+			Timestamp:  time.Now().UnixNano(),
+			UsedBytes:  &runtimeapi.UInt64Value{Value: 4096},
+			InodesUsed: &runtimeapi.UInt64Value{Value: 3},
+		}
+	}
+	p := newCRIStatsProvider(&fakeCadvisor{}, rt, nil, CRIStatsProviderConfig{
+		PodLogsDirectory: func(podUID string) string {
+			return filepath.Join(logsRoot, podUID, "logs")
+		},
+	})
+	result, err := p.ListPodStats()
+	if err != nil {
+		t.Fatalf("ListPodStats: %v", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("expect 2 pods, got %d", len(result))
+	}
+	for _, ps := range result {
+		es := ps.EphemeralStorage
+		if es == nil || es.UsedBytes == nil || es.InodesUsed == nil {
+			t.Fatalf("pod %s: ephemeral storage not reported: %#v", ps.PodRef.Name, es)
+		}
+		switch ps.PodRef.SandboxUID {
+		case "uid0":
+			// the rootfs plus the log directory and file
+			if *es.UsedBytes < 4096+8192 || *es.InodesUsed != 3+2 {
+				t.Errorf("pod with logs: used bytes %d inodes %d", *es.UsedBytes, *es.InodesUsed)
+			}
+		case "uid1":
+			if *es.UsedBytes != 4096 || *es.InodesUsed != 3 {
+				t.Errorf("pod without logs: used bytes %d inodes %d", *es.UsedBytes, *es.InodesUsed)
+			}
+		}
+	}
+}
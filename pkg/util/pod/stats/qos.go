@@ -0,0 +1,118 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	cadvisorapiv2 "github.com/google/cadvisor/info/v2"
+
+	"yunion.io/x/onecloud/pkg/util/cgrouputils/cpuset"
+)
+
+// PodQOSClass is the quality of service class of a pod. It's derived from
+// the containers of the pod:
+//   - Guaranteed: every container is pinned to exclusive cpus and has a
+//     memory limit.
+//   - Burstable: some container is pinned or has a memory limit.
+//   - BestEffort: no container is pinned nor has a memory limit.
+//   - Unknown: the pinning or the memory limit of a container can't be told,
+//     e.g. cadvisor has no info of it yet.
+//
+// The pinning of a pod is told by CRIStatsProviderConfig.PodCPUPinning when
+// it's set, otherwise a container is considered pinned when its cpuset is
+// narrower than the cpus of the host.
+type PodQOSClass string
+
+const (
+	PodQOSGuaranteed PodQOSClass = "Guaranteed"
+	PodQOSBurstable  PodQOSClass = "Burstable"
+	PodQOSBestEffort PodQOSClass = "BestEffort"
+	PodQOSUnknown    PodQOSClass = "Unknown"
+)
+
+// OOMScoreAdj returns the oom_score_adj of the processes of a pod of the
+// class, so that best effort pods are killed first and guaranteed pods last
+// on out of memory.
+func (c PodQOSClass) OOMScoreAdj() int {
+	switch c {
+	case PodQOSGuaranteed:
+		return -997
+	case PodQOSBestEffort:
+		return 1000
+	default:
+		return 500
+	}
+}
+
+// NewPodQOSClass returns the class of a pod of the containers, of which
+// pinned are pinned to exclusive cpus and memoryLimited have a memory limit.
+func NewPodQOSClass(containers, pinned, memoryLimited int) PodQOSClass {
+	state := &podQOSState{
+		containers:    containers,
+		pinned:        pinned,
+		memoryLimited: memoryLimited,
+	}
+	return state.class()
+}
+
+// podQOSState accumulates the qos inputs of the containers of a pod.
+type podQOSState struct {
+	containers    int
+	unknown       bool
+	pinned        int
+	memoryLimited int
+}
+
+func (s *podQOSState) class() PodQOSClass {
+	switch {
+	case s == nil || s.unknown || s.containers == 0:
+		return PodQOSUnknown
+	case s.pinned == s.containers && s.memoryLimited == s.containers:
+		return PodQOSGuaranteed
+	case s.pinned > 0 || s.memoryLimited > 0:
+		return PodQOSBurstable
+	default:
+		return PodQOSBestEffort
+	}
+}
+
+// addContainerQOS records the qos inputs of a container of the pod.
+func (p *criStatsProvider) addContainerQOS(state *podQOSState, podUID string, caInfo *cadvisorapiv2.ContainerInfo) {
+	state.containers++
+	if caInfo == nil || !caInfo.Spec.HasMemory || !caInfo.Spec.HasCpu {
+		state.unknown = true
+		return
+	}
+	if !isMemoryUnlimited(caInfo.Spec.Memory.Limit) {
+		state.memoryLimited++
+	}
+
+	if p.config.PodCPUPinning != nil {
+		exclusive, known := p.config.PodCPUPinning(podUID)
+		if !known {
+			state.unknown = true
+		} else if exclusive {
+			state.pinned++
+		}
+		return
+	}
+	cpus, err := cpuset.Parse(caInfo.Spec.Cpu.Mask)
+	if err != nil || cpus.IsEmpty() {
+		state.unknown = true
+		return
+	}
+	if cpus.Size() < p.getNumCPUs() {
+		state.pinned++
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"yunion.io/x/pkg/errors"
+)
+
+var (
+	// defaultCRIListRetries is the default number of retries of a top
+	// level CRI list request failed with a transient error.
+	defaultCRIListRetries = 2
+	// criListRetryBackoff is the backoff before the first retry, it's
+	// doubled for each further retry, so 2 retries add at most 300ms.
+	criListRetryBackoff = 100 * time.Millisecond
+)
+
+// ProviderStats are the counters of the stats provider itself.
+type ProviderStats struct {
+	// CRIListRetries is the number of the retried CRI list requests.
+	CRIListRetries uint64 `json:"cri_list_retries"`
+	// CRIListRetryFailures is the number of the CRI list requests still
+	// failed after all the retries.
+	CRIListRetryFailures uint64 `json:"cri_list_retry_failures"`
+	// ListPodStatsCacheHits is the number of the ListPodStats calls served
+	// from the cache.
+	ListPodStatsCacheHits uint64 `json:"list_pod_stats_cache_hits"`
+	// ListPodStatsCacheMisses is the number of the collections of the
+	// cached ListPodStats.
+	ListPodStatsCacheMisses uint64 `json:"list_pod_stats_cache_misses"`
+	// ListPodStatsCacheShared is the number of the ListPodStats calls
+	// which shared a collection with the concurrent calls.
+	ListPodStatsCacheShared uint64 `json:"list_pod_stats_cache_shared"`
+	// StaleContainers is the number of the containers whose stats were
+	// stale in their latest collection.
+	StaleContainers uint64 `json:"stale_containers"`
+	// ContainerCreateLatency is the histogram of the latency from the
+	// creation of a container to its first stats, nil if disabled by
+	// CRIStatsProviderConfig.CreateLatencyBuckets.
+	ContainerCreateLatency *LatencyHistogram `json:"container_create_latency,omitempty"`
+	// ObservedContainers is the number of the running containers seen by
+	// the latest host-wide collection.
+	ObservedContainers uint64 `json:"observed_containers"`
+	// SkippedContainers is the number of the containers left out of the
+	// latest collection by CRIStatsProviderConfig.MaxContainersPerCollection.
+	SkippedContainers uint64 `json:"skipped_containers"`
+}
+
+// isTransientCRIError tells whether a CRI request may succeed on retry.
+func isTransientCRIError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryCRIList calls f until it succeeds, fails with a non transient error
+// or the retries are exhausted.
+func (p *criStatsProvider) retryCRIList(ctx context.Context, name string, f func() error) error {
+	backoff := criListRetryBackoff
+	for retry := 0; ; retry++ {
+		err := f()
+		if err == nil || !isTransientCRIError(err) {
+			return err
+		}
+		if retry >= p.config.CRIListRetries {
+			if retry > 0 {
+				atomic.AddUint64(&p.criListRetryFailures, 1)
+			}
+			return err
+		}
+		atomic.AddUint64(&p.criListRetries, 1)
+		p.loggerFromContext(ctx).V(4).Info("CRI list failed with transient error", "request", name, "retryIn", backoff, "err", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrapf(ctx.Err(), "%s retry %d", name, retry+1)
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (p *criStatsProvider) listContainers(ctx context.Context, req *runtimeapi.ListContainersRequest) (*runtimeapi.ListContainersResponse, error) {
+	var resp *runtimeapi.ListContainersResponse
+	err := p.retryCRIList(ctx, "ListContainers", func() error {
+		var err error
+		resp, err = p.runtimeService.ListContainers(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (p *criStatsProvider) listPodSandbox(ctx context.Context, req *runtimeapi.ListPodSandboxRequest) (*runtimeapi.ListPodSandboxResponse, error) {
+	var resp *runtimeapi.ListPodSandboxResponse
+	err := p.retryCRIList(ctx, "ListPodSandbox", func() error {
+		var err error
+		resp, err = p.runtimeService.ListPodSandbox(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// GetProviderStats returns the counters of the provider.
+func (p *criStatsProvider) GetProviderStats() ProviderStats {
+	stats := ProviderStats{
+		CRIListRetries:       atomic.LoadUint64(&p.criListRetries),
+		CRIListRetryFailures: atomic.LoadUint64(&p.criListRetryFailures),
+		StaleContainers:      p.countStaleContainers(),
+		ObservedContainers:   atomic.LoadUint64(&p.observedContainers),
+		SkippedContainers:    atomic.LoadUint64(&p.skippedContainers),
+	}
+	if p.createLatency != nil {
+		stats.ContainerCreateLatency = p.createLatency.histogram()
+	}
+	if p.listCache != nil {
+		stats.ListPodStatsCacheHits = atomic.LoadUint64(&p.listCache.hits)
+		stats.ListPodStatsCacheMisses = atomic.LoadUint64(&p.listCache.misses)
+		stats.ListPodStatsCacheShared = atomic.LoadUint64(&p.listCache.shared)
+	}
+	return stats
+}
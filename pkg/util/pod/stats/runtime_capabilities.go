@@ -0,0 +1,104 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+
+	"yunion.io/x/pkg/util/version"
+)
+
+// RuntimeCapabilities are the version dependent stats features of the CRI
+// runtime, detected once from the runtime version.
+type RuntimeCapabilities struct {
+	RuntimeName       string `json:"runtime_name"`
+	RuntimeVersion    string `json:"runtime_version"`
+	RuntimeApiVersion string `json:"runtime_api_version"`
+
+	// WritableLayerFsId is true when the runtime reports the filesystem of
+	// the container writable layer, which is looked up for the rootfs
+	// capacity of the container.
+	WritableLayerFsId bool `json:"writable_layer_fs_id"`
+	// SandboxStatsFilter is true when ListContainerStats honours the pod
+	// sandbox id filter, otherwise the stats of all the containers are
+	// listed and the unrelated ones are dropped.
+	SandboxStatsFilter bool `json:"sandbox_stats_filter"`
+}
+
+func (c RuntimeCapabilities) String() string {
+	return fmt.Sprintf("%s %s (api %s): writable_layer_fs_id=%v sandbox_stats_filter=%v",
+		c.RuntimeName, c.RuntimeVersion, c.RuntimeApiVersion, c.WritableLayerFsId, c.SandboxStatsFilter)
+}
+
+// runtimeCapabilityVersions are the minimum runtime versions of the
+// capabilities, a new version dependent feature adds its field here.
+type runtimeCapabilityVersions struct {
+	writableLayerFsId  string
+	sandboxStatsFilter string
+}
+
+// runtimeCapabilityRules are keyed on the runtime name, the runtimes not
+// listed are assumed to support all the capabilities.
+var runtimeCapabilityRules = map[string]runtimeCapabilityVersions{
+	// the cri plugin is built into containerd since 1.1
+	"containerd": {
+		writableLayerFsId:  "1.1",
+		sandboxStatsFilter: "1.1",
+	},
+}
+
+// normalizeRuntimeVersion strips the v prefix and the pre-release or build
+// suffix of a semver runtime version, e.g. v1.7.2-k3s1 to 1.7.2.
+func normalizeRuntimeVersion(v string) string {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	return v
+}
+
+func newRuntimeCapabilities(resp *runtimeapi.VersionResponse) RuntimeCapabilities {
+	caps := RuntimeCapabilities{
+		RuntimeName:        resp.GetRuntimeName(),
+		RuntimeVersion:     resp.GetRuntimeVersion(),
+		RuntimeApiVersion:  resp.GetRuntimeApiVersion(),
+		WritableLayerFsId:  true,
+		SandboxStatsFilter: true,
+	}
+	rule, ok := runtimeCapabilityRules[caps.RuntimeName]
+	if !ok {
+		return caps
+	}
+	ver := normalizeRuntimeVersion(caps.RuntimeVersion)
+	caps.WritableLayerFsId = version.GE(ver, rule.writableLayerFsId)
+	caps.SandboxStatsFilter = version.GE(ver, rule.sandboxStatsFilter)
+	return caps
+}
+
+// detectRuntimeCapabilities queries the runtime version, all the
+// capabilities are assumed when the version is unavailable.
+func detectRuntimeCapabilities(runtimeService runtimeapi.RuntimeServiceClient) RuntimeCapabilities {
+	resp, err := runtimeService.Version(context.Background(), &runtimeapi.VersionRequest{})
+	if err != nil {
+		klog.Warningf("failed to get runtime version, assume all the stats capabilities: %v", err)
+		resp = &runtimeapi.VersionResponse{}
+	}
+	return newRuntimeCapabilities(resp)
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// SandboxedRuntimeAnnotation marks a pod sandbox as sandboxed when its
+// runtime handler isn't one of CRIStatsProviderConfig.SandboxedRuntimeHandlers,
+// its value is "true" or "false".
+const SandboxedRuntimeAnnotation = "io.yunion.pod.sandboxed-runtime"
+
+// defaultSandboxedRuntimeHandlers are the runtime handlers of kata and gvisor
+// as they are usually configured in containerd.
+var defaultSandboxedRuntimeHandlers = []string{
+	"kata", "kata-qemu", "kata-clh", "kata-fc", "kata-dragonball",
+	"runsc", "gvisor",
+}
+
+// SandboxMetrics are the stats of a pod measured by its sandboxed runtime,
+// the ones the runtime doesn't report are nil.
+type SandboxMetrics struct {
+	CPU          *CPUStats
+	Memory       *MemoryStats
+	Network      *NetworkStats
+	ProcessStats *ProcessStats
+	DiskIo       DiskIoStats
+}
+
+// SandboxMetricsSource is the metrics api of a sandboxed runtime, e.g. the
+// metrics of the kata shim, which measures the pod inside the sandbox.
+type SandboxMetricsSource interface {
+	PodSandboxMetrics(ctx context.Context, podSandbox *runtimeapi.PodSandbox) (*SandboxMetrics, error)
+}
+
+// isSandboxedPod tells whether the containers of the pod run inside a vm or
+// a user space kernel, whose cgroups can't be read by cadvisor on the host.
+// The cgroups cadvisor reads for such a pod are of the sandbox processes, so
+// the stats of the pod come from CRI and the SandboxMetrics instead.
+func (p *criStatsProvider) isSandboxedPod(podSandbox *runtimeapi.PodSandbox) bool {
+	switch podSandbox.GetAnnotations()[SandboxedRuntimeAnnotation] {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	handler := podSandbox.GetRuntimeHandler()
+	if handler == "" {
+		return false
+	}
+	handlers := p.config.SandboxedRuntimeHandlers
+	if handlers == nil {
+		handlers = defaultSandboxedRuntimeHandlers
+	}
+	for _, h := range handlers {
+		if h == handler {
+			return true
+		}
+	}
+	return false
+}
+
+// clearUnreportedSandboxedStats leaves the stats of a sandboxed container
+// which CRI doesn't report nil instead of the zero values filled for a
+// standard container, so they aren't mistaken for an idle container.
+func clearUnreportedSandboxedStats(cs *ContainerStats, stats *runtimeapi.ContainerStats) {
+	if stats.GetCpu() == nil {
+		cs.CPU = nil
+	}
+	if stats.GetMemory() == nil {
+		cs.Memory = nil
+	}
+	cs.ProcessStats = nil
+	cs.DiskIo = nil
+}
+
+// addSandboxMetrics overrides the stats of a sandboxed pod summed from its
+// containers with the ones measured by the runtime.
+func (p *criStatsProvider) addSandboxMetrics(ctx context.Context, ps *PodStats, podSandbox *runtimeapi.PodSandbox) error {
+	if p.config.SandboxMetrics == nil {
+		return nil
+	}
+	metrics, err := p.config.SandboxMetrics.PodSandboxMetrics(ctx, podSandbox)
+	if err != nil {
+		return errors.Wrapf(err, "get metrics of sandboxed pod %s", podSandbox.GetId())
+	}
+	if metrics == nil {
+		return nil
+	}
+	if metrics.CPU != nil {
+		ps.CPU = metrics.CPU
+	}
+	if metrics.Memory != nil {
+		ps.Memory = metrics.Memory
+	}
+	if metrics.Network != nil {
+		ps.Network = metrics.Network
+	}
+	if metrics.ProcessStats != nil {
+		ps.ProcessStats = metrics.ProcessStats
+	}
+	if metrics.DiskIo != nil {
+		ps.DiskIo = metrics.DiskIo
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// podStatsToken is the state of a ListPodStatsSince caller, it's handed out
+// as base64 encoded json so the provider keeps no state per caller.
+type podStatsToken struct {
+	// Pods are the hashes of the gauges of the pods by uid.
+	Pods map[string]string `json:"pods"`
+}
+
+func decodePodStatsToken(token string) (*podStatsToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.Wrapf(errors.ErrInvalidFormat, "pod stats token: %v", err)
+	}
+	ret := &podStatsToken{}
+	if err := json.Unmarshal(data, ret); err != nil {
+		return nil, errors.Wrapf(errors.ErrInvalidFormat, "pod stats token: %v", err)
+	}
+	return ret, nil
+}
+
+func (t *podStatsToken) encode() string {
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ListPodStatsSince returns the stats of the pods whose gauges changed since
+// the listing the token was issued by, along with the token of this listing.
+// All the pods are returned for an empty token. Only the gauges are compared,
+// see podStatsGauges, so an idle pod isn't returned again while its
+// cumulative counters and timestamps advance. The pods gone since the token
+// are not reported.
+//
+// The partial stats are returned along with the error like ListPodStats,
+// the token only covers the returned pods then.
+func (p *criStatsProvider) ListPodStatsSince(token string) ([]PodStats, string, error) {
+	var prev *podStatsToken
+	if token != "" {
+		var err error
+		if prev, err = decodePodStatsToken(token); err != nil {
+			return nil, "", err
+		}
+	}
+	pods, err := p.ListPodStats()
+	if err != nil && pods == nil {
+		return nil, "", err
+	}
+	next := &podStatsToken{
+		Pods: make(map[string]string, len(pods)),
+	}
+	changed := make([]PodStats, 0, len(pods))
+	for i := range pods {
+		uid := pods[i].PodRef.UID
+		hash := podStatsHash(&pods[i])
+		next.Pods[uid] = hash
+		if prev == nil || prev.Pods[uid] != hash {
+			changed = append(changed, pods[i])
+		}
+	}
+	return changed, next.encode(), err
+}
+
+// podStatsGauges are the metrics of a pod a caller of ListPodStatsSince
+// diffs, the cumulative counters, e.g. the cpu usage seconds, the page faults
+// and the network bytes, advance on every sample and are left out.
+type podStatsGauges struct {
+	StartTime             int64
+	Ready                 bool
+	QOSClass              PodQOSClass
+	CPUUsageNanoCores     *uint64
+	MemoryWorkingSetBytes *uint64
+	MemoryUsageBytes      *uint64
+	CPULimitCores         *float64
+	MemoryLimitBytes      *uint64
+	EphemeralUsedBytes    *uint64
+	Containers            []containerStatsGauges
+}
+
+type containerStatsGauges struct {
+	Name                  string
+	StartTime             int64
+	CPUUsageNanoCores     *uint64
+	MemoryWorkingSetBytes *uint64
+	RootfsUsedBytes       *uint64
+}
+
+func newPodStatsGauges(ps *PodStats) *podStatsGauges {
+	g := &podStatsGauges{
+		StartTime:        ps.StartTime.Unix(),
+		Ready:            ps.Ready,
+		QOSClass:         ps.QOSClass,
+		CPULimitCores:    ps.CPULimitCores,
+		MemoryLimitBytes: ps.MemoryLimitBytes,
+	}
+	if ps.CPU != nil {
+		g.CPUUsageNanoCores = ps.CPU.UsageNanoCores
+	}
+	if ps.Memory != nil {
+		g.MemoryWorkingSetBytes = ps.Memory.WorkingSetBytes
+		g.MemoryUsageBytes = ps.Memory.UsageBytes
+	}
+	if ps.EphemeralStorage != nil {
+		g.EphemeralUsedBytes = ps.EphemeralStorage.UsedBytes
+	}
+	for i := range ps.Containers {
+		cs := &ps.Containers[i]
+		cg := containerStatsGauges{
+			Name:      cs.Name,
+			StartTime: cs.StartTime.Unix(),
+		}
+		if cs.CPU != nil {
+			cg.CPUUsageNanoCores = cs.CPU.UsageNanoCores
+		}
+		if cs.Memory != nil {
+			cg.MemoryWorkingSetBytes = cs.Memory.WorkingSetBytes
+		}
+		if cs.Rootfs != nil {
+			cg.RootfsUsedBytes = cs.Rootfs.UsedBytes
+		}
+		g.Containers = append(g.Containers, cg)
+	}
+	sort.Slice(g.Containers, func(i, j int) bool { return g.Containers[i].Name < g.Containers[j].Name })
+	return g
+}
+
+// podStatsHash hashes the gauges of the pod.
+func podStatsHash(ps *PodStats) string {
+	data, err := json.Marshal(newPodStatsGauges(ps))
+	if err != nil {
+		return ""
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	cadvisorapiv1 "github.com/google/cadvisor/info/v1"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"yunion.io/x/pkg/errors"
+)
+
+func TestListPodStatsSince(t *testing.T) {
+	rt := newTestPodsRuntimeService(2)
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, rt, nil, CRIStatsProviderConfig{})
+	names := func(pods []PodStats) map[string]bool {
+		ret := map[string]bool{}
+		for _, ps := range pods {
+			ret[ps.PodRef.Name] = true
+		}
+		return ret
+	}
+
+	pods, token, err := p.ListPodStatsSince("")
+	if err != nil {
+		t.Fatalf("ListPodStatsSince: %v", err)
+	}
+	if got := names(pods); len(got) != 2 {
+		t.Fatalf("expect all pods on the first call, got %v", got)
+	}
+
+	// pod0 is idle, only its timestamps and cumulative cpu usage advance,
+	// while the working set of pod1 grows
+	cpu0 := rt.containerStats[0].Cpu
+	rt.containerStats[0].Cpu = &runtimeapi.CpuUsage{
+		Timestamp:            cpu0.Timestamp + int64(time.Second),
+		UsageCoreNanoSeconds: &runtimeapi.UInt64Value{Value: cpu0.UsageCoreNanoSeconds.Value + 1e6},
+	}
+	rt.containerStats[1].Memory = &runtimeapi.MemoryUsage{
+		Timestamp:       rt.containerStats[1].Cpu.Timestamp + int64(time.Second),
+		WorkingSetBytes: &runtimeapi.UInt64Value{Value: 64 << 20},
+	}
+	pods, token2, err := p.ListPodStatsSince(token)
+	if err != nil {
+		t.Fatalf("ListPodStatsSince: %v", err)
+	}
+	if got := names(pods); len(got) != 1 || !got["pod1"] {
+		t.Errorf("expect only pod1 changed, got %v", got)
+	}
+
+	// both are idle now
+	cpu0 = rt.containerStats[0].Cpu
+	rt.containerStats[0].Cpu = &runtimeapi.CpuUsage{
+		Timestamp:            cpu0.Timestamp + int64(time.Second),
+		UsageCoreNanoSeconds: &runtimeapi.UInt64Value{Value: cpu0.UsageCoreNanoSeconds.Value + 1e6},
+	}
+	pods, _, err = p.ListPodStatsSince(token2)
+	if err != nil {
+		t.Fatalf("ListPodStatsSince: %v", err)
+	}
+	if len(pods) != 0 {
+		t.Errorf("expect no pod changed, got %v", names(pods))
+	}
+
+	if _, _, err := p.ListPodStatsSince("not a token"); errors.Cause(err) != errors.ErrInvalidFormat {
+		t.Errorf("expect ErrInvalidFormat for a bad token, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// StatsSnapshot is a ListPodStats result retained for post-mortem
+// debugging. The pods are kept as json so the snapshot can't be changed by
+// the callers of ListPodStats and its memory is exactly known.
+type StatsSnapshot struct {
+	Time time.Time       `json:"time"`
+	Pods json.RawMessage `json:"pods"`
+}
+
+// statsSnapshotRing retains the latest snapshots bounded by both the count
+// and the total size of the snapshots.
+type statsSnapshotRing struct {
+	maxCount int
+	maxBytes int
+	// file is overwritten with the latest snapshot if set.
+	file string
+
+	lock sync.Mutex
+	// snapshots are ordered from the oldest to the latest.
+	snapshots []StatsSnapshot
+	bytes     int
+}
+
+func newStatsSnapshotRing(maxCount, maxBytes int, file string) *statsSnapshotRing {
+	return &statsSnapshotRing{
+		maxCount:  maxCount,
+		maxBytes:  maxBytes,
+		file:      file,
+		snapshots: make([]StatsSnapshot, 0, maxCount),
+	}
+}
+
+func (r *statsSnapshotRing) add(now time.Time, pods []PodStats) {
+	data, err := json.Marshal(pods)
+	if err != nil {
+		klog.Warningf("failed to marshal stats snapshot: %v", err)
+		return
+	}
+	snapshot := StatsSnapshot{Time: now, Pods: data}
+	if r.file != "" {
+		r.writeFile(snapshot)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(data) > r.maxBytes {
+		klog.Warningf("stats snapshot of %d bytes exceeds the limit %d, not retained", len(data), r.maxBytes)
+		return
+	}
+	r.snapshots = append(r.snapshots, snapshot)
+	r.bytes += len(data)
+	for len(r.snapshots) > r.maxCount || r.bytes > r.maxBytes {
+		r.bytes -= len(r.snapshots[0].Pods)
+		r.snapshots = r.snapshots[1:]
+	}
+}
+
+func (r *statsSnapshotRing) writeFile(snapshot StatsSnapshot) {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		klog.Warningf("failed to marshal stats snapshot: %v", err)
+		return
+	}
+	// write to a temporary file first so a crash never leaves a torn one
+	tmp := r.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		klog.Warningf("failed to write stats snapshot %s: %v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, r.file); err != nil {
+		klog.Warningf("failed to rename stats snapshot to %s: %v", r.file, err)
+	}
+}
+
+// list returns the retained snapshots from the oldest to the latest.
+func (r *statsSnapshotRing) list() []StatsSnapshot {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	result := make([]StatsSnapshot, len(r.snapshots))
+	copy(result, r.snapshots)
+	return result
+}
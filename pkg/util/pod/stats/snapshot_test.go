@@ -0,0 +1,69 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatsSnapshotRing(t *testing.T) {
+	pods := func(name string) []PodStats {
+		return []PodStats{{PodRef: PodReference{Name: name}}}
+	}
+	size := func() int {
+		data, _ := json.Marshal(pods("pod0"))
+		return len(data)
+	}()
+	file := filepath.Join(t.TempDir(), "stats.json")
+
+	// bounded by count
+	r := newStatsSnapshotRing(2, 100*size, file)
+	now := time.Now()
+	for i, name := range []string{"pod0", "pod1", "pod2"} {
+		r.add(now.Add(time.Duration(i)*time.Second), pods(name))
+	}
+	snapshots := r.list()
+	if len(snapshots) != 2 || !snapshots[0].Time.Equal(now.Add(time.Second)) {
+		t.Fatalf("expect the latest 2 snapshots, got %d", len(snapshots))
+	}
+	var latest StatsSnapshot
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("read snapshot file: %v", err)
+	}
+	if err := json.Unmarshal(data, &latest); err != nil {
+		t.Fatalf("unmarshal snapshot file: %v", err)
+	}
+	if string(latest.Pods) != string(snapshots[1].Pods) {
+		t.Errorf("snapshot file %s is not the latest %s", latest.Pods, snapshots[1].Pods)
+	}
+
+	// bounded by size
+	r = newStatsSnapshotRing(10, 2*size, "")
+	for _, name := range []string{"pod0", "pod1", "pod2"} {
+		r.add(now, pods(name))
+	}
+	if n := len(r.list()); n != 2 {
+		t.Errorf("expect 2 snapshots within the size limit, got %d", n)
+	}
+	r.add(now, append(append(pods("pod3"), pods("pod4")...), pods("pod5")...))
+	if n := len(r.list()); n != 2 {
+		t.Errorf("a snapshot over the size limit should not be retained, got %d", n)
+	}
+}
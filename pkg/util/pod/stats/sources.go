@@ -0,0 +1,120 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"context"
+
+	cadvisorapiv2 "github.com/google/cadvisor/info/v2"
+	"golang.org/x/sync/errgroup"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// podStatsSources are the data the pod stats of a collection are built from.
+type podStatsSources struct {
+	// rootFsInfo is valid only if rootFsFound.
+	rootFsInfo  cadvisorapiv2.FsInfo
+	rootFsFound bool
+	// containers are the running containers.
+	containers []*runtimeapi.Container
+	// podSandboxes are the sandboxes with the terminated ones removed.
+	podSandboxes   []*runtimeapi.PodSandbox
+	containerStats []*runtimeapi.ContainerStats
+	// allInfos is empty if cadvisor failed.
+	allInfos              map[string]cadvisorapiv2.ContainerInfo
+	containerNetworkStats map[string]*NetworkStats
+}
+
+// fetchPodStatsSources fetches the data sources of the pod stats of the
+// sandbox, or of all the sandboxes when sandboxID is empty. The sources are
+// independent except the container stats, which are listed after the
+// containers, so they are fetched concurrently and the collection takes as
+// long as the slowest source instead of the sum of them.
+//
+// The error is returned if the containers or the sandboxes can't be listed,
+// which cancels the other requests. The failures of the other sources are
+// returned as errs, the stats are built without them.
+func (p *criStatsProvider) fetchPodStatsSources(ctx context.Context, sandboxID string) (src *podStatsSources, errs []error, err error) {
+	src = &podStatsSources{}
+	var rootFsErr, containerStatsErr, cadvisorErr, networkErr error
+
+	csReq := &runtimeapi.ListContainersRequest{}
+	sbReq := &runtimeapi.ListPodSandboxRequest{}
+	if sandboxID != "" {
+		csReq.Filter = &runtimeapi.ContainerFilter{PodSandboxId: sandboxID}
+		sbReq.Filter = &runtimeapi.PodSandboxFilter{Id: sandboxID}
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		// Gets node root filesystem information, which will be used to
+		// populate the available and capacity bytes/inodes in container stats.
+		src.rootFsInfo, rootFsErr = p.cadvisor.RootFsInfo()
+		src.rootFsFound = rootFsErr == nil
+		return nil
+	})
+	g.Go(func() error {
+		csResp, err := p.listContainers(gctx, csReq)
+		if err != nil {
+			return errors.Wrap(err, "failed to list all containers")
+		}
+		src.containers = p.capCollectionContainers(gctx, sandboxID, removeTerminatedContainers(csResp.Containers))
+		// the stats of the listed containers are kept on a partial failure
+		src.containerStats, containerStatsErr = p.listContainerStats(gctx, sandboxID, src.containers)
+		return nil
+	})
+	g.Go(func() error {
+		resp, err := p.listPodSandbox(gctx, sbReq)
+		if err != nil {
+			return errors.Wrap(err, "failed to list all pod sandboxes")
+		}
+		src.podSandboxes = removeTerminatedPods(resp.Items)
+		return nil
+	})
+	g.Go(func() error {
+		// the stats from CRI are still filled without cadvisor
+		src.allInfos, cadvisorErr = getCadvisorContainerInfo(p.cadvisor, *p.config.CadvisorRequestOptions)
+		if cadvisorErr != nil {
+			src.allInfos = map[string]cadvisorapiv2.ContainerInfo{}
+		}
+		return nil
+	})
+	g.Go(func() error {
+		// get network stats for containers.
+		// This is only used on Windows. For other platforms, (nil, nil) should be returned.
+		src.containerNetworkStats, networkErr = p.listContainerNetworkStats()
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	errs = make([]error, 0)
+	if rootFsErr != nil {
+		errs = append(errs, errors.Wrap(rootFsErr, "failed to get rootFs info"))
+	}
+	if containerStatsErr != nil {
+		errs = append(errs, containerStatsErr)
+	}
+	if cadvisorErr != nil {
+		errs = append(errs, errors.Wrap(cadvisorErr, "failed to fetch cadvisor stats"))
+	}
+	if networkErr != nil {
+		errs = append(errs, errors.Wrap(networkErr, "failed to list container network stats"))
+	}
+	return src, errs, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"time"
+
+	"github.com/go-logr/logr"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+var (
+	// defaultStaleStatsThreshold is the default time the cpu and memory
+	// timestamps of a container may stay unchanged before its stats are
+	// considered stale.
+	defaultStaleStatsThreshold = 2 * time.Minute
+)
+
+// statsStalenessRecord tracks the timestamps of the CRI stats of a container
+// across the collections.
+type statsStalenessRecord struct {
+	cpuTimestamp    int64
+	memoryTimestamp int64
+	// advancedAt is the collection time the timestamps were last seen
+	// changed.
+	advancedAt time.Time
+	// seenAt is the collection time the container was last seen.
+	seenAt time.Time
+	stale  bool
+}
+
+// updateStatsStaleness flags the container stats as stale when neither the
+// cpu nor the memory timestamp reported by the runtime has changed within
+// the threshold, e.g. the runtime keeps returning a cached sample of a
+// wedged shim. A container is logged once when its stats turn stale.
+func (p *criStatsProvider) updateStatsStaleness(logger logr.Logger, stats *runtimeapi.ContainerStats, cs *ContainerStats, now time.Time) {
+	threshold := p.config.StaleStatsThreshold
+	if threshold < 0 {
+		return
+	}
+	cpuTimestamp := stats.GetCpu().GetTimestamp()
+	memoryTimestamp := stats.GetMemory().GetTimestamp()
+	if cpuTimestamp == 0 && memoryTimestamp == 0 {
+		// no sample to compare
+		return
+	}
+	id := stats.GetAttributes().GetId()
+
+	p.mutex.Lock()
+	record, ok := p.statsStalenessCache[id]
+	if !ok || record.cpuTimestamp != cpuTimestamp || record.memoryTimestamp != memoryTimestamp {
+		p.statsStalenessCache[id] = &statsStalenessRecord{
+			cpuTimestamp:    cpuTimestamp,
+			memoryTimestamp: memoryTimestamp,
+			advancedAt:      now,
+			seenAt:          now,
+		}
+		p.mutex.Unlock()
+		return
+	}
+	record.seenAt = now
+	stale := now.Sub(record.advancedAt) >= threshold
+	turnedStale := stale && !record.stale
+	record.stale = stale
+	advancedAt := record.advancedAt
+	p.mutex.Unlock()
+
+	cs.Stale = stale
+	if turnedStale {
+		logger.Info("Container stats are stale", "containerId", id,
+			"cpuTimestamp", time.Unix(0, cpuTimestamp), "memoryTimestamp", time.Unix(0, memoryTimestamp),
+			"unchangedFor", now.Sub(advancedAt))
+	}
+}
+
+// countStaleContainers returns the number of the containers whose stats
+// were stale in their latest collection.
+func (p *criStatsProvider) countStaleContainers() uint64 {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	var count uint64
+	for _, record := range p.statsStalenessCache {
+		if record.stale {
+			count++
+		}
+	}
+	return count
+}
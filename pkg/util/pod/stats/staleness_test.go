@@ -0,0 +1,63 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	cadvisorapiv1 "github.com/google/cadvisor/info/v1"
+)
+
+func TestListPodStatsStaleContainers(t *testing.T) {
+	// the timestamps of the stats are frozen
+	rt := newTestPodsRuntimeService(2)
+	p := newCRIStatsProvider(&fakeCadvisor{machineInfo: &cadvisorapiv1.MachineInfo{NumCores: 4}}, rt, nil, CRIStatsProviderConfig{
+		StaleStatsThreshold: 30 * time.Millisecond,
+	})
+	staleOf := func() map[string]bool {
+		result, err := p.ListPodStats()
+		if err != nil {
+			t.Fatalf("ListPodStats: %v", err)
+		}
+		ret := map[string]bool{}
+		for _, ps := range result {
+			for _, cs := range ps.Containers {
+				ret[ps.PodRef.Name] = cs.Stale
+			}
+		}
+		return ret
+	}
+
+	if stale := staleOf(); stale["pod0"] || stale["pod1"] {
+		t.Fatalf("stale on first collection: %v", stale)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if stale := staleOf(); !stale["pod0"] || !stale["pod1"] {
+		t.Fatalf("frozen stats not stale: %v", stale)
+	}
+	if n := p.GetProviderStats().StaleContainers; n != 2 {
+		t.Errorf("expect 2 stale containers, got %d", n)
+	}
+
+	// the stats of ctr0 advance again
+	rt.containerStats[0].Cpu.Timestamp += int64(time.Second)
+	if stale := staleOf(); stale["pod0"] || !stale["pod1"] {
+		t.Fatalf("unexpected staleness %v", stale)
+	}
+	if n := p.GetProviderStats().StaleContainers; n != 1 {
+		t.Errorf("expect 1 stale container, got %d", n)
+	}
+}
@@ -18,10 +18,28 @@ import "yunion.io/x/onecloud/pkg/util/pod/cadvisor"
 
 type ContainerStatsProvider interface {
 	ListPodStats() ([]PodStats, error)
+	// ListPodStatsSince returns the stats of the pods changed since the
+	// listing the token was issued by, and the token of this listing.
+	ListPodStatsSince(token string) ([]PodStats, string, error)
 	ListPodStatsAndUpdateCPUNanoCoreUsage() ([]PodStats, error)
 	ListPodCPUAndMemoryStats() ([]PodStats, error)
+	// ListPodCPUStats is the cheapest listing which only fills cpu stats.
+	ListPodCPUStats() ([]PodStats, error)
+	// GetPodStats returns the stats of the pod whose sandbox has the uid,
+	// errors.ErrNotFound is returned if no such running pod.
+	GetPodStats(podUID string) (*PodStats, error)
 	ImageFsStats() (FsStats, error)
 	ImageFsDevice() (string, error)
+	// RuntimeCapabilities returns the stats features detected from the
+	// runtime version.
+	RuntimeCapabilities() RuntimeCapabilities
+	// ListStatsSnapshots returns the latest ListPodStats results retained
+	// for debugging.
+	ListStatsSnapshots() []StatsSnapshot
+	// GetProviderStats returns the counters of the provider itself.
+	GetProviderStats() ProviderStats
+	// Close releases the resources held by the provider.
+	Close() error
 }
 
 type StatsProvider struct {
@@ -95,6 +95,11 @@ type PodStats struct {
 	PodRef PodReference `json:"podRef"`
 	// The time at which data collection for the pod-scoped (e.g. network) stats was (re)started.
 	StartTime metav1.Time `json:"startTime"`
+	// Ready is false when the selected sandbox of the pod is not ready,
+	// e.g. it crashed and no ready sandbox of the same pod exists.
+	Ready bool `json:"ready"`
+	// QOSClass is derived from the cpu pinning and memory limits of the containers.
+	QOSClass PodQOSClass `json:"qosClass,omitempty"`
 	// Stats of containers in the measured pod.
 	// +patchMergeKey=name
 	// +patchStrategy=merge
@@ -105,6 +110,15 @@ type PodStats struct {
 	// Stats pertaining to memory (RAM) resources consumed by pod cgroup (which includes all containers' resource usage and pod overhead).
 	// +optional
 	Memory *MemoryStats `json:"memory,omitempty"`
+	// CPULimitCores is the sum of the cpu limits of the containers, which
+	// is the cfs quota or the cpus allocated from the cpu map. Nil when the
+	// limit of any container is unknown or unlimited.
+	// +optional
+	CPULimitCores *float64 `json:"cpuLimitCores,omitempty"`
+	// MemoryLimitBytes is the sum of the memory limits of the containers.
+	// Nil when the limit of any container is unknown or unlimited.
+	// +optional
+	MemoryLimitBytes *uint64 `json:"memoryLimitBytes,omitempty"`
 	// Stats pertaining to network resources.
 	// +optional
 	Network *NetworkStats `json:"network,omitempty"`
@@ -123,32 +137,41 @@ type PodStats struct {
 	DiskIo       DiskIoStats   `json:"diskio,omitempty"`
 }
 
+// DiskIoStats are the io stats keyed by the device name.
 type DiskIoStats map[string]*DiskIoStat
 
+// Add merges the stats of target into ds per device. The stats of target
+// are copied, so they aren't changed by the later merges into ds.
 func (ds DiskIoStats) Add(target DiskIoStats) {
 	for k, v := range target {
+		if v == nil {
+			continue
+		}
 		cv, ok := ds[k]
-		if !ok {
-			ds[k] = v
+		if !ok || cv == nil {
+			cp := *v
+			ds[k] = &cp
 		} else {
 			cv.Add(v)
-			ds[k] = cv
 		}
 	}
 }
 
+// DiskIoStat is the io of a device broken down by the operation type, the
+// bytes are from io_service_bytes and the counts from io_serviced.
 type DiskIoStat struct {
 	DeviceName   string `json:"device_name"`
 	AsyncBytes   uint64 `json:"async_bytes"`
 	DiscardBytes uint64 `json:"discard_bytes"`
 	ReadBytes    uint64 `json:"read_bytes"`
 	WriteBytes   uint64 `json:"write_bytes"`
+	SyncBytes    uint64 `json:"sync_bytes"`
 	TotalBytes   uint64 `json:"total_bytes"`
-	// SyncBytes    uint64 `json:"sync_bytes"`
 	AsyncCount   uint64 `json:"async_count"`
 	DiscardCount uint64 `json:"discard_count"`
 	ReadCount    uint64 `json:"read_count"`
 	WriteCount   uint64 `json:"write_count"`
+	SyncCount    uint64 `json:"sync_count"`
 	TotalCount   uint64 `json:"total_count"`
 }
 
@@ -185,6 +208,10 @@ func (s *DiskIoStat) fillStats(stats map[string]uint64, isByte bool) {
 			&s.ReadCount,
 			&s.ReadBytes,
 		},
+		SYNC: {
+			&s.SyncCount,
+			&s.SyncBytes,
+		},
 		TOTAL: {
 			&s.TotalCount,
 			&s.TotalBytes,
@@ -212,6 +239,8 @@ func (s *DiskIoStat) Add(v *DiskIoStat) {
 	s.ReadCount += v.ReadCount
 	s.WriteBytes += v.WriteBytes
 	s.WriteCount += v.WriteCount
+	s.SyncBytes += v.SyncBytes
+	s.SyncCount += v.SyncCount
 	s.TotalBytes += v.TotalBytes
 	s.TotalCount += v.TotalCount
 }
@@ -234,6 +263,12 @@ type ContainerStats struct {
 	// Rootfs.UsedBytes is the number of bytes used for the container write layer.
 	// +optional
 	Rootfs *FsStats `json:"rootfs,omitempty"`
+	// Stats pertaining to the read-only image layers of the container.
+	// ImageFs.UsedBytes is the size of the image, which is shared by the
+	// containers of the same image. It's nil if the runtime doesn't report
+	// the image separately from the writable layer.
+	// +optional
+	ImageFs *FsStats `json:"imageFs,omitempty"`
 	// Stats pertaining to container logs usage of filesystem resources.
 	// Logs.UsedBytes is the number of bytes used for the container logs.
 	// +optional
@@ -246,6 +281,13 @@ type ContainerStats struct {
 	// +optional
 	ProcessStats *ProcessStats `json:"process_stats,omitempty"`
 	DiskIo       DiskIoStats   `json:"diskio,omitempty"`
+	// Stale is set when the runtime keeps reporting the same cpu and memory
+	// samples of the container, so the stats are outdated.
+	Stale bool `json:"stale,omitempty"`
+	// RestartedSinceLastSample is set when the cumulative cpu usage of the
+	// container dropped since the last cpu sample, i.e. the container was
+	// restarted in place, so a gap of the cpu usage can be correlated.
+	RestartedSinceLastSample bool `json:"restartedSinceLastSample,omitempty"`
 }
 
 // PodReference contains enough information to locate the referenced pod.
@@ -253,6 +295,12 @@ type PodReference struct {
 	Name      string `json:"name"`
 	Namespace string `json:"namespace"`
 	UID       string `json:"uid"`
+	// SandboxUID is the UID of the current pod sandbox, it differs from UID
+	// when the pod identity is keyed on namespace/name.
+	SandboxUID string `json:"sandboxUID,omitempty"`
+	// Attempt is the attempt number of the pod sandbox, it is increased each
+	// time the sandbox is recreated.
+	Attempt uint32 `json:"attempt,omitempty"`
 }
 
 // InterfaceStats contains resource value data about interface.
@@ -282,6 +330,15 @@ type NetworkStats struct {
 	InterfaceStats `json:",inline"`
 
 	Interfaces []InterfaceStats `json:"interfaces,omitempty"`
+
+	// The egress bandwidth limit of the pod in bits per second, unset if
+	// unlimited.
+	// +optional
+	EgressLimitBps *uint64 `json:"egressLimitBps,omitempty"`
+	// The ingress bandwidth limit of the pod in bits per second, unset if
+	// unlimited.
+	// +optional
+	IngressLimitBps *uint64 `json:"ingressLimitBps,omitempty"`
 }
 
 // CPUStats contains data about CPU usage.
@@ -295,6 +352,15 @@ type CPUStats struct {
 	// Cumulative CPU usage (sum of all cores) since object creation.
 	// +optional
 	UsageCoreNanoSeconds *uint64 `json:"usageCoreNanoSeconds,omitempty"`
+	// UsageNanoCores in percent of the cpu limit, i.e. the cfs quota of the
+	// cgroup, it's around or above 100 when the cgroup is throttled.
+	// Nil when no limit is set.
+	// +optional
+	UsageLimitPercent *float64 `json:"usageLimitPercent,omitempty"`
+	// UsageNanoCores in percent of the cpu request, i.e. the cpu shares of
+	// the cgroup. Nil when the shares are the default.
+	// +optional
+	UsageRequestPercent *float64 `json:"usageRequestPercent,omitempty"`
 }
 
 // MemoryStats contains data about memory usage.
@@ -322,6 +388,40 @@ type MemoryStats struct {
 	// Cumulative number of major page faults.
 	// +optional
 	MajorPageFaults *uint64 `json:"majorPageFaults,omitempty"`
+	// Swap usage of the container, nil if swap isn't accounted or unused.
+	// +optional
+	SwapUsageBytes *uint64 `json:"swapUsageBytes,omitempty"`
+	// Available swap for use, defined as the swap limit - swapUsageBytes.
+	// If swap usage or limit is unknown, the available bytes is omitted.
+	// +optional
+	SwapAvailableBytes *uint64 `json:"swapAvailableBytes,omitempty"`
+	// Hugepage usage keyed by page size, e.g. "2MB" or "1GB".
+	// +optional
+	HugePages map[string]HugePageStats `json:"hugePages,omitempty"`
+	// Memory usage of each NUMA node, ordered by node id.
+	// +optional
+	NumaNodes []NumaNodeMemoryStats `json:"numaNodes,omitempty"`
+}
+
+// HugePageStats contains the usage of hugepages of one page size.
+type HugePageStats struct {
+	// Hugepage memory in use.
+	UsageBytes uint64 `json:"usageBytes"`
+	// Maximum hugepage memory usage ever recorded.
+	MaxUsageBytes uint64 `json:"maxUsageBytes"`
+	// Number of times hugepage allocation failed.
+	Failcnt uint64 `json:"failcnt"`
+}
+
+// NumaNodeMemoryStats contains the memory usage of a container on one NUMA node.
+type NumaNodeMemoryStats struct {
+	Node uint8 `json:"node"`
+	// Number of file backed pages on the node.
+	FilePages uint64 `json:"filePages"`
+	// Number of anonymous pages on the node.
+	AnonPages uint64 `json:"anonPages"`
+	// Number of unevictable pages on the node.
+	UnevictablePages uint64 `json:"unevictablePages"`
 }
 
 // AcceleratorStats contains stats for accelerators attached to the container.
@@ -446,6 +546,12 @@ type ProcessStats struct {
 	ThreadsCurrent uint64 `json:"threads_current,omitempty"`
 	// Maximum number of threads allowed in container
 	ThreadsMax uint64 `json:"threads_max,omitempty"`
+	// Change of the number of processes per second over the rate window
+	// +optional
+	ProcessCountRate *float64 `json:"process_count_rate,omitempty"`
+	// Change of the number of open file descriptors per second over the rate window
+	// +optional
+	FdCountRate *float64 `json:"fd_count_rate,omitempty"`
 }
 
 const (
@@ -453,8 +559,22 @@ const (
 	KubernetesPodNamespaceLabel  = "io.kubernetes.pod.namespace"
 	KubernetesPodUIDLabel        = "io.kubernetes.pod.uid"
 	KubernetesContainerNameLabel = "io.kubernetes.container.name"
+
+	// KubernetesInfraContainerName is the container name label of the
+	// pause container holding the namespaces of a pod.
+	KubernetesInfraContainerName = "POD"
+	// ContainerdKindLabel is set to ContainerdKindSandbox on the pause
+	// container by containerd.
+	ContainerdKindLabel   = "io.cri-containerd.kind"
+	ContainerdKindSandbox = "sandbox"
 )
 
+// IsInfraContainer tells whether the labels belong to the pause container
+// of a pod.
+func IsInfraContainer(labels map[string]string) bool {
+	return GetContainerName(labels) == KubernetesInfraContainerName || labels[ContainerdKindLabel] == ContainerdKindSandbox
+}
+
 func GetContainerName(labels map[string]string) string {
 	return labels[KubernetesContainerNameLabel]
 }
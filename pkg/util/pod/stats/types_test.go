@@ -0,0 +1,55 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"testing"
+)
+
+func TestDiskIoStatsAdd(t *testing.T) {
+	newStat := func(dev string, read, write, sync uint64) *DiskIoStat {
+		s := NewDiskIoStat(dev, map[string]uint64{"Read": read, "Write": write, "Sync": sync, "Total": read + write}, true)
+		s.fillStats(map[string]uint64{"Read": read / 100, "Write": write / 100, "Sync": sync / 100, "Total": (read + write) / 100}, false)
+		return s
+	}
+	ctr1 := DiskIoStats{
+		"/dev/vda": newStat("/dev/vda", 1000, 200, 100),
+	}
+	ctr2 := DiskIoStats{
+		"/dev/vda": newStat("/dev/vda", 300, 4000, 400),
+		"/dev/vdb": newStat("/dev/vdb", 0, 500, 0),
+	}
+	pod := DiskIoStats{}
+	pod.Add(ctr1)
+	pod.Add(ctr2)
+
+	vda := pod["/dev/vda"]
+	if vda.ReadBytes != 1300 || vda.WriteBytes != 4200 || vda.SyncBytes != 500 || vda.TotalBytes != 5500 {
+		t.Errorf("vda bytes: got %#v", vda)
+	}
+	if vda.ReadCount != 13 || vda.WriteCount != 42 || vda.SyncCount != 5 || vda.TotalCount != 55 {
+		t.Errorf("vda counts: got %#v", vda)
+	}
+	if vdb := pod["/dev/vdb"]; vdb.ReadBytes != 0 || vdb.WriteBytes != 500 || vdb.WriteCount != 5 {
+		t.Errorf("vdb: got %#v", vdb)
+	}
+	// the stats of the containers aren't changed by the aggregation
+	if s := ctr1["/dev/vda"]; s.ReadBytes != 1000 || s.WriteBytes != 200 || s.ReadCount != 10 || s.WriteCount != 2 {
+		t.Errorf("container stats changed: got %#v", s)
+	}
+	if s := ctr2["/dev/vdb"]; s.WriteBytes != 500 {
+		t.Errorf("container stats changed: got %#v", s)
+	}
+}